@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dirtybull/fff/requester"
+)
+
+// compareMain is fff compare, which lists the saved responses with
+// bodies that aren't in a corpus of common ones
+func compareMain(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+
+	var outputDir string
+	fs.StringVar(&outputDir, "output", "out", "")
+	fs.StringVar(&outputDir, "o", "out", "")
+
+	var corpusFile string
+	fs.StringVar(&corpusFile, "corpus", "", "")
+
+	fs.Usage = func() {
+		h := []string{
+			"List saved responses with bodies that aren't in a corpus of common ones",
+			"",
+			"Usage:",
+			"  fff compare --corpus <file> [options]",
+			"",
+			"Options:",
+			"      --corpus <file>       Hashes of known-common bodies (MD5, SHA-1 or SHA-256), one per line",
+			"  -o, --output <dir>        Directory containing saved responses (default: out)",
+			"",
+		}
+		fmt.Fprint(os.Stderr, strings.Join(h, "\n"))
+	}
+
+	fs.Parse(args)
+
+	if corpusFile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	unusual, total, err := requester.Compare(os.Stdout, os.Stderr, outputDir, corpusFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d saved bodies aren't in the corpus\n", unusual, total)
+}
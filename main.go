@@ -1,24 +1,16 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/sha1"
-	"crypto/tls"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
-	"net/http"
-	"net/url"
 	"os"
-	"path"
-	"regexp"
-	"strconv"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+
+	"github.com/dirtybull/fff/requester"
 )
 
 func init() {
@@ -27,328 +19,400 @@ func init() {
 			"Request URLs provided on stdin fairly frickin' fast",
 			"",
 			"Options:",
-			"  -b, --body <data>         Request body",
+			"      --auto-calibrate      Request a few random paths on each host first, and drop responses that look like them",
+			"      --auto-concurrency    Adjust how many requests are made at once (overall and per host) as we go",
+			"      --audit-cookies       Flag cookies set without Secure, HttpOnly and so on (tagged insecure-cookie)",
+			"  -b, --body <data>         Request body ({{host}}, {{rand}} etc. are filled in; see README)",
+			"      --cacert <file>       Check certificates, trusting the CAs in <file> (PEM) as well as the system's",
+			"      --chrome-path <path>  Path to the Chrome executable used by --render",
+			"      --client-cert <file>  Present the certificate in <file> (PEM) to servers that ask for one",
+			"      --client-key <file>   The key for --client-cert, if it isn't in the same file",
+			"  -c, --concurrency <n>     Make at most <n> requests at once (default: no limit)",
+			"      --compare-profiles <a,b> Request each URL as two profiles (see README) and rank them by similarity",
+			"      --compare-schemes     Fetch the http and https version of each URL and report differences",
+			"      --connect-timeout <ms> Give up connecting (including the DNS lookup) after this long (default: 10000)",
+			"      --count-runes         Count sizes in UTF-8 characters, and split words on any Unicode space, not bytes and spaces",
+			"      --capture-proxy <addr> Run a recording proxy on <addr> instead of reading stdin",
 			"  -d, --delay <delay>       Delay between issuing requests (ms)",
-			"  -H, --header <header>     Add a header to the request (can be specified multiple times)",
+			"      --dedupe              Don't save or print responses with the same status and body as an earlier one",
+			"      --dedupe-per-host     Like --dedupe, but only comparing responses from the same host",
+			"      --deny-private        Refuse to connect to private, loopback and link-local addresses",
+			"      --diff-headers <header> Also make each request with this header and report differences (repeatable)",
+			"      --expand-cidr         Accept CIDR ranges (10.0.0.0/24) and IP ranges (10.0.0.1-50) as input",
+			"      --flush-every <n>     Write the index to disk after every <n> results (default: 100)",
+			"      --flush-interval <ms> Also write the index to disk this often, with some jitter (default: 1000)",
+			"      --forward <addr>      Stream results as JSON lines to host:port (or tls://host:port)",
+			"      --forward-urls        Only send the URL of each result to --forward",
+			"      --graphql             POST a GraphQL introspection query to each URL and tag GraphQL responses",
+			"      --graphql-query <q>   Send this GraphQL query instead (or @file to read it from a file)",
+			"      --graphql-schemas <dir> Save introspection results in <dir>, one file per endpoint",
+			"      --grpc <addr>         Serve the gRPC results API (see proto/fff.proto) on <addr>",
+			"      --host-concurrency <n> Make at most <n> requests at once to each host",
+			"      --host-delay <ms>     Leave at least this long between starting requests to each host",
+			"      --http1.1-only        Only use HTTP/1.1 (the default, but explicit)",
+			"      --http2               Only use HTTP/2 (h2c with prior knowledge for http URLs)",
+			"      --host <value>        Send this Host header (and server name in TLS handshakes), whatever the URL's host is",
+			"      --host-backoff        Put a host's URLs to the back of the queue for a while when it starts failing",
+			"      --group-by <what>     Print results at the end of the run, grouped by host or status",
+			"      --health <addr>       Answer GET /health on <addr> with the run's stats, for service managers",
+			"  -H, --header <header>     Add a header (repeatable; 'Name:' removes one, @file reads them from a file)",
+			"      --hash-response       Include the response in the hash that identifies each saved response",
 			"      --ignore-html         Don't save HTML files; useful when looking non-HTML files only",
 			"      --ignore-empty        Don't save empty files",
+			"      --interleave          Take turns between hosts rather than requesting URLs in input order",
+			"      --include-headers     Include the response headers in JSON results (--json, --rpc and --forward)",
+			"  -i, --input <file>        Read input from a file instead of stdin (can be specified multiple times)",
+			"      --input-format <fmt>  Read the output of gau, katana or nuclei (-jsonl) as input (see README)",
+			"      --json                Print each result as a line of JSON",
 			"  -k, --keep-alive          Use HTTP Keep-Alive",
-			"  -m, --method              HTTP method to use (default: GET, or POST if body is specified)",
+			"  -L, --follow-redirects    Follow redirects (--redirect rules still apply)",
+			"      --min-matches <n>     Only keep responses where -ms or -mr matches at least <n> times (default: 1)",
+			"      --min-speed <rate>    Give up on requests receiving less than this over any --min-speed-time, e.g. 1k/s",
+			"      --min-speed-time <ms> How long --min-speed is measured over (default: 10000)",
+			"      --mirror <base-url>   Send a copy of each request to another host and compare the responses",
+			"  -m, --method              HTTP method to use, or methods (comma separated) to request each URL with (default: GET, or POST if body is specified)",
+			"      --methods-file <file> Request each URL with each of the methods in <file> (one per line) instead",
+			"      --max-bandwidth <rate> Limit the total download rate, in bytes per second (e.g. 10MB/s, 500k)",
+			"      --match-context <n>   Keep <n> bytes either side of each -ms/-mr match, or 'line' for its line (default: line)",
+			"      --max-size <size>     Stop reading bodies after <size> bytes (default: 100M, 0 for no limit); they're tagged oversized",
+			"      --max-decompressed <size> Stop reading compressed bodies after <size> bytes once decompressed",
+			"      --max-redirects <n>   Follow at most <n> redirects for each request (default: 10)",
 			"  -ms <string>              Match string that is included in the body",
-			"  -mc <code>                Match status code (can be specified in comma separated format)",
-			"  -fc <code>                Filter out status code (can be specified in comma separated format)",
+			"  -mr <regex>               Keep responses with a body that matches a regex (repeatable, or @file)",
+			"  -mc <codes>               Match status codes: comma separated codes, ranges (200-299), classes (3xx) or !404",
+			"      --emit <format>       Print results for the next tool: nuclei-targets, httpx or burp-scope (see README)",
+			"  -fc <codes>               Filter out status codes, in the same format as -mc",
+			"  -fr <regex>               Filter out responses with a body that matches a regex (repeatable, or @file)",
+			"  -fs, -fw, -fl <n>         Filter out responses with this size, word count or line count (e.g. 0,100-200)",
+			"  -ms-size, -mw, -ml <n>    Match responses with this size, word count or line count",
+			"      --openapi-discover    Also look for OpenAPI/Swagger specs on each host and tag any that are found",
+			"      --openapi-expand      Request the GET endpoints in any specs that are found (implies --openapi-discover)",
+			"  -0, --null                End each line of output with a NUL instead of a newline (for xargs -0)",
+			"      --optout-list <src>   Never contact the domains listed in the file or URL <src>; fail if it can't be read",
 			"  -o, --output <dir>        Directory to save responses in (will be created)",
+			"      --format <format>     Save responses the way fff (the default) or meg does",
+			"      --output-template <t> Lay the output directory out like <t>, e.g. '{{hostname}}/{{status}}-{{hash}}' (see README)",
+			"      --paths <paths>       Request each of these paths (comma separated) on every input host",
+			"      --ports <ports>       Request each input host on each of these ports (comma separated)",
+			"      --pretty              Print aligned, coloured lines when stdout's a terminal (plain ones otherwise)",
+			"      --pid-file <file>     Write the process ID to <file> while running",
+			"      --preview <n>         Include the first <n> bytes of each body in the output",
+			"      --processing-timeout <ms> Stop matching and analysing a response after this long, and tag it partial-analysis",
+			"      --redirect <rule>     Decide which redirects to follow, e.g. 'allow same-host' (see README, repeatable)",
+			"      --reflect-marker      Inject a unique marker into each request and tag responses that reflect it",
+			"      --reflect-in <locs>   Where to inject the marker: query[:name], header[:name], path (default: query:fff)",
+			"      --render              Also render HTML responses in headless Chrome and save the DOM",
+			"      --request-file <file> Make the raw HTTP request in <file> (e.g. saved from Burp) to each URL (see README)",
+			"      --resume              Skip requests that have already been saved in the output directory",
+			"      --resolve <h:p:addr>  Connect to <addr> for host <h> on port <p> (or * for any), keeping the Host and SNI (repeatable)",
+			"      --resolve-file <file> Read --resolve overrides (or hosts file lines) from <file>",
+			"      --retries <n>         Retry timeouts, resets and 429/502/503/504 responses up to <n> times",
+			"      --retry-backoff <ms>  How long to wait before the first retry; it doubles each time (default: 500)",
+			"      --rpc                 Read JSON requests from stdin and write JSON results to stdout (see README)",
+			"      --sample <rate>       Only request a share of the URLs, e.g. 10%, picked by a hash of the URL",
+			"      --sample-per-host <n> Only request the first <n> URLs for each host",
+			"      --save-chain          Also save the responses to redirects and retries before the final one (see README)",
+			"      --save-sent           Also save the exact bytes of each request as they were sent, in a .sent file",
+			"      --screenshot          Save a PNG screenshot of each saved response (uses headless Chrome)",
+			"      --script <cmd>        Run <cmd> and ask it about each request and response (see README)",
+			"      --script-procs <n>    Run <n> copies of the --script command (default: one per CPU, or -c if that's fewer)",
+			"      --serialize-hosts     Only ever have one request in flight to each host",
+			"      --shared              Share the output directory with other fffs run with --shared",
+			"      --sign <spec>         Sign each request, e.g. hmac-sha256:key=<key>:header=X-Signature (see README)",
+			"      --show-matches        Include the context of each -ms/-mr match in the output",
+			"      --sni <name>          Send this server name in TLS handshakes (default: the URL's host, or --host)",
+			"      --skip-oversized      Don't keep responses that went over --max-size",
+			"      --skip-duplicates     Don't make the same request more than once (it's tagged by default)",
+			"      --unique-by <sig>     Only print the first response with each hash, status+size or title (all are saved)",
+			"      --self-test           Run fff against a built-in test server and report any failures",
+			"      --stats-json <file>   Also write the summary at the end of the run to <file> as JSON",
+			"      --stall-timeout <ms>  Give up on requests when nothing's been received for this long",
+			"      --sync-cmd <cmd>      Run <cmd> every --sync-interval to copy new responses elsewhere (see README)",
+			"      --sync-interval <d>   How often to run --sync-cmd, e.g. 30s or 1h (default: 10m)",
+			"      --timeout <ms>        Give up on requests that take longer than this altogether; 0 for no limit (default: 10000)",
+			"      --tls-timeout <ms>    Give up on TLS handshakes after this long (default: 10000)",
+			"      --vhost-file <file>   Request each URL with each of the names in <file> as the Host header (see README)",
+			"      --verify-tls          Check certificates, failing requests to hosts with bad ones",
+			"      --truncate-at <size>  Only keep the first <size> bytes of bigger bodies (e.g. 512k, 10M)",
 			"  -x, --proxy <proxyURL>    Use the provided HTTP proxy",
+			"      --proxy-file <file>   Spread requests over the proxies in <file>, one URL per line",
+			"      --proxy-random        Pick proxies from --proxy-file at random instead of in turn",
+			"",
+			"Commands:",
+			"  serve --replay            Serve saved responses from the output directory",
+			"  compare --corpus <file>   List saved responses with bodies that aren't in a corpus of common ones",
+			"  split -n <shards> [file]  Split input up into shards, keeping each host's URLs together",
+			"  tag <hash> --tag <tag>    Annotate saved responses with tags and notes, or --list them",
 			"",
 		}
 
-		fmt.Fprintf(os.Stderr, strings.Join(h, "\n"))
+		fmt.Fprint(os.Stderr, strings.Join(h, "\n"))
 	}
 }
 
+// how long requests that are in flight when the run's interrupted get to
+// finish
+const shutdownGrace = 5 * time.Second
+
 func main() {
 
-	var requestBody string
-	flag.StringVar(&requestBody, "body", "", "")
-	flag.StringVar(&requestBody, "b", "", "")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serveMain(os.Args[2:])
+			return
+		case "compare":
+			compareMain(os.Args[2:])
+			return
+		case "split":
+			splitMain(os.Args[2:])
+			return
+		case "tag":
+			tagMain(os.Args[2:])
+			return
+		}
+	}
+
+	opts := requester.DefaultOptions()
+	opts.Stdin = os.Stdin
+	opts.Stdout = os.Stdout
+	opts.Stderr = os.Stderr
 
-	var keepAlives bool
-	flag.BoolVar(&keepAlives, "keep-alive", false, "")
-	flag.BoolVar(&keepAlives, "keep-alives", false, "")
-	flag.BoolVar(&keepAlives, "k", false, "")
+	flag.StringVar(&opts.Body, "body", "", "")
+	flag.StringVar(&opts.Body, "b", "", "")
+	flag.BoolVar(&opts.KeepAlives, "keep-alive", false, "")
+	flag.BoolVar(&opts.KeepAlives, "keep-alives", false, "")
+	flag.BoolVar(&opts.KeepAlives, "k", false, "")
+	flag.IntVar(&opts.Concurrency, "concurrency", 0, "")
+	flag.IntVar(&opts.Concurrency, "c", 0, "")
 
 	var delayMs int
 	flag.IntVar(&delayMs, "delay", 100, "")
 	flag.IntVar(&delayMs, "d", 100, "")
-
-	var method string
-	flag.StringVar(&method, "method", "GET", "")
-	flag.StringVar(&method, "m", "GET", "")
-
-	var headers headerArgs
-	flag.Var(&headers, "header", "")
-	flag.Var(&headers, "H", "")
-
-	var matchString string
-	flag.StringVar(&matchString, "ms", "", "")
-
-	var matchCode statusArgs
-	flag.Var(&matchCode, "mc", "")
-
-	var filterCode statusArgs
-	flag.Var(&filterCode, "exclude-status", "")
-	flag.Var(&filterCode, "ex", "")
-
-	var outputDir string
-	flag.StringVar(&outputDir, "output", "", "")
-	flag.StringVar(&outputDir, "o", "", "")
-
-	var proxy string
-	flag.StringVar(&proxy, "proxy", "", "")
-	flag.StringVar(&proxy, "x", "", "")
-
-	var ignoreHTMLFiles bool
-	flag.BoolVar(&ignoreHTMLFiles, "ignore-html", false, "")
-
-	var ignoreEmpty bool
-	flag.BoolVar(&ignoreEmpty, "ignore-empty", false, "")
+	flag.StringVar(&opts.Method, "method", "GET", "")
+	flag.StringVar(&opts.Method, "m", "GET", "")
+	flag.Var(&opts.Headers, "header", "")
+	flag.Var(&opts.Headers, "H", "")
+	flag.StringVar(&opts.MatchString, "ms", "", "")
+	flag.Var(&opts.MatchRegex, "mr", "")
+	flag.Var(&opts.FilterRegex, "fr", "")
+
+	// ffuf style size, word and line matching and filtering; -ms is
+	// already the match string, so matching sizes is -ms-size
+	flag.Var(&opts.MatchSize, "ms-size", "")
+	flag.Var(&opts.MatchWords, "mw", "")
+	flag.Var(&opts.MatchLines, "ml", "")
+	flag.Var(&opts.FilterSize, "fs", "")
+	flag.Var(&opts.FilterWords, "fw", "")
+	flag.Var(&opts.FilterLines, "fl", "")
+	flag.Var(&opts.MatchCodes, "mc", "")
+	flag.Var(&opts.FilterCodes, "fc", "")
+	flag.Var(&opts.FilterCodes, "exclude-status", "")
+	flag.Var(&opts.FilterCodes, "ex", "")
+	flag.StringVar(&opts.OutputDir, "output", "", "")
+	flag.StringVar(&opts.OutputDir, "o", "", "")
+	flag.StringVar(&opts.SyncCmd, "sync-cmd", "", "")
+	flag.DurationVar(&opts.SyncInterval, "sync-interval", 10*time.Minute, "")
+	flag.StringVar(&opts.OutputFormat, "format", "", "")
+	flag.StringVar(&opts.OutputTemplate, "output-template", "", "")
+	flag.StringVar(&opts.Proxy, "proxy", "", "")
+	flag.StringVar(&opts.Proxy, "x", "", "")
+	flag.StringVar(&opts.ProxyFile, "proxy-file", "", "")
+	flag.BoolVar(&opts.ProxyRandom, "proxy-random", false, "")
+	flag.BoolVar(&opts.IgnoreHTML, "ignore-html", false, "")
+	flag.BoolVar(&opts.CountRunes, "count-runes", false, "")
+	flag.BoolVar(&opts.IgnoreEmpty, "ignore-empty", false, "")
+	flag.StringVar(&opts.CaptureProxy, "capture-proxy", "", "")
+	flag.BoolVar(&opts.Render, "render", false, "")
+	flag.BoolVar(&opts.Screenshot, "screenshot", false, "")
+	flag.StringVar(&opts.ChromePath, "chrome-path", "", "")
+	flag.Var(&opts.InputFiles, "input", "")
+	flag.StringVar(&opts.StatsJSON, "stats-json", "", "")
+	flag.StringVar(&opts.Emit, "emit", "", "")
+	flag.StringVar(&opts.InputFormat, "input-format", "", "")
+	flag.StringVar(&opts.Host, "host", "", "")
+	flag.StringVar(&opts.VhostFile, "vhost-file", "", "")
+	flag.Var(&opts.Resolve, "resolve", "")
+	flag.StringVar(&opts.ResolveFile, "resolve-file", "", "")
+	flag.StringVar(&opts.TLS.ServerName, "sni", "", "")
+	flag.Var(&opts.Sample, "sample", "")
+	flag.IntVar(&opts.SamplePerHost, "sample-per-host", 0, "")
+	flag.StringVar(&opts.UniqueBy, "unique-by", "", "")
+	flag.StringVar(&opts.GroupBy, "group-by", "", "")
+	flag.BoolVar(&opts.Pretty, "pretty", false, "")
+	flag.BoolVar(&opts.Dedupe, "dedupe", false, "")
+	flag.BoolVar(&opts.DedupePerHost, "dedupe-per-host", false, "")
+	flag.StringVar(&opts.PIDFile, "pid-file", "", "")
+	flag.StringVar(&opts.Health, "health", "", "")
+	flag.BoolVar(&opts.AutoCalibrate, "auto-calibrate", false, "")
+	flag.StringVar(&opts.RequestFile, "request-file", "", "")
+	flag.StringVar(&opts.MethodsFile, "methods-file", "", "")
+	flag.StringVar(&opts.Script, "script", "", "")
+	flag.IntVar(&opts.ScriptProcs, "script-procs", 0, "")
+	flag.BoolVar(&opts.Null, "null", false, "")
+	flag.BoolVar(&opts.Null, "0", false, "")
+	flag.Var(&opts.InputFiles, "i", "")
+	flag.Var(&opts.Ports, "ports", "")
+	flag.Var(&opts.Paths, "paths", "")
+	flag.BoolVar(&opts.ExpandCIDR, "expand-cidr", false, "")
+	flag.BoolVar(&opts.CompareSchemes, "compare-schemes", false, "")
+	flag.StringVar(&opts.Forward, "forward", "", "")
+	flag.BoolVar(&opts.ForwardURLs, "forward-urls", false, "")
+	flag.StringVar(&opts.GRPC, "grpc", "", "")
+	flag.BoolVar(&opts.ReflectMarker, "reflect-marker", false, "")
+	flag.Var(&opts.ReflectIn, "reflect-in", "")
+	flag.Var(&opts.TruncateAt, "truncate-at", "")
+	flag.Var(&opts.MaxSize, "max-size", "")
+	flag.Var(&opts.MaxDecompressed, "max-decompressed", "")
+	flag.BoolVar(&opts.SkipOversized, "skip-oversized", false, "")
+	flag.IntVar(&opts.FlushEvery, "flush-every", 100, "")
+
+	var flushIntervalMs int
+	flag.IntVar(&flushIntervalMs, "flush-interval", 1000, "")
+	flag.BoolVar(&opts.HashResponse, "hash-response", false, "")
+	flag.BoolVar(&opts.SkipDuplicates, "skip-duplicates", false, "")
+	flag.BoolVar(&opts.RPC, "rpc", false, "")
+	flag.BoolVar(&opts.AutoConcurrency, "auto-concurrency", false, "")
+	flag.Var(&opts.MaxBandwidth, "max-bandwidth", "")
+
+	var timeoutMs int
+	flag.IntVar(&timeoutMs, "timeout", int(opts.Timeout/time.Millisecond), "")
+
+	var connectTimeoutMs int
+	flag.IntVar(&connectTimeoutMs, "connect-timeout", int(opts.Timeout/time.Millisecond), "")
+
+	var tlsTimeoutMs int
+	flag.IntVar(&tlsTimeoutMs, "tls-timeout", int(opts.Timeout/time.Millisecond), "")
+	flag.BoolVar(&opts.TLS.Verify, "verify-tls", false, "")
+	flag.StringVar(&opts.TLS.CAFile, "cacert", "", "")
+	flag.StringVar(&opts.TLS.CertFile, "client-cert", "", "")
+	flag.StringVar(&opts.TLS.KeyFile, "client-key", "", "")
+
+	var processingTimeoutMs int
+	flag.IntVar(&processingTimeoutMs, "processing-timeout", 0, "")
+
+	var stallTimeoutMs int
+	flag.IntVar(&stallTimeoutMs, "stall-timeout", 0, "")
+
+	flag.Var(&opts.MinSpeed, "min-speed", "")
+	var minSpeedTimeMs int
+	flag.IntVar(&minSpeedTimeMs, "min-speed-time", int(opts.MinSpeedTime/time.Millisecond), "")
+	flag.StringVar(&opts.Mirror, "mirror", "", "")
+	flag.Var(&opts.DiffHeaders, "diff-headers", "")
+	flag.Var(&opts.CompareProfiles, "compare-profiles", "")
+	flag.BoolVar(&opts.SerializeHosts, "serialize-hosts", false, "")
+	flag.StringVar(&opts.Sign, "sign", "", "")
+	flag.BoolVar(&opts.GraphQL, "graphql", false, "")
+	flag.StringVar(&opts.GraphQLQuery, "graphql-query", "", "")
+	flag.StringVar(&opts.GraphQLSchemas, "graphql-schemas", "", "")
+	flag.BoolVar(&opts.OpenAPIDiscover, "openapi-discover", false, "")
+	flag.BoolVar(&opts.OpenAPIExpand, "openapi-expand", false, "")
+	flag.StringVar(&opts.OptOutList, "optout-list", "", "")
+	flag.BoolVar(&opts.DenyPrivate, "deny-private", false, "")
+	flag.Var(&opts.Redirects, "redirect", "")
+	flag.BoolVar(&opts.JSON, "json", false, "")
+	flag.BoolVar(&opts.SaveSent, "save-sent", false, "")
+	flag.BoolVar(&opts.SaveChain, "save-chain", false, "")
+	flag.BoolVar(&opts.Resume, "resume", false, "")
+	flag.IntVar(&opts.MinMatches, "min-matches", 1, "")
+	flag.Var(&opts.MatchContext, "match-context", "")
+	flag.BoolVar(&opts.ShowMatches, "show-matches", false, "")
+	flag.IntVar(&opts.Preview, "preview", 0, "")
+	flag.BoolVar(&opts.IncludeHeaders, "include-headers", false, "")
+	flag.BoolVar(&opts.AuditCookies, "audit-cookies", false, "")
+	flag.BoolVar(&opts.FollowRedirects, "follow-redirects", false, "")
+	flag.BoolVar(&opts.FollowRedirects, "L", false, "")
+	flag.IntVar(&opts.MaxRedirects, "max-redirects", opts.MaxRedirects, "")
+	flag.IntVar(&opts.HostConcurrency, "host-concurrency", 0, "")
+
+	var hostDelayMs int
+	flag.IntVar(&hostDelayMs, "host-delay", 0, "")
+	flag.BoolVar(&opts.Interleave, "interleave", false, "")
+	flag.BoolVar(&opts.HTTP2, "http2", false, "")
+	flag.BoolVar(&opts.HTTP1Only, "http1.1-only", false, "")
+	flag.BoolVar(&opts.Shared, "shared", false, "")
+	flag.BoolVar(&opts.HostBackoff, "host-backoff", false, "")
+	flag.IntVar(&opts.Retries, "retries", 0, "")
+
+	var retryBackoffMs int
+	flag.IntVar(&retryBackoffMs, "retry-backoff", 500, "")
+
+	var selfTestMode bool
+	flag.BoolVar(&selfTestMode, "self-test", false, "")
 
 	flag.Parse()
 
-	delay := time.Duration(delayMs * 1000000)
-	client := newClient(keepAlives, proxy)
-	prefix := outputDir
-	if prefix == "" {
-		prefix = "out"
-	}
-
-	stdoutFormatStr := "%s,%s,status: %d,size: %d,words: %d,lines: %d,type: %s\n"
-
-	// regex for determining if something is probably HTML. You might
-	// think that checking the content-type response header would be a better
-	// idea, and you might be right - but if there's one thing I've learnt
-	// about webservers it's that they are dirty, rotten, filthy liars.
-	isHTML := regexp.MustCompile(`(?i)<html`)
-
-	var wg sync.WaitGroup
-
-	sc := bufio.NewScanner(os.Stdin)
-
-	for sc.Scan() {
-
-		rawURL := sc.Text()
-		wg.Add(1)
-		time.Sleep(delay)
-
-		go func() {
-			defer wg.Done()
-
-			// create the request
-			var b io.Reader
-			if requestBody != "" {
-				b = strings.NewReader(requestBody)
-
-				// Can't send a body with a GET request
-				if method == "GET" {
-					method = "POST"
-				}
-			}
-
-			_, err := url.ParseRequestURI(rawURL)
-			if err != nil {
-				return
-			}
-
-			req, err := http.NewRequest(method, rawURL, b)
-			if err != nil {
-				//fmt.Fprintf(os.Stderr, "failed to create request: %s\n", err)
-				fmt.Printf(stdoutFormatStr, rawURL, err, 0, 0, 0, 0, "error")
-				return
-			}
-
-			// add headers to the request
-			for _, h := range headers {
-				parts := strings.SplitN(h, ":", 2)
-
-				if len(parts) != 2 {
-					continue
-				}
-				req.Header.Set(parts[0], parts[1])
-			}
-
-			// send the request
-			resp, err := client.Do(req)
-			if err != nil {
-				//fmt.Fprintf(os.Stderr, "request failed: %s\n", err)
-				fmt.Printf(stdoutFormatStr, rawURL, err, 0, 0, 0, 0, "error")
-				return
-			}
-			defer resp.Body.Close()
-
-			// we want to read the body into a string or something like that so we can provide options to
-			// not save content based on a pattern or something like that
-			responseBody, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				//fmt.Fprintf(os.Stderr, "failed to read body: %s\n", err)
-				fmt.Printf(stdoutFormatStr, rawURL, err, 0, 0, 0, 0, "error")
-				return
-			}
-
-			// If we've been asked to ignore HTML files then we should really do that.
-			// But why would you want to ignore HTML files? Sometimes you're looking at
-			// a ton of hosts for config files and that sort of thing, and they lie to you
-			// by sending a 200 response code instead of a 404. Those pages are *usually*
-			// HTML so providing a way to ignore them cuts down on clutter a little bit,
-			// even if it is a niche use-case.
-			if ignoreHTMLFiles && isHTML.Match(responseBody) {
-				return
-			}
-
-			// sometimes we don't about the response at all if it's empty
-			if ignoreEmpty && len(bytes.TrimSpace(responseBody)) == 0 {
-				return
-			}
-
-			// if a -M/--match option has been used, we always want to save if it matches
-			if matchString != "" && !bytes.Contains(responseBody, []byte(matchString)) {
-				return
-			}
-
-			if len(matchCode) > 0 && !matchCode.Includes(resp.StatusCode) {
-				return
-			}
-
-			if len(filterCode) > 0 && !filterCode.Includes(resp.StatusCode) {
-				return
-			}
-
-			resp.ContentLength = int64(len(string(responseBody)))
-			wordsSize := len(strings.Split(string(responseBody), " "))
-			linesSize := len(strings.Split(string(responseBody), "\n"))
-
-			if outputDir == "" {
-				fmt.Printf(stdoutFormatStr, rawURL, resp.Header.Get("Location"), resp.StatusCode, resp.ContentLength, wordsSize, linesSize, resp.Header.Get("Content-Type"))
-				return
-			}
-
-			// output files are stored in prefix/domain/normalisedpath/hash.(body|headers)
-			normalisedPath := normalisePath(req.URL)
-			hash := sha1.Sum([]byte(method + rawURL + requestBody + headers.String()))
-			p := path.Join(prefix, req.URL.Hostname(), normalisedPath, fmt.Sprintf("%x.body", hash))
-			err = os.MkdirAll(path.Dir(p), 0750)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to create dir: %s\n", err)
-				return
-			}
-
-			// write the response body to a file
-			err = ioutil.WriteFile(p, responseBody, 0644)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to write file contents: %s\n", err)
-				return
-			}
-
-			// create the headers file
-			headersPath := path.Join(prefix, req.URL.Hostname(), normalisedPath, fmt.Sprintf("%x.headers", hash))
-			headersFile, err := os.Create(headersPath)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to create file: %s\n", err)
-				return
-			}
-			defer headersFile.Close()
-
-			var buf strings.Builder
-
-			// put the request URL and method at the top
-			buf.WriteString(fmt.Sprintf("%s %s\n\n", method, rawURL))
-
-			// add the request headers
-			for _, h := range headers {
-				buf.WriteString(fmt.Sprintf("> %s\n", h))
-			}
-			buf.WriteRune('\n')
-
-			// add the request body
-			if requestBody != "" {
-				buf.WriteString(requestBody)
-				buf.WriteString("\n\n")
-			}
-
-			// add the proto and status
-			buf.WriteString(fmt.Sprintf("< %s %s\n", resp.Proto, resp.Status))
-
-			// add the response headers
-			for k, vs := range resp.Header {
-				for _, v := range vs {
-					buf.WriteString(fmt.Sprintf("< %s: %s\n", k, v))
-				}
-			}
-
-			// add the response body
-			_, err = io.Copy(headersFile, strings.NewReader(buf.String()))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to write file contents: %s\n", err)
-				return
-			}
-
-			// output the body filename for each URL
-			fmt.Printf("%s: %s %d\n", p, rawURL, resp.StatusCode)
-		}()
-	}
-
-	wg.Wait()
-
-}
-
-func newClient(keepAlives bool, proxy string) *http.Client {
-
-	tr := &http.Transport{
-		MaxIdleConns:      30,
-		IdleConnTimeout:   time.Second,
-		DisableKeepAlives: !keepAlives,
-		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-		DialContext: (&net.Dialer{
-			Timeout:   time.Second * 10,
-			KeepAlive: time.Second,
-		}).DialContext,
-	}
-
-	if proxy != "" {
-		if p, err := url.Parse(proxy); err == nil {
-			tr.Proxy = http.ProxyURL(p)
+	if selfTestMode {
+		if requester.SelfTest(os.Stdout) > 0 {
+			os.Exit(1)
 		}
+		return
 	}
 
-	re := func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	}
-
-	return &http.Client{
-		Transport:     tr,
-		CheckRedirect: re,
-		Timeout:       time.Second * 10,
+	opts.Delay = time.Duration(delayMs) * time.Millisecond
+	opts.FlushInterval = time.Duration(flushIntervalMs) * time.Millisecond
+	opts.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	opts.ConnectTimeout = time.Duration(connectTimeoutMs) * time.Millisecond
+	opts.TLSTimeout = time.Duration(tlsTimeoutMs) * time.Millisecond
+	opts.ProcessingTimeout = time.Duration(processingTimeoutMs) * time.Millisecond
+	opts.StallTimeout = time.Duration(stallTimeoutMs) * time.Millisecond
+	opts.MinSpeedTime = time.Duration(minSpeedTimeMs) * time.Millisecond
+	opts.HostDelay = time.Duration(hostDelayMs) * time.Millisecond
+	opts.RetryBackoff = time.Duration(retryBackoffMs) * time.Millisecond
+
+	r, err := requester.New(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-}
-
-type headerArgs []string
-
-func (h *headerArgs) Set(val string) error {
-	*h = append(*h, val)
-	return nil
-}
-
-func (h headerArgs) String() string {
-	return strings.Join(h, ", ")
-}
-
-type statusArgs []int
-
-func (s *statusArgs) Set(val string) error {
-	ary := strings.Split(val, ",")
-	for i := range ary {
-		if iVal, err := strconv.Atoi(ary[i]); err == nil {
-			*s = append(*s, iVal)
+	// The first interrupt (or SIGTERM) stops any more input being read,
+	// and the requests that are already going get a little while to
+	// finish. Then the summary's written, the index is flushed and we
+	// exit with the status a shell gives a process killed by the signal.
+	// A second interrupt doesn't wait.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	var stopCode int
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// with --grpc, a SIGHUP re-reads the input files. Otherwise there's
+	// nothing to reload, but it doesn't kill the run either.
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			if opts.GRPC == "" {
+				fmt.Fprintln(os.Stderr, "ignoring SIGHUP; only --grpc has input to re-read")
+				continue
+			}
+			r.Reload()
 		}
-	}
+	}()
 
-	return nil
-}
+	go func() {
+		sig := <-signals
+		stopCode = requester.SignalExitCode(sig)
+		fmt.Fprintf(os.Stderr, "interrupted; waiting up to %s for requests in flight (interrupt again to stop now)\n", shutdownGrace)
+		stop()
 
-func (s statusArgs) String() string {
-	return "string"
-}
-
-func (s statusArgs) Includes(search int) bool {
-	for _, status := range s {
-		if status == search {
-			return true
+		select {
+		case <-signals:
+		case <-time.After(shutdownGrace):
 		}
+		r.Close()
+		os.Exit(stopCode)
+	}()
+
+	err = r.Run(ctx)
+	r.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		os.Exit(stopCode)
 	}
-	return false
-}
-
-func normalisePath(u *url.URL) string {
-	re := regexp.MustCompile(`[^a-zA-Z0-9/._-]+`)
-	return re.ReplaceAllString(u.Path, "-")
 }
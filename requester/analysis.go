@@ -0,0 +1,102 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// analysisBudget handles --processing-timeout, which limits how long is
+// spent looking through each response once it's been fetched: matching,
+// finding reflections and the like. Each step runs against a copy of the
+// job, and when the budget runs out part way through one, the worker stops
+// waiting for it: Go can't stop a regex that's going, so the step carries
+// on in the background, but what it finds is thrown away. That step and
+// the ones after it are skipped, and the response is kept whatever they'd
+// have said, tagged partial-analysis, so that it can be looked at by hand
+// rather than quietly missed.
+type analysisBudget struct {
+	limit time.Duration
+
+	mu         sync.Mutex
+	partial    int
+	slowest    time.Duration
+	slowestURL string
+}
+
+func newAnalysisBudget(limit time.Duration) *analysisBudget {
+	return &analysisBudget{limit: limit}
+}
+
+// startHook starts the clock on the job; it goes first in the filter
+// stage
+func (b *analysisBudget) startHook(j *job) bool {
+	j.analysisStart = time.Now()
+	return true
+}
+
+// guard wraps a hook that looks through the response, skipping it when
+// the job's run out of time, and giving up on it when the time runs out
+// while it's going
+func (b *analysisBudget) guard(h hook) hook {
+	return func(j *job) bool {
+		if j.analysisStart.IsZero() {
+			return h(j)
+		}
+
+		left := b.limit - time.Since(j.analysisStart)
+		if left > 0 {
+			c := j.analysisCopy()
+			done := make(chan bool, 1)
+			go func() {
+				done <- h(c)
+			}()
+
+			timer := time.NewTimer(left)
+			defer timer.Stop()
+			select {
+			case ok := <-done:
+				*j = *c
+				return ok
+			case <-timer.C:
+			}
+		}
+
+		if j.analysisSkipped == 0 {
+			j.tag("partial-analysis")
+		}
+		j.analysisSkipped++
+		return true
+	}
+}
+
+// doneHook notes how long the job took to look through, and what was
+// skipped. It goes first in the store stage.
+func (b *analysisBudget) doneHook(j *job) bool {
+	took := time.Since(j.analysisStart)
+	j.meta = append(j.meta, fmt.Sprintf("analysis: %s", took.Round(time.Microsecond)))
+	if j.analysisSkipped > 0 {
+		j.meta = append(j.meta, fmt.Sprintf("partial-analysis: %d steps skipped after --processing-timeout of %s", j.analysisSkipped, b.limit))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if j.analysisSkipped > 0 {
+		b.partial++
+	}
+	if took > b.slowest {
+		b.slowest, b.slowestURL = took, j.url
+	}
+	return true
+}
+
+func (b *analysisBudget) print(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.partial > 0 {
+		fmt.Fprintf(w, "%d responses only partly analysed (--processing-timeout); the slowest took %s (%s)\n", b.partial, b.slowest.Round(time.Millisecond), b.slowestURL)
+	}
+}
@@ -0,0 +1,67 @@
+package requester
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalysisBudget(t *testing.T) {
+	b := newAnalysisBudget(50 * time.Millisecond)
+
+	ran := 0
+	quick := b.guard(func(j *job) bool {
+		ran++
+		j.meta = append(j.meta, "quick")
+		time.Sleep(15 * time.Millisecond)
+		return true
+	})
+	stuck := b.guard(func(j *job) bool {
+		j.meta = append(j.meta, "stuck")
+		time.Sleep(time.Second)
+		return false
+	})
+	reject := b.guard(func(j *job) bool {
+		ran++
+		return false
+	})
+
+	j := newJob("http://example.com/", "GET", "", nil)
+	b.startHook(j)
+	start := time.Now()
+	if !quick(j) || !stuck(j) || !reject(j) {
+		t.Fatalf("want a skipped filter to keep the response")
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("want the stuck hook given up on, took %s", took)
+	}
+	if ran != 1 || j.analysisSkipped != 2 {
+		t.Errorf("want the last two hooks skipped, have %d run and %d skipped", ran, j.analysisSkipped)
+	}
+	if len(j.meta) != 1 || j.meta[0] != "quick" {
+		t.Errorf("want only what the finished hook did kept, have %v", j.meta)
+	}
+	if len(j.res.Tags) != 1 || j.res.Tags[0] != "partial-analysis" {
+		t.Errorf("want a partial-analysis tag, have %v", j.res.Tags)
+	}
+
+	b.doneHook(j)
+	if !strings.HasPrefix(j.meta[len(j.meta)-1], "partial-analysis: 2 steps skipped") {
+		t.Errorf("want a partial-analysis meta line, have %v", j.meta)
+	}
+
+	// a quick one's left alone
+	j = newJob("http://example.com/quick", "GET", "", nil)
+	b.startHook(j)
+	if reject(j) || j.analysisSkipped != 0 || len(j.res.Tags) != 0 {
+		t.Errorf("want the hook run, have %d skipped and tags %v", j.analysisSkipped, j.res.Tags)
+	}
+	b.doneHook(j)
+
+	var buf bytes.Buffer
+	b.print(&buf)
+	if !strings.HasPrefix(buf.String(), "1 responses only partly analysed") || !strings.Contains(buf.String(), "http://example.com/)") {
+		t.Errorf("unexpected summary %q", buf.String())
+	}
+}
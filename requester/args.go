@@ -0,0 +1,173 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusArgs are comma separated status codes, ranges (200-299), classes
+// (3xx) and exceptions (!404). They're expanded into the codes they cover
+// as they're parsed. Exceptions are taken out of the rest, or out of every
+// code from 100 to 999 when there's nothing else, so 2xx,!204 is every 2xx
+// but 204 and !404 on its own is everything but 404.
+type StatusArgs []int
+
+func (s *StatusArgs) Set(val string) error {
+	var except []int
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		not := strings.HasPrefix(part, "!")
+		codes, err := parseStatusCodes(strings.TrimPrefix(part, "!"))
+		if err != nil {
+			return err
+		}
+
+		if not {
+			except = append(except, codes...)
+		} else {
+			*s = append(*s, codes...)
+		}
+	}
+
+	if len(except) == 0 {
+		return nil
+	}
+	if len(*s) == 0 {
+		*s, _ = parseStatusCodes("100-999")
+	}
+	kept := (*s)[:0]
+	for _, code := range *s {
+		if !StatusArgs(except).Includes(code) {
+			kept = append(kept, code)
+		}
+	}
+	*s = kept
+	if len(kept) == 0 {
+		return fmt.Errorf("%q leaves no status codes", val)
+	}
+	return nil
+}
+
+// parseStatusCodes returns the codes a single status, range or class covers
+func parseStatusCodes(spec string) ([]int, error) {
+	lo, hi, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		hi = lo
+	}
+
+	// a class is a digit followed by xx
+	if !isRange && len(spec) == 3 && strings.EqualFold(spec[1:], "xx") {
+		lo, hi = spec[:1]+"00", spec[:1]+"99"
+	}
+
+	l, lerr := strconv.Atoi(lo)
+	h, herr := strconv.Atoi(hi)
+	if lerr != nil || herr != nil || l < 100 || h > 999 || h < l {
+		return nil, fmt.Errorf("invalid status code %q; want a code (200), range (200-299) or class (2xx), optionally with ! in front", spec)
+	}
+
+	codes := make([]int, 0, h-l+1)
+	for c := l; c <= h; c++ {
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+func (s StatusArgs) String() string {
+	return "string"
+}
+
+func (s StatusArgs) Includes(search int) bool {
+	for _, status := range s {
+		if status == search {
+			return true
+		}
+	}
+	return false
+}
+
+// RangeArgs are comma separated numbers and ranges, like 0,100-200,5312
+type RangeArgs [][2]int64
+
+func (r *RangeArgs) Set(val string) error {
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		if !isRange {
+			hi = lo
+		}
+
+		l, err := strconv.ParseInt(lo, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", lo)
+		}
+		h, err := strconv.ParseInt(hi, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", hi)
+		}
+		if l < 0 || h < l {
+			return fmt.Errorf("invalid range %q", part)
+		}
+		*r = append(*r, [2]int64{l, h})
+	}
+	return nil
+}
+
+func (r RangeArgs) String() string {
+	parts := make([]string, len(r))
+	for i, rg := range r {
+		parts[i] = strconv.FormatInt(rg[0], 10)
+		if rg[1] != rg[0] {
+			parts[i] += "-" + strconv.FormatInt(rg[1], 10)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r RangeArgs) Includes(n int64) bool {
+	for _, rg := range r {
+		if n >= rg[0] && n <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// ByteSize is a flag for a number of bytes, which can have a k, M or G
+// suffix (optionally followed by a B, as in 10MB)
+type ByteSize int64
+
+func (b *ByteSize) Set(val string) error {
+	if l := len(val); l > 1 && (val[l-1] == 'B' || val[l-1] == 'b') && strings.ContainsRune("kKmMgG", rune(val[l-2])) {
+		val = val[:l-1]
+	}
+	if val == "" {
+		return errors.New("empty size")
+	}
+
+	mult := int64(1)
+	switch strings.ToLower(val[len(val)-1:]) {
+	case "k":
+		mult = 1 << 10
+	case "m":
+		mult = 1 << 20
+	case "g":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		val = val[:len(val)-1]
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", val)
+	}
+	*b = ByteSize(n * mult)
+	return nil
+}
+
+func (b ByteSize) String() string {
+	return strconv.FormatInt(int64(b), 10)
+}
@@ -0,0 +1,218 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// how long --host-backoff holds back a host's URLs after it fails, which
+// doubles with each failure in a row up to the max
+const (
+	hostBackoffBase = time.Second
+	maxHostBackoff  = time.Minute
+)
+
+// hostBackoff handles --host-backoff. When requests to a host start
+// failing, the rest of its URLs are put to the back of the queue for a
+// while instead of being requested straight away, so that a sick host
+// doesn't tie up workers (or --host-concurrency slots) while everything
+// else waits. Each failure in a row doubles the wait; a good response ends
+// it.
+type hostBackoff struct {
+	mu    sync.Mutex
+	hosts map[string]*hostHealth
+
+	// lets the scheduler know that a host's backoff has changed
+	changed chan struct{}
+
+	// how many targets were held back, for the summary
+	deferred int
+}
+
+type hostHealth struct {
+	failures int
+	until    time.Time
+}
+
+func newHostBackoff() *hostBackoff {
+	return &hostBackoff{
+		hosts:   make(map[string]*hostHealth),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// record notes how a request to the host went
+func (b *hostBackoff) record(host string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h, ok := b.hosts[host]
+	if !failed {
+		if ok {
+			delete(b.hosts, host)
+			b.notify()
+		}
+		return
+	}
+
+	if !ok {
+		h = &hostHealth{}
+		b.hosts[host] = h
+	}
+	h.failures++
+
+	wait := maxHostBackoff
+	if h.failures <= 16 && hostBackoffBase<<(h.failures-1) < maxHostBackoff {
+		wait = hostBackoffBase << (h.failures - 1)
+	}
+	h.until = time.Now().Add(wait)
+	b.notify()
+}
+
+// notify wakes the scheduler up, if it isn't already going to wake up;
+// b.mu must be held
+func (b *hostBackoff) notify() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// until returns when the host's backoff ends, which is the zero time when
+// it isn't backing off
+func (b *hostBackoff) until(host string) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if h, ok := b.hosts[host]; ok {
+		return h.until
+	}
+	return time.Time{}
+}
+
+// hook records whether the job's response was a failure: no response at
+// all, or one that says the host is struggling. It goes in the fetch
+// stage; errors are recorded by errorHook.
+func (b *hostBackoff) hook(j *job) bool {
+	failed := false
+	switch j.resp.StatusCode {
+	case 429, 502, 503, 504:
+		failed = true
+	}
+	b.record(j.req.URL.Hostname(), failed)
+	return true
+}
+
+// errorHook records jobs that failed before they got a response
+func (b *hostBackoff) errorHook(j *job) {
+	if j.req != nil && j.resp == nil {
+		b.record(j.req.URL.Hostname(), true)
+	}
+}
+
+func (b *hostBackoff) print(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.deferred > 0 {
+		fmt.Fprintf(w, "%d requests put back while their hosts were failing\n", b.deferred)
+	}
+}
+
+// backoffTargets passes targets on in the order they came in, except that
+// those for hosts that are backing off wait until the backoff ends. Up
+// to window targets are held at once; after that no more input is read
+// until some can be sent.
+func backoffTargets(in <-chan target, b *hostBackoff, window int) <-chan target {
+	out := make(chan target)
+
+	go func() {
+		defer close(out)
+
+		// targets are numbered as they come in, so that they go out in
+		// the same order
+		type waiting struct {
+			target
+			n int
+		}
+		queues := make(map[string][]waiting)
+		var hosts []string // hosts with targets waiting
+		held, n := 0, 0
+
+		for in != nil || held > 0 {
+			// the oldest target for a host that isn't backing off goes
+			// next; failing that, wake up when the soonest backoff ends
+			var send chan<- target
+			var next waiting
+			var nextHost string
+			var wake <-chan time.Time
+
+			now := time.Now()
+			var soonest time.Time
+			for _, h := range hosts {
+				until := b.until(h)
+				if !until.After(now) {
+					if send == nil || queues[h][0].n < next.n {
+						send, next, nextHost = out, queues[h][0], h
+					}
+					continue
+				}
+				if soonest.IsZero() || until.Before(soonest) {
+					soonest = until
+				}
+			}
+			if send == nil && !soonest.IsZero() {
+				wake = time.After(soonest.Sub(now))
+			}
+
+			var recv <-chan target
+			if held < window {
+				recv = in
+			}
+
+			select {
+			case send <- next.target:
+				held--
+
+				// the host's still failing, so this one had to wait
+				if !b.until(nextHost).IsZero() {
+					b.mu.Lock()
+					b.deferred++
+					b.mu.Unlock()
+				}
+
+				if len(queues[nextHost]) == 1 {
+					delete(queues, nextHost)
+					for i, h := range hosts {
+						if h == nextHost {
+							hosts = append(hosts[:i], hosts[i+1:]...)
+							break
+						}
+					}
+					continue
+				}
+				queues[nextHost] = queues[nextHost][1:]
+
+			case t, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				h := targetHost(t.url)
+				if len(queues[h]) == 0 {
+					hosts = append(hosts, h)
+				}
+				queues[h] = append(queues[h], waiting{t, n})
+				held++
+				n++
+
+			case <-wake:
+			case <-b.changed:
+			}
+		}
+	}()
+
+	return out
+}
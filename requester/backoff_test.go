@@ -0,0 +1,66 @@
+package requester
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHostBackoff(t *testing.T) {
+	b := newHostBackoff()
+
+	// each failure in a row doubles the wait
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		b.record("a", true)
+		wait := time.Until(b.until("a"))
+		if wait > want || wait < want-100*time.Millisecond {
+			t.Errorf("failure %d: want a wait of %s, have %s", i+1, want, wait)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		b.record("a", true)
+	}
+	if wait := time.Until(b.until("a")); wait > maxHostBackoff {
+		t.Errorf("want at most %s, have %s", maxHostBackoff, wait)
+	}
+
+	// and a good response ends it
+	b.record("a", false)
+	if !b.until("a").IsZero() {
+		t.Error("want no backoff after a good response")
+	}
+}
+
+func TestBackoffTargets(t *testing.T) {
+	b := newHostBackoff()
+	b.record("a", true)
+
+	in := make(chan target)
+	go func() {
+		for _, u := range []string{"http://a/1", "http://b/1", "http://a/2", "http://b/2"} {
+			in <- target{url: u}
+		}
+		close(in)
+	}()
+
+	start := time.Now()
+	var order []string
+	var aAfter time.Duration
+	for tg := range backoffTargets(in, b, 10) {
+		order = append(order, tg.url)
+		if tg.url == "http://a/1" {
+			aAfter = time.Since(start)
+		}
+	}
+
+	want := []string{"http://b/1", "http://b/2", "http://a/1", "http://a/2"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("want %q, have %q", want, order)
+	}
+	if aAfter < 900*time.Millisecond {
+		t.Errorf("want a's URLs held back for a second, have %s", aAfter)
+	}
+	if b.deferred != 2 {
+		t.Errorf("want 2 deferred, have %d", b.deferred)
+	}
+}
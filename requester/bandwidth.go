@@ -0,0 +1,109 @@
+package requester
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter is a token bucket shared by every connection, which
+// keeps the total download rate under --max-bandwidth. Up to a second's
+// worth of bytes can be read in a burst.
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes out of the bucket, sleeping for as long as it takes
+// the bucket to cover them if it's run dry
+func (b *bandwidthLimiter) wait(n int) {
+	b.mu.Lock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// chunk is the most that should be read in one go, so that slow rates
+// are smooth rather than bursty
+func (b *bandwidthLimiter) chunk() int {
+	c := int(b.rate / 10)
+	if c < 512 {
+		c = 512
+	}
+	return c
+}
+
+// limitBandwidth makes every connection the client opens share the limiter.
+// The bytes are counted as they're read off the wire, so TLS and headers
+// count too, and TCP does the job of slowing down the other end.
+func limitBandwidth(client *http.Client, b *bandwidthLimiter) {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &throttledConn{Conn: conn, limiter: b}, nil
+	}
+}
+
+type throttledConn struct {
+	net.Conn
+	limiter *bandwidthLimiter
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if max := c.limiter.chunk(); len(p) > max {
+		p = p[:max]
+	}
+
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.limiter.wait(n)
+	}
+	return n, err
+}
+
+// BandwidthArg is a flag for a rate like 10MB/s or 500k; it's always in
+// bytes per second
+type BandwidthArg struct {
+	ByteSize
+}
+
+func (b *BandwidthArg) Set(val string) error {
+	return b.ByteSize.Set(strings.TrimSuffix(strings.ToLower(val), "/s"))
+}
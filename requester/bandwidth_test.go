@@ -0,0 +1,52 @@
+package requester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"100":  100,
+		"10k":  10 << 10,
+		"10KB": 10 << 10,
+		"5M":   5 << 20,
+		"1gb":  1 << 30,
+	}
+	for in, want := range cases {
+		var b ByteSize
+		if err := b.Set(in); err != nil || int64(b) != want {
+			t.Errorf("byteSize(%q): want %d, have %d (%v)", in, want, b, err)
+		}
+	}
+
+	for _, in := range []string{"", "B", "10x", "-1", "k"} {
+		var b ByteSize
+		if err := b.Set(in); err == nil {
+			t.Errorf("byteSize(%q): want error", in)
+		}
+	}
+
+	var bw BandwidthArg
+	if err := bw.Set("10MB/s"); err != nil || bw.ByteSize != 10<<20 {
+		t.Errorf("bandwidth(10MB/s): want %d, have %d (%v)", 10<<20, bw.ByteSize, err)
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	b := newBandwidthLimiter(10000)
+
+	// the first second's worth is free
+	start := time.Now()
+	b.wait(10000)
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("burst took %s", d)
+	}
+
+	// but after that it has to wait
+	start = time.Now()
+	b.wait(1000)
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("want to wait around 100ms, waited %s", d)
+	}
+}
@@ -0,0 +1,213 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// the lengths of the random paths requested to calibrate each host; they
+// differ so that pages that echo the path back don't all look the same
+var calibrationPaths = []int{8, 12, 16}
+
+// calibrator handles --auto-calibrate. Before the first request to each
+// host, a few paths that can't exist are requested, and what comes back
+// for them is the host's soft 404. Responses that look the same are
+// dropped, because they're that page again, whatever their status.
+type calibrator struct {
+	client *http.Client
+	limits bodyLimits
+
+	mu    sync.Mutex
+	hosts map[string]*hostCalibration
+
+	// how many responses were dropped, for the summary
+	filtered int
+}
+
+type hostCalibration struct {
+	done        chan struct{}
+	fingerprint *softNotFound
+}
+
+// softNotFound is what a host sends back for paths that don't exist. The
+// status has to match, and then whichever of the body's hash, word count
+// and line count was the same every time, in that order; a page that
+// echoes the path back has the same number of words, say, but a
+// different hash each time.
+type softNotFound struct {
+	status int
+	hash   string
+	words  int
+	lines  int
+}
+
+func newCalibrator(client *http.Client, limits bodyLimits) *calibrator {
+	return &calibrator{client: client, limits: limits, hosts: make(map[string]*hostCalibration)}
+}
+
+// hook calibrates the job's host if it hasn't been already; other jobs for
+// the host wait until it's done. It goes in the schedule stage, after the
+// host limits and pinning.
+func (c *calibrator) hook(j *job) bool {
+	key := j.req.URL.Scheme + "://" + j.req.URL.Host
+
+	c.mu.Lock()
+	h, ok := c.hosts[key]
+	if !ok {
+		h = &hostCalibration{done: make(chan struct{})}
+		c.hosts[key] = h
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-h.done
+		return true
+	}
+
+	h.fingerprint = c.calibrate(j)
+	close(h.done)
+	return true
+}
+
+// calibrate requests the random paths, the same way the job's going to be
+// requested, and returns the soft 404. That's nil when the host didn't
+// send the same thing back each time.
+func (c *calibrator) calibrate(j *job) *softNotFound {
+	body, err := requestBody(j.req)
+	if err != nil {
+		return nil
+	}
+	headers := headerLines(j.req.Header)
+
+	var fp *softNotFound
+	for i, n := range calibrationPaths {
+		b := make([]byte, n/2)
+		rand.Read(b)
+		u := *j.req.URL
+		u.Path, u.RawPath, u.RawQuery = "/"+hex.EncodeToString(b), "", ""
+
+		r, respBody := fetchResponse(withPins(context.Background(), j.pins), c.client, c.limits, j.method, u.String(), body, headers)
+		if r.err != nil {
+			return nil
+		}
+
+		got := newSoftNotFound(r.status, respBody)
+		if i == 0 {
+			fp = got
+			continue
+		}
+		if !fp.add(got) {
+			return nil
+		}
+	}
+
+	if !fp.consistent() {
+		return nil
+	}
+	return fp
+}
+
+func newSoftNotFound(status int, body []byte) *softNotFound {
+	sum := sha1.Sum(body)
+	return &softNotFound{
+		status: status,
+		hash:   hex.EncodeToString(sum[:]),
+		words:  bytes.Count(body, []byte(" ")) + 1,
+		lines:  bytes.Count(body, []byte("\n")) + 1,
+	}
+}
+
+// add takes another response into account, forgetting whichever of the
+// hash, word count and line count it doesn't share. It's false when the
+// status is different, and there's no soft 404 at all.
+func (s *softNotFound) add(got *softNotFound) bool {
+	if got.status != s.status {
+		return false
+	}
+	if got.hash != s.hash {
+		s.hash = ""
+	}
+	if got.words != s.words {
+		s.words = -1
+	}
+	if got.lines != s.lines {
+		s.lines = -1
+	}
+	return true
+}
+
+// consistent reports whether anything's left to compare responses with
+func (s *softNotFound) consistent() bool {
+	return s.hash != "" || s.words >= 0 || s.lines >= 0
+}
+
+// matches reports whether the response looks like the soft 404
+func (s *softNotFound) matches(status int, body []byte) bool {
+	if s == nil || status != s.status {
+		return false
+	}
+	got := newSoftNotFound(status, body)
+	switch {
+	case s.hash != "":
+		return got.hash == s.hash
+	case s.words >= 0:
+		return got.words == s.words
+	default:
+		return got.lines == s.lines
+	}
+}
+
+func (s *softNotFound) String() string {
+	switch {
+	case s.hash != "":
+		return fmt.Sprintf("status %d, body %s", s.status, s.hash)
+	case s.words >= 0:
+		return fmt.Sprintf("status %d, %d words", s.status, s.words)
+	}
+	return fmt.Sprintf("status %d, %d lines", s.status, s.lines)
+}
+
+// filterHook drops responses that look like their host's soft 404, and
+// notes what the soft 404 looks like on the rest. It goes in the filter
+// stage.
+func (c *calibrator) filterHook(j *job) bool {
+	key := j.req.URL.Scheme + "://" + j.req.URL.Host
+
+	c.mu.Lock()
+	h := c.hosts[key]
+	c.mu.Unlock()
+	if h == nil {
+		return true
+	}
+
+	<-h.done
+	if h.fingerprint == nil {
+		j.meta = append(j.meta, "calibration: no consistent soft 404")
+		return true
+	}
+
+	if h.fingerprint.matches(j.resp.StatusCode, j.respBody) {
+		c.mu.Lock()
+		c.filtered++
+		c.mu.Unlock()
+		return false
+	}
+	j.meta = append(j.meta, "calibration: soft 404 is "+h.fingerprint.String())
+	return true
+}
+
+func (c *calibrator) print(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.filtered > 0 {
+		fmt.Fprintf(w, "%d responses dropped as soft 404s (--auto-calibrate)\n", c.filtered)
+	}
+}
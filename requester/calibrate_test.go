@@ -0,0 +1,81 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCalibrator(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/admin":
+			fmt.Fprint(w, "the admin panel")
+		case "/gone":
+			w.WriteHeader(404)
+			fmt.Fprintf(w, "no page called %s here", r.URL.Path)
+		default:
+			// a soft 404 that echoes the path back
+			fmt.Fprintf(w, "no page called %s here", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	client := newClient(false, "")
+	c := newCalibrator(client, bodyLimits{})
+	fetch := fetchHook(client, bodyLimits{})
+
+	run := func(path string) (*job, bool) {
+		j := newJob(srv.URL+path, "GET", "", nil)
+		buildRequestHook(j)
+		c.hook(j)
+		fetch(j)
+		if j.err != nil {
+			t.Fatal(j.err)
+		}
+		return j, c.filterHook(j)
+	}
+
+	if _, ok := run("/admin"); !ok {
+		t.Error("want a real page kept")
+	}
+	if _, ok := run("/backup.zip"); ok {
+		t.Error("want a soft 404 dropped")
+	}
+	j, ok := run("/gone")
+	if !ok {
+		t.Error("want a response with a different status kept")
+	}
+	if len(j.meta) != 1 || !strings.Contains(j.meta[0], "status 200, 5 words") {
+		t.Errorf("want the soft 404 in the meta, have %q", j.meta)
+	}
+
+	// three calibration requests, once, then the real ones
+	if len(requests) != 6 || requests[3] != "/admin" {
+		t.Errorf("want 3 calibration requests before the first real one, have %q", requests)
+	}
+	if c.filtered != 1 {
+		t.Errorf("want 1 response filtered, have %d", c.filtered)
+	}
+}
+
+func TestSoftNotFound(t *testing.T) {
+	fp := newSoftNotFound(200, []byte("same"))
+	if !fp.matches(200, []byte("same")) || fp.matches(200, []byte("other")) || fp.matches(404, []byte("same")) {
+		t.Error("want a hash fingerprint to match the same body and status only")
+	}
+
+	fp.hash, fp.words = "", -1
+	if !fp.matches(200, []byte("different but one line")) {
+		t.Error("want a lines fingerprint to match any body with the same number of lines")
+	}
+
+	var none *softNotFound
+	if none.matches(200, nil) {
+		t.Error("want no fingerprint to match nothing")
+	}
+}
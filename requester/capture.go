@@ -0,0 +1,428 @@
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hopHeaders are only meaningful for a single connection, so they're not
+// passed on by the capture proxy (or recorded)
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// how long the capture CA lasts before a new one's made. It can sign
+// certificates for any host, so it shouldn't be trusted for longer than
+// it's needed.
+const captureCALifetime = 30 * 24 * time.Hour
+
+// captureProxy is a forward proxy that records everything sent through it
+// into the output directory, using the same layout as a normal run. HTTPS
+// is intercepted with certificates signed by a local CA, which needs to be
+// trusted by whatever is sending traffic through the proxy. The CA is kept
+// in the user's config directory rather than the output directory, which
+// gets synced and shared and tarred up.
+type captureProxy struct {
+	client *http.Client
+	store  Storage
+	ca     tls.Certificate
+
+	// where a line's printed for each response that's saved, and where
+	// failures to save them go
+	w   io.Writer
+	log io.Writer
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// newCaptureProxy sets up a capture proxy with the CA in caDir
+func newCaptureProxy(caDir string, store Storage, client *http.Client, w, log io.Writer) (*captureProxy, string, error) {
+	ca, caPath, err := loadOrCreateCA(caDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to set up CA: %s", err)
+	}
+
+	return &captureProxy{
+		client: client,
+		store:  store,
+		w:      w,
+		log:    log,
+		ca:     ca,
+		certs:  make(map[string]*tls.Certificate),
+	}, caPath, nil
+}
+
+// captureCADir is where the capture CA is kept
+func captureCADir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fff"), nil
+}
+
+// oldCAKey returns the capture CA key that older versions of fff left in
+// the output directory, if there is one
+func oldCAKey(prefix string) string {
+	p := filepath.Join(prefix, "fff-ca.key")
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// captureListenAddr is where the capture proxy listens. Anyone who can
+// reach it can use it as an open proxy, so an address without a host is
+// taken to mean localhost rather than every interface.
+func captureListenAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
+
+// listensEverywhere reports whether addr is on every interface
+func listensEverywhere(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+func (p *captureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "fff capture proxy only handles proxy requests", http.StatusBadRequest)
+		return
+	}
+
+	resp, body, err := p.forward(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("request failed: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// handleConnect intercepts a CONNECT tunnel by terminating TLS itself and
+// then reading plain HTTP requests from the client
+func (p *captureProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	connectHost, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		connectHost = r.Host
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = connectHost
+			}
+			return p.certFor(name)
+		},
+	})
+	defer tlsConn.Close()
+
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+
+	br := bufio.NewReader(tlsConn)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+		if req.URL.Host == "" {
+			req.URL.Host = r.Host
+		}
+
+		resp, body, err := p.forward(req)
+		if err != nil {
+			resp = &http.Response{
+				StatusCode: http.StatusBadGateway,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     make(http.Header),
+			}
+			body = []byte(fmt.Sprintf("request failed: %s\n", err))
+		}
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.TransferEncoding = nil
+		resp.Close = req.Close
+		resp.Header.Del("Transfer-Encoding")
+
+		if err := resp.Write(tlsConn); err != nil || req.Close {
+			return
+		}
+	}
+}
+
+// forward sends a copy of the proxied request upstream and records the
+// request and response in the output directory
+func (p *captureProxy) forward(r *http.Request) (*http.Response, []byte, error) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Body.Close()
+
+	rawURL := r.URL.String()
+	out, err := http.NewRequest(r.Method, rawURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out.Header = r.Header.Clone()
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	// leaving Accept-Encoding to the transport means bodies are
+	// transparently decompressed, so what's saved is readable
+	out.Header.Del("Accept-Encoding")
+
+	resp, err := p.client.Do(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+
+	headers := headerLines(out.Header)
+	saved, err := p.store.Put(artifact{
+		Hash:           artifactHash(r.Method, rawURL, headers, string(reqBody), nil, nil),
+		Method:         r.Method,
+		URL:            rawURL,
+		RequestHeaders: headers,
+		RequestBody:    string(reqBody),
+		Response:       resp,
+		Body:           body,
+	})
+	if err != nil {
+		fmt.Fprintf(p.log, "%s\n", err)
+		return resp, body, nil
+	}
+
+	err = p.store.Index(Result{
+		URL:         rawURL,
+		Method:      r.Method,
+		Status:      resp.StatusCode,
+		Size:        int64(len(body)),
+		ContentType: resp.Header.Get("Content-Type"),
+		Location:    resp.Header.Get("Location"),
+		Path:        saved,
+	})
+	if err != nil {
+		fmt.Fprintf(p.log, "%s\n", err)
+	}
+
+	fmt.Fprintf(p.w, "%s: %s %d\n", saved, rawURL, resp.StatusCode)
+
+	return resp, body, nil
+}
+
+// certFor returns a certificate for the provided hostname signed by
+// the capture CA, generating and caching it if needed
+func (p *captureProxy) certFor(host string) (*tls.Certificate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.certs[host]; ok {
+		return c, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     p.ca.Leaf.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca.Leaf, &key.PublicKey, p.ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Certificate{
+		Certificate: [][]byte{der, p.ca.Certificate[0]},
+		PrivateKey:  key,
+	}
+	p.certs[host] = c
+	return c, nil
+}
+
+// loadOrCreateCA loads the capture CA from dir, creating it first if it
+// doesn't exist yet, so that it only needs trusting once. It's made again
+// when it's got less than a day left.
+func loadOrCreateCA(dir string) (tls.Certificate, string, error) {
+	certPath := filepath.Join(dir, "capture-ca.pem")
+	keyPath := filepath.Join(dir, "capture-ca.key")
+
+	ca, err := loadCA(certPath, keyPath)
+	if err == nil && time.Until(ca.Leaf.NotAfter) > 24*time.Hour {
+		return ca, certPath, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return ca, "", err
+	}
+
+	if err := createCA(certPath, keyPath, captureCALifetime); err != nil {
+		return tls.Certificate{}, "", err
+	}
+	ca, err = loadCA(certPath, keyPath)
+	return ca, certPath, err
+}
+
+func loadCA(certPath, keyPath string) (tls.Certificate, error) {
+	ca, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return ca, err
+	}
+
+	ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0])
+	return ca, err
+}
+
+func createCA(certPath, keyPath string, lifetime time.Duration) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "fff capture proxy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(lifetime),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(certPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func newSerial() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return n
+}
+
+// headerLines returns sorted 'Name: value' lines for a header map
+func headerLines(h http.Header) []string {
+	var lines []string
+	for k, vs := range h {
+		for _, v := range vs {
+			lines = append(lines, fmt.Sprintf("%s: %s", k, v))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,144 @@
+package requester
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureProxy(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "yes")
+		w.Write([]byte("hello from " + r.URL.Path))
+	}
+	plain := httptest.NewServer(http.HandlerFunc(upstream))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(upstream))
+	defer secure.Close()
+
+	caDir, outDir := t.TempDir(), t.TempDir()
+	var lines bytes.Buffer
+	p, caPath, err := newCaptureProxy(caDir, newFSStorage(outDir), newClient(false, ""), newLockedWriter(&lines), ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(caPath) != caDir {
+		t.Errorf("want the CA in %s, have %s", caDir, caPath)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "fff-ca.key")); err == nil {
+		t.Errorf("want no CA key in the output directory")
+	}
+
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	// the client trusts the capture CA, and nothing else
+	pem, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+
+	for _, u := range []string{plain.URL + "/plain", secure.URL + "/connect"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("request for %s through the proxy failed: %s", u, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		want := "hello from " + u[strings.LastIndex(u, "/"):]
+		if string(body) != want || resp.Header.Get("X-Upstream") != "yes" {
+			t.Errorf("want %q from %s, have %q (%v)", want, u, body, resp.Header)
+		}
+		if !strings.Contains(lines.String(), u+" 200") {
+			t.Errorf("want %s saved, have %q", u, lines.String())
+		}
+	}
+
+	// requests that aren't for the proxy are turned away
+	resp, err := http.Get(proxy.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("want 400 for a direct request, have %d", resp.StatusCode)
+	}
+}
+
+func TestCaptureCA(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, certPath, err := loadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if life := time.Until(ca.Leaf.NotAfter); life > captureCALifetime {
+		t.Errorf("want the CA to last at most %s, have %s", captureCALifetime, life)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "capture-ca.key")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("want a private key file, have %v (%v)", info, err)
+	}
+
+	// it's the same CA the second time
+	again, _, err := loadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Certificate[0], ca.Certificate[0]) {
+		t.Errorf("want the CA loaded again, not made again")
+	}
+
+	// one that's about to expire is replaced
+	if err := createCA(certPath, filepath.Join(dir, "capture-ca.key"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	again, _, err = loadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(again.Leaf.NotAfter) < 24*time.Hour {
+		t.Errorf("want a CA that's about to expire replaced, have one that lasts until %s", again.Leaf.NotAfter)
+	}
+
+	p := &captureProxy{ca: ca, certs: make(map[string]*tls.Certificate)}
+	c, err := p.certFor("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(c.Certificate[0])
+	if leaf.NotAfter.After(ca.Leaf.NotAfter) {
+		t.Errorf("want host certificates to expire with the CA")
+	}
+}
+
+func TestCaptureListenAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		":8081":          "127.0.0.1:8081",
+		"127.0.0.1:8081": "127.0.0.1:8081",
+		"0.0.0.0:8081":   "0.0.0.0:8081",
+	} {
+		if have := captureListenAddr(in); have != want {
+			t.Errorf("want %s for %s, have %s", want, in, have)
+		}
+	}
+	if !listensEverywhere("0.0.0.0:8081") || !listensEverywhere("[::]:8081") || listensEverywhere("127.0.0.1:8081") {
+		t.Errorf("listensEverywhere is wrong")
+	}
+}
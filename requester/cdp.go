@@ -0,0 +1,365 @@
+package requester
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// This file contains just enough of a WebSocket client and Chrome DevTools
+// Protocol client to drive a headless browser, so that we don't need to
+// pull in any dependencies for render mode.
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu sync.Mutex
+}
+
+func dialWebSocket(rawURL string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second*10)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := fmt.Sprintf(
+		"GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		u.RequestURI(), u.Host, key,
+	)
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	accept := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake failed: %s", resp.Status)
+	}
+
+	return &wsConn{conn: conn, br: br}, nil
+}
+
+// writeFrame sends a single, masked (as required for clients) frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch l := len(payload); {
+	case l < 126:
+		header = append(header, 0x80|byte(l))
+	case l <= 0xffff:
+		header = append(header, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(l))
+	default:
+		header = append(header, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(l))
+	}
+
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	header = append(header, mask...)
+
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+
+	_, err := c.conn.Write(append(header, masked...))
+	return err
+}
+
+// readMessage reads a complete data message, reassembling fragments and
+// dealing with control frames along the way
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			return nil, err
+		}
+		fin := h[0]&0x80 != 0
+		opcode := h[0] & 0x0f
+
+		length := uint64(h[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+
+		var mask [4]byte
+		masked := h[1]&0x80 != 0
+		if masked {
+			if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			msg = append(msg, payload...)
+		}
+
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, nil)
+	return c.conn.Close()
+}
+
+// cdpMessage covers commands, responses and events
+type cdpMessage struct {
+	ID        int64           `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// cdpSession collects the events for a single attached target
+type cdpSession struct {
+	mu     sync.Mutex
+	events []cdpMessage
+	notify chan struct{}
+}
+
+// cdpClient speaks the DevTools protocol over a single browser-level
+// connection, with targets multiplexed over it using flat sessions
+type cdpClient struct {
+	ws *wsConn
+
+	mu       sync.Mutex
+	nextID   int64
+	pending  map[int64]chan cdpMessage
+	sessions map[string]*cdpSession
+	err      error
+}
+
+func newCDPClient(wsURL string) (*cdpClient, error) {
+	ws, err := dialWebSocket(wsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cdpClient{
+		ws:       ws,
+		pending:  make(map[int64]chan cdpMessage),
+		sessions: make(map[string]*cdpSession),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *cdpClient) readLoop() {
+	for {
+		raw, err := c.ws.readMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		var msg cdpMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		if msg.ID != 0 {
+			if ch, ok := c.pending[msg.ID]; ok {
+				ch <- msg
+				delete(c.pending, msg.ID)
+			}
+		} else if s, ok := c.sessions[msg.SessionID]; ok {
+			s.mu.Lock()
+			s.events = append(s.events, msg)
+			s.mu.Unlock()
+			select {
+			case s.notify <- struct{}{}:
+			default:
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// call sends a command and waits for its result, which is unmarshalled
+// into result if it's not nil
+func (c *cdpClient) call(sessionID, method string, params interface{}, Result interface{}, timeout time.Duration) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan cdpMessage, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	raw, err := json.Marshal(cdpMessage{ID: id, SessionID: sessionID, Method: method, Params: rawParams})
+	if err != nil {
+		return err
+	}
+	if err := c.ws.writeFrame(wsText, raw); err != nil {
+		return err
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return errors.New("browser connection closed")
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %s", method, msg.Error.Message)
+		}
+		if Result != nil {
+			return json.Unmarshal(msg.Result, Result)
+		}
+		return nil
+
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("%s: timed out", method)
+	}
+}
+
+func (c *cdpClient) addSession(id string) *cdpSession {
+	s := &cdpSession{notify: make(chan struct{}, 1)}
+	c.mu.Lock()
+	c.sessions[id] = s
+	c.mu.Unlock()
+	return s
+}
+
+func (c *cdpClient) removeSession(id string) {
+	c.mu.Lock()
+	delete(c.sessions, id)
+	c.mu.Unlock()
+}
+
+// waitFor blocks until an event with the given method has been received
+// for the session, or the deadline passes
+func (s *cdpSession) waitFor(method string, deadline time.Time) bool {
+	for {
+		s.mu.Lock()
+		for _, e := range s.events {
+			if e.Method == method {
+				s.mu.Unlock()
+				return true
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-time.After(time.Until(deadline)):
+			return false
+		}
+	}
+}
+
+// eventsFor returns all of the events received so far with the given method
+func (s *cdpSession) eventsFor(method string) []cdpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []cdpMessage
+	for _, e := range s.events {
+		if e.Method == method {
+			out = append(out, e)
+		}
+	}
+	return out
+}
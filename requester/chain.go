@@ -0,0 +1,123 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// as much of the body of each earlier response in a chain as is kept
+const maxChainBody = 1 << 20
+
+// responseChain records the responses that came before the one a job
+// ended up with, for --save-chain: the redirects that were followed, and
+// the attempts that were retried, in the order they happened. Without it
+// only the last response of all is saved.
+type responseChain struct {
+	mu    sync.Mutex
+	links []chainLink
+}
+
+// chainLink is a request and the response it got. reason says why there
+// was another request after it (redirect or retry).
+type chainLink struct {
+	reason string
+
+	method  string
+	url     string
+	headers []string
+	body    string
+
+	resp     *http.Response
+	respBody []byte
+}
+
+// add records a response in the chain, along with the request it was for
+func (c *responseChain) add(reason string, resp *http.Response, respBody []byte) {
+	l := chainLink{reason: reason, resp: resp, respBody: respBody}
+	if req := resp.Request; req != nil {
+		l.method, l.url = req.Method, req.URL.String()
+		l.headers = headerLines(req.Header)
+		l.body, _ = requestBody(req)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.links = append(c.links, l)
+}
+
+// addRedirect records a redirect that's about to be followed. The client
+// throws the redirect's body away once it's been followed, so it's read
+// here.
+func (c *responseChain) addRedirect(resp *http.Response) {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxChainBody))
+	c.add("redirect", resp, body)
+}
+
+// chainTraceHook starts recording the job's chain. It goes in the
+// schedule stage, just before the fetch.
+func chainTraceHook(j *job) bool {
+	j.chain = &responseChain{}
+	return true
+}
+
+// chainStoreHook saves and indexes each response in the job's chain
+// before the job's own, so they're in order in the index. Each of them
+// says where it is in the chain, and the last response lists the rest,
+// e.g.
+//
+//	chain: 1 redirect 302 http://example.com/login out/example.com/login/5c1e8a0b.body
+//
+// Their IDs include the response, so that they can't be mistaken for a
+// request that's been made by --resume.
+func chainStoreHook(store Storage, log io.Writer) hook {
+	return func(j *job) bool {
+		if j.chain == nil {
+			return true
+		}
+
+		j.chain.mu.Lock()
+		links := j.chain.links
+		j.chain.mu.Unlock()
+
+		for i, l := range links {
+			meta := []string{
+				"hash: " + hashScheme + "+response",
+				fmt.Sprintf("chain: %d of %d (then a %s) for %s", i+1, len(links)+1, l.reason, j.url),
+			}
+
+			p, err := store.Put(artifact{
+				Hash:           artifactHash(l.method, l.url, l.headers, l.body, l.resp, l.respBody),
+				Method:         l.method,
+				URL:            l.url,
+				RequestHeaders: l.headers,
+				RequestBody:    l.body,
+				Meta:           meta,
+				Response:       l.resp,
+				Body:           l.respBody,
+			})
+			if err != nil {
+				fmt.Fprintf(log, "%s\n", err)
+				continue
+			}
+
+			err = store.Index(Result{
+				Input:       j.input,
+				URL:         l.url,
+				Method:      l.method,
+				Status:      l.resp.StatusCode,
+				Size:        int64(len(l.respBody)),
+				ContentType: l.resp.Header.Get("Content-Type"),
+				Path:        p,
+			})
+			if err != nil {
+				fmt.Fprintf(log, "%s\n", err)
+			}
+
+			j.meta = append(j.meta, fmt.Sprintf("chain: %d %s %d %s %s", i+1, l.reason, l.resp.StatusCode, l.url, p))
+		}
+		return true
+	}
+}
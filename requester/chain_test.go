@@ -0,0 +1,82 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseChain(t *testing.T) {
+	tries := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/flaky", http.StatusFound)
+			return
+		}
+		if tries++; tries == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("try again"))
+			return
+		}
+		w.Write([]byte("made it"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	store := newFSStorage(dir)
+
+	pipe := &pipeline{}
+	pipe.OnRedirect(followRedirects)
+	pipe.Use(stagePrepare, buildRequestHook)
+	pipe.Use(stageSchedule, chainTraceHook)
+	pipe.Use(stageFetch, retryHook(fetchHook(newClient(false, ""), bodyLimits{}), 1, time.Millisecond))
+	pipe.Use(stageEnrich, resultHook)
+	pipe.Use(stageStore, chainStoreHook(store, ioutil.Discard))
+	pipe.Use(stageStore, storeHook(store, false, ioutil.Discard))
+
+	var meta []string
+	pipe.Use(stageReport, func(j *job) bool {
+		meta = j.meta
+		return true
+	})
+	pipe.Run(newJob(srv.URL+"/start", "GET", "", nil))
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(index)), "\n")
+	// the retry starts again from the beginning, and the last response is
+	// saved under the URL that was asked for
+	want := []string{"/start (302)", "/flaky (503)", "/start (302)", "/start (200)"}
+	if len(lines) != len(want) {
+		t.Fatalf("want %d index lines, have %q", len(want), lines)
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Errorf("want index line %d to be for %s, have %q", i+1, w, lines[i])
+		}
+	}
+
+	var chain []string
+	for _, m := range meta {
+		if strings.HasPrefix(m, "chain: ") {
+			chain = append(chain, m)
+		}
+	}
+	if len(chain) != 3 || !strings.HasPrefix(chain[0], "chain: 1 redirect 302 ") || !strings.HasPrefix(chain[1], "chain: 2 retry 503 ") {
+		t.Errorf("unexpected chain meta %q", chain)
+	}
+
+	body, _ := ioutil.ReadFile(strings.Fields(lines[1])[0])
+	if string(body) != "try again" {
+		t.Errorf("want the retried response's body saved, have %q", body)
+	}
+}
@@ -0,0 +1,42 @@
+package requester
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func newClient(keepAlives bool, proxy string) *http.Client {
+
+	tr := &http.Transport{
+		MaxIdleConns:      30,
+		IdleConnTimeout:   time.Second,
+		DisableKeepAlives: !keepAlives,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+		},
+		TLSHandshakeTimeout: defaultTimeout,
+		DialContext: (&net.Dialer{
+			Timeout:        defaultTimeout,
+			KeepAlive:      time.Second,
+			FallbackDelay:  fallbackDelay,
+			ControlContext: dialControl,
+		}).DialContext,
+	}
+
+	if proxy != "" {
+		if p, err := url.Parse(proxy); err == nil {
+			tr.Proxy = http.ProxyURL(p)
+		}
+	}
+
+	return &http.Client{
+		Transport:     tr,
+		CheckRedirect: checkRedirect,
+		Timeout:       defaultTimeout,
+	}
+
+}
@@ -0,0 +1,187 @@
+package requester
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// `fff compare` looks for the responses in an output directory that are
+// out of the ordinary. It's given a corpus of hashes of bodies that are
+// known to be common (default pages, error pages, parked domains and the
+// like, from something like Common Crawl) and lists the saved bodies that
+// aren't in it. Those that turned up the fewest times in the output come
+// first, because a body that every host sent isn't unusual either.
+
+// the hash algorithms a corpus can use, told apart by the length of the
+// hashes in hex
+var corpusHashes = map[int]func() hash.Hash{
+	md5.Size * 2:    md5.New,
+	sha1.Size * 2:   sha1.New,
+	sha256.Size * 2: sha256.New,
+}
+
+// hashCorpus is a set of known body hashes, by their length in hex
+type hashCorpus map[int]map[string]bool
+
+// unusualBody is a saved body that isn't in the corpus
+type unusualBody struct {
+	path   string
+	url    string
+	status int
+	size   int
+
+	// the body's SHA-1, and how many saved bodies were the same
+	hash  string
+	count int
+
+	// what's been said about it with fff tag
+	review *review
+}
+
+// Compare writes a line to w for each of the responses saved in dir with
+// a body whose hash isn't in the corpus file, the least common first. It
+// returns how many there were, out of how many saved bodies. Responses
+// that can't be read are skipped with a line on log.
+func Compare(w, log io.Writer, dir, corpusFile string) (int, int, error) {
+	corpus, err := loadHashCorpus(corpusFile)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load corpus: %s", err)
+	}
+
+	unusual, total, err := findUnusual(dir, corpus, log)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read responses: %s", err)
+	}
+
+	for _, u := range unusual {
+		var extra string
+		if u.review != nil && u.review.String() != "" {
+			extra = " " + u.review.String()
+		}
+		fmt.Fprintf(w, "%s %s (%d) %d x%d%s\n", u.path, u.url, u.status, u.size, u.count, extra)
+	}
+	return len(unusual), total, nil
+}
+
+// loadHashCorpus reads hashes from a file, one per line. Anything after
+// the hash is ignored, so the output of sha256sum and the like is fine,
+// as are blank lines and lines starting with #.
+func loadHashCorpus(path string) (hashCorpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	corpus := make(hashCorpus)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		h := strings.ToLower(fields[0])
+		if _, err := hex.DecodeString(h); err != nil || corpusHashes[len(h)] == nil {
+			return nil, fmt.Errorf("%s: line %d: want an MD5, SHA-1 or SHA-256 hash in hex", path, n)
+		}
+		if corpus[len(h)] == nil {
+			corpus[len(h)] = make(map[string]bool)
+		}
+		corpus[len(h)][h] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(corpus) == 0 {
+		return nil, fmt.Errorf("%s: no hashes", path)
+	}
+	return corpus, nil
+}
+
+// contains reports whether the body's hash is in the corpus, in any of the
+// algorithms it has hashes for
+func (c hashCorpus) contains(body []byte) bool {
+	for size, known := range c {
+		h := corpusHashes[size]()
+		h.Write(body)
+		if known[hex.EncodeToString(h.Sum(nil))] {
+			return true
+		}
+	}
+	return false
+}
+
+// findUnusual reads every saved response in dir and returns those with
+// bodies that aren't in the corpus, rarest first, along with how many
+// bodies there were altogether. Empty bodies are left out.
+func findUnusual(dir string, corpus hashCorpus, log io.Writer) ([]unusualBody, int, error) {
+	var unusual []unusualBody
+	counts := make(map[string]int)
+	total := 0
+	reviews := loadReviews(dir)
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != ".headers" {
+			return nil
+		}
+
+		sr, err := readStoredResponse(p)
+		if err != nil {
+			fmt.Fprintf(log, "skipping %s: %s\n", p, err)
+			return nil
+		}
+		body, err := ioutil.ReadFile(sr.bodyPath)
+		if err != nil || len(body) == 0 {
+			return nil
+		}
+		total++
+
+		h := sha1.Sum(body)
+		key := string(h[:])
+		counts[key]++
+		if corpus.contains(body) {
+			return nil
+		}
+
+		unusual = append(unusual, unusualBody{
+			path:   sr.bodyPath,
+			url:    sr.url,
+			status: sr.status,
+			size:   len(body),
+			hash:   key,
+			review: reviews[hashFromPath(sr.bodyPath)],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range unusual {
+		unusual[i].count = counts[unusual[i].hash]
+	}
+	sort.SliceStable(unusual, func(i, j int) bool {
+		if unusual[i].count != unusual[j].count {
+			return unusual[i].count < unusual[j].count
+		}
+		return unusual[i].path < unusual[j].path
+	})
+
+	return unusual, total, nil
+}
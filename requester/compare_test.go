@@ -0,0 +1,63 @@
+package requester
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindUnusual(t *testing.T) {
+	dir := t.TempDir()
+	s := newFSStorage(dir)
+
+	bodies := map[string]string{
+		"1": "It works!",
+		"2": "not found",
+		"3": "not found",
+		"4": "the admin panel",
+		"5": "",
+	}
+	for id, body := range bodies {
+		a := testArtifact()
+		a.Hash = id
+		a.URL = "http://example.com/" + id
+		a.Body = []byte(body)
+		if _, err := s.Put(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the corpus can mix algorithms
+	corpusFile := filepath.Join(dir, "corpus.txt")
+	ioutil.WriteFile(corpusFile, []byte(fmt.Sprintf("# known\n%x  index.html\n%X\n", sha256.Sum256([]byte("It works!")), md5.Sum([]byte("nothing")))), 0644)
+	corpus, err := loadHashCorpus(corpusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unusual, total, err := findUnusual(dir, corpus, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 {
+		t.Errorf("want 4 bodies that aren't empty, have %d", total)
+	}
+
+	// the one-off comes before the two the same
+	var have []string
+	for _, u := range unusual {
+		have = append(have, fmt.Sprintf("%s x%d", u.url, u.count))
+	}
+	want := []string{"http://example.com/4 x1", "http://example.com/2 x2", "http://example.com/3 x2"}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	ioutil.WriteFile(corpusFile, []byte("abc\n"), 0644)
+	if _, err := loadHashCorpus(corpusFile); err == nil {
+		t.Error("want an error for a bad hash")
+	}
+}
@@ -0,0 +1,254 @@
+package requester
+
+import (
+	"sync"
+	"time"
+)
+
+// limits for --auto-concurrency
+const (
+	autoGlobalStart = 10
+	autoGlobalMax   = 500
+	autoHostStart   = 2
+	autoHostMax     = 32
+)
+
+// aimdLimiter limits how many requests are in flight, adjusting the limit
+// as it goes like TCP does: it grows by one for every limit's worth of
+// good responses (additive increase), and halves when a request fails, the
+// server says it's overloaded, or a response takes much longer than usual
+// (multiplicative decrease).
+type aimdLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	limit    float64
+	max      float64
+	inflight int
+
+	// smoothed latency of good responses
+	baseline time.Duration
+
+	// a burst of failures from requests that were all in flight at the
+	// same time should only count once
+	lastDecrease time.Time
+}
+
+func newAIMDLimiter(start, max float64) *aimdLimiter {
+	l := &aimdLimiter{limit: start, max: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Acquire blocks until there's room for another request
+func (l *aimdLimiter) Acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.inflight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inflight++
+}
+
+// Release frees up the job's slot and adjusts the limit based on how the
+// job went. Only timeouts and dropped connections count as the server
+// being overloaded; jobs that failed for any other reason (a bad URL, a
+// private address, a script error) never got as far as the server, so
+// they don't count at all.
+func (l *aimdLimiter) Release(j *job) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	defer l.cond.Broadcast()
+
+	if j.err == nil && j.resp == nil || j.err != nil && !retryableError(j.err) {
+		return
+	}
+
+	overloaded := j.err != nil || j.resp.StatusCode == 429 || j.resp.StatusCode == 503
+	slow := l.baseline > 0 && j.fetchTime > 4*l.baseline
+
+	if overloaded || slow {
+		if time.Since(l.lastDecrease) > l.baseline {
+			l.limit /= 2
+			if l.limit < 1 {
+				l.limit = 1
+			}
+			l.lastDecrease = time.Now()
+		}
+		return
+	}
+
+	l.limit += 1 / l.limit
+	if l.limit > l.max {
+		l.limit = l.max
+	}
+
+	if l.baseline == 0 {
+		l.baseline = j.fetchTime
+	} else {
+		l.baseline = (7*l.baseline + j.fetchTime) / 8
+	}
+}
+
+// hostLimitHook gives each host its own aimdLimiter, so that a slow or
+// struggling host gets fewer requests at once without holding back the
+// others. It belongs in the schedule stage.
+func hostLimitHook() hook {
+	var mu sync.Mutex
+	limiters := make(map[string]*aimdLimiter)
+
+	return func(j *job) bool {
+		host := j.req.URL.Host
+
+		mu.Lock()
+		l, ok := limiters[host]
+		if !ok {
+			l = newAIMDLimiter(autoHostStart, autoHostMax)
+			limiters[host] = l
+		}
+		mu.Unlock()
+
+		l.Acquire()
+		j.onFinish(func() { l.Release(j) })
+		return true
+	}
+}
+
+// hostConcurrencyHook handles --host-concurrency (and --serialize-hosts,
+// which is a limit of one), making sure there are never more than n
+// requests in flight to each host, while different hosts are still
+// requested in parallel. Hosts are told apart by name alone, so different
+// ports on the same device wait for each other too. Like hostLimitHook it
+// belongs in the schedule stage, and the slot is held until the job's
+// finished, so anything else sent for the job (--mirror and the like)
+// counts too.
+func hostConcurrencyHook(n int) hook {
+	var mu sync.Mutex
+	slots := make(map[string]chan struct{})
+
+	return func(j *job) bool {
+		host := j.req.URL.Hostname()
+
+		mu.Lock()
+		slot, ok := slots[host]
+		if !ok {
+			slot = make(chan struct{}, n)
+			slots[host] = slot
+		}
+		mu.Unlock()
+
+		slot <- struct{}{}
+		j.onFinish(func() { <-slot })
+		return true
+	}
+}
+
+// hostDelayHook handles --host-delay, spacing out the requests to each
+// host so that they start at least delay apart. It goes in the schedule
+// stage, after anything else that waits, so that it's the start of the
+// requests that's spaced out.
+func hostDelayHook(delay time.Duration) hook {
+	var mu sync.Mutex
+	next := make(map[string]time.Time)
+
+	return func(j *job) bool {
+		host := j.req.URL.Hostname()
+
+		// each job reserves its start time, so that jobs waiting for the
+		// same host queue up rather than all going at once
+		mu.Lock()
+		now := time.Now()
+		start := next[host]
+		if start.Before(now) {
+			start = now
+		}
+		next[host] = start.Add(delay)
+		mu.Unlock()
+
+		time.Sleep(time.Until(start))
+		return true
+	}
+}
+
+// workerPool runs jobs through the pipeline. With a size it's a fixed
+// number of workers, and Run blocks while they're all busy, so input
+// isn't read any faster than it can be dealt with; without one every job
+// gets a goroutine of its own.
+type workerPool struct {
+	pipe *pipeline
+	jobs chan *job
+	wg   sync.WaitGroup
+	stop <-chan struct{}
+}
+
+func newWorkerPool(pipe *pipeline, size int) *workerPool {
+	p := &workerPool{pipe: pipe}
+	if size <= 0 {
+		return p
+	}
+
+	p.jobs = make(chan *job)
+	for i := 0; i < size; i++ {
+		go func() {
+			for j := range p.jobs {
+				p.pipe.Run(j)
+				p.wg.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// Run runs the job, waiting for a free worker if there's a limit
+func (p *workerPool) Run(j *job) {
+	p.add(1)
+	p.dispatch(j)
+}
+
+// add counts jobs that are going to be dispatched later. Jobs found while
+// others are running have to be counted before those finish, so that
+// Wait can't return early.
+func (p *workerPool) add(n int) {
+	p.wg.Add(n)
+}
+
+// dispatch runs the job. Once stop is closed, jobs that haven't started
+// yet (including any waiting for a worker) are dropped instead.
+func (p *workerPool) dispatch(j *job) {
+	select {
+	case <-p.stop:
+		p.drop(j)
+		return
+	default:
+	}
+
+	if p.jobs != nil {
+		select {
+		case p.jobs <- j:
+		case <-p.stop:
+			p.drop(j)
+		}
+		return
+	}
+
+	go func() {
+		defer p.wg.Done()
+		p.pipe.Run(j)
+	}()
+}
+
+func (p *workerPool) drop(j *job) {
+	j.finish()
+	p.wg.Done()
+}
+
+// Wait waits for every job to finish, then stops the workers
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+	if p.jobs != nil {
+		close(p.jobs)
+	}
+}
@@ -0,0 +1,276 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	l := newAIMDLimiter(2, 4)
+
+	ok := &job{resp: &http.Response{StatusCode: 200}, fetchTime: 10 * time.Millisecond}
+
+	// four good responses at a limit of 2 is an increase of 1.5 or so
+	for i := 0; i < 4; i++ {
+		l.Acquire()
+		l.Release(ok)
+	}
+	if l.limit < 3 || l.limit > 4 {
+		t.Errorf("want limit between 3 and 4, have %f", l.limit)
+	}
+
+	// it shouldn't go past the max
+	for i := 0; i < 100; i++ {
+		l.Acquire()
+		l.Release(ok)
+	}
+	if l.limit != 4 {
+		t.Errorf("want limit capped at 4, have %f", l.limit)
+	}
+
+	// a 429 halves it, but a burst of them only counts once
+	for i := 0; i < 3; i++ {
+		l.Acquire()
+		l.Release(&job{resp: &http.Response{StatusCode: 429}})
+	}
+	if l.limit != 2 {
+		t.Errorf("want limit halved to 2, have %f", l.limit)
+	}
+
+	// so does a response that's much slower than usual
+	time.Sleep(20 * time.Millisecond)
+	l.Acquire()
+	l.Release(&job{resp: &http.Response{StatusCode: 200}, fetchTime: time.Second})
+	if l.limit != 1 {
+		t.Errorf("want limit halved to 1 for a slow response, have %f", l.limit)
+	}
+
+	// and it never drops below one
+	time.Sleep(20 * time.Millisecond)
+	l.Acquire()
+	l.Release(&job{err: stallError{time.Second}})
+	if l.limit != 1 {
+		t.Errorf("want limit to stay at 1, have %f", l.limit)
+	}
+
+	// jobs that didn't get a response don't change anything
+	l.Acquire()
+	l.Release(&job{})
+	if l.limit != 1 || l.inflight != 0 {
+		t.Errorf("want limit 1 and nothing in flight, have %f and %d", l.limit, l.inflight)
+	}
+}
+
+func TestAIMDLimiterErrors(t *testing.T) {
+	l := newAIMDLimiter(8, 8)
+
+	// failures that never got to the server don't say anything about it
+	l.Acquire()
+	l.Release(&job{err: errors.New(`net/http: invalid method "GE T"`)})
+	if l.limit != 8 {
+		t.Errorf("want limit left at 8, have %f", l.limit)
+	}
+
+	for _, err := range []error{stallError{time.Second}, fmt.Errorf("read: %w", syscall.ECONNRESET)} {
+		l.Acquire()
+		l.Release(&job{err: err})
+	}
+	if l.limit != 2 {
+		t.Errorf("want limit halved twice to 2, have %f", l.limit)
+	}
+}
+
+func TestAIMDLimiterBlocks(t *testing.T) {
+	l := newAIMDLimiter(1, 1)
+	l.Acquire()
+
+	acquired := make(chan bool)
+	go func() {
+		l.Acquire()
+		acquired <- true
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	l.Release(&job{})
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after release")
+	}
+}
+
+func TestHostSerialHook(t *testing.T) {
+	var mu sync.Mutex
+	inflight, most := 0, 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		if inflight > most {
+			most = inflight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	pipe, col := testPipeline(nil)
+	pipe.Use(stageSchedule, hostConcurrencyHook(1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pipe.Run(newJob(fmt.Sprintf("%s/%d", srv.URL, i), "GET", "", nil))
+		}(i)
+	}
+	wg.Wait()
+
+	if len(col.results) != 10 {
+		t.Fatalf("want 10 results, have %d", len(col.results))
+	}
+	if most != 1 {
+		t.Errorf("want at most 1 request in flight, have %d", most)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	var mu sync.Mutex
+	inflight, most, done := 0, 0, 0
+
+	pipe := &pipeline{}
+	pipe.Use(stageFetch, func(j *job) bool {
+		mu.Lock()
+		inflight++
+		if inflight > most {
+			most = inflight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inflight--
+		done++
+		mu.Unlock()
+		return true
+	})
+
+	pool := newWorkerPool(pipe, 3)
+	for i := 0; i < 20; i++ {
+		pool.Run(newJob("http://example.com/", "GET", "", nil))
+	}
+	pool.Wait()
+
+	if done != 20 {
+		t.Errorf("want 20 jobs run, have %d", done)
+	}
+	if most != 3 {
+		t.Errorf("want at most 3 jobs at once, have %d", most)
+	}
+}
+
+func TestWorkerPoolStop(t *testing.T) {
+	var mu sync.Mutex
+	ran, finished := 0, 0
+
+	release := make(chan struct{})
+	pipe := &pipeline{}
+	pipe.Use(stageFetch, func(j *job) bool {
+		mu.Lock()
+		ran++
+		mu.Unlock()
+		<-release
+		return true
+	})
+
+	stop := make(chan struct{})
+	pool := newWorkerPool(pipe, 1)
+	pool.stop = stop
+
+	// the first job takes the only worker, so the second waits for it
+	first := newJob("http://example.com/", "GET", "", nil)
+	pool.Run(first)
+	waiting := make(chan struct{})
+	go func() {
+		j := newJob("http://example.com/", "GET", "", nil)
+		j.onFinish(func() {
+			mu.Lock()
+			finished++
+			mu.Unlock()
+		})
+		pool.Run(j)
+		close(waiting)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	<-waiting
+	pool.Run(newJob("http://example.com/", "GET", "", nil))
+	close(release)
+	pool.Wait()
+
+	if ran != 1 {
+		t.Errorf("want only the job in flight run, have %d run", ran)
+	}
+	if finished != 1 {
+		t.Error("want a dropped job finished")
+	}
+}
+
+func TestHostDelayHook(t *testing.T) {
+	delay := hostDelayHook(20 * time.Millisecond)
+
+	var mu sync.Mutex
+	starts := make(map[string][]time.Time)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		for _, host := range []string{"a.example.com", "b.example.com:8080", "b.example.com"} {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+				j := newJob("http://"+host+"/", "GET", "", nil)
+				buildRequestHook(j)
+				delay(j)
+
+				mu.Lock()
+				starts[j.req.URL.Hostname()] = append(starts[j.req.URL.Hostname()], time.Now())
+				mu.Unlock()
+			}(host)
+		}
+	}
+	wg.Wait()
+
+	for host, times := range starts {
+		sort.Slice(times, func(i, k int) bool { return times[i].Before(times[k]) })
+		for i := 1; i < len(times); i++ {
+			if gap := times[i].Sub(times[i-1]); gap < 15*time.Millisecond {
+				t.Errorf("%s: requests only %s apart", host, gap)
+			}
+		}
+	}
+
+	// different hosts don't hold each other up
+	if first := starts["a.example.com"][0].Sub(starts["b.example.com"][0]); first > 10*time.Millisecond || first < -10*time.Millisecond {
+		t.Errorf("first requests to different hosts were %s apart", first)
+	}
+}
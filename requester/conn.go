@@ -0,0 +1,267 @@
+package requester
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// How long to wait for a connection to one address family before trying
+// the other as well, as recommended by RFC 8305. Go's dialer races the
+// families this way, so a target with broken IPv6 costs us this rather
+// than the whole dial timeout.
+const fallbackDelay = 250 * time.Millisecond
+
+// How many TLS sessions the client remembers, one per host. Resuming a
+// session skips the certificate exchange, which adds up against servers
+// that close the connection after every response.
+const tlsSessionCacheSize = 1024
+
+// connInfo records what happened when connecting for a request. It's
+// filled in by an httptrace.ClientTrace, the hooks of which can be called
+// concurrently while addresses are being raced.
+type connInfo struct {
+	mu       sync.Mutex
+	attempts []*connAttempt
+
+	// whether the request went over a connection that had already been
+	// used, and how long it had been sitting idle if so
+	gotConn  bool
+	reused   bool
+	idleTime time.Duration
+
+	// the TLS handshake, if there was one, and whether it resumed an
+	// earlier session
+	handshakeStart time.Time
+	handshake      time.Duration
+	handshook      bool
+	resumed        bool
+
+	// when the request started waiting for a connection, how long the
+	// DNS lookup took, and how long it was from the start to the first
+	// byte of the response
+	start     time.Time
+	dnsStart  time.Time
+	dns       time.Duration
+	firstByte time.Duration
+}
+
+type connAttempt struct {
+	addr  string
+	start time.Time
+	took  time.Duration
+	err   error
+	done  bool
+}
+
+func (c *connInfo) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.start = time.Now()
+		},
+
+		DNSStart: func(httptrace.DNSStartInfo) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.dnsStart = time.Now()
+		},
+
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if !c.dnsStart.IsZero() {
+				c.dns = time.Since(c.dnsStart)
+			}
+		},
+
+		GotFirstResponseByte: func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if !c.start.IsZero() {
+				c.firstByte = time.Since(c.start)
+			}
+		},
+
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.gotConn = true
+			c.reused = info.Reused
+			c.idleTime = info.IdleTime
+		},
+
+		TLSHandshakeStart: func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.handshakeStart = time.Now()
+		},
+
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if err != nil || c.handshakeStart.IsZero() {
+				return
+			}
+			c.handshake = time.Since(c.handshakeStart)
+			c.handshook = true
+			c.resumed = state.DidResume
+		},
+
+		ConnectStart: func(network, addr string) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.attempts = append(c.attempts, &connAttempt{addr: addr, start: time.Now()})
+		},
+
+		ConnectDone: func(network, addr string, err error) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for _, a := range c.attempts {
+				if a.addr == addr && !a.done {
+					a.took = time.Since(a.start)
+					a.err = err
+					a.done = true
+					return
+				}
+			}
+		},
+	}
+}
+
+// family returns ipv4 or ipv6 for an address
+func family(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "unknown"
+	}
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// Reused reports whether the request went over an existing connection
+func (c *connInfo) Reused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reused
+}
+
+// Handshake returns how long the TLS handshake took and whether it resumed
+// an earlier session, and false if there wasn't one
+func (c *connInfo) Handshake() (time.Duration, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handshake, c.resumed, c.handshook
+}
+
+// timing returns how long the DNS lookup, connecting, the TLS handshake
+// and the wait for the first byte of the response took. The first three
+// are zero when they didn't happen, like on a reused connection. When
+// more than one address was tried, connecting covers all of the attempts
+// up to the one that worked.
+func (c *connInfo) timing() (dns, connect, handshake, firstByte time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var first *connAttempt
+	for _, a := range c.attempts {
+		if first == nil {
+			first = a
+		}
+		if a.done && a.err == nil {
+			connect = a.start.Add(a.took).Sub(first.start)
+			break
+		}
+	}
+	return c.dns, connect, c.handshake, c.firstByte
+}
+
+// meta describes whether the connection was reused, the TLS handshake,
+// the attempts to connect, which family was used in the end, and how long
+// it took to get there when we had to fall back to the other family. It
+// returns whether there was a fallback, too.
+func (c *connInfo) meta() ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var lines []string
+	if c.reused {
+		lines = append(lines, fmt.Sprintf("connection: reused (idle for %s)", c.idleTime.Round(time.Millisecond)))
+	} else if c.gotConn {
+		lines = append(lines, "connection: new")
+	}
+
+	if c.handshook {
+		kind := "full"
+		if c.resumed {
+			kind = "resumed"
+		}
+		lines = append(lines, fmt.Sprintf("tls-handshake: %s in %s", kind, c.handshake.Round(time.Millisecond)))
+	}
+
+	var first, winner *connAttempt
+	for _, a := range c.attempts {
+		if first == nil {
+			first = a
+		}
+
+		switch {
+		case !a.done:
+			lines = append(lines, fmt.Sprintf("connect: %s %s abandoned", family(a.addr), a.addr))
+		case a.err != nil:
+			lines = append(lines, fmt.Sprintf("connect: %s %s failed after %s (%s)", family(a.addr), a.addr, a.took.Round(time.Millisecond), a.err))
+		default:
+			lines = append(lines, fmt.Sprintf("connect: %s %s ok in %s", family(a.addr), a.addr, a.took.Round(time.Millisecond)))
+			if winner == nil {
+				winner = a
+			}
+		}
+	}
+
+	if winner == nil {
+		return lines, false
+	}
+
+	lines = append(lines, "family: "+family(winner.addr))
+
+	fellBack := family(winner.addr) != family(first.addr)
+	if fellBack {
+		took := winner.start.Add(winner.took).Sub(first.start)
+		lines = append(lines, fmt.Sprintf("fallback: from %s after %s", family(first.addr), took.Round(time.Millisecond)))
+	}
+	return lines, fellBack
+}
+
+// connTraceHook starts recording what happens when connecting for the
+// job. It goes at the end of the schedule stage, just before the fetch.
+func connTraceHook(j *job) bool {
+	j.conn = &connInfo{}
+	j.req = j.req.WithContext(httptrace.WithClientTrace(j.req.Context(), j.conn.clientTrace()))
+	return true
+}
+
+// connMetaHook adds what happened when connecting to the job's meta, and
+// tags responses where one address family had to be abandoned for the
+// other (which usually means broken IPv6)
+func connMetaHook(j *job) bool {
+	if j.conn == nil {
+		return true
+	}
+
+	lines, fellBack := j.conn.meta()
+	j.meta = append(j.meta, lines...)
+	if fellBack {
+		j.tag("fallback")
+	}
+	return true
+}
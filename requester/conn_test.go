@@ -0,0 +1,99 @@
+package requester
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnInfoFallback(t *testing.T) {
+	c := &connInfo{}
+	trace := c.clientTrace()
+
+	trace.ConnectStart("tcp", "[2001:db8::1]:443")
+	trace.ConnectStart("tcp", "192.0.2.1:443")
+	trace.ConnectDone("tcp", "[2001:db8::1]:443", errors.New("timeout"))
+	trace.ConnectDone("tcp", "192.0.2.1:443", nil)
+
+	lines, fellBack := c.meta()
+	if !fellBack {
+		t.Error("want fallback")
+	}
+
+	var keys []string
+	for _, l := range lines {
+		keys = append(keys, strings.Fields(l)[0]+" "+strings.Fields(l)[1])
+	}
+	want := []string{"connect: ipv6", "connect: ipv4", "family: ipv4", "fallback: from"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("want %q, have %q", want, lines)
+	}
+}
+
+func TestConnInfoNoFallback(t *testing.T) {
+	c := &connInfo{}
+	trace := c.clientTrace()
+
+	trace.ConnectStart("tcp", "192.0.2.1:80")
+	trace.ConnectDone("tcp", "192.0.2.1:80", nil)
+
+	lines, fellBack := c.meta()
+	if fellBack || len(lines) != 2 || lines[1] != "family: ipv4" {
+		t.Errorf("want plain ipv4 connection, have %q (fallback: %v)", lines, fellBack)
+	}
+
+	// reused connections don't connect at all
+	c = &connInfo{}
+	c.clientTrace().GotConn(httptrace.GotConnInfo{Reused: true, IdleTime: time.Second})
+	if lines, _ := c.meta(); len(lines) != 1 || lines[0] != "connection: reused (idle for 1s)" || !c.Reused() {
+		t.Errorf("want reused connection, have %q", lines)
+	}
+}
+
+func TestTLSResumption(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	// without keep-alives every request needs a new handshake, and all
+	// but the first should be able to resume. --save-sent does the
+	// handshakes itself, so it's checked too.
+	for _, tap := range []bool{false, true} {
+		client := newClient(false, "")
+		if tap {
+			tapConnections(client)
+		}
+
+		var resumed []bool
+		for i := 0; i < 3; i++ {
+			c := &connInfo{}
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), c.clientTrace()))
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			_, r, ok := c.Handshake()
+			if !ok {
+				t.Fatalf("tap %v, request %d: want a handshake", tap, i)
+			}
+			resumed = append(resumed, r)
+		}
+
+		want := []bool{false, true, true}
+		if !reflect.DeepEqual(resumed, want) {
+			t.Errorf("tap %v: want resumed %v, have %v", tap, want, resumed)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// describeCookie sums up a cookie's attributes on one line, e.g.
+//
+//	sid domain=.example.com path=/ expires=2026-01-02T15:04:05Z secure httponly samesite=lax
+//
+// Cookies without a Domain attribute are only sent back to the host that
+// set them, which is noted as host-only. The value is left out; it's in
+// the Set-Cookie header if it's wanted.
+func describeCookie(c *http.Cookie) string {
+	parts := []string{c.Name}
+
+	if c.Domain == "" {
+		parts = append(parts, "host-only")
+	} else {
+		parts = append(parts, "domain="+c.Domain)
+	}
+	if c.Path != "" {
+		parts = append(parts, "path="+c.Path)
+	}
+
+	// Max-Age wins over Expires when there are both
+	switch {
+	case c.MaxAge < 0:
+		parts = append(parts, "expired")
+	case c.MaxAge > 0:
+		parts = append(parts, fmt.Sprintf("max-age=%ds", c.MaxAge))
+	case !c.Expires.IsZero():
+		parts = append(parts, "expires="+c.Expires.UTC().Format(time.RFC3339))
+	default:
+		parts = append(parts, "session")
+	}
+
+	if c.Secure {
+		parts = append(parts, "secure")
+	}
+	if c.HttpOnly {
+		parts = append(parts, "httponly")
+	}
+	if c.Partitioned {
+		parts = append(parts, "partitioned")
+	}
+	switch c.SameSite {
+	case http.SameSiteLaxMode:
+		parts = append(parts, "samesite=lax")
+	case http.SameSiteStrictMode:
+		parts = append(parts, "samesite=strict")
+	case http.SameSiteNoneMode:
+		parts = append(parts, "samesite=none")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// cookieIssues returns what's wrong with a cookie set by a response from
+// host, from a security point of view. Cookies that are being deleted don't
+// matter, so they never have any issues.
+func cookieIssues(c *http.Cookie, https bool, host string) []string {
+	if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) {
+		return nil
+	}
+
+	var issues []string
+	if https && !c.Secure {
+		issues = append(issues, "no Secure flag on an https response")
+	}
+	if !c.HttpOnly {
+		issues = append(issues, "no HttpOnly flag")
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		issues = append(issues, "SameSite=None without Secure (browsers reject it)")
+	}
+
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if domain != "" && domain != strings.ToLower(host) {
+		issues = append(issues, "sent to all of "+domain+", not just "+host)
+	}
+
+	// the prefixes are promises that browsers enforce, so breaking them
+	// means the cookie is thrown away
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		issues = append(issues, "__Secure- prefix without Secure")
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		issues = append(issues, "__Host- prefix without Secure, Path=/ and no Domain")
+	}
+
+	return issues
+}
+
+// cookieHook adds a cookie: line to the job's meta for each cookie the
+// response sets. With audit, there's a cookie-issue: line for anything
+// insecure about them too, and the response is tagged insecure-cookie.
+func cookieHook(audit bool) hook {
+	return func(j *job) bool {
+		cookies := j.resp.Cookies()
+		if len(cookies) == 0 {
+			return true
+		}
+
+		// after redirects it's the last request that the cookies
+		// are for
+		u := j.req.URL
+		if j.resp.Request != nil {
+			u = j.resp.Request.URL
+		}
+
+		insecure := false
+		for _, c := range cookies {
+			j.meta = append(j.meta, "cookie: "+describeCookie(c))
+			if !audit {
+				continue
+			}
+			for _, issue := range cookieIssues(c, u.Scheme == "https", u.Hostname()) {
+				j.meta = append(j.meta, "cookie-issue: "+c.Name+": "+issue)
+				insecure = true
+			}
+		}
+		if insecure {
+			j.tag("insecure-cookie")
+		}
+		return true
+	}
+}
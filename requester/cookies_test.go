@@ -0,0 +1,51 @@
+package requester
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCookieHook(t *testing.T) {
+	j := newJob("https://www.example.com/", "GET", "", nil)
+	buildRequestHook(j)
+	j.resp = &http.Response{Header: http.Header{"Set-Cookie": {
+		"sid=abc; Path=/; Secure; HttpOnly; SameSite=Lax",
+		"prefs=dark; Domain=.example.com; Max-Age=3600",
+		"old=; Max-Age=0",
+	}}}
+
+	cookieHook(true)(j)
+
+	want := []string{
+		"cookie: sid host-only path=/ session secure httponly samesite=lax",
+		"cookie: prefs domain=.example.com max-age=3600s",
+		"cookie-issue: prefs: no Secure flag on an https response",
+		"cookie-issue: prefs: no HttpOnly flag",
+		"cookie-issue: prefs: sent to all of example.com, not just www.example.com",
+		"cookie: old host-only expired",
+	}
+	if strings.Join(j.meta, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want meta %q, have %q", want, j.meta)
+	}
+	if want := []string{"insecure-cookie"}; !reflect.DeepEqual(j.res.Tags, want) {
+		t.Errorf("want tags %q, have %q", want, j.res.Tags)
+	}
+}
+
+func TestCookiePrefixes(t *testing.T) {
+	cases := []struct {
+		cookie *http.Cookie
+		issues int
+	}{
+		{&http.Cookie{Name: "__Host-sid", Path: "/", Secure: true, HttpOnly: true}, 0},
+		{&http.Cookie{Name: "__Host-sid", Path: "/app", Secure: true, HttpOnly: true}, 1},
+		{&http.Cookie{Name: "__Secure-sid", HttpOnly: true}, 2},
+	}
+	for _, c := range cases {
+		if issues := cookieIssues(c.cookie, true, "example.com"); len(issues) != c.issues {
+			t.Errorf("%s: want %d issues, have %q", c.cookie, c.issues, issues)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package requester
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bodyCounts are a response's size, words and lines: the metrics that are
+// reported for it, and matched and filtered on with -fs, -fw, -fl and so
+// on. They're counted as the body's read, so they're for all of it, even
+// the part that --truncate-at doesn't keep. A body that stops being read
+// at --max-size or --max-decompressed only has counts for what was read.
+//
+// By default, like ffuf, the size is in bytes and words are the number of
+// spaces plus one. With --count-runes the body's taken as UTF-8, so the
+// size is in characters (a byte that isn't valid UTF-8 counts as one), and
+// any Unicode space but a newline separates words, so text with
+// non-breaking or ideographic spaces in it isn't one long word. Lines are
+// the number of newlines plus one either way.
+type bodyCounts struct {
+	runes bool
+
+	size, words, lines int64
+
+	// the start of a character that's been split between writes
+	pending []byte
+}
+
+func newBodyCounts(runes bool) *bodyCounts {
+	return &bodyCounts{runes: runes, words: 1, lines: 1}
+}
+
+// Write counts another part of the body
+func (c *bodyCounts) Write(p []byte) (int, error) {
+	n := len(p)
+	c.lines += int64(bytes.Count(p, []byte("\n")))
+
+	if !c.runes {
+		c.size += int64(n)
+		c.words += int64(bytes.Count(p, []byte(" ")))
+		return n, nil
+	}
+
+	if len(c.pending) > 0 {
+		p = append(c.pending, p...)
+		c.pending = nil
+	}
+	for len(p) > 0 {
+		if !utf8.FullRune(p) {
+			c.pending = append([]byte{}, p...)
+			break
+		}
+		r, size := utf8.DecodeRune(p)
+		p = p[size:]
+		c.size++
+		if r != '\n' && unicode.IsSpace(r) {
+			c.words++
+		}
+	}
+	return n, nil
+}
+
+// done counts anything that's left over, once the whole body's been
+// written
+func (c *bodyCounts) done() *bodyCounts {
+	c.size += int64(len(c.pending))
+	c.pending = nil
+	return c
+}
@@ -0,0 +1,58 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyCounts(t *testing.T) {
+	body := "héllo wörld\nこんにちは　世界\nend"
+	cases := []struct {
+		runes              bool
+		size, words, lines int64
+	}{
+		{false, int64(len(body)), 2, 3},
+		{true, 24, 3, 3},
+	}
+	for _, c := range cases {
+		// split in the middle of characters, to check they're put back together
+		counts := newBodyCounts(c.runes)
+		for i := 0; i < len(body); i += 5 {
+			end := i + 5
+			if end > len(body) {
+				end = len(body)
+			}
+			counts.Write([]byte(body[i:end]))
+		}
+		counts.done()
+
+		if counts.size != c.size || counts.words != c.words || counts.lines != c.lines {
+			t.Errorf("runes %t: want %d/%d/%d, have %d/%d/%d", c.runes, c.size, c.words, c.lines, counts.size, counts.words, counts.lines)
+		}
+	}
+
+	// a character cut off at the end counts byte by byte
+	counts := newBodyCounts(true)
+	counts.Write([]byte("ab\xe3\x81"))
+	if counts.done().size != 4 {
+		t.Errorf("want 4 for a cut off character, have %d", counts.size)
+	}
+}
+
+func TestTruncatedCounts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a b\n", 25)))
+	}))
+	defer srv.Close()
+
+	// the words and lines are for the whole body, like the size
+	j := newJob(srv.URL, "GET", "", nil)
+	if !buildRequestHook(j) || !fetchHook(srv.Client(), bodyLimits{truncateAt: 10})(j) {
+		t.Fatal(j.err)
+	}
+	if bodySize(j) != 100 || bodyWords(j) != 26 || bodyLines(j) != 26 {
+		t.Errorf("want 100/26/26, have %d/%d/%d", bodySize(j), bodyWords(j), bodyLines(j))
+	}
+}
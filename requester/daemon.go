@@ -0,0 +1,63 @@
+package requester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The bits that make fff behave under systemd and the like when it's
+// left running (with --grpc, or as fff serve): a PID file and a health
+// endpoint. Reloading on a SIGHUP is up to the fff command.
+
+// WritePIDFile writes our PID to the file, and returns a function that
+// removes it again. A PID file left behind by a process that's still
+// running is an error, but one left by a process that's died is replaced.
+func WritePIDFile(path string) (func(), error) {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("%s says fff is already running as pid %d", path, pid)
+		}
+	}
+
+	err := writeFileAtomic(path, []byte(strconv.Itoa(os.Getpid())+"\n"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %s", err)
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// HealthHandler answers GET /health, saying we're up, for how long, and
+// whatever status returns, in a line of JSON
+func HealthHandler(status func() interface{}) http.Handler {
+	start := time.Now()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Status string      `json:"status"`
+			PID    int         `json:"pid"`
+			Uptime float64     `json:"uptime_seconds"`
+			Info   interface{} `json:"info,omitempty"`
+		}{"ok", os.Getpid(), time.Since(start).Seconds(), status()})
+	})
+	return mux
+}
+
+// SignalExitCode returns the exit status for being stopped by the signal,
+// which is what a shell would report if it had killed us: 130 for an
+// interrupt and 143 for SIGTERM
+func SignalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 130
+}
@@ -0,0 +1,56 @@
+package requester
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fff.pid")
+
+	// one left behind by a process that's gone is replaced
+	ioutil.WriteFile(path, []byte("999999999\n"), 0644)
+	remove, err := WritePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("want our pid in the file, have %q", data)
+	}
+	remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("want the file removed")
+	}
+
+	// one for a process that's running isn't
+	ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0644)
+	if _, err := WritePIDFile(path); err == nil {
+		t.Error("want an error for a running process")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	h := HealthHandler(func() interface{} { return map[string]int{"requests": 3} })
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+
+	var v struct {
+		Status string
+		PID    int
+		Info   map[string]int
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != "ok" || v.PID != os.Getpid() || v.Info["requests"] != 3 {
+		t.Errorf("want ok, our pid and the info, have %+v", v)
+	}
+}
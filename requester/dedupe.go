@@ -0,0 +1,60 @@
+package requester
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// bodyDeduper handles --dedupe. A response with the same status and body
+// as one that's already come back is dropped before it's saved or
+// printed, which gets rid of the thousands of copies of a CDN's error
+// page. Empty bodies are never dropped, because responses like redirects
+// are told apart by their headers. With perHost, bodies are only compared
+// with others from the same host.
+type bodyDeduper struct {
+	perHost bool
+
+	mu      sync.Mutex
+	seen    map[string]bool
+	dropped int
+}
+
+func newBodyDeduper(perHost bool) *bodyDeduper {
+	return &bodyDeduper{perHost: perHost, seen: make(map[string]bool)}
+}
+
+// hook goes at the end of the filter stage, so that only responses that
+// would otherwise have been kept count
+func (d *bodyDeduper) hook(j *job) bool {
+	if len(j.respBody) == 0 {
+		return true
+	}
+
+	sum := sha1.Sum(j.respBody)
+	key := strconv.Itoa(j.resp.StatusCode) + " " + string(sum[:])
+	if d.perHost {
+		key = j.req.URL.Host + " " + key
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.seen[key] {
+		d.dropped++
+		return false
+	}
+	d.seen[key] = true
+	return true
+}
+
+func (d *bodyDeduper) print(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dropped > 0 {
+		fmt.Fprintf(w, "%d responses dropped with bodies that had been seen already (--dedupe)\n", d.dropped)
+	}
+}
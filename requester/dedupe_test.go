@@ -0,0 +1,40 @@
+package requester
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBodyDeduper(t *testing.T) {
+	page := func(rawURL string, status int, body string) *job {
+		j := newJob(rawURL, "GET", "", nil)
+		buildRequestHook(j)
+		j.resp = &http.Response{StatusCode: status}
+		j.respBody = []byte(body)
+		return j
+	}
+
+	jobs := []*job{
+		page("http://a.example.com/", 404, "not found"),
+		page("http://b.example.com/", 404, "not found"),
+		page("http://a.example.com/x", 404, "not found"),
+		page("http://b.example.com/x", 200, "not found"),
+		page("http://a.example.com/r", 301, ""),
+		page("http://a.example.com/s", 301, ""),
+	}
+
+	for _, c := range []struct {
+		perHost bool
+		want    []bool
+	}{
+		{false, []bool{true, false, false, true, true, true}},
+		{true, []bool{true, true, false, true, true, true}},
+	} {
+		d := newBodyDeduper(c.perHost)
+		for i, j := range jobs {
+			if have := d.hook(j); have != c.want[i] {
+				t.Errorf("per host %t, job %d: want %t, have %t", c.perHost, i, c.want[i], have)
+			}
+		}
+	}
+}
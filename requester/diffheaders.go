@@ -0,0 +1,55 @@
+package requester
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// compareResponses returns the ways a response differs from another one
+// meaningfully: its status, its size (by more than 10%) or where it
+// redirects to
+func compareResponses(resp *http.Response, size int, other schemeResult) []string {
+	if other.err != nil {
+		return []string{"error"}
+	}
+
+	var diffs []string
+	if resp.StatusCode != other.status {
+		diffs = append(diffs, "status")
+	}
+	if sizesDiffer(size, other.size) {
+		diffs = append(diffs, "size")
+	}
+	if resp.Header.Get("Location") != other.location {
+		diffs = append(diffs, "location")
+	}
+	return diffs
+}
+
+// diffHeadersHook handles --diff-headers, making every request a second
+// time with the extra headers (a session cookie, say) and comparing the
+// response with the original, baseline one. Responses that differ are
+// tagged header-diff, which is what to look for when testing access
+// control. Like mirrorHook it goes at the end of the fetch stage.
+func diffHeadersHook(client *http.Client, limits bodyLimits, extra []string) hook {
+	return func(j *job) bool {
+		body, err := requestBody(j.req)
+		if err != nil {
+			j.err = err
+			return false
+		}
+
+		headers := mergeHeaders(headerLines(j.req.Header), extra)
+		d := fetchSummary(withPins(context.Background(), j.pins), client, limits, j.method, j.req.URL.String(), body, headers)
+
+		j.meta = append(j.meta, "diff-headers: "+strings.Join(extra, ", ")+" "+d.String())
+
+		diffs := compareResponses(j.resp, int(j.resp.ContentLength), d)
+		if len(diffs) > 0 {
+			j.tag("header-diff")
+			j.meta = append(j.meta, "header-diff: "+strings.Join(diffs, " "))
+		}
+		return true
+	}
+}
@@ -0,0 +1,38 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestDiffHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin" && r.Header.Get("Cookie") != "admin=1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("the same for everyone"))
+	}))
+	defer srv.Close()
+
+	client := newClient(false, "")
+	diff := diffHeadersHook(client, bodyLimits{}, []string{"Cookie: admin=1"})
+
+	cases := map[string][]string{
+		"/public": nil,
+		"/admin":  {"header-diff"},
+	}
+
+	for path, want := range cases {
+		j := newJob(srv.URL+path, "GET", "", nil)
+		if !buildRequestHook(j) || !fetchHook(client, bodyLimits{})(j) || !diff(j) {
+			t.Fatal(j.err)
+		}
+
+		if have := j.res.Tags; !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: want tags %v, have %v", path, want, j.res.Tags)
+		}
+	}
+}
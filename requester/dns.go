@@ -0,0 +1,279 @@
+package requester
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsRecorder keeps the DNS answers seen for each host during the run.
+// Rather than making lookups of its own it watches the ones the client
+// makes anyway (see recordDNS), so it's free. CNAME chains are what we're
+// really after: a CNAME pointing at an unclaimed bucket or app is the
+// classic subdomain takeover.
+type dnsRecorder struct {
+	mu      sync.Mutex
+	answers map[string][]string
+}
+
+func newDNSRecorder() *dnsRecorder {
+	return &dnsRecorder{answers: make(map[string][]string)}
+}
+
+// recordDNS makes the client resolve hosts with Go's own resolver and
+// records the answers to every query it makes. Nothing is recorded when
+// requests go through a proxy, because the proxy does the lookups.
+func recordDNS(client *http.Client) *dnsRecorder {
+	rec := newDNSRecorder()
+
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return rec
+	}
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+
+			// the resolver treats packet conns differently, so we
+			// have to keep being one if we were given one
+			if _, ok := conn.(net.PacketConn); ok {
+				return &dnsPacketConn{dnsConn{Conn: conn, rec: rec}}, nil
+			}
+			return &dnsConn{Conn: conn, rec: rec, stream: true}, nil
+		},
+	}
+
+	// the connect timeout is left to setTimeouts, which wraps this
+	tr.DialContext = (&net.Dialer{
+		KeepAlive:      time.Second,
+		FallbackDelay:  fallbackDelay,
+		Resolver:       resolver,
+		ControlContext: dialControl,
+	}).DialContext
+
+	return rec
+}
+
+// Answers returns the records seen for a host, in zone file format
+func (d *dnsRecorder) Answers(host string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.answers[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+// add records the answers in a DNS response
+func (d *dnsRecorder) add(msg []byte) {
+	question, records, err := parseDNSResponse(msg)
+	if err != nil || question == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the same host is looked up for every new connection, so only keep
+	// records we haven't already got
+	existing := d.answers[question]
+outer:
+	for _, r := range records {
+		for _, e := range existing {
+			if e == r {
+				continue outer
+			}
+		}
+		existing = append(existing, r)
+	}
+	d.answers[question] = existing
+}
+
+// dnsConn passes everything read from a connection to a DNS server on to
+// the recorder. Over TCP (stream) each message has a two byte length
+// prefix and can be split across reads.
+type dnsConn struct {
+	net.Conn
+	rec *dnsRecorder
+
+	stream bool
+	buf    []byte
+}
+
+func (c *dnsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.received(b[:n])
+	}
+	return n, err
+}
+
+func (c *dnsConn) received(b []byte) {
+	if !c.stream {
+		c.rec.add(b)
+		return
+	}
+
+	c.buf = append(c.buf, b...)
+	for len(c.buf) >= 2 {
+		l := int(binary.BigEndian.Uint16(c.buf))
+		if len(c.buf) < 2+l {
+			return
+		}
+		c.rec.add(c.buf[2 : 2+l])
+		c.buf = c.buf[2+l:]
+	}
+}
+
+type dnsPacketConn struct {
+	dnsConn
+}
+
+func (c *dnsPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.Conn.(net.PacketConn).ReadFrom(b)
+	if n > 0 {
+		c.received(b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *dnsPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Conn.(net.PacketConn).WriteTo(b, addr)
+}
+
+// DNS record types we know the names of
+var dnsTypes = map[uint16]string{
+	1:  "A",
+	2:  "NS",
+	5:  "CNAME",
+	6:  "SOA",
+	12: "PTR",
+	15: "MX",
+	16: "TXT",
+	28: "AAAA",
+}
+
+// parseDNSResponse returns the name that was asked about and the records
+// in the answer section of a DNS response, e.g.
+//
+//	www.example.com. CNAME example.herokuapp.com.
+//	example.herokuapp.com. A 192.0.2.1
+func parseDNSResponse(msg []byte) (string, []string, error) {
+	if len(msg) < 12 {
+		return "", nil, errors.New("short DNS message")
+	}
+
+	// it has to be a response
+	if msg[2]&0x80 == 0 {
+		return "", nil, errors.New("not a DNS response")
+	}
+
+	qdcount := binary.BigEndian.Uint16(msg[4:])
+	ancount := binary.BigEndian.Uint16(msg[6:])
+
+	off := 12
+	var question string
+	for i := 0; i < int(qdcount); i++ {
+		name, n, err := readDNSName(msg, off)
+		if err != nil {
+			return "", nil, err
+		}
+		if i == 0 {
+			question = strings.ToLower(strings.TrimSuffix(name, "."))
+		}
+		off = n + 4
+	}
+
+	var records []string
+	for i := 0; i < int(ancount); i++ {
+		name, n, err := readDNSName(msg, off)
+		if err != nil {
+			return "", nil, err
+		}
+		off = n
+		if off+10 > len(msg) {
+			return "", nil, errors.New("short DNS record")
+		}
+
+		typ := binary.BigEndian.Uint16(msg[off:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return "", nil, errors.New("short DNS record data")
+		}
+		rdata := msg[off : off+rdlen]
+
+		var value string
+		switch typ {
+		case 1, 28:
+			value = net.IP(rdata).String()
+		case 2, 5, 12:
+			value, _, err = readDNSName(msg, off)
+			if err != nil {
+				return "", nil, err
+			}
+		default:
+			value = fmt.Sprintf("(%d bytes)", rdlen)
+		}
+
+		typeName, ok := dnsTypes[typ]
+		if !ok {
+			typeName = fmt.Sprintf("TYPE%d", typ)
+		}
+
+		records = append(records, fmt.Sprintf("%s %s %s", strings.ToLower(name), typeName, value))
+		off += rdlen
+	}
+
+	return question, records, nil
+}
+
+// readDNSName reads a possibly compressed name from msg, returning it and
+// the offset just past it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+
+	// a limit on labels and pointers followed guards against loops
+	for jumps := 0; jumps < 128; jumps++ {
+		if off >= len(msg) {
+			return "", 0, errors.New("DNS name out of range")
+		}
+
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("DNS name out of range")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("DNS name out of range")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+
+	return "", 0, errors.New("too many DNS name pointers")
+}
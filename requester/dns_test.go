@@ -0,0 +1,55 @@
+package requester
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDNSResponse(t *testing.T) {
+	msg := []byte{
+		// header: response with one question and two answers
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 0,
+
+		// question: www.example.com A IN
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, 1, 0, 1,
+
+		// www.example.com (pointer) CNAME app.example.com (partly a pointer)
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 6,
+		3, 'a', 'p', 'p', 0xc0, 16,
+
+		// app.example.com (pointer) A 192.0.2.1
+		0xc0, 45, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4,
+		192, 0, 2, 1,
+	}
+
+	question, records, err := parseDNSResponse(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if question != "www.example.com" {
+		t.Errorf("want question www.example.com, have %q", question)
+	}
+
+	want := []string{
+		"www.example.com. CNAME app.example.com.",
+		"app.example.com. A 192.0.2.1",
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("want %q, have %q", want, records)
+	}
+
+	// truncated messages should be errors rather than panics
+	for i := 0; i < len(msg); i++ {
+		if _, _, err := parseDNSResponse(msg[:i]); err == nil {
+			t.Errorf("no error for message truncated to %d bytes", i)
+		}
+	}
+
+	// a name that points to itself mustn't loop forever
+	loop := append(msg[:12:12], 0xc0, 12, 0, 1, 0, 1)
+	if _, _, err := parseDNSResponse(loop); err == nil {
+		t.Error("no error for name pointer loop")
+	}
+}
@@ -0,0 +1,206 @@
+package requester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// emitFormats are the formats --emit can write results in, so that what
+// makes it through the filters can go straight into the next tool:
+//
+//	nuclei-targets  one URL per line, for nuclei -l (or anything else)
+//	httpx           a line of JSON per result, like httpx -json writes
+//	burp-scope      a Burp Suite project options file, with each scheme,
+//	                host and port in the target scope; it's written at
+//	                the end of the run
+var emitFormats = []string{"nuclei-targets", "httpx", "burp-scope"}
+
+// emitter writes results for --emit. Only results go to w, so that it can
+// be piped; errors go to log.
+type emitter struct {
+	format string
+	w      io.Writer
+	log    io.Writer
+
+	// the scope entries for burp-scope, by their key
+	mu    sync.Mutex
+	scope map[string]burpScopeEntry
+}
+
+func newEmitter(format string, w, log io.Writer) (*emitter, error) {
+	for _, f := range emitFormats {
+		if f == format {
+			return &emitter{format: format, w: w, log: log, scope: make(map[string]burpScopeEntry)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown --emit format %q; want one of %s", format, strings.Join(emitFormats, ", "))
+}
+
+// hook writes the result, or remembers it for the end of the run. It goes
+// in the report stage.
+func (e *emitter) hook(j *job) bool {
+	r := j.res
+
+	switch e.format {
+	case "nuclei-targets":
+		fmt.Fprintf(e.w, "%s\n", r.URL)
+
+	case "httpx":
+		line, err := json.Marshal(httpxResult(r, time.Now()))
+		if err != nil {
+			fmt.Fprintf(e.log, "failed to encode result: %s\n", err)
+			return true
+		}
+		fmt.Fprintf(e.w, "%s\n", line)
+
+	case "burp-scope":
+		entry, ok := newBurpScopeEntry(r.URL)
+		if !ok {
+			return true
+		}
+		e.mu.Lock()
+		e.scope[entry.Protocol+" "+entry.Host+" "+entry.Port] = entry
+		e.mu.Unlock()
+	}
+	return true
+}
+
+// errorHook reports failed requests on log
+func (e *emitter) errorHook(j *job) {
+	fmt.Fprintf(e.log, "%s: %s\n", j.url, j.err)
+}
+
+// finish writes anything that has to wait for the end of the run
+func (e *emitter) finish() error {
+	if e.format != "burp-scope" {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var keys []string
+	for k := range e.scope {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	include := []burpScopeEntry{}
+	for _, k := range keys {
+		include = append(include, e.scope[k])
+	}
+
+	var doc struct {
+		Target struct {
+			Scope struct {
+				AdvancedMode bool             `json:"advanced_mode"`
+				Exclude      []burpScopeEntry `json:"exclude"`
+				Include      []burpScopeEntry `json:"include"`
+			} `json:"scope"`
+		} `json:"target"`
+	}
+	doc.Target.Scope.AdvancedMode = true
+	doc.Target.Scope.Exclude = []burpScopeEntry{}
+	doc.Target.Scope.Include = include
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "%s\n", out)
+	return err
+}
+
+// httpxJSON has the fields from httpx -json that we know the values of
+type httpxJSON struct {
+	Timestamp     string   `json:"timestamp"`
+	Input         string   `json:"input"`
+	URL           string   `json:"url"`
+	Scheme        string   `json:"scheme"`
+	Host          string   `json:"host"`
+	Port          string   `json:"port"`
+	Path          string   `json:"path"`
+	Method        string   `json:"method"`
+	StatusCode    int      `json:"status_code"`
+	ContentLength int64    `json:"content_length"`
+	ContentType   string   `json:"content_type"`
+	Words         int      `json:"words"`
+	Lines         int      `json:"lines"`
+	Location      string   `json:"location,omitempty"`
+	Chain         []string `json:"chain,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Screenshot    string   `json:"screenshot_path,omitempty"`
+}
+
+func httpxResult(r Result, now time.Time) httpxJSON {
+	h := httpxJSON{
+		Timestamp:     now.UTC().Format(time.RFC3339),
+		Input:         r.Input,
+		URL:           r.URL,
+		Method:        r.Method,
+		StatusCode:    r.Status,
+		ContentLength: r.Size,
+		ContentType:   r.ContentType,
+		Words:         r.Words,
+		Lines:         r.Lines,
+		Location:      r.Location,
+		Chain:         r.Redirects,
+		Tags:          r.Tags,
+		Screenshot:    r.Screenshot,
+	}
+
+	// httpx leaves the parameters off the content type
+	if i := strings.IndexByte(h.ContentType, ';'); i != -1 {
+		h.ContentType = strings.TrimSpace(h.ContentType[:i])
+	}
+
+	if u, err := url.Parse(r.URL); err == nil {
+		h.Scheme, h.Host, h.Port, h.Path = u.Scheme, u.Hostname(), urlPort(u), u.EscapedPath()
+	}
+	return h
+}
+
+// burpScopeEntry is an advanced mode entry in Burp's target scope, where
+// everything's a regex
+type burpScopeEntry struct {
+	Enabled  bool   `json:"enabled"`
+	File     string `json:"file"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// newBurpScopeEntry returns the scope entry covering every path on the
+// URL's scheme, host and port
+func newBurpScopeEntry(rawURL string) (burpScopeEntry, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return burpScopeEntry{}, false
+	}
+
+	return burpScopeEntry{
+		Enabled:  true,
+		File:     "^/.*",
+		Host:     "^" + regexp.QuoteMeta(u.Hostname()) + "$",
+		Port:     "^" + urlPort(u) + "$",
+		Protocol: u.Scheme,
+	}, true
+}
+
+// urlPort returns the URL's port, or the default one for its scheme
+func urlPort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,62 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEmitter(t *testing.T) {
+	results := []Result{
+		{Input: "example.com", URL: "https://example.com/a", Method: "GET", Status: 200, Size: 10, ContentType: "text/html; charset=utf-8"},
+		{URL: "https://example.com/b", Status: 404},
+		{URL: "http://example.com:8080/", Status: 302, Location: "/login"},
+	}
+	emit := func(format string) string {
+		var buf bytes.Buffer
+		e, err := newEmitter(format, &buf, ioutil.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			e.hook(&job{res: r})
+		}
+		e.finish()
+		return buf.String()
+	}
+
+	if have := emit("nuclei-targets"); have != "https://example.com/a\nhttps://example.com/b\nhttp://example.com:8080/\n" {
+		t.Errorf("unexpected nuclei targets %q", have)
+	}
+
+	lines := strings.Split(strings.TrimSpace(emit("httpx")), "\n")
+	var h httpxJSON
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[0]), &h) != nil {
+		t.Fatalf("want 3 lines of JSON, have %q", lines)
+	}
+	if h.Host != "example.com" || h.Port != "443" || h.ContentType != "text/html" || h.Input != "example.com" || h.Path != "/a" {
+		t.Errorf("unexpected httpx result %+v", h)
+	}
+
+	// one scope entry per scheme, host and port
+	var doc struct {
+		Target struct {
+			Scope struct {
+				Include []burpScopeEntry `json:"include"`
+			} `json:"scope"`
+		} `json:"target"`
+	}
+	if err := json.Unmarshal([]byte(emit("burp-scope")), &doc); err != nil {
+		t.Fatal(err)
+	}
+	include := doc.Target.Scope.Include
+	if len(include) != 2 || include[0].Host != `^example\.com$` || include[0].Port != "^8080$" || include[1].Protocol != "https" {
+		t.Errorf("unexpected scope %+v", include)
+	}
+
+	if _, err := newEmitter("nope", nil, nil); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+}
@@ -0,0 +1,97 @@
+package requester
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwarder streams results to a remote listener as they're produced, one
+// per line. The connection is made lazily and re-made if it's lost.
+type forwarder struct {
+	addr     string
+	useTLS   bool
+	urlsOnly bool
+
+	// where failures to send results are reported
+	log io.Writer
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newForwarder returns a forwarder for addr, which is host:port for plain
+// TCP or tls://host:port for TLS. With urlsOnly set just the URL of each
+// result is sent, so the listener could be another fff.
+func newForwarder(addr string, urlsOnly bool, log io.Writer) *forwarder {
+	f := &forwarder{addr: addr, urlsOnly: urlsOnly, log: log}
+	if strings.HasPrefix(addr, "tls://") {
+		f.addr = strings.TrimPrefix(addr, "tls://")
+		f.useTLS = true
+	}
+	f.addr = strings.TrimPrefix(f.addr, "tcp://")
+	return f
+}
+
+func (f *forwarder) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: time.Second * 10}
+	if f.useTLS {
+		return tls.DialWithDialer(d, "tcp", f.addr, &tls.Config{InsecureSkipVerify: true})
+	}
+	return d.Dial("tcp", f.addr)
+}
+
+// Send writes a result to the listener, reconnecting once if the write
+// fails. Failures are reported but don't stop the run.
+func (f *forwarder) Send(r Result) {
+	var line []byte
+	if f.urlsOnly {
+		line = []byte(r.URL)
+	} else {
+		var err error
+		line, err = json.Marshal(r)
+		if err != nil {
+			fmt.Fprintf(f.log, "failed to encode result: %s\n", err)
+			return
+		}
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if f.conn == nil {
+			conn, err := f.dial()
+			if err != nil {
+				fmt.Fprintf(f.log, "failed to connect to %s: %s\n", f.addr, err)
+				return
+			}
+			f.conn = conn
+		}
+
+		if _, err := f.conn.Write(line); err == nil {
+			return
+		}
+
+		f.conn.Close()
+		f.conn = nil
+	}
+
+	fmt.Fprintf(f.log, "failed to forward result for %s\n", r.URL)
+}
+
+func (f *forwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
@@ -0,0 +1,138 @@
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forwardListener accepts connections and sends each line it reads on
+// the returned channel, with the number of the connection it came on.
+// With dropFirst set the first connection's closed after one line.
+func forwardListener(t *testing.T, dropFirst bool) (net.Listener, <-chan [2]string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	lines := make(chan [2]string, 10)
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, n int) {
+				defer conn.Close()
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					lines <- [2]string{string(rune('0' + n)), sc.Text()}
+					if dropFirst && n == 1 {
+						return
+					}
+				}
+			}(conn, n)
+		}
+	}()
+	return l, lines
+}
+
+func nextForwarded(t *testing.T, lines <-chan [2]string) [2]string {
+	select {
+	case l := <-lines:
+		return l
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a forwarded result")
+		return [2]string{}
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	l, lines := forwardListener(t, false)
+
+	f := newForwarder("tcp://"+l.Addr().String(), false, ioutil.Discard)
+	if f.addr != l.Addr().String() || f.useTLS {
+		t.Errorf("want a plain TCP connection to %s, have %s (TLS %t)", l.Addr(), f.addr, f.useTLS)
+	}
+	defer f.Close()
+
+	// results are streamed over the one connection, a line of JSON each
+	for _, u := range []string{"http://example.com/a", "http://example.com/b"} {
+		f.Send(Result{URL: u, Status: 200})
+	}
+	for _, want := range []string{"http://example.com/a", "http://example.com/b"} {
+		got := nextForwarded(t, lines)
+		var res Result
+		if err := json.Unmarshal([]byte(got[1]), &res); err != nil || res.URL != want || res.Status != 200 {
+			t.Errorf("want %s as JSON, have %q (%v)", want, got[1], err)
+		}
+		if got[0] != "1" {
+			t.Errorf("want everything on the first connection, have connection %s", got[0])
+		}
+	}
+}
+
+func TestForwarderReconnect(t *testing.T) {
+	l, lines := forwardListener(t, true)
+
+	var log bytes.Buffer
+	f := newForwarder(l.Addr().String(), false, &log)
+	defer f.Close()
+
+	f.Send(Result{URL: "http://example.com/a"})
+	if got := nextForwarded(t, lines); got[0] != "1" {
+		t.Fatalf("want the first result on the first connection, have %q on %s", got[1], got[0])
+	}
+
+	// the listener's hung up; a write or two can still look like it
+	// worked before the connection's found to be gone, but then it's made
+	// again
+	for i := 0; i < 20; i++ {
+		f.Send(Result{URL: "http://example.com/again"})
+		select {
+		case got := <-lines:
+			if got[0] != "2" || !strings.Contains(got[1], "http://example.com/again") {
+				t.Errorf("want the result on a new connection, have %q on %s", got[1], got[0])
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	t.Errorf("want the forwarder to reconnect, have nothing (%s)", log.String())
+}
+
+func TestForwarderURLs(t *testing.T) {
+	l, lines := forwardListener(t, false)
+
+	f := newForwarder(l.Addr().String(), true, ioutil.Discard)
+	defer f.Close()
+
+	f.Send(Result{URL: "http://example.com/a", Status: 404})
+	if got := nextForwarded(t, lines); got[1] != "http://example.com/a" {
+		t.Errorf("want just the URL, have %q", got[1])
+	}
+}
+
+func TestForwarderUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var log bytes.Buffer
+	f := newForwarder(addr, false, &log)
+	f.Send(Result{URL: "http://example.com/"})
+	f.Close()
+
+	if !strings.Contains(log.String(), "failed to connect to "+addr) {
+		t.Errorf("want the failure logged, have %q", log.String())
+	}
+}
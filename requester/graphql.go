@@ -0,0 +1,158 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// introspectionQuery is what --graphql sends by default. It asks for
+// enough of the schema to rebuild it with the usual tools.
+const introspectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+
+fragment FullType on __Type {
+  kind name description
+  fields(includeDeprecated: true) {
+    name description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+
+fragment InputValue on __InputValue {
+  name description
+  type { ...TypeRef }
+  defaultValue
+}
+
+fragment TypeRef on __Type {
+  kind name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
+
+// graphqlBody returns the request body for a query. A query starting
+// with @ is read from that file.
+func graphqlBody(query string) (string, error) {
+	if query == "" {
+		query = introspectionQuery
+	}
+
+	if strings.HasPrefix(query, "@") {
+		b, err := ioutil.ReadFile(query[1:])
+		if err != nil {
+			return "", err
+		}
+		query = string(b)
+	}
+
+	b, err := json.Marshal(map[string]string{"query": query})
+	return string(b), err
+}
+
+// graphqlResponse is just enough of a GraphQL response to recognise one
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// parseGraphQL returns the response if the body looks like a GraphQL
+// response: a JSON object with data, or a list of errors, or both
+func parseGraphQL(body []byte) (*graphqlResponse, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return nil, false
+	}
+
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) != nil {
+		return nil, false
+	}
+
+	var r graphqlResponse
+	if json.Unmarshal(body, &r) != nil {
+		return nil, false
+	}
+
+	_, hasData := raw["data"]
+	if !hasData && len(r.Errors) == 0 {
+		return nil, false
+	}
+	return &r, true
+}
+
+// schema returns the result of an introspection query, if that's what
+// the response has in it
+func (r *graphqlResponse) schema() json.RawMessage {
+	var data struct {
+		Schema json.RawMessage `json:"__schema"`
+	}
+	if json.Unmarshal(r.Data, &data) != nil || len(data.Schema) == 0 || string(data.Schema) == "null" {
+		return nil
+	}
+	return data.Schema
+}
+
+// graphqlHook tags GraphQL responses with graphql, and ones that answer
+// an introspection query with introspection too. It goes in the enrich
+// stage. With schemaDir set, the introspection results are also saved
+// there, in a file for each endpoint: schemaDir/host/path/schema.json.
+func graphqlHook(schemaDir string) hook {
+	return func(j *job) bool {
+		r, ok := parseGraphQL(j.respBody)
+		if !ok {
+			return true
+		}
+		j.tag("graphql")
+
+		if len(r.Errors) > 0 {
+			j.meta = append(j.meta, fmt.Sprintf("graphql-errors: %d (%s)", len(r.Errors), r.Errors[0].Message))
+		}
+
+		schema := r.schema()
+		if schema == nil {
+			return true
+		}
+		j.tag("introspection")
+
+		if schemaDir == "" {
+			return true
+		}
+
+		host, err := hostToASCII(j.req.URL.Hostname())
+		if err != nil {
+			j.err = err
+			return false
+		}
+
+		p := path.Join(schemaDir, host, normalisePath(j.req.URL), "schema.json")
+		err = os.MkdirAll(path.Dir(p), 0750)
+		if err == nil {
+			err = ioutil.WriteFile(p, schema, 0644)
+		}
+		if err != nil {
+			j.err = fmt.Errorf("failed to save schema: %s", err)
+			return false
+		}
+		j.meta = append(j.meta, "graphql-schema: "+p)
+		return true
+	}
+}
@@ -0,0 +1,62 @@
+package requester
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGraphQL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Query string }
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch {
+		case r.URL.Path == "/rest":
+			w.Write([]byte(`{"users": []}`))
+		case r.URL.Path == "/locked":
+			w.Write([]byte(`{"errors": [{"message": "introspection is disabled"}]}`))
+		case strings.Contains(req.Query, "__schema"):
+			w.Write([]byte(`{"data": {"__schema": {"types": []}}}`))
+		}
+	}))
+	defer srv.Close()
+
+	body, err := graphqlBody("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	client := newClient(false, "")
+	gql := graphqlHook(dir)
+
+	cases := map[string][]string{
+		"/graphql": {"graphql", "introspection"},
+		"/locked":  {"graphql"},
+		"/rest":    nil,
+	}
+
+	for path, want := range cases {
+		j := newJob(srv.URL+path, "POST", body, nil)
+		if !buildRequestHook(j) || !fetchHook(client, bodyLimits{})(j) || !gql(j) {
+			t.Fatal(j.err)
+		}
+		if !reflect.DeepEqual(j.res.Tags, want) {
+			t.Errorf("%s: want tags %v, have %v", path, want, j.res.Tags)
+		}
+	}
+
+	schema, err := ioutil.ReadFile(filepath.Join(dir, "127.0.0.1", "graphql", "schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(schema) != `{"types": []}` {
+		t.Errorf("unexpected schema: %s", schema)
+	}
+}
@@ -0,0 +1,120 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// groupedOutput handles --group-by. Instead of printing each line as it
+// comes, it keeps them until the end of the run and prints them grouped
+// by host or by status, with each group sorted by URL. That's easier to
+// read than lines in whatever order the responses came back, but it's no
+// use in a pipe, so results still go to -o, --forward and the like
+// straight away.
+type groupedOutput struct {
+	by string
+
+	// how a result or an error is printed
+	format      func(r Result) string
+	formatError func(j *job) string
+
+	// headings go before each group, unless the lines are JSON
+	headings bool
+
+	mu    sync.Mutex
+	lines []groupedLine
+}
+
+type groupedLine struct {
+	group string
+	url   string
+	line  string
+
+	// statuses sort as numbers, with errors (0) at the end
+	status int
+}
+
+func newGroupedOutput(by string, jsonMode bool) (*groupedOutput, error) {
+	if by != "host" && by != "status" {
+		return nil, fmt.Errorf("unknown --group-by %q; want host or status", by)
+	}
+
+	g := &groupedOutput{by: by, format: formatResult, formatError: formatError, headings: true}
+	if jsonMode {
+		g.format, g.formatError, g.headings = formatResultJSON, formatErrorJSON, false
+	}
+	return g, nil
+}
+
+// hook keeps the result's line for the end. It goes in the report stage in
+// place of the one that prints to stdout.
+func (g *groupedOutput) hook(j *job) bool {
+	g.add(j.res.URL, j.res.Status, g.format(j.res))
+	return true
+}
+
+func (g *groupedOutput) errorHook(j *job) {
+	g.add(j.url, 0, g.formatError(j))
+}
+
+func (g *groupedOutput) add(rawURL string, status int, line string) {
+	if line == "" {
+		return
+	}
+
+	l := groupedLine{url: rawURL, status: status, line: line}
+	switch {
+	case g.by == "status" && status == 0:
+		l.group = "error"
+	case g.by == "status":
+		l.group = strconv.Itoa(status)
+	default:
+		l.group = rawURL
+		if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+			l.group = u.Host
+		}
+	}
+
+	g.mu.Lock()
+	g.lines = append(g.lines, l)
+	g.mu.Unlock()
+}
+
+// print writes out the groups, each with a heading saying how many lines
+// are in it
+func (g *groupedOutput) print(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sort.SliceStable(g.lines, func(i, j int) bool {
+		a, b := g.lines[i], g.lines[j]
+		if a.group != b.group {
+			if g.by == "status" && (a.status == 0) != (b.status == 0) {
+				return b.status == 0
+			}
+			if g.by == "status" {
+				return a.status < b.status
+			}
+			return a.group < b.group
+		}
+		return a.url < b.url
+	})
+
+	for i, l := range g.lines {
+		if g.headings && (i == 0 || g.lines[i-1].group != l.group) {
+			n := 1
+			for n < len(g.lines)-i && g.lines[i+n].group == l.group {
+				n++
+			}
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "== %s (%d)\n", l.group, n)
+		}
+		fmt.Fprint(w, l.line)
+	}
+}
@@ -0,0 +1,53 @@
+package requester
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGroupedOutput(t *testing.T) {
+	add := func(g *groupedOutput) {
+		for _, r := range []Result{
+			{URL: "http://b.example.com/2", Status: 404},
+			{URL: "http://a.example.com/", Status: 200},
+			{URL: "http://b.example.com/1", Status: 200},
+		} {
+			g.hook(&job{res: r})
+		}
+		g.errorHook(&job{url: "http://a.example.com/x", input: "http://a.example.com/x", err: errors.New("refused")})
+	}
+	line := func(r string) string { return r + "\n" }
+
+	g, err := newGroupedOutput("host", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.format = func(r Result) string { return line(r.URL) }
+	g.formatError = func(j *job) string { return line(j.url + " error") }
+	add(g)
+
+	var b bytes.Buffer
+	g.print(&b)
+	want := "== a.example.com (2)\nhttp://a.example.com/\nhttp://a.example.com/x error\n\n" +
+		"== b.example.com (2)\nhttp://b.example.com/1\nhttp://b.example.com/2\n"
+	if b.String() != want {
+		t.Errorf("by host: want\n%s\nhave\n%s", want, b.String())
+	}
+
+	g, _ = newGroupedOutput("status", true)
+	g.format = func(r Result) string { return line(r.URL) }
+	g.formatError = func(j *job) string { return line(j.url + " error") }
+	add(g)
+
+	b.Reset()
+	g.print(&b)
+	want = "http://a.example.com/\nhttp://b.example.com/1\nhttp://b.example.com/2\nhttp://a.example.com/x error\n"
+	if b.String() != want {
+		t.Errorf("by status: want\n%s\nhave\n%s", want, b.String())
+	}
+
+	if _, err := newGroupedOutput("size", false); err == nil {
+		t.Error("want an error for an unknown grouping")
+	}
+}
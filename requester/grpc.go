@@ -0,0 +1,294 @@
+package requester
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file implements the Results service described in proto/fff.proto.
+// The messages are small enough that encoding them by hand is simpler than
+// depending on the protobuf and gRPC libraries.
+
+// gRPC status codes
+const (
+	grpcOK            = 0
+	grpcInvalidArg    = 3
+	grpcTooBig        = 8
+	grpcUnimplemented = 12
+	grpcInternal      = 13
+)
+
+// the biggest Submit request that's read, which is the most gRPC clients
+// send by default
+const grpcMaxMessage = 4 << 20
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+type grpcServer struct {
+	mu   sync.Mutex
+	subs map[chan Result]bool
+
+	// submit is called for each URL a client submits; without it, Submit
+	// isn't available
+	submit func(string)
+
+	// backlog, if it's set, gives the results each Stream client is sent
+	// when it connects, before any new ones
+	backlog func() []Result
+}
+
+func newGRPCServer(submit func(string)) *grpcServer {
+	return &grpcServer{
+		subs:   make(map[chan Result]bool),
+		submit: submit,
+	}
+}
+
+// newGRPCHTTPServer returns a server for a gRPC service over cleartext
+// HTTP/2, which is what gRPC clients use for 'insecure' channels
+func newGRPCHTTPServer(h http.Handler) *http.Server {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Server{
+		Handler:   h,
+		Protocols: &protocols,
+	}
+}
+
+// ServeGRPC serves a gRPC service, like the one Replay.GRPC returns, on
+// the listener until it's closed
+func ServeGRPC(l net.Listener, h http.Handler) error {
+	return newGRPCHTTPServer(h).Serve(l)
+}
+
+// Send passes a result to every connected Stream client. Clients that
+// can't keep up miss results rather than slowing down the whole run.
+func (g *grpcServer) Send(r Result) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for ch := range g.subs {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+func (g *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+
+	switch r.URL.Path {
+	case "/fff.Results/Stream":
+		g.stream(w, r)
+	case "/fff.Results/Submit":
+		g.handleSubmit(w, r)
+	default:
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+func (g *grpcServer) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		grpcStatus(w, grpcInternal, "streaming not supported")
+		return
+	}
+
+	ch := make(chan Result, 256)
+	g.mu.Lock()
+	g.subs[ch] = true
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.subs, ch)
+		g.mu.Unlock()
+	}()
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if g.backlog != nil {
+		for _, res := range g.backlog() {
+			if _, err := w.Write(grpcFrame(encodeResult(res))); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case res := <-ch:
+			if _, err := w.Write(grpcFrame(encodeResult(res))); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (g *grpcServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if g.submit == nil {
+		grpcStatus(w, grpcUnimplemented, "nothing's being requested, so URLs can't be submitted")
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, grpcMaxMessage+5))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			grpcStatus(w, grpcTooBig, fmt.Sprintf("messages can be at most %d bytes", grpcMaxMessage))
+			return
+		}
+		grpcStatus(w, grpcInternal, err.Error())
+		return
+	}
+
+	msg, err := readGRPCFrame(body)
+	if err != nil {
+		grpcStatus(w, grpcInvalidArg, err.Error())
+		return
+	}
+
+	urls, err := decodeSubmitRequest(msg)
+	if err != nil {
+		grpcStatus(w, grpcInvalidArg, err.Error())
+		return
+	}
+
+	for _, u := range urls {
+		g.submit(u)
+	}
+
+	var resp []byte
+	resp = appendVarintField(resp, 1, uint64(len(urls)))
+	w.Write(grpcFrame(resp))
+	grpcStatus(w, grpcOK, "")
+}
+
+// grpcStatus sets the status trailers that finish every gRPC response
+func grpcStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+	}
+}
+
+// grpcFrame adds the length prefix for an uncompressed message
+func grpcFrame(msg []byte) []byte {
+	out := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(out[1:], uint32(len(msg)))
+	return append(out, msg...)
+}
+
+func readGRPCFrame(b []byte) ([]byte, error) {
+	if len(b) < 5 {
+		return nil, errors.New("short message")
+	}
+	if b[0] != 0 {
+		return nil, errors.New("compressed messages aren't supported")
+	}
+	l := binary.BigEndian.Uint32(b[1:5])
+	if uint32(len(b)-5) < l {
+		return nil, errors.New("short message")
+	}
+	return b[5 : 5+l], nil
+}
+
+func encodeResult(r Result) []byte {
+	var b []byte
+	b = appendStringField(b, 1, r.URL)
+	b = appendStringField(b, 2, r.Method)
+	b = appendVarintField(b, 3, uint64(r.Status))
+	b = appendVarintField(b, 4, uint64(r.Size))
+	b = appendVarintField(b, 5, uint64(r.Words))
+	b = appendVarintField(b, 6, uint64(r.Lines))
+	b = appendStringField(b, 7, r.ContentType)
+	b = appendStringField(b, 8, r.Location)
+	b = appendStringField(b, 9, r.Path)
+	for _, t := range r.Tags {
+		b = appendStringField(b, 10, t)
+	}
+	b = appendStringField(b, 11, r.Input)
+	for _, rd := range r.Redirects {
+		b = appendStringField(b, 12, rd)
+	}
+	b = appendStringField(b, 13, r.Screenshot)
+	return b
+}
+
+// decodeSubmitRequest returns the URLs from a SubmitRequest, skipping over
+// any fields it doesn't know about
+func decodeSubmitRequest(b []byte) ([]string, error) {
+	var urls []string
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("malformed message")
+		}
+		b = b[n:]
+
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case wireVarint:
+			_, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("malformed message")
+			}
+			b = b[n:]
+
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New("malformed message")
+			}
+			if field == 1 {
+				urls = append(urls, string(b[n:n+int(l)]))
+			}
+			b = b[n+int(l):]
+
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+	}
+	return urls, nil
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(b, v)
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,183 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// grpcTestServer serves g over h2c and returns a client that speaks it
+func grpcTestServer(t *testing.T, g http.Handler) (string, *http.Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeGRPC(l, g)
+	t.Cleanup(func() { l.Close() })
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return "http://" + l.Addr().String(), &http.Client{
+		Transport: &http.Transport{Protocols: &protocols},
+	}
+}
+
+// grpcCall makes a unary call and returns the response message and the
+// status it finished with
+func grpcCall(t *testing.T, c *http.Client, url string, body []byte) ([]byte, string, string) {
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("want HTTP/2, have %s", resp.Proto)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg []byte
+	if len(b) > 0 {
+		msg, err = readGRPCFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msg, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+}
+
+func TestGRPCSubmit(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	g := newGRPCServer(func(u string) {
+		mu.Lock()
+		got = append(got, u)
+		mu.Unlock()
+	})
+	base, c := grpcTestServer(t, g)
+
+	var req []byte
+	req = appendStringField(req, 1, "http://example.com/a")
+	req = appendVarintField(req, 2, 7) // a field that isn't known is skipped
+	req = appendStringField(req, 1, "http://example.com/b")
+
+	msg, status, _ := grpcCall(t, c, base+"/fff.Results/Submit", grpcFrame(req))
+	if status != "0" {
+		t.Fatalf("want status 0, have %q", status)
+	}
+	if want := appendVarintField(nil, 1, 2); !bytes.Equal(msg, want) {
+		t.Errorf("want %x (2 accepted), have %x", want, msg)
+	}
+	if len(got) != 2 || got[0] != "http://example.com/a" || got[1] != "http://example.com/b" {
+		t.Errorf("want both URLs submitted, have %v", got)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	g := newGRPCServer(func(string) {})
+	base, c := grpcTestServer(t, g)
+
+	compressed := grpcFrame(appendStringField(nil, 1, "http://example.com/"))
+	compressed[0] = 1
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		body   []byte
+		status string
+	}{
+		{"unknown method", "/fff.Results/Nope", grpcFrame(nil), "12"},
+		{"short frame", "/fff.Results/Submit", []byte{0, 0, 0}, "3"},
+		{"frame longer than the body", "/fff.Results/Submit", []byte{0, 0, 0, 0, 9, 1}, "3"},
+		{"compressed frame", "/fff.Results/Submit", compressed, "3"},
+		{"truncated field", "/fff.Results/Submit", grpcFrame([]byte{0x0a, 0x10, 'h'}), "3"},
+		{"bad wire type", "/fff.Results/Submit", grpcFrame([]byte{0x0b}), "3"},
+		{"too big", "/fff.Results/Submit", make([]byte, grpcMaxMessage+10), "8"},
+	} {
+		_, status, msg := grpcCall(t, c, base+tt.path, tt.body)
+		if status != tt.status {
+			t.Errorf("%s: want status %s, have %q (%s)", tt.name, tt.status, status, msg)
+		}
+	}
+
+	// without anything being requested, Submit isn't there
+	base, c = grpcTestServer(t, newGRPCServer(nil))
+	if _, status, _ := grpcCall(t, c, base+"/fff.Results/Submit", grpcFrame(nil)); status != "12" {
+		t.Errorf("want status 12 without a submit func, have %q", status)
+	}
+
+	// and it's gRPC only
+	resp, err := c.Get(base + "/fff.Results/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("want 415 for a GET, have %d", resp.StatusCode)
+	}
+}
+
+func TestGRPCStream(t *testing.T) {
+	old := Result{URL: "http://example.com/old", Method: "GET", Status: 200, Tags: []string{"saved"}}
+	g := newGRPCServer(nil)
+	g.backlog = func() []Result { return []Result{old} }
+	base, c := grpcTestServer(t, g)
+
+	req, _ := http.NewRequest("POST", base+"/fff.Results/Stream", bytes.NewReader(grpcFrame(nil)))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	readFrame := func() []byte {
+		head := make([]byte, 5)
+		if _, err := io.ReadFull(resp.Body, head); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(head[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// the backlog comes first, and the client's subscribed by then
+	if msg := readFrame(); !bytes.Equal(msg, encodeResult(old)) {
+		t.Errorf("want the backlog first, have %x", msg)
+	}
+
+	res := Result{URL: "http://example.com/new", Method: "POST", Status: 404, Size: 12, Redirects: []string{"301 http://example.com/"}}
+	g.Send(res)
+	if msg := readFrame(); !bytes.Equal(msg, encodeResult(res)) {
+		t.Errorf("want the new result, have %x", msg)
+	}
+}
+
+func TestEncodeResult(t *testing.T) {
+	b := encodeResult(Result{URL: "http://a/", Status: 200, Tags: []string{"x", "y"}})
+
+	// field 1 (the URL), field 3 (status 200 as a varint), then field 10
+	// twice for the tags
+	want := []byte{0x0a, 9}
+	want = append(want, "http://a/"...)
+	want = append(want, 0x18, 0xc8, 0x01, 0x52, 1, 'x', 0x52, 1, 'y')
+	if !bytes.Equal(b, want) {
+		t.Errorf("want %x, have %x", want, b)
+	}
+
+	// empty fields aren't sent at all
+	if b := encodeResult(Result{}); len(b) != 0 {
+		t.Errorf("want nothing for an empty result, have %x", b)
+	}
+}
@@ -0,0 +1,144 @@
+package requester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// applyHeaders sets each 'Name: value' header on the request. The first
+// time a name comes up it replaces anything the request already has for
+// it, and after that each value is added, so repeating a header sends it
+// more than once. A header with no value ('Name:') is removed, including
+// User-Agent, which Go would otherwise add itself. Go ignores Host in the
+// headers, so it's set on the request instead (and can't be removed).
+func applyHeaders(req *http.Request, headers []string) {
+	seen := make(map[string]bool)
+
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+		name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(parts[0]))
+
+		if name == "Host" {
+			if v := strings.TrimSpace(parts[1]); v != "" {
+				req.Host = v
+			}
+			continue
+		}
+
+		if strings.TrimSpace(parts[1]) == "" {
+			removeHeader(req.Header, name)
+			seen[name] = true
+			continue
+		}
+
+		if seen[name] {
+			req.Header.Add(name, parts[1])
+			continue
+		}
+		req.Header.Set(name, parts[1])
+		seen[name] = true
+	}
+}
+
+// sentHeaderLines are the request's headers as sorted lines, including
+// Host when it's been set to something other than the URL's host, which Go
+// keeps apart from the rest
+func sentHeaderLines(req *http.Request) []string {
+	lines := headerLines(req.Header)
+	if req.Host != "" && req.Host != req.URL.Host {
+		lines = append(lines, "Host: "+req.Host)
+		sort.Strings(lines)
+	}
+	return lines
+}
+
+// removeHeader takes a header out. An empty User-Agent is how Go's told
+// not to send its default one.
+func removeHeader(h http.Header, name string) {
+	if name == "User-Agent" {
+		h[name] = []string{""}
+		return
+	}
+	h.Del(name)
+}
+
+// mergeHeaders returns the base headers with the extra ones on top: any
+// header in extra replaces all of base's values for it, rather than being
+// sent as well
+func mergeHeaders(base, extra []string) []string {
+	replaced := make(map[string]bool)
+	for _, h := range extra {
+		replaced[headerName(h)] = true
+	}
+
+	var out []string
+	for _, h := range base {
+		if !replaced[headerName(h)] {
+			out = append(out, h)
+		}
+	}
+	return append(out, extra...)
+}
+
+func headerName(h string) string {
+	name, _, _ := strings.Cut(h, ":")
+	return textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+}
+
+// removesHeader reports whether the headers include removing name
+func removesHeader(headers []string, name string) bool {
+	for _, h := range headers {
+		n, v, ok := strings.Cut(h, ":")
+		if ok && strings.TrimSpace(v) == "" && textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(n)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+type HeaderArgs []string
+
+// Set adds a header, or with @file, every header in the file. The file
+// can be a block copied from a request: a request line at the top, blank
+// lines and lines starting with # are skipped.
+func (h *HeaderArgs) Set(val string) error {
+	if !strings.HasPrefix(val, "@") {
+		*h = append(*h, val)
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(val[1:])
+	if err != nil {
+		return err
+	}
+
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if i == 0 && requestLine.MatchString(l) {
+			continue
+		}
+		if !strings.Contains(l, ":") {
+			return fmt.Errorf("%s: line %d isn't a header: %q", val[1:], i+1, l)
+		}
+		*h = append(*h, l)
+	}
+	return nil
+}
+
+var requestLine = regexp.MustCompile(`^[A-Z]+ \S+ HTTP/[0-9.]+$`)
+
+func (h HeaderArgs) String() string {
+	return strings.Join(h, ", ")
+}
@@ -0,0 +1,61 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyHeaders(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("X-Existing", "old")
+	req.Header.Set("Accept", "*/*")
+
+	applyHeaders(req, []string{"x-existing: new", "X-Multi: 1", "X-Multi: 2", "Accept:", "User-Agent:", "host: other.example.com"})
+
+	want := http.Header{
+		"X-Existing": {" new"},
+		"X-Multi":    {" 1", " 2"},
+		"User-Agent": {""},
+	}
+	if !reflect.DeepEqual(req.Header, want) {
+		t.Errorf("want %v, have %v", want, req.Header)
+	}
+
+	if req.Host != "other.example.com" {
+		t.Errorf("want Host set on the request, have %q", req.Host)
+	}
+}
+
+func TestMergeHeaders(t *testing.T) {
+	base := []string{"Content-Type: application/json", "X-A: 1", "X-A: 2", "X-B: 1"}
+	extra := []string{"content-type: text/plain", "X-A:"}
+
+	want := []string{"X-B: 1", "content-type: text/plain", "X-A:"}
+	if have := mergeHeaders(base, extra); !reflect.DeepEqual(have, want) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestHeaderFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "headers")
+	ioutil.WriteFile(p, []byte("POST /login HTTP/1.1\r\nHost: example.com\r\n# a comment\r\n\r\nCookie: a=b\r\n"), 0644)
+
+	var h HeaderArgs
+	h.Set("X-First: 1")
+	if err := h.Set("@" + p); err != nil {
+		t.Fatal(err)
+	}
+
+	want := HeaderArgs{"X-First: 1", "Host: example.com", "Cookie: a=b"}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("want %v, have %v", want, h)
+	}
+
+	ioutil.WriteFile(p, []byte("Cookie: a=b\nnot a header\n"), 0644)
+	if err := h.Set("@" + p); err == nil {
+		t.Error("want an error for a line that isn't a header")
+	}
+}
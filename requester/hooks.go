@@ -0,0 +1,724 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// This file contains the hooks for fff's own pipeline stages
+
+const stdoutFormatStr = "%s,%s,status: %d,size: %d,words: %d,lines: %d,type: %s%s\n"
+
+// regex for determining if something is probably HTML. You might
+// think that checking the content-type response header would be a better
+// idea, and you might be right - but if there's one thing I've learnt
+// about webservers it's that they are dirty, rotten, filthy liars.
+var isHTML = regexp.MustCompile(`(?i)<html`)
+
+// validURLHook normalises the input URL, dropping anything that still
+// isn't a URL we can request. Any changes made are noted in the job's meta.
+func validURLHook(j *job) bool {
+	u, notes, err := normaliseURL(j.url)
+	if err != nil {
+		return false
+	}
+
+	j.url = u.String()
+	if j.input != j.url {
+		j.meta = append(j.meta, "input: "+j.input)
+	}
+	if len(notes) > 0 {
+		j.meta = append(j.meta, "normalised: "+strings.Join(notes, " "))
+	}
+	return true
+}
+
+// duplicateHook spots requests that have already been made during the
+// run, which usually means different input lines were normalised to the
+// same URL. A warning's printed because when responses are saved the
+// second one overwrites the first. With skip set duplicates aren't
+// requested again, otherwise they're tagged. It needs the request, so it
+// has to come after everything else that changes it, except the reflection
+// marker: that's different every time, so nothing would ever be a
+// duplicate. Only each request's hash is kept, so a long run doesn't use
+// much memory remembering them. The warnings go to log.
+func duplicateHook(skip bool, log io.Writer) hook {
+	var mu sync.Mutex
+	seen := make(map[[sha1.Size]byte]struct{})
+
+	return func(j *job) bool {
+		h, err := j.requestSum()
+		if err != nil {
+			j.err = err
+			return false
+		}
+
+		mu.Lock()
+		_, dupe := seen[h]
+		if !dupe {
+			seen[h] = struct{}{}
+		}
+		mu.Unlock()
+
+		if !dupe {
+			return true
+		}
+
+		fmt.Fprintf(log, "duplicate request for %s from input %q\n", j.url, j.input)
+		if skip {
+			return false
+		}
+		j.tag("duplicate")
+		return true
+	}
+}
+
+// compareSchemesHook handles --compare-schemes, which is a mode of its own;
+// only the URLs where the http and https versions differ get output
+func compareSchemesHook(client *http.Client, limits bodyLimits, w io.Writer) hook {
+	return func(j *job) bool {
+		h, s, diffs := compareSchemes(client, limits, j.method, j.url, j.body, j.headers)
+		if len(diffs) > 0 {
+			fmt.Fprintf(w, "%s,http: %s,https: %s,diff: %s\n", j.url, h, s, strings.Join(diffs, " "))
+		}
+		return false
+	}
+}
+
+// buildRequestHook creates the request for the job
+func buildRequestHook(j *job) bool {
+	var b io.Reader
+	if j.body != "" {
+		b = strings.NewReader(j.body)
+	}
+
+	req, err := http.NewRequestWithContext(withJob(context.Background(), j), j.method, j.url, b)
+	if err != nil {
+		j.err = err
+		return false
+	}
+
+	// add headers to the request
+	applyHeaders(req, j.headers)
+
+	j.req = req
+	return true
+}
+
+// injectMarkerHook adds a marker that's unique to the request, which lets
+// us spot where it's reflected in the response
+func injectMarkerHook(locs ReflectLocations) hook {
+	return func(j *job) bool {
+		j.marker = newReflectMarker()
+		injectMarker(j.req, j.marker, locs)
+		return true
+	}
+}
+
+// the most of a body that's read unless --max-size says otherwise; bodies
+// are read into memory, so there has to be a limit of some sort
+const defaultMaxSize = 100 << 20
+
+// bodyLimits are how much of response bodies is read and kept. With
+// truncateAt set, only that much of the body is kept; the rest is read and
+// thrown away so that the size is still right. With maxSize set, no more
+// than that is read at all, so a huge download doesn't hold everything up.
+// maxDecompressed is the same, but only for bodies Go has decompressed, so
+// that a small gzip bomb can't turn into gigabytes. countRunes is
+// --count-runes, for how the body's measured as it's read (see bodyCounts).
+type bodyLimits struct {
+	truncateAt      int64
+	maxSize         int64
+	maxDecompressed int64
+	countRunes      bool
+}
+
+// max returns the most of the response's body that can be read, or 0 if
+// there's no limit, and what a body that's cut off there is tagged
+func (l bodyLimits) max(resp *http.Response) (int64, string) {
+	if resp.Uncompressed && l.maxDecompressed > 0 && (l.maxSize <= 0 || l.maxDecompressed < l.maxSize) {
+		return l.maxDecompressed, "decompression-bomb"
+	}
+	return l.maxSize, "oversized"
+}
+
+// fetchHook sends the request and reads the response, within the limits.
+// Bodies that are cut off at the max size are tagged oversized, and ones
+// cut off at the max decompressed size are tagged decompression-bomb.
+func fetchHook(client *http.Client, limits bodyLimits) hook {
+	return func(j *job) bool {
+		start := time.Now()
+		defer func() { j.fetchTime = time.Since(start) }()
+
+		resp, err := client.Do(j.req)
+		if err != nil {
+			j.err = err
+			return false
+		}
+		defer resp.Body.Close()
+
+		max, over := limits.max(resp)
+
+		keep := limits.truncateAt
+		if max > 0 && (keep <= 0 || max < keep) {
+			keep = max
+		}
+
+		var body io.Reader = resp.Body
+		if keep > 0 {
+			body = io.LimitReader(resp.Body, keep)
+		}
+
+		// we want to read the body into a string or something like that so we can provide options to
+		// not save content based on a pattern or something like that
+		j.respBody, err = ioutil.ReadAll(body)
+		if err != nil {
+			j.err = err
+			return false
+		}
+		size := int64(len(j.respBody))
+
+		counts := newBodyCounts(limits.countRunes)
+		counts.Write(j.respBody)
+		defer func() { j.counts = counts.done() }()
+
+		var rest int64
+		if limits.truncateAt > 0 {
+			var r io.Reader = resp.Body
+			if max > 0 {
+				r = io.LimitReader(resp.Body, max-size)
+			}
+			rest, err = io.Copy(counts, r)
+			if err != nil {
+				j.err = err
+				return false
+			}
+			size += rest
+		}
+
+		// at the max size, see if there's any more without reading it
+		if max > 0 && size >= max {
+			if n, _ := io.ReadFull(resp.Body, make([]byte, 1)); n > 0 {
+				j.tag(over)
+				line := fmt.Sprintf("%s: stopped reading after %d bytes", over, size)
+				if resp.ContentLength > size {
+					line += fmt.Sprintf(" of %d", resp.ContentLength)
+					size = resp.ContentLength
+				}
+				if int64(len(j.respBody)) < max {
+					line += fmt.Sprintf(", first %d saved", len(j.respBody))
+				}
+				j.meta = append(j.meta, line)
+
+				resp.ContentLength = size
+				j.resp = resp
+				return true
+			}
+		}
+
+		if rest > 0 {
+			j.tag("truncated")
+			j.meta = append(j.meta, fmt.Sprintf("truncated: first %d of %d bytes saved", limits.truncateAt, size))
+		}
+
+		resp.ContentLength = size
+		j.resp = resp
+		return true
+	}
+}
+
+// skipOversizedHook drops responses that were too big to read in full,
+// for --skip-oversized
+func skipOversizedHook(j *job) bool {
+	for _, t := range j.res.Tags {
+		if t == "oversized" {
+			return false
+		}
+	}
+	return true
+}
+
+// If we've been asked to ignore HTML files then we should really do that.
+// But why would you want to ignore HTML files? Sometimes you're looking at
+// a ton of hosts for config files and that sort of thing, and they lie to you
+// by sending a 200 response code instead of a 404. Those pages are *usually*
+// HTML so providing a way to ignore them cuts down on clutter a little bit,
+// even if it is a niche use-case.
+func ignoreHTMLHook(j *job) bool {
+	return !isHTML.Match(j.respBody)
+}
+
+// sometimes we don't about the response at all if it's empty
+func ignoreEmptyHook(j *job) bool {
+	return len(bytes.TrimSpace(j.respBody)) != 0
+}
+
+// if a -M/--match option has been used, we always want to save if it matches,
+// as long as it matches at least min times (--min-matches)
+func matchStringHook(s string, min int) hook {
+	return func(j *job) bool {
+		return bytes.Count(j.respBody, []byte(s)) >= min
+	}
+}
+
+// metricHook keeps responses where the metric (bodySize, bodyWords or
+// bodyLines) is in one of the ranges, or with keep unset, drops them.
+// It's -ms-size, -mw and -ml, and -fs, -fw and -fl.
+func metricHook(ranges RangeArgs, metric func(j *job) int64, keep bool) hook {
+	return func(j *job) bool {
+		return ranges.Includes(metric(j)) == keep
+	}
+}
+
+// the metrics that are reported for each response, which are counted as
+// the body's fetched; responses that weren't fetched by fetchHook (from
+// --capture-proxy, say) have them counted from what's there
+func bodySize(j *job) int64  { return jobCounts(j).size }
+func bodyWords(j *job) int64 { return jobCounts(j).words }
+func bodyLines(j *job) int64 { return jobCounts(j).lines }
+
+func jobCounts(j *job) *bodyCounts {
+	if j.counts != nil {
+		return j.counts
+	}
+	c := newBodyCounts(false)
+	c.Write(j.respBody)
+	c.size = j.resp.ContentLength
+	return c
+}
+
+// matchRegexHook is -mr, which keeps responses with a body that matches
+// re at least min times
+func matchRegexHook(re bodyRegex, min int) hook {
+	return func(j *job) bool {
+		return len(re.FindAllIndex(j.respBody, min)) >= min
+	}
+}
+
+// filterRegexHook is -fr, which drops responses with a body that matches
+func filterRegexHook(re bodyRegex) hook {
+	return func(j *job) bool {
+		return !re.Match(j.respBody)
+	}
+}
+
+// statusHook is -mc, which keeps responses with one of the codes
+func statusHook(codes StatusArgs) hook {
+	return func(j *job) bool {
+		return codes.Includes(j.resp.StatusCode)
+	}
+}
+
+// filterStatusHook is -fc, which drops responses with one of the codes
+func filterStatusHook(codes StatusArgs) hook {
+	return func(j *job) bool {
+		return !codes.Includes(j.resp.StatusCode)
+	}
+}
+
+// resultHook fills in the basics of the job's result; it's the first
+// thing in the enrich stage so that other hooks can add to it
+func resultHook(j *job) bool {
+	j.res = Result{
+		Input:       j.input,
+		URL:         j.url,
+		Method:      j.method,
+		Status:      j.resp.StatusCode,
+		Size:        bodySize(j),
+		Words:       int(bodyWords(j)),
+		Lines:       int(bodyLines(j)),
+		ContentType: j.resp.Header.Get("Content-Type"),
+		Title:       htmlTitle(j.respBody),
+		Server:      j.resp.Header.Get("Server"),
+		Location:    j.resp.Header.Get("Location"),
+		Redirects:   j.redirects,
+		VHost:       j.vhost,
+
+		// hooks in earlier stages can tag the job too, and a script can
+		// add fields
+		Tags:   j.res.Tags,
+		Fields: j.res.Fields,
+	}
+	return true
+}
+
+// htmlTitle is the page's title, for bodies that look like HTML
+func htmlTitle(body []byte) string {
+	if !isHTML.Match(body) {
+		return ""
+	}
+	return pageTitle(body)
+}
+
+// findReflectionsHook tags responses where the request's marker was
+// reflected and keeps some context for each reflection
+func findReflectionsHook(j *job) bool {
+	if j.marker == "" {
+		return true
+	}
+
+	j.meta = append(j.meta, "reflect-marker: "+j.marker)
+	tag, contexts := findReflections(j.respBody, j.marker)
+	if tag != "" {
+		j.tag(tag)
+	}
+	for _, c := range contexts {
+		j.meta = append(j.meta, "reflect-context: "+c)
+	}
+	return true
+}
+
+// dnsHook adds the DNS answers for the host to the job's meta, and tags
+// responses from hosts that are CNAMEs for something else
+func dnsHook(rec *dnsRecorder) hook {
+	return func(j *job) bool {
+		cname := false
+		for _, a := range rec.Answers(j.req.URL.Hostname()) {
+			j.meta = append(j.meta, "dns: "+a)
+			if strings.Contains(a, " CNAME ") {
+				cname = true
+			}
+		}
+		if cname {
+			j.tag("cname")
+		}
+		return true
+	}
+}
+
+// renderHook handles --render and --screenshot. Single page apps often
+// send nothing useful to a plain HTTP client, so HTML responses get
+// rendered in a real browser too and the resulting DOM is saved next to
+// the raw body. When screenshots are wanted every response gets rendered.
+// Pages that can't be rendered are reported on log and kept anyway.
+func renderHook(rend *renderer, dom, screenshot bool, log io.Writer) hook {
+	return func(j *job) bool {
+		if !screenshot && !isHTML.Match(j.respBody) {
+			return true
+		}
+
+		rendered, err := rend.Render(j.url)
+		if err != nil {
+			fmt.Fprintf(log, "failed to render %s: %s\n", j.url, err)
+			return true
+		}
+
+		if dom {
+			j.extras["dom"] = []byte(rendered.DOM)
+
+			// the links the live page actually uses, one per line
+			j.extras["links"] = []byte(strings.Join(rendered.Links, "\n") + "\n")
+		}
+		if screenshot {
+			j.extras["png"] = rendered.Screenshot
+		}
+		return true
+	}
+}
+
+// storeHook saves the response and adds it to the index. Artifacts are
+// identified by the request that was sent, plus the response when
+// hashResponse is set. Anything that can't be saved is reported on log.
+func storeHook(store Storage, hashResponse bool, log io.Writer) hook {
+	return func(j *job) bool {
+		reqBody, err := requestBody(j.req)
+		if err != nil {
+			fmt.Fprintf(log, "failed to read request body for %s: %s\n", j.url, err)
+			return false
+		}
+
+		// the request that was actually sent can differ from the one we
+		// started with (e.g. a reflection marker in a header), so that's
+		// what gets stored
+		reqHeaders := sentHeaderLines(j.req)
+
+		var hash string
+		scheme := hashScheme
+		if hashResponse {
+			hash = artifactHash(j.method, j.req.URL.String(), reqHeaders, reqBody, j.resp, j.respBody)
+			scheme += "+response"
+		} else {
+			hash = artifactHash(j.method, j.req.URL.String(), reqHeaders, reqBody, nil, nil)
+		}
+
+		meta := append([]string{"hash: " + scheme}, j.meta...)
+		if len(j.res.Tags) > 0 {
+			meta = append([]string{"tags: " + strings.Join(j.res.Tags, " ")}, meta...)
+		}
+
+		p, err := store.Put(artifact{
+			Hash:           hash,
+			Method:         j.method,
+			URL:            j.url,
+			RequestHeaders: reqHeaders,
+			RequestBody:    reqBody,
+			Meta:           meta,
+			Response:       j.resp,
+			Body:           j.respBody,
+			Extras:         j.extras,
+		})
+		if err != nil {
+			fmt.Fprintf(log, "%s\n", err)
+			return false
+		}
+
+		j.res.Path = p
+		if _, ok := j.extras["png"]; ok {
+			j.res.Screenshot = extraPath(p, "png")
+		}
+		if err := store.Index(j.res); err != nil {
+			fmt.Fprintf(log, "%s\n", err)
+		}
+		return true
+	}
+}
+
+// requestBody gets a fresh copy of a request's body, which will already
+// have been read when the request was sent
+func requestBody(req *http.Request) (string, error) {
+	if req.GetBody == nil {
+		return "", nil
+	}
+
+	rc, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	return string(b), err
+}
+
+// sinksHook passes the result to each of the sinks
+func sinksHook(sinks []resultSink) hook {
+	return func(j *job) bool {
+		for _, s := range sinks {
+			s.Send(j.res)
+		}
+		return true
+	}
+}
+
+// lockedWriter writes to w a whole line at a time, for output that's
+// shared by all of the workers
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newLockedWriter(w io.Writer) *lockedWriter {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	return &lockedWriter{w: w}
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// stdoutHook prints the result
+func stdoutHook(w io.Writer) hook {
+	return func(j *job) bool {
+		fmt.Fprint(w, formatResult(j.res))
+		return true
+	}
+}
+
+// formatResult returns the line printed for the result. When responses are
+// being saved that's the body filename for each URL, otherwise it's a
+// summary of the response.
+func formatResult(r Result) string {
+	if r.Path != "" {
+		var extra string
+		if len(r.Tags) > 0 {
+			extra = " [" + strings.Join(r.Tags, " ") + "]"
+		}
+		if r.Title != "" {
+			extra += " " + strconv.Quote(r.Title)
+		}
+		if r.Server != "" {
+			extra += " (server: " + r.Server + ")"
+		}
+		if r.VHost != "" {
+			extra += " (vhost: " + r.VHost + ")"
+		}
+		if r.Input != r.URL {
+			extra += " (input: " + r.Input + ")"
+		}
+		if len(r.Redirects) > 0 {
+			extra += " (redirects: " + strings.Join(r.Redirects, " -> ") + ")"
+		}
+		if r.Preview != "" {
+			extra += " " + strconv.Quote(r.Preview)
+		}
+		if len(r.Matches) > 0 {
+			extra += " (matches: " + quoteAll(r.Matches) + ")"
+		}
+		if r.Screenshot != "" {
+			extra += " (screenshot: " + r.Screenshot + ")"
+		}
+		return fmt.Sprintf("%s: %s %d%s\n", r.Path, r.URL, r.Status, extra)
+	}
+
+	var extra string
+	if r.Method != "" && r.Method != "GET" {
+		extra = ",method: " + r.Method
+	}
+	if r.Title != "" {
+		extra += ",title: " + strconv.Quote(r.Title)
+	}
+	if r.Server != "" {
+		extra += ",server: " + r.Server
+	}
+	if len(r.Tags) > 0 {
+		extra += ",tags: " + strings.Join(r.Tags, " ")
+	}
+	if r.VHost != "" {
+		extra += ",vhost: " + r.VHost
+	}
+	if r.Input != r.URL {
+		extra += ",input: " + r.Input
+	}
+	if len(r.Redirects) > 0 {
+		extra += ",redirects: " + strings.Join(r.Redirects, " -> ")
+	}
+	if r.Preview != "" {
+		extra += ",preview: " + strconv.Quote(r.Preview)
+	}
+	if len(r.Matches) > 0 {
+		extra += ",matches: " + quoteAll(r.Matches)
+	}
+	return fmt.Sprintf(stdoutFormatStr, r.URL, r.Location, r.Status, r.Size, r.Words, r.Lines, r.ContentType, extra)
+}
+
+// quoteAll quotes each of ss and joins them with spaces
+func quoteAll(ss []string) string {
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = strconv.Quote(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// stdoutJSONHook prints the result as a line of JSON, for --json
+func stdoutJSONHook(w io.Writer) hook {
+	return func(j *job) bool {
+		fmt.Fprint(w, formatResultJSON(j.res))
+		return true
+	}
+}
+
+// formatResultJSON is a line of JSON for the result. One that can't be
+// encoded gets a line like a failed request's instead, so it isn't lost.
+func formatResultJSON(r Result) string {
+	line, err := json.Marshal(r)
+	if err != nil {
+		line, _ = json.Marshal(struct {
+			Input string `json:"input"`
+			URL   string `json:"url"`
+			Error string `json:"error"`
+		}{r.Input, r.URL, "failed to encode result: " + err.Error()})
+	}
+	return string(line) + "\n"
+}
+
+// stdoutJSONErrorHook prints a line of JSON for requests that failed
+func stdoutJSONErrorHook(w io.Writer) func(j *job) {
+	return func(j *job) {
+		fmt.Fprint(w, formatErrorJSON(j))
+	}
+}
+
+func formatErrorJSON(j *job) string {
+	line, _ := json.Marshal(struct {
+		Input string `json:"input"`
+		URL   string `json:"url"`
+		Error string `json:"error"`
+	}{j.input, j.url, j.err.Error()})
+	return string(line) + "\n"
+}
+
+// previewHook adds the start of the body to the result, for --preview
+func previewHook(n int) hook {
+	return func(j *job) bool {
+		j.res.Preview = preview(j.respBody, n)
+		return true
+	}
+}
+
+// preview returns up to the first n bytes of body as a string. A
+// character cut in half at the end is left out, and anything else that
+// isn't valid UTF-8 is replaced, so it's safe to print and encode.
+func preview(body []byte, n int) string {
+	if len(body) <= n {
+		return strings.ToValidUTF8(string(body), "\uFFFD")
+	}
+
+	b := body[:n]
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				b = b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return strings.ToValidUTF8(string(b), "\uFFFD")
+}
+
+// includeHeadersHook adds the response headers to the result, for
+// --include-headers
+func includeHeadersHook(j *job) bool {
+	j.res.Headers = j.resp.Header.Clone()
+	return true
+}
+
+// stdoutErrorHook prints a summary line for requests that failed
+func stdoutErrorHook(w io.Writer) func(j *job) {
+	return func(j *job) {
+		fmt.Fprint(w, formatError(j))
+	}
+}
+
+// callbackHook and callbackErrorHook pass results and errors to the
+// functions in the Options, for using fff as a library
+func callbackHook(fn func(Result)) hook {
+	return func(j *job) bool {
+		if fn != nil {
+			fn(j.res)
+		}
+		return true
+	}
+}
+
+func callbackErrorHook(fn func(url string, err error)) func(j *job) {
+	return func(j *job) {
+		if fn != nil {
+			fn(j.url, j.err)
+		}
+	}
+}
+
+func formatError(j *job) string {
+	var extra string
+	if j.input != j.url {
+		extra = ",input: " + j.input
+	}
+	return fmt.Sprintf(stdoutFormatStr, j.url, j.err, 0, 0, 0, 0, "error", extra)
+}
@@ -0,0 +1,215 @@
+package requester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPreview(t *testing.T) {
+	cases := []struct {
+		body string
+		n    int
+		want string
+	}{
+		{"hello world", 5, "hello"},
+		{"short", 100, "short"},
+		{"café au lait", 4, "caf"},
+		{"café au lait", 5, "café"},
+		{"\xff\xfebin", 5, "�bin"},
+	}
+	for _, c := range cases {
+		if have := preview([]byte(c.body), c.n); have != c.want {
+			t.Errorf("preview(%q, %d): want %q, have %q", c.body, c.n, c.want, have)
+		}
+	}
+}
+
+func TestTitleAndServer(t *testing.T) {
+	j := newJob("http://example.com/", "GET", "", nil)
+	j.resp = &http.Response{StatusCode: 200, Header: http.Header{"Server": {"nginx"}}}
+	j.respBody = []byte("<html><head><title>\n  Index of /\n</title></head></html>")
+	resultHook(j)
+
+	if j.res.Title != "Index of /" || j.res.Server != "nginx" {
+		t.Errorf("want title and server, have %q and %q", j.res.Title, j.res.Server)
+	}
+	want := `http://example.com/,,status: 200,size: 0,words: 5,lines: 3,type: ,title: "Index of /",server: nginx` + "\n"
+	if have := formatResult(j.res); have != want {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	j.respBody = []byte(`{"title": "<title>not a page</title>"}`)
+	resultHook(j)
+	if j.res.Title != "" {
+		t.Errorf("want no title for JSON, have %q", j.res.Title)
+	}
+}
+
+func TestMatchStringHook(t *testing.T) {
+	j := newJob("http://example.com/", "GET", "", nil)
+	j.respBody = []byte("error: one error, two errors")
+
+	for min, want := range map[int]bool{1: true, 3: true, 4: false} {
+		if have := matchStringHook("error", min)(j); have != want {
+			t.Errorf("min %d: want %t, have %t", min, want, have)
+		}
+	}
+}
+
+func TestRegexHooks(t *testing.T) {
+	j := newJob("http://example.com/", "GET", "", nil)
+	j.respBody = []byte("token=abc123 token=def456")
+
+	re := regexp.MustCompile(`token=[a-z]+\d+`)
+	if !matchRegexHook(re, 2)(j) || matchRegexHook(re, 3)(j) {
+		t.Error("want -mr to need two matches")
+	}
+	if filterRegexHook(re)(j) || !filterRegexHook(regexp.MustCompile(`secret`))(j) {
+		t.Error("want -fr to drop matching bodies only")
+	}
+}
+
+func TestMetricHook(t *testing.T) {
+	var ranges RangeArgs
+	if err := ranges.Set("0,100-200,5312"); err != nil {
+		t.Fatal(err)
+	}
+
+	j := newJob("http://example.com/", "GET", "", nil)
+	j.respBody = []byte("two words\nand lines")
+	for size, want := range map[int64]bool{0: true, 150: true, 201: false, 5312: true} {
+		j.resp = &http.Response{ContentLength: size}
+		if have := metricHook(ranges, bodySize, true)(j); have != want {
+			t.Errorf("size %d: want %t, have %t", size, want, have)
+		}
+		if have := metricHook(ranges, bodySize, false)(j); have == want {
+			t.Errorf("size %d: want filter to be the opposite of match", size)
+		}
+	}
+
+	if bodyWords(j) != 3 || bodyLines(j) != 2 {
+		t.Errorf("want 3 words and 2 lines, have %d and %d", bodyWords(j), bodyLines(j))
+	}
+
+	for _, bad := range []string{"", "x", "200-100", "-5", "1-"} {
+		var r RangeArgs
+		if r.Set(bad) == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
+
+func TestStatusArgs(t *testing.T) {
+	cases := []struct {
+		args    []string
+		in, out []int
+	}{
+		{[]string{"200,301"}, []int{200, 301}, []int{201, 404}},
+		{[]string{"200-204"}, []int{200, 204}, []int{199, 205}},
+		{[]string{"3xx", "5XX"}, []int{300, 399, 503}, []int{400, 200}},
+		{[]string{"2xx,!204"}, []int{200, 299}, []int{204, 404}},
+		{[]string{"!404"}, []int{100, 200, 999}, []int{404}},
+	}
+
+	for _, c := range cases {
+		var s StatusArgs
+		for _, a := range c.args {
+			if err := s.Set(a); err != nil {
+				t.Fatalf("%q: %s", c.args, err)
+			}
+		}
+		for _, code := range c.in {
+			if !s.Includes(code) {
+				t.Errorf("%q: want %d included", c.args, code)
+			}
+		}
+		for _, code := range c.out {
+			if s.Includes(code) {
+				t.Errorf("%q: want %d left out", c.args, code)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "x", "2x", "6xxx", "99", "1000", "300-200", "!", "200,!200"} {
+		var s StatusArgs
+		if s.Set(bad) == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+
+	j := &job{resp: &http.Response{StatusCode: 404}}
+	codes := StatusArgs{404}
+	if !statusHook(codes)(j) || filterStatusHook(codes)(j) {
+		t.Error("want -mc to keep and -fc to drop a matching status")
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 100)
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte(body[:50]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[50:]))
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		path   string
+		limits bodyLimits
+		kept   int
+		size   int64
+		tags   string
+	}{
+		{"/", bodyLimits{}, 100, 100, ""},
+		{"/", bodyLimits{truncateAt: 10}, 10, 100, "truncated"},
+		{"/", bodyLimits{maxSize: 100}, 100, 100, ""},
+		{"/", bodyLimits{maxSize: 40}, 40, 100, "oversized"},
+		{"/chunked", bodyLimits{maxSize: 40}, 40, 40, "oversized"},
+		{"/chunked", bodyLimits{truncateAt: 10, maxSize: 40}, 10, 40, "oversized"},
+		{"/chunked", bodyLimits{truncateAt: 10, maxSize: 200}, 10, 100, "truncated"},
+	}
+	for _, c := range cases {
+		j := newJob(srv.URL+c.path, "GET", "", nil)
+		if !buildRequestHook(j) || !fetchHook(srv.Client(), c.limits)(j) {
+			t.Fatal(j.err)
+		}
+		tags := strings.Join(j.res.Tags, " ")
+		if len(j.respBody) != c.kept || j.resp.ContentLength != c.size || tags != c.tags {
+			t.Errorf("%s %+v: want %d of %d kept with tags %q, have %d of %d with %q", c.path, c.limits, c.kept, c.size, c.tags, len(j.respBody), j.resp.ContentLength, tags)
+		}
+		if skipOversizedHook(j) == (c.tags == "oversized") {
+			t.Errorf("%s %+v: want oversized responses skipped", c.path, c.limits)
+		}
+	}
+}
+
+func TestMaxDecompressed(t *testing.T) {
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb.Bytes())
+	}))
+	defer srv.Close()
+
+	j := newJob(srv.URL, "GET", "", nil)
+	if !buildRequestHook(j) || !fetchHook(newClient(false, ""), bodyLimits{maxSize: 1 << 30, maxDecompressed: 1000})(j) {
+		t.Fatal(j.err)
+	}
+	if len(j.respBody) != 1000 || strings.Join(j.res.Tags, " ") != "decompression-bomb" {
+		t.Errorf("want 1000 bytes tagged decompression-bomb, have %d tagged %q", len(j.respBody), j.res.Tags)
+	}
+}
@@ -0,0 +1,431 @@
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// targetOptions control how input lines are expanded into URLs
+type targetOptions struct {
+	ports      PortArgs
+	paths      PathArgs
+	expandCIDR bool
+
+	// every URL is requested with each of the methods, when there's more
+	// than one
+	methods []string
+
+	// and with each of the names in the Host header, with --vhost-file
+	vhosts []string
+
+	// the --input-format lines are in, if they aren't plain, and where
+	// the lines that don't parse are reported
+	format string
+	log    io.Writer
+}
+
+// target is a URL to request along with the input line it came from, so
+// that results can always be joined back to the input, and anything the
+// input said about how to request it
+type target struct {
+	input string
+	url   string
+	hints *requestHints
+
+	// the method to use instead of -m's, with more than one, and the
+	// Host header, with --vhost-file
+	method string
+	vhost  string
+}
+
+// expandTargets turns input lines into the URLs to request, expanding
+// each line as needed along the way. Nothing is expanded up front, so even
+// a /8 doesn't use much memory.
+func expandTargets(lines <-chan string, opts targetOptions) <-chan target {
+	out := make(chan target)
+
+	go func() {
+		defer close(out)
+
+		for line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			host, hints := line, (*requestHints)(nil)
+			if parse, ok := inputFormats[opts.format]; ok {
+				var err error
+				host, hints, err = parse(line)
+				if err != nil {
+					fmt.Fprintf(opts.log, "skipping %s input line: %s\n", opts.format, err)
+					continue
+				}
+			}
+
+			if opts.expandCIDR && expandIPs(host, func(ip net.IP) { emitTargets(out, line, ip.String(), hints, opts) }) {
+				continue
+			}
+
+			emitTargets(out, line, host, hints, opts)
+		}
+	}()
+
+	return out
+}
+
+// emitTargets sends the URLs for a single host or URL, expanded across
+// any ports, paths, methods and vhosts
+func emitTargets(out chan<- target, input, host string, hints *requestHints, opts targetOptions) {
+	var urls []string
+	switch {
+	case len(opts.ports) > 0:
+		urls = expandPorts(host, opts.ports)
+	case opts.expandCIDR && !strings.Contains(host, "://"):
+		urls = []string{"http://" + bracketIPv6(host)}
+	default:
+		urls = []string{host}
+	}
+
+	if len(opts.paths) > 0 {
+		var expanded []string
+		for _, u := range urls {
+			expanded = append(expanded, expandPaths(u, opts.paths)...)
+		}
+		urls = expanded
+	}
+
+	methods, vhosts := opts.methods, opts.vhosts
+	if len(methods) == 0 {
+		methods = []string{""}
+	}
+	if len(vhosts) == 0 {
+		vhosts = []string{""}
+	}
+	for _, u := range urls {
+		for _, m := range methods {
+			for _, v := range vhosts {
+				out <- target{input: input, url: u, hints: hints, method: m, vhost: v}
+			}
+		}
+	}
+}
+
+// expandIPs calls fn for each IP in the range described by line, which can
+// be in CIDR notation (10.0.0.0/24), a range (10.0.0.1-10.0.0.50), or a
+// range of the last octet (10.0.0.1-50). It returns false if line isn't a
+// range at all.
+func expandIPs(line string, fn func(net.IP)) bool {
+	if _, network, err := net.ParseCIDR(line); err == nil {
+		start := network.IP.Mask(network.Mask)
+		ones, bits := network.Mask.Size()
+
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^network.Mask[i]
+		}
+
+		// the network and broadcast addresses of IPv4 networks
+		// aren't going to be serving anything
+		if bits == 32 && ones < 31 {
+			start = nextIP(start)
+			end = prevIP(end)
+		}
+
+		walkIPs(start, end, fn)
+		return true
+	}
+
+	parts := strings.SplitN(line, "-", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	start := net.ParseIP(parts[0])
+	if start == nil {
+		return false
+	}
+
+	end := net.ParseIP(parts[1])
+	if end == nil && start.To4() != nil {
+		// just the last octet was specified for the end
+		last, err := strconv.Atoi(parts[1])
+		if err != nil || last < 0 || last > 255 {
+			return false
+		}
+		end = make(net.IP, 4)
+		copy(end, start.To4())
+		end[3] = byte(last)
+	}
+	if end == nil {
+		return false
+	}
+
+	if s4, e4 := start.To4(), end.To4(); s4 != nil && e4 != nil {
+		start, end = s4, e4
+	}
+
+	walkIPs(start, end, fn)
+	return true
+}
+
+// walkIPs calls fn for every IP from start to end inclusive
+func walkIPs(start, end net.IP, fn func(net.IP)) {
+	if len(start) != len(end) {
+		return
+	}
+	for ip := start; bytes.Compare(ip, end) <= 0; ip = nextIP(ip) {
+		fn(ip)
+		if ip.Equal(end) {
+			return
+		}
+	}
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+func bracketIPv6(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// expandPaths returns a copy of the URL for each of the paths
+func expandPaths(rawURL string, paths PathArgs) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		v := *u
+		ref, err := url.Parse(p)
+		if err != nil {
+			continue
+		}
+		v.Path = ref.Path
+		v.RawPath = ref.RawPath
+		v.RawQuery = ref.RawQuery
+		out = append(out, v.String())
+	}
+	return out
+}
+
+// expandPorts returns a URL for each of the ports for the host in line,
+// which can be either a full URL or a bare hostname
+func expandPorts(line string, ports PortArgs) []string {
+	schemeGiven := strings.Contains(line, "://")
+	if !schemeGiven {
+		line = "http://" + bracketIPv6(line)
+	}
+
+	u, err := url.Parse(line)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+
+	out := make([]string, 0, len(ports))
+	for _, port := range ports {
+		v := *u
+
+		v.Scheme = schemeForPort(port, u.Scheme, schemeGiven)
+
+		if (v.Scheme == "http" && port == 80) || (v.Scheme == "https" && port == 443) {
+			v.Host = bracketIPv6(u.Hostname())
+		} else {
+			v.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+		}
+
+		out = append(out, v.String())
+	}
+	return out
+}
+
+// schemeForPort works out what scheme to use for a port. The well known
+// ports always get their usual scheme; anything else keeps the scheme from
+// the input, or falls back to http if there wasn't one.
+func schemeForPort(port int, scheme string, schemeGiven bool) string {
+	switch port {
+	case 443, 8443:
+		return "https"
+	case 80, 8080:
+		return "http"
+	}
+
+	if schemeGiven {
+		return scheme
+	}
+	return "http"
+}
+
+type PortArgs []int
+
+func (p *PortArgs) Set(val string) error {
+	for _, s := range strings.Split(val, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", s)
+		}
+		*p = append(*p, port)
+	}
+	return nil
+}
+
+func (p PortArgs) String() string {
+	out := make([]string, len(p))
+	for i, port := range p {
+		out[i] = strconv.Itoa(port)
+	}
+	return strings.Join(out, ",")
+}
+
+type PathArgs []string
+
+func (p *PathArgs) Set(val string) error {
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, "/") {
+			s = "/" + s
+		}
+		*p = append(*p, s)
+	}
+	return nil
+}
+
+func (p PathArgs) String() string {
+	return strings.Join(p, ",")
+}
+
+// parseMethods splits a comma separated list of methods, like -m
+// GET,POST,PUT. Methods are case sensitive, so they're left as they are.
+func parseMethods(val string) []string {
+	var methods []string
+	for _, m := range strings.Split(val, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// readMethodsFile reads the methods in a --methods-file, one per line.
+// Blank lines and lines starting with # are skipped.
+func readMethodsFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []string
+	for _, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		methods = append(methods, l)
+	}
+	return methods, nil
+}
+
+// how many targets --interleave holds on to while it takes turns between
+// their hosts
+const interleaveWindow = 10000
+
+// interleaveTargets handles --interleave, reordering targets so that
+// each host gets a turn rather than its URLs going one after another.
+// Only up to window targets are held at once, so it works on input of
+// any size, but hosts only get interleaved with others in the same window.
+func interleaveTargets(in <-chan target, window int) <-chan target {
+	out := make(chan target)
+
+	go func() {
+		defer close(out)
+
+		queues := make(map[string][]target)
+		var hosts []string // hosts with targets waiting, in turn order
+		turn, held := 0, 0
+
+		for in != nil || held > 0 {
+			// send the next host's target while taking more input, as
+			// long as there's room for it
+			var send chan<- target
+			var next target
+			if held > 0 {
+				if turn >= len(hosts) {
+					turn = 0
+				}
+				send, next = out, queues[hosts[turn]][0]
+			}
+
+			var recv <-chan target
+			if held < window {
+				recv = in
+			}
+
+			select {
+			case send <- next:
+				held--
+				h := hosts[turn]
+				if len(queues[h]) == 1 {
+					delete(queues, h)
+					hosts = append(hosts[:turn], hosts[turn+1:]...)
+					continue
+				}
+				queues[h] = queues[h][1:]
+				turn++
+
+			case t, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				h := targetHost(t.url)
+				if len(queues[h]) == 0 {
+					hosts = append(hosts, h)
+				}
+				queues[h] = append(queues[h], t)
+				held++
+			}
+		}
+	}()
+
+	return out
+}
+
+func targetHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
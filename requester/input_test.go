@@ -0,0 +1,104 @@
+package requester
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpandPorts(t *testing.T) {
+	cases := []struct {
+		line  string
+		ports PortArgs
+		want  []string
+	}{
+		{"example.com", PortArgs{80, 443, 8000}, []string{"http://example.com", "https://example.com", "http://example.com:8000"}},
+		{"https://example.com/x", PortArgs{8443}, []string{"https://example.com:8443/x"}},
+	}
+
+	for _, c := range cases {
+		have := expandPorts(c.line, c.ports)
+		if !reflect.DeepEqual(have, c.want) {
+			t.Errorf("expandPorts(%q, %v): want %v, have %v", c.line, c.ports, c.want, have)
+		}
+	}
+}
+
+func TestExpandIPs(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"10.0.0.0/30", []string{"10.0.0.1", "10.0.0.2"}},
+		{"10.0.0.1-3", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"10.0.0.254-10.0.1.1", []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+	}
+
+	for _, c := range cases {
+		var have []string
+		ok := expandIPs(c.line, func(ip net.IP) {
+			have = append(have, ip.String())
+		})
+		if !ok || !reflect.DeepEqual(have, c.want) {
+			t.Errorf("expandIPs(%q): want %v, have %v (%v)", c.line, c.want, have, ok)
+		}
+	}
+
+	if expandIPs("example.com", func(net.IP) {}) {
+		t.Error("hostname treated as an IP range")
+	}
+}
+
+func TestExpandMethods(t *testing.T) {
+	lines := make(chan string, 1)
+	lines <- "https://example.com"
+	close(lines)
+
+	var have []string
+	opts := targetOptions{paths: PathArgs{"/a", "/b"}, methods: parseMethods("GET, POST,,PUT")}
+	for tg := range expandTargets(lines, opts) {
+		have = append(have, tg.method+" "+tg.url)
+	}
+
+	want := []string{
+		"GET https://example.com/a", "POST https://example.com/a", "PUT https://example.com/a",
+		"GET https://example.com/b", "POST https://example.com/b", "PUT https://example.com/b",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestInterleaveTargets(t *testing.T) {
+	urls := []string{
+		"http://a.example.com/1", "http://a.example.com/2", "http://a.example.com/3",
+		"http://b.example.com/1", "http://b.example.com/2",
+		"http://c.example.com/1",
+	}
+
+	in := make(chan target, len(urls))
+	for _, u := range urls {
+		in <- target{input: u, url: u}
+	}
+	close(in)
+
+	// a window big enough for everything, with the output only read once
+	// it's all been taken in
+	out := interleaveTargets(in, 100)
+	time.Sleep(10 * time.Millisecond)
+
+	var have []string
+	for t := range out {
+		have = append(have, t.url)
+	}
+
+	want := []string{
+		"http://a.example.com/1", "http://b.example.com/1", "http://c.example.com/1",
+		"http://a.example.com/2", "http://b.example.com/2",
+		"http://a.example.com/3",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,193 @@
+package requester
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The --input-format adapters read the output of other tools directly, so
+// that anything they know about how to make a request (the method, the
+// headers and the body) isn't lost by flattening it into plain URLs first.
+// Lines that aren't JSON are taken as plain URLs in every format, because
+// the tools can all write those too.
+var inputFormats = map[string]func(line string) (string, *requestHints, error){
+	"gau":            parseGauLine,
+	"katana":         parseKatanaLine,
+	"nuclei-targets": parseNucleiLine,
+}
+
+// requestHints are the parts of a request that came with an input line.
+// Anything that's empty is left to the command line.
+type requestHints struct {
+	method  string
+	headers []string
+	body    string
+}
+
+// apply puts the hints into the job. They take the place of the method
+// and body from the command line, and any -H headers go on top of theirs.
+func (h *requestHints) apply(j *job) {
+	if h.method != "" {
+		j.method = h.method
+	}
+	if h.body != "" {
+		j.body = h.body
+	}
+	if len(h.headers) > 0 {
+		j.headers = mergeHeaders(h.headers, j.headers)
+	}
+}
+
+// inputFormatNames lists the formats, for messages
+func inputFormatNames() string {
+	var names []string
+	for name := range inputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseGauLine reads a line from gau --json (or waybackurls, which
+// doesn't do JSON):
+//
+//	{"url":"https://example.com/a?b=c"}
+func parseGauLine(line string) (string, *requestHints, error) {
+	if !strings.HasPrefix(line, "{") {
+		return line, nil, nil
+	}
+
+	var v struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		return "", nil, err
+	}
+	if v.URL == "" {
+		return "", nil, fmt.Errorf("no url")
+	}
+	return v.URL, nil, nil
+}
+
+// parseKatanaLine reads a line from katana -jsonl. The request's under
+// "request", but older versions had its fields at the top level.
+//
+//	{"request":{"method":"POST","endpoint":"https://example.com/login","headers":{"Content-Type":"application/x-www-form-urlencoded"},"body":"user=a"},"response":{...}}
+func parseKatanaLine(line string) (string, *requestHints, error) {
+	if !strings.HasPrefix(line, "{") {
+		return line, nil, nil
+	}
+
+	type katanaRequest struct {
+		Method   string            `json:"method"`
+		Endpoint string            `json:"endpoint"`
+		URL      string            `json:"url"`
+		Headers  map[string]string `json:"headers"`
+		Body     string            `json:"body"`
+	}
+	var v struct {
+		katanaRequest
+		Request *katanaRequest `json:"request"`
+	}
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		return "", nil, err
+	}
+
+	req := v.katanaRequest
+	if v.Request != nil {
+		req = *v.Request
+	}
+	u := req.Endpoint
+	if u == "" {
+		u = req.URL
+	}
+	if u == "" {
+		return "", nil, fmt.Errorf("no endpoint")
+	}
+
+	hints := &requestHints{method: req.Method, body: req.Body}
+	for name, val := range req.Headers {
+		hints.headers = append(hints.headers, name+": "+val)
+	}
+	sort.Strings(hints.headers)
+	return u, hints, nil
+}
+
+// parseNucleiLine reads a line from nuclei -jsonl, which has where the
+// template matched and, with -irr, the raw request that was sent:
+//
+//	{"template-id":"...","host":"https://example.com","matched-at":"https://example.com/admin","request":"GET /admin HTTP/1.1\r\nHost: example.com\r\n..."}
+//
+// Plain lines are nuclei's own target lists, which have hosts as well
+// as URLs; those are left to the usual input handling.
+func parseNucleiLine(line string) (string, *requestHints, error) {
+	if !strings.HasPrefix(line, "{") {
+		return line, nil, nil
+	}
+
+	var v struct {
+		MatchedAt string `json:"matched-at"`
+		URL       string `json:"url"`
+		Host      string `json:"host"`
+		Request   string `json:"request"`
+	}
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		return "", nil, err
+	}
+
+	u := v.MatchedAt
+	if u == "" {
+		u = v.URL
+	}
+	if u == "" {
+		u = v.Host
+	}
+	if u == "" {
+		return "", nil, fmt.Errorf("no matched-at, url or host")
+	}
+
+	if v.Request == "" {
+		return u, nil, nil
+	}
+	hints, _, err := rawRequestHints(v.Request)
+	if err != nil {
+		return "", nil, fmt.Errorf("bad request: %s", err)
+	}
+	return u, hints, nil
+}
+
+// rawRequestHints gets the method, headers and body out of a raw HTTP
+// request, along with the path and query it was for. The body is
+// everything after the headers, whatever they say about its length, and
+// the headers that are about the connection or the body's framing are left
+// for Go to work out again, as is Host.
+func rawRequestHints(raw string) (*requestHints, string, error) {
+	// raw requests are often saved with bare newlines
+	head, body, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		head, body, _ = strings.Cut(raw, "\n\n")
+		head = strings.ReplaceAll(head, "\n", "\r\n")
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\r\n\r\n")))
+	if err != nil {
+		return nil, "", err
+	}
+
+	hints := &requestHints{method: req.Method, body: body}
+	for name, vals := range req.Header {
+		switch name {
+		case "Content-Length", "Transfer-Encoding", "Connection":
+			continue
+		}
+		for _, val := range vals {
+			hints.headers = append(hints.headers, name+": "+val)
+		}
+	}
+	sort.Strings(hints.headers)
+	return hints, req.URL.RequestURI(), nil
+}
@@ -0,0 +1,59 @@
+package requester
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInputFormats(t *testing.T) {
+	cases := []struct {
+		format, line string
+		url          string
+		hints        *requestHints
+	}{
+		{"gau", `{"url":"https://example.com/a?b=c"}`, "https://example.com/a?b=c", nil},
+		{"gau", "https://example.com/plain", "https://example.com/plain", nil},
+		{
+			"katana",
+			`{"timestamp":"2026-01-02T15:04:05Z","request":{"method":"POST","endpoint":"https://example.com/login","tag":"form","headers":{"Content-Type":"application/x-www-form-urlencoded","X-A":"1"},"body":"user=a"},"response":{"status_code":200}}`,
+			"https://example.com/login",
+			&requestHints{method: "POST", body: "user=a", headers: []string{"Content-Type: application/x-www-form-urlencoded", "X-A: 1"}},
+		},
+		{"katana", `{"endpoint":"https://example.com/old","method":"GET"}`, "https://example.com/old", &requestHints{method: "GET"}},
+		{
+			"nuclei-targets",
+			`{"template-id":"x","host":"https://example.com","matched-at":"https://example.com/api","request":"PUT /api HTTP/1.1\nHost: example.com\nContent-Type: application/json\nContent-Length: 8\n\n{\"a\":1}"}`,
+			"https://example.com/api",
+			&requestHints{method: "PUT", body: `{"a":1}`, headers: []string{"Content-Type: application/json"}},
+		},
+		{"nuclei-targets", `{"host":"example.com:8443"}`, "example.com:8443", nil},
+		{"nuclei-targets", "example.com", "example.com", nil},
+	}
+
+	for _, c := range cases {
+		u, hints, err := inputFormats[c.format](c.line)
+		if err != nil {
+			t.Errorf("%s %s: %s", c.format, c.line, err)
+			continue
+		}
+		if u != c.url || !reflect.DeepEqual(hints, c.hints) {
+			t.Errorf("%s %s: want %s %+v, have %s %+v", c.format, c.line, c.url, c.hints, u, hints)
+		}
+	}
+
+	for _, line := range []string{`{"url":`, `{"other":"x"}`} {
+		if _, _, err := parseGauLine(line); err == nil {
+			t.Errorf("want an error for %s", line)
+		}
+	}
+}
+
+func TestRequestHintsApply(t *testing.T) {
+	j := newJob("https://example.com/", "GET", "", []string{"X-A: 2", "Cookie: a=b"})
+	(&requestHints{method: "POST", body: "x=1", headers: []string{"X-A: 1", "X-B: 1"}}).apply(j)
+
+	want := []string{"X-B: 1", "X-A: 2", "Cookie: a=b"}
+	if j.method != "POST" || j.body != "x=1" || !reflect.DeepEqual(j.headers, want) {
+		t.Errorf("unexpected job %s %q %q", j.method, j.body, j.headers)
+	}
+}
@@ -0,0 +1,96 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync"
+)
+
+// Once we've been told about a 1xx response it's up to us to stop a server
+// sending them forever, so requests that get more than this many fail
+const maxInterim = 32
+
+// interimResponses records the informational (1xx) responses a server
+// sends before the real one, like 103 Early Hints. The transport normally
+// swallows them, so without this they'd never be seen.
+type interimResponses struct {
+	mu        sync.Mutex
+	responses []interimResponse
+}
+
+type interimResponse struct {
+	status int
+	header http.Header
+}
+
+func (r *interimResponses) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if len(r.responses) >= maxInterim {
+				return fmt.Errorf("more than %d informational responses", maxInterim)
+			}
+
+			// the transport reuses the header map for the next
+			// response, so it has to be copied
+			r.responses = append(r.responses, interimResponse{
+				status: code,
+				header: http.Header(header).Clone(),
+			})
+			return nil
+		},
+	}
+}
+
+// interimTraceHook starts recording informational responses for the job.
+// It goes in the schedule stage, just before the fetch.
+func interimTraceHook(j *job) bool {
+	j.interim = &interimResponses{}
+	j.req = j.req.WithContext(httptrace.WithClientTrace(j.req.Context(), j.interim.clientTrace()))
+	return true
+}
+
+// interimMetaHook adds any informational responses and trailers to the
+// job's meta, e.g.
+//
+//	informational: 103 Early Hints
+//	informational: 103 Link: </style.css>; rel=preload; as=style
+//	trailer: Grpc-Status: 0
+//
+// Responses with early hints are tagged early-hints, and ones with
+// trailers are tagged trailers.
+func interimMetaHook(j *job) bool {
+	if j.interim != nil {
+		hints := false
+
+		j.interim.mu.Lock()
+		for _, r := range j.interim.responses {
+			hints = hints || r.status == http.StatusEarlyHints
+			j.meta = append(j.meta, fmt.Sprintf("informational: %d %s", r.status, http.StatusText(r.status)))
+			for _, h := range headerLines(r.header) {
+				j.meta = append(j.meta, fmt.Sprintf("informational: %d %s", r.status, h))
+			}
+		}
+		j.interim.mu.Unlock()
+
+		if hints {
+			j.tag("early-hints")
+		}
+	}
+
+	// trailers are only there once the whole body's been read, which
+	// the fetch always does. Ones that were announced but never sent
+	// have no values, and so no lines.
+	lines := headerLines(j.resp.Trailer)
+	if len(lines) > 0 {
+		j.tag("trailers")
+	}
+	for _, l := range lines {
+		j.meta = append(j.meta, "trailer: "+l)
+	}
+	return true
+}
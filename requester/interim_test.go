@@ -0,0 +1,39 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestInterimResponses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+
+		w.Header().Del("Link")
+		w.Header().Set("Trailer", "X-Checksum, X-Never-Sent")
+		w.Write([]byte("body"))
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer ts.Close()
+
+	j := newJob(ts.URL, "GET", "", nil)
+	if !buildRequestHook(j) || !interimTraceHook(j) || !fetchHook(ts.Client(), bodyLimits{})(j) {
+		t.Fatal(j.err)
+	}
+	interimMetaHook(j)
+
+	want := []string{
+		"informational: 103 Early Hints",
+		"informational: 103 Link: </style.css>; rel=preload; as=style",
+		"trailer: X-Checksum: abc",
+	}
+	if !reflect.DeepEqual(j.meta, want) {
+		t.Errorf("want meta %q, have %q", want, j.meta)
+	}
+	if want := []string{"early-hints", "trailers"}; !reflect.DeepEqual(j.res.Tags, want) {
+		t.Errorf("want tags %q, have %q", want, j.res.Tags)
+	}
+}
@@ -0,0 +1,116 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Two fffs writing to the same output directory would mix up their index
+// lines, and --resume in one would miss what the other saved. So each run
+// leaves a lock file in the directory while it's writing to it (.lock-
+// followed by something random), saying who it is:
+//
+//	exclusive 12345 myhost
+//
+// A run can only have the directory to itself when there are no other
+// locks, and runs with --shared can share it with each other but not with
+// one that wants it to itself. Locks left behind by runs that have died
+// are cleared out, as long as they were on the same machine.
+
+type dirLock struct {
+	path string
+}
+
+// lockDir takes a lock on the directory, which has to exist. The lock
+// file's created before looking for others, so two runs starting at the
+// same time see each other's locks; at worst they both give up.
+func lockDir(dir string, shared bool) (*dirLock, error) {
+	mode := "exclusive"
+	if shared {
+		mode = "shared"
+	}
+	host, _ := os.Hostname()
+
+	f, err := ioutil.TempFile(dir, ".lock-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %s", err)
+	}
+	_, err = fmt.Fprintf(f, "%s %d %s\n", mode, os.Getpid(), host)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	l := &dirLock{path: f.Name()}
+	if err != nil {
+		l.Unlock()
+		return nil, fmt.Errorf("failed to write lock file: %s", err)
+	}
+
+	others, err := filepath.Glob(filepath.Join(dir, ".lock-*"))
+	if err != nil {
+		l.Unlock()
+		return nil, err
+	}
+
+	for _, p := range others {
+		if p == l.path {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			// it's gone already
+			continue
+		}
+
+		fields := strings.Fields(string(data))
+		if len(fields) != 3 {
+			// it's still being written, so it's a run that's starting
+			fields = []string{"exclusive", "0", ""}
+		}
+		pid, _ := strconv.Atoi(fields[1])
+
+		if fields[2] == host && !processAlive(pid) {
+			os.Remove(p)
+			continue
+		}
+
+		if shared && fields[0] == "shared" {
+			continue
+		}
+
+		l.Unlock()
+		if shared {
+			return nil, fmt.Errorf("%s is being written to by another fff (pid %s on %s) that isn't using --shared", dir, fields[1], fields[2])
+		}
+		return nil, fmt.Errorf("%s is being written to by another fff (pid %s on %s); use --shared in both to share it", dir, fields[1], fields[2])
+	}
+
+	return l, nil
+}
+
+// Unlock removes the lock file
+func (l *dirLock) Unlock() error {
+	return os.Remove(l.path)
+}
+
+// processAlive reports whether there's a process with the pid. One we're
+// not allowed to signal still counts.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,71 @@
+package requester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLockDir(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := lockDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing else gets in while it's held
+	for _, shared := range []bool{false, true} {
+		if _, err := lockDir(dir, shared); err == nil {
+			t.Errorf("shared %v: want an error while the directory's locked", shared)
+		}
+	}
+	l.Unlock()
+
+	// shared locks can be shared, but only with each other
+	a, err := lockDir(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := lockDir(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockDir(dir, false); err == nil || !strings.Contains(err.Error(), "--shared") {
+		t.Errorf("want an error suggesting --shared, have %v", err)
+	}
+	a.Unlock()
+	b.Unlock()
+
+	// the failed attempts shouldn't have left their locks behind
+	if locks, _ := filepath.Glob(filepath.Join(dir, ".lock-*")); len(locks) != 0 {
+		t.Errorf("want no locks left, have %q", locks)
+	}
+}
+
+func TestLockDirStale(t *testing.T) {
+	dir := t.TempDir()
+	host, _ := os.Hostname()
+
+	// a run on this machine that's gone away, and one somewhere else
+	// that might not have
+	stale := filepath.Join(dir, ".lock-stale")
+	ioutil.WriteFile(stale, []byte(fmt.Sprintf("exclusive 2147483647 %s\n", host)), 0644)
+
+	l, err := lockDir(dir, false)
+	if err != nil {
+		t.Fatalf("want the stale lock cleared, have %s", err)
+	}
+	l.Unlock()
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("want the stale lock removed")
+	}
+
+	ioutil.WriteFile(stale, []byte("exclusive 2147483647 elsewhere\n"), 0644)
+	if _, err := lockDir(dir, false); err == nil {
+		t.Error("want locks from other machines respected")
+	}
+}
@@ -0,0 +1,126 @@
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// only the first few matches in a body get their context kept
+const maxMatchContexts = 10
+
+// in line mode, the most of the line kept either side of a match, so that
+// a match in minified JavaScript doesn't drag the whole file along
+const maxLineContext = 120
+
+// MatchContext is how much of the body around a match to keep: the line
+// it's on, or n bytes either side of it
+type MatchContext struct {
+	line bool
+	n    int
+}
+
+func (m *MatchContext) Set(val string) error {
+	if val == "line" {
+		*m = MatchContext{line: true}
+		return nil
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return fmt.Errorf("want 'line' or a number of bytes")
+	}
+	*m = MatchContext{n: n}
+	return nil
+}
+
+func (m MatchContext) String() string {
+	if m.line {
+		return "line"
+	}
+	return strconv.Itoa(m.n)
+}
+
+// matcher finds where something matches in a body, returning the start
+// and end of each match like regexp's FindAllIndex does
+type matcher func(body []byte) [][]int
+
+// stringMatcher finds every occurrence of s, for -ms
+func stringMatcher(s string) matcher {
+	return func(body []byte) [][]int {
+		var locs [][]int
+		for offset := 0; len(locs) < maxMatchContexts; {
+			i := bytes.Index(body[offset:], []byte(s))
+			if i == -1 {
+				break
+			}
+			i += offset
+			locs = append(locs, []int{i, i + len(s)})
+			offset = i + len(s)
+		}
+		return locs
+	}
+}
+
+// regexMatcher finds the matches for re, for -mr
+func regexMatcher(re bodyRegex) matcher {
+	return func(body []byte) [][]int {
+		return re.FindAllIndex(body, maxMatchContexts)
+	}
+}
+
+// contexts returns the context around each match. Matches on the same
+// line only get it once.
+func (m MatchContext) contexts(body []byte, locs [][]int) []string {
+	var out []string
+	for _, loc := range locs {
+		if len(out) == maxMatchContexts {
+			break
+		}
+
+		start, end := loc[0]-m.n, loc[1]+m.n
+		if m.line {
+			start = bytes.LastIndexByte(body[:loc[0]], '\n') + 1
+			if start < loc[0]-maxLineContext {
+				start = loc[0] - maxLineContext
+			}
+
+			end = len(body)
+			if i := bytes.IndexByte(body[loc[1]:], '\n'); i != -1 {
+				end = loc[1] + i
+			}
+			if end > loc[1]+maxLineContext {
+				end = loc[1] + maxLineContext
+			}
+		}
+
+		if start < 0 {
+			start = 0
+		}
+		if end > len(body) {
+			end = len(body)
+		}
+		c := string(body[start:end])
+		if len(out) > 0 && out[len(out)-1] == c {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// matchContextHook adds the context around each match to the job's meta
+// as match: lines, so it's clear why a response was kept. With show set
+// it goes in the result too, for the output.
+func matchContextHook(find matcher, mc MatchContext, show bool) hook {
+	return func(j *job) bool {
+		contexts := mc.contexts(j.respBody, find(j.respBody))
+		for _, c := range contexts {
+			j.meta = append(j.meta, fmt.Sprintf("match: %q", c))
+		}
+		if show {
+			j.res.Matches = append(j.res.Matches, contexts...)
+		}
+		return true
+	}
+}
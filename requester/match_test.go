@@ -0,0 +1,42 @@
+package requester
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchContexts(t *testing.T) {
+	body := []byte("first line\napi_key = abc; other api_key = def\nlast line")
+	find := stringMatcher("api_key")
+
+	cases := []struct {
+		context string
+		want    []string
+	}{
+		{"line", []string{"api_key = abc; other api_key = def"}},
+		{"4", []string{"ine\napi_key = a", "her api_key = d"}},
+		{"0", []string{"api_key"}},
+	}
+	for _, c := range cases {
+		var mc MatchContext
+		if err := mc.Set(c.context); err != nil {
+			t.Fatal(err)
+		}
+		if have := mc.contexts(body, find(body)); !reflect.DeepEqual(have, c.want) {
+			t.Errorf("context %s: want %q, have %q", c.context, c.want, have)
+		}
+	}
+
+	// long lines are cut down to around the match
+	long := []byte(strings.Repeat("x", 1000) + "needle" + strings.Repeat("y", 1000))
+	have := MatchContext{line: true}.contexts(long, stringMatcher("needle")(long))
+	if len(have) != 1 || len(have[0]) != 2*maxLineContext+len("needle") {
+		t.Errorf("want one cut down line, have %d contexts", len(have))
+	}
+
+	var mc MatchContext
+	if mc.Set("lots") == nil || mc.Set("-1") == nil {
+		t.Error("want errors for invalid contexts")
+	}
+}
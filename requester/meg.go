@@ -0,0 +1,90 @@
+package requester
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// With --format meg, responses are saved the way meg saves them, so that
+// anything written for meg's output works on fff's. Each response is a
+// single file, prefix/host/hash, where the hash is the SHA-1 of the file:
+//
+//	https://example.com/robots.txt
+//
+//	> GET /robots.txt HTTP/1.1
+//	> Host: example.com
+//
+//	< HTTP/1.1 200 OK
+//	< Content-Type: text/plain
+//
+//	body
+//
+// and the index lines are the path, the URL and the status:
+//
+//	out/example.com/45ed6f717d44385c5e9c539b0ad8dc71771780e0 https://example.com/robots.txt (200 OK)
+//
+// There's nowhere in that for the meta lines, so they're left out. Request
+// bodies and any extras are saved next to the file, as hash.request and
+// so on.
+
+func megFileContents(a artifact, u *url.URL) []byte {
+	var buf strings.Builder
+	buf.WriteString(a.URL + "\n\n")
+
+	buf.WriteString(fmt.Sprintf("> %s %s HTTP/1.1\n", a.Method, u.RequestURI()))
+	buf.WriteString(fmt.Sprintf("> Host: %s\n", u.Host))
+	for _, h := range a.RequestHeaders {
+		buf.WriteString(fmt.Sprintf("> %s\n", h))
+	}
+	buf.WriteRune('\n')
+
+	buf.WriteString(fmt.Sprintf("< %s %s\n", a.Response.Proto, a.Response.Status))
+	for _, h := range headerLines(a.Response.Header) {
+		buf.WriteString(fmt.Sprintf("< %s\n", h))
+	}
+	buf.WriteRune('\n')
+
+	return append([]byte(buf.String()), a.Body...)
+}
+
+// putMeg is Put for --format meg
+func (s *fsStorage) putMeg(a artifact, u *url.URL, host string) (string, error) {
+	content := megFileContents(a, u)
+	p := path.Join(s.prefix, host, fmt.Sprintf("%x", sha1.Sum(content)))
+
+	err := os.MkdirAll(path.Dir(p), 0750)
+	if err != nil {
+		return "", fmt.Errorf("failed to create dir: %s", err)
+	}
+
+	err = writeFileAtomic(p, content)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file contents: %s", err)
+	}
+
+	extras := make(map[string][]byte, len(a.Extras)+1)
+	for ext, data := range a.Extras {
+		extras[ext] = data
+	}
+	if a.RequestBody != "" {
+		extras["request"] = []byte(a.RequestBody)
+	}
+	for ext, data := range extras {
+		err = writeFileAtomic(p+"."+ext, data)
+		if err != nil {
+			return "", fmt.Errorf("failed to write file contents: %s", err)
+		}
+	}
+
+	return p, nil
+}
+
+// megIndexLine is the index line for a result with --format meg
+func megIndexLine(r Result) string {
+	return fmt.Sprintf("%s %s (%d %s)\n", r.Path, r.URL, r.Status, http.StatusText(r.Status))
+}
@@ -0,0 +1,59 @@
+package requester
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestMegFormat(t *testing.T) {
+	dir := t.TempDir()
+	s := newFSStorage(dir)
+	s.meg = true
+
+	p, err := s.Put(artifact{
+		Hash:           "ignored",
+		Method:         "GET",
+		URL:            "https://example.com/robots.txt?a=b",
+		RequestHeaders: []string{"User-Agent: fff"},
+		Meta:           []string{"dns: A 192.0.2.1"},
+		Response: &http.Response{
+			Proto:  "HTTP/1.1",
+			Status: "404 Not Found",
+			Header: http.Header{"Server": {"ECS"}, "Content-Type": {"text/html"}},
+		},
+		Body: []byte("<html>"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "https://example.com/robots.txt?a=b\n\n" +
+		"> GET /robots.txt?a=b HTTP/1.1\n> Host: example.com\n> User-Agent: fff\n\n" +
+		"< HTTP/1.1 404 Not Found\n< Content-Type: text/html\n< Server: ECS\n\n<html>"
+
+	have, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != want {
+		t.Errorf("want file\n%s\nhave\n%s", want, have)
+	}
+	if wantPath := filepath.Join(dir, "example.com", fmt.Sprintf("%x", sha1.Sum([]byte(want)))); p != wantPath {
+		t.Errorf("want path %s, have %s", wantPath, p)
+	}
+
+	if err := s.Index(Result{URL: "https://example.com/robots.txt?a=b", Status: 404, Path: p}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	index, _ := ioutil.ReadFile(filepath.Join(dir, "index"))
+	if want := p + " https://example.com/robots.txt?a=b (404 Not Found)\n"; string(index) != want {
+		t.Errorf("want index %q, have %q", want, index)
+	}
+}
@@ -0,0 +1,82 @@
+package requester
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mirrorURL rewrites a URL to point at the mirror: the scheme and host come
+// from the mirror's base URL, and any path it has is put in front of the
+// original path
+func mirrorURL(base, u *url.URL) *url.URL {
+	m := *u
+	m.Scheme = base.Scheme
+	m.Host = base.Host
+	m.User = base.User
+	m.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	m.RawPath = ""
+	return &m
+}
+
+// compareMirror works out how the mirror's response differs from the
+// original one
+func compareMirror(base, orig *url.URL, resp *http.Response, size int, m schemeResult) []string {
+	if m.err != nil {
+		return []string{"error"}
+	}
+
+	// redirects are compared as absolute URLs, with ones to the original
+	// host rewritten to point at the mirror, so that a mirror redirecting
+	// to itself in the same way isn't a difference
+	var location, mirrorLocation string
+	if l, err := orig.Parse(resp.Header.Get("Location")); err == nil && resp.Header.Get("Location") != "" {
+		if l.Host == orig.Host {
+			l = mirrorURL(base, l)
+		}
+		location = l.String()
+	}
+	if l, err := mirrorURL(base, orig).Parse(m.location); err == nil && m.location != "" {
+		mirrorLocation = l.String()
+	}
+
+	var diffs []string
+	if resp.StatusCode != m.status {
+		diffs = append(diffs, "status")
+	}
+	if sizesDiffer(size, m.size) {
+		diffs = append(diffs, "size")
+	}
+	if location != mirrorLocation {
+		diffs = append(diffs, "location")
+	}
+	return diffs
+}
+
+// mirrorHook handles --mirror, sending a copy of every request to another
+// host (a staging server, say) and comparing the responses. It goes at the
+// end of the fetch stage so that everything's mirrored, whether it makes it
+// through the filters or not. Responses where the mirror's differs are
+// tagged mirror-diff.
+func mirrorHook(client *http.Client, limits bodyLimits, base *url.URL) hook {
+	return func(j *job) bool {
+		body, err := requestBody(j.req)
+		if err != nil {
+			j.err = err
+			return false
+		}
+
+		mu := mirrorURL(base, j.req.URL)
+		m := fetchSummary(withPins(context.Background(), j.pins), client, limits, j.method, mu.String(), body, headerLines(j.req.Header))
+
+		j.meta = append(j.meta, "mirror: "+mu.String()+" "+m.String())
+
+		diffs := compareMirror(base, j.req.URL, j.resp, int(j.resp.ContentLength), m)
+		if len(diffs) > 0 {
+			j.tag("mirror-diff")
+			j.meta = append(j.meta, "mirror-diff: "+strings.Join(diffs, " "))
+		}
+		return true
+	}
+}
@@ -0,0 +1,58 @@
+package requester
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestMirrorURL(t *testing.T) {
+	cases := map[[2]string]string{
+		{"https://staging.example.com", "http://example.com/a?b=c"}:       "https://staging.example.com/a?b=c",
+		{"http://127.0.0.1:8080/prefix/", "https://example.com/a/b"}:      "http://127.0.0.1:8080/prefix/a/b",
+		{"http://canary.internal", "http://user:pw@example.com:8443/x#y"}: "http://canary.internal/x#y",
+	}
+
+	for in, want := range cases {
+		base, _ := url.Parse(in[0])
+		u, _ := url.Parse(in[1])
+		if have := mirrorURL(base, u).String(); have != want {
+			t.Errorf("mirrorURL(%s, %s): want %s, have %s", in[0], in[1], want, have)
+		}
+	}
+}
+
+func TestCompareMirror(t *testing.T) {
+	base, _ := url.Parse("https://staging.example.com")
+	orig, _ := url.Parse("https://example.com/login")
+
+	redirect := func(location string) *http.Response {
+		return &http.Response{StatusCode: 302, Header: http.Header{"Location": {location}}}
+	}
+
+	cases := []struct {
+		resp *http.Response
+		size int
+		m    schemeResult
+		want []string
+	}{
+		// the same redirect, relative on one side and absolute on the other
+		{redirect("https://example.com/home"), 0, schemeResult{status: 302, location: "/home"}, nil},
+		{redirect("/home"), 0, schemeResult{status: 302, location: "https://staging.example.com/home"}, nil},
+
+		// redirects off-site should go to the same place
+		{redirect("https://sso.example.com/"), 0, schemeResult{status: 302, location: "https://staging.example.com/"}, []string{"location"}},
+
+		{&http.Response{StatusCode: 200}, 1000, schemeResult{status: 403, size: 200}, []string{"status", "size"}},
+		{&http.Response{StatusCode: 200}, 1000, schemeResult{status: 200, size: 1050}, nil},
+		{&http.Response{StatusCode: 200}, 1000, schemeResult{err: errors.New("refused")}, []string{"error"}},
+	}
+
+	for i, c := range cases {
+		if have := compareMirror(base, orig, c.resp, c.size, c.m); !reflect.DeepEqual(have, c.want) {
+			t.Errorf("case %d: want %v, have %v", i, c.want, have)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// -0 ends each line of output with a NUL instead of a newline, for xargs
+// -0 and the like. URLs and errors can have newlines in them, so any in
+// the middle of a line are escaped as \n, to keep each result in one
+// record.
+func nullRecord(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.ReplaceAll(line, "\r", `\r`)
+	line = strings.ReplaceAll(line, "\n", `\n`)
+	return line + "\x00"
+}
+
+// nullHook prints results formatted by format as NUL-terminated records
+func nullHook(w io.Writer, format func(r Result) string) hook {
+	return func(j *job) bool {
+		if line := format(j.res); line != "" {
+			fmt.Fprint(w, nullRecord(line))
+		}
+		return true
+	}
+}
+
+// nullErrorHook is nullHook for requests that failed
+func nullErrorHook(w io.Writer, format func(j *job) string) func(j *job) {
+	return func(j *job) {
+		fmt.Fprint(w, nullRecord(format(j)))
+	}
+}
@@ -0,0 +1,16 @@
+package requester
+
+import "testing"
+
+func TestNullRecord(t *testing.T) {
+	cases := map[string]string{
+		"http://example.com/,,status: 200\n": "http://example.com/,,status: 200\x00",
+		"a\nb\r\nc\n":                        `a\nb\r\nc` + "\x00",
+		"no newline":                         "no newline\x00",
+	}
+	for line, want := range cases {
+		if have := nullRecord(line); have != want {
+			t.Errorf("%q: want %q, have %q", line, want, have)
+		}
+	}
+}
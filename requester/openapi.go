@@ -0,0 +1,247 @@
+package requester
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// openapiPaths are where API specs are usually found
+var openapiPaths = []string{
+	"/openapi.json",
+	"/swagger.json",
+	"/api-docs",
+	"/v2/api-docs",
+	"/v3/api-docs",
+	"/swagger/v1/swagger.json",
+	"/swagger/doc.json",
+	"/api/openapi.json",
+	"/api/swagger.json",
+}
+
+// openapiProbes passes targets through, adding requests for each of the
+// usual spec paths the first time a scheme and host turn up
+func openapiProbes(in <-chan target) <-chan target {
+	out := make(chan target)
+
+	go func() {
+		defer close(out)
+
+		seen := make(map[string]bool)
+		for t := range in {
+			out <- t
+
+			u, err := url.Parse(t.url)
+			if err != nil || u.Host == "" {
+				continue
+			}
+
+			origin := u.Scheme + "://" + u.Host
+			if seen[origin] {
+				continue
+			}
+			seen[origin] = true
+
+			for _, p := range openapiPaths {
+				out <- target{input: t.input, url: origin + p}
+			}
+		}
+	}()
+
+	return out
+}
+
+// openapiSpec is the part of an OpenAPI 3 or Swagger 2 spec that's
+// needed to work out which URLs it describes
+type openapiSpec struct {
+	OpenAPI  string `json:"openapi"`
+	Swagger  string `json:"swagger"`
+	BasePath string `json:"basePath"`
+	Servers  []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+type openapiParam struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Type     string        `json:"type"`
+	Enum     []interface{} `json:"enum"`
+	Schema   struct {
+		Type string        `json:"type"`
+		Enum []interface{} `json:"enum"`
+	} `json:"schema"`
+}
+
+// placeholder is a harmless value for a parameter: the first of its enum
+// if it has one, otherwise something of the right type
+func (p openapiParam) placeholder() string {
+	enum, typ := p.Enum, p.Type
+	if typ == "" {
+		enum, typ = p.Schema.Enum, p.Schema.Type
+	}
+
+	if len(enum) > 0 {
+		return fmt.Sprint(enum[0])
+	}
+	switch typ {
+	case "integer", "number":
+		return "1"
+	case "boolean":
+		return "true"
+	}
+	return "fff"
+}
+
+// parseOpenAPI returns the spec if body is a JSON OpenAPI or Swagger spec
+func parseOpenAPI(body []byte) (*openapiSpec, bool) {
+	var s openapiSpec
+	if json.Unmarshal(body, &s) != nil {
+		return nil, false
+	}
+	if (s.OpenAPI == "" && s.Swagger == "") || s.Paths == nil {
+		return nil, false
+	}
+	return &s, true
+}
+
+func (s *openapiSpec) version() string {
+	if s.OpenAPI != "" {
+		return "openapi " + s.OpenAPI
+	}
+	return "swagger " + s.Swagger
+}
+
+// basePath is the path that the spec's paths are relative to. Only the
+// path is taken from a server URL: everything's requested from the host
+// the spec was found on, so that an expanded spec can't send requests
+// somewhere that wasn't in the input.
+func (s *openapiSpec) basePath(specURL *url.URL) string {
+	if s.Swagger != "" {
+		return strings.TrimSuffix(s.BasePath, "/")
+	}
+	if len(s.Servers) == 0 || strings.Contains(s.Servers[0].URL, "{") {
+		return ""
+	}
+
+	u, err := specURL.Parse(s.Servers[0].URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// expand returns a URL for every GET operation in the spec, with
+// placeholders for the path parameters and any required query parameters.
+// Other methods are left alone so that expanding a spec can't change
+// anything.
+func (s *openapiSpec) expand(specURL *url.URL) []string {
+	base := s.basePath(specURL)
+
+	paths := make([]string, 0, len(s.Paths))
+	for p := range s.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var urls []string
+	for _, p := range paths {
+		item := s.Paths[p]
+		if _, ok := item["get"]; !ok {
+			continue
+		}
+
+		var params []openapiParam
+		for _, raw := range []json.RawMessage{item["parameters"], getParameters(item["get"])} {
+			var ps []openapiParam
+			json.Unmarshal(raw, &ps)
+			params = append(params, ps...)
+		}
+
+		query := url.Values{}
+		for _, param := range params {
+			switch {
+			case param.In == "path":
+				p = strings.ReplaceAll(p, "{"+param.Name+"}", url.PathEscape(param.placeholder()))
+			case param.In == "query" && param.Required:
+				query.Set(param.Name, param.placeholder())
+			}
+		}
+
+		// any path parameters that weren't described
+		for strings.Contains(p, "{") {
+			start := strings.Index(p, "{")
+			end := strings.Index(p[start:], "}")
+			if end == -1 {
+				break
+			}
+			p = p[:start] + "fff" + p[start+end+1:]
+		}
+
+		u := url.URL{Scheme: specURL.Scheme, Host: specURL.Host, Path: base + p, RawQuery: query.Encode()}
+		urls = append(urls, u.String())
+	}
+	return urls
+}
+
+func getParameters(op json.RawMessage) json.RawMessage {
+	var o struct {
+		Parameters json.RawMessage `json:"parameters"`
+	}
+	json.Unmarshal(op, &o)
+	return o.Parameters
+}
+
+// openapiDiscovery handles --openapi-discover, tagging the responses that
+// are API specs with openapi. With expand set, the URLs the specs describe
+// are passed to submit to be requested like any others.
+type openapiDiscovery struct {
+	expand bool
+	submit func([]target)
+
+	// URLs that have already been submitted, so that a spec found at
+	// more than one path is only expanded once
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newOpenAPIDiscovery(expand bool) *openapiDiscovery {
+	return &openapiDiscovery{expand: expand, seen: make(map[string]bool)}
+}
+
+// hook goes in the enrich stage
+func (d *openapiDiscovery) hook(j *job) bool {
+	spec, ok := parseOpenAPI(j.respBody)
+	if !ok {
+		return true
+	}
+
+	j.tag("openapi")
+	j.meta = append(j.meta, fmt.Sprintf("openapi: %s, %d paths", spec.version(), len(spec.Paths)))
+
+	if !d.expand || d.submit == nil {
+		return true
+	}
+
+	var targets []target
+	d.mu.Lock()
+	for _, u := range spec.expand(j.req.URL) {
+		if d.seen[u] {
+			continue
+		}
+		d.seen[u] = true
+		targets = append(targets, target{input: j.input, url: u})
+	}
+	d.mu.Unlock()
+
+	if len(targets) > 0 {
+		j.meta = append(j.meta, fmt.Sprintf("openapi-expanded: %d URLs", len(targets)))
+		d.submit(targets)
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package requester
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestOpenAPIExpand(t *testing.T) {
+	specURL, _ := url.Parse("https://example.com/docs/openapi.json")
+
+	cases := []struct {
+		spec string
+		want []string
+	}{
+		{
+			`{"openapi": "3.0.1", "servers": [{"url": "https://elsewhere.example.com/api/v1/"}], "paths": {
+				"/users/{id}": {
+					"parameters": [{"name": "id", "in": "path", "schema": {"type": "integer"}}],
+					"get": {"parameters": [
+						{"name": "order", "in": "query", "required": true, "schema": {"enum": ["asc", "desc"]}},
+						{"name": "q", "in": "query"}
+					]},
+					"delete": {}
+				},
+				"/users": {"post": {}},
+				"/files/{name}/{version}": {"get": {}}
+			}}`,
+			[]string{
+				"https://example.com/api/v1/files/fff/fff",
+				"https://example.com/api/v1/users/1?order=asc",
+			},
+		},
+		{
+			`{"swagger": "2.0", "basePath": "/v2", "paths": {
+				"/pets": {"get": {"parameters": [{"name": "all", "in": "query", "required": true, "type": "boolean"}]}},
+				"/pets/{petId}": {"get": {"parameters": [{"name": "petId", "in": "path", "type": "string"}]}}
+			}}`,
+			[]string{
+				"https://example.com/v2/pets?all=true",
+				"https://example.com/v2/pets/fff",
+			},
+		},
+		{
+			`{"openapi": "3.0.0", "servers": [{"url": "{scheme}://example.com/x"}], "paths": {"/": {"get": {}}}}`,
+			[]string{"https://example.com/"},
+		},
+	}
+
+	for i, c := range cases {
+		spec, ok := parseOpenAPI([]byte(c.spec))
+		if !ok {
+			t.Fatalf("case %d: not recognised as a spec", i)
+		}
+		if have := spec.expand(specURL); !reflect.DeepEqual(have, c.want) {
+			t.Errorf("case %d: want %v, have %v", i, c.want, have)
+		}
+	}
+
+	for _, notSpec := range []string{`{"paths": {}}`, `{"openapi": "3.0.0"}`, `<html>`} {
+		if _, ok := parseOpenAPI([]byte(notSpec)); ok {
+			t.Errorf("%s shouldn't be recognised as a spec", notSpec)
+		}
+	}
+}
+
+func TestOpenAPIProbes(t *testing.T) {
+	in := make(chan target)
+	go func() {
+		in <- target{input: "a", url: "https://example.com/one"}
+		in <- target{input: "b", url: "https://example.com/two"}
+		in <- target{input: "c", url: "http://example.com/"}
+		close(in)
+	}()
+
+	var have []target
+	for t := range openapiProbes(in) {
+		have = append(have, t)
+	}
+
+	// each origin gets probed once, after the target that first had it
+	if want := 3 + 2*len(openapiPaths); len(have) != want {
+		t.Fatalf("want %d targets, have %d", want, len(have))
+	}
+	if have[1] != (target{input: "a", url: "https://example.com" + openapiPaths[0]}) {
+		t.Errorf("unexpected probe %v", have[1])
+	}
+}
@@ -0,0 +1,194 @@
+package requester
+
+import (
+	"io"
+	"time"
+)
+
+// Options are everything that can be set on the command line; the README
+// has more on what each of them does. Start from DefaultOptions, which
+// has the same defaults as the command line, rather than the zero value.
+type Options struct {
+	// where URLs come from: the files, and Input, or Stdin if there
+	// are neither (--input). Stdin's where --rpc requests come from too.
+	InputFiles  InputArgs
+	Input       InputSource
+	InputFormat string
+	Stdin       io.Reader
+
+	// requesting only some of the input, for a first look (--sample,
+	// --sample-per-host)
+	Sample        SampleRate
+	SamplePerHost int
+
+	// what to request for each URL (--method, --header, --body...)
+	Method      string
+	MethodsFile string
+	Headers     HeaderArgs
+	Host        string
+	VhostFile   string
+	Body        string
+	RequestFile string
+	Ports       PortArgs
+	Paths       PathArgs
+	ExpandCIDR  bool
+
+	// how fast, and how many at once (--concurrency, --delay...)
+	Concurrency     int
+	Delay           time.Duration
+	AutoConcurrency bool
+	HostConcurrency int
+	HostDelay       time.Duration
+	SerializeHosts  bool
+	HostBackoff     bool
+	Interleave      bool
+	MaxBandwidth    BandwidthArg
+	Retries         int
+	RetryBackoff    time.Duration
+
+	// connections (--timeout, --proxy, --http2...)
+	KeepAlives        bool
+	Timeout           time.Duration
+	ConnectTimeout    time.Duration
+	TLSTimeout        time.Duration
+	StallTimeout      time.Duration
+	MinSpeed          BandwidthArg
+	MinSpeedTime      time.Duration
+	ProcessingTimeout time.Duration
+	TLS               TLSOptions
+	Proxy             string
+	ProxyFile         string
+	ProxyRandom       bool
+	HTTP2             bool
+	HTTP1Only         bool
+	DenyPrivate       bool
+	Resolve           ResolveArgs
+	ResolveFile       string
+	OptOutList        string
+	Sign              string
+
+	// following redirects (--follow-redirects, --redirect...)
+	FollowRedirects bool
+	MaxRedirects    int
+	Redirects       RedirectArgs
+
+	// reading bodies (--truncate-at, --max-size...)
+	TruncateAt      ByteSize
+	MaxSize         ByteSize
+	MaxDecompressed ByteSize
+	SkipOversized   bool
+	CountRunes      bool
+
+	// matching and filtering (-ms, -mr, -mc, -fc...)
+	MatchString    string
+	MatchRegex     RegexArgs
+	FilterRegex    RegexArgs
+	MinMatches     int
+	MatchCodes     StatusArgs
+	FilterCodes    StatusArgs
+	MatchSize      RangeArgs
+	MatchWords     RangeArgs
+	MatchLines     RangeArgs
+	FilterSize     RangeArgs
+	FilterWords    RangeArgs
+	FilterLines    RangeArgs
+	IgnoreHTML     bool
+	IgnoreEmpty    bool
+	AutoCalibrate  bool
+	Dedupe         bool
+	DedupePerHost  bool
+	SkipDuplicates bool
+	Script         string
+	ScriptProcs    int
+
+	// what's found out about responses (--show-matches, --reflect-marker...)
+	MatchContext    MatchContext
+	ShowMatches     bool
+	Preview         int
+	IncludeHeaders  bool
+	AuditCookies    bool
+	ReflectMarker   bool
+	ReflectIn       ReflectLocations
+	CompareSchemes  bool
+	CompareProfiles ProfileArgs
+	DiffHeaders     HeaderArgs
+	Mirror          string
+	GraphQL         bool
+	GraphQLQuery    string
+	GraphQLSchemas  string
+	OpenAPIDiscover bool
+	OpenAPIExpand   bool
+	Render          bool
+	Screenshot      bool
+	ChromePath      string
+
+	// saving responses (--output, --format...)
+	OutputDir      string
+	OutputFormat   string
+	OutputTemplate string
+	HashResponse   bool
+	SaveSent       bool
+	SaveChain      bool
+	Resume         bool
+	Shared         bool
+	FlushEvery     int
+	FlushInterval  time.Duration
+	SyncCmd        string
+	SyncInterval   time.Duration
+
+	// reporting results (--json, --pretty, --emit...)
+	JSON      bool
+	Pretty    bool
+	Null      bool
+	Emit      string
+	GroupBy   string
+	UniqueBy  string
+	StatsJSON string
+
+	// OnResult is called with each result, and OnError with the URL and
+	// the error for each request that failed. When either of them is set,
+	// nothing's printed on stdout.
+	OnResult func(Result)
+	OnError  func(url string, err error)
+
+	// Stdout is where results are printed otherwise, and where the
+	// output that comes at the end (--group-by, --compare-profiles) goes.
+	// Nothing's printed when it isn't set.
+	Stdout io.Writer
+
+	// Stderr is where warnings, errors that don't stop the run and the
+	// summaries at the end go. Nothing's printed when it isn't set.
+	Stderr io.Writer
+
+	// Quiet stops the summaries being printed at the end
+	Quiet bool
+
+	// running alongside other things (--grpc, --rpc, --capture-proxy...)
+	Forward      string
+	ForwardURLs  bool
+	GRPC         string
+	RPC          bool
+	CaptureProxy string
+	Health       string
+	PIDFile      string
+}
+
+// DefaultOptions returns the options the command line starts with
+func DefaultOptions() Options {
+	return Options{
+		Method:         "GET",
+		Delay:          100 * time.Millisecond,
+		RetryBackoff:   500 * time.Millisecond,
+		Timeout:        defaultTimeout,
+		ConnectTimeout: defaultTimeout,
+		TLSTimeout:     defaultTimeout,
+		MaxRedirects:   defaultMaxRedirects,
+		MaxSize:        defaultMaxSize,
+		MinMatches:     1,
+		MatchContext:   MatchContext{line: true},
+		FlushEvery:     100,
+		FlushInterval:  time.Second,
+		SyncInterval:   10 * time.Minute,
+		MinSpeedTime:   10 * time.Second,
+	}
+}
@@ -0,0 +1,132 @@
+package requester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// how long fetching an --optout-list URL can take before fff gives up
+// and doesn't start
+const optOutFetchTimeout = 30 * time.Second
+
+// optOutList is a list of domains that mustn't be contacted, from
+// --optout-list. A domain covers all of its subdomains too, and a leading
+// *. is allowed but makes no difference. IP addresses can be listed as
+// well. Blank lines and anything after a # are ignored, so lists like
+//
+//	# do not scan
+//	example.com
+//	*.internal.example.org
+//	192.0.2.10
+//
+// work as they are.
+type optOutList struct {
+	domains map[string]bool
+}
+
+// loadOptOutList reads the list from a file, or fetches it when it's an
+// http or https URL. Failing to get the list at all is an error, rather
+// than carrying on without it.
+func loadOptOutList(src string) (*optOutList, error) {
+	var r io.Reader
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		client := &http.Client{Timeout: optOutFetchTimeout}
+		resp, err := client.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %s", src, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	l := &optOutList{domains: make(map[string]bool)}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimPrefix(strings.TrimSpace(line), "*.")
+		if line == "" {
+			continue
+		}
+		l.domains[normaliseOptOutHost(line)] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func normaliseOptOutHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// contains reports whether the host, or any domain it's part of, is on
+// the list
+func (l *optOutList) contains(host string) bool {
+	host = normaliseOptOutHost(host)
+	if host == "" {
+		return false
+	}
+	if l.domains[host] {
+		return true
+	}
+	if net.ParseIP(host) != nil {
+		return false
+	}
+
+	for {
+		i := strings.IndexByte(host, '.')
+		if i == -1 {
+			return false
+		}
+		host = host[i+1:]
+		if l.domains[host] {
+			return true
+		}
+	}
+}
+
+// optOutTransport refuses requests to anything on the list. It wraps the
+// client's whole transport, so the list applies to everything fff
+// requests: redirects, retries, calibration, mirroring and the rest. The
+// Host header's checked as well as the URL, so an address that's
+// allowed can't be used to reach a name that isn't.
+type optOutTransport struct {
+	http.RoundTripper
+	list *optOutList
+}
+
+func (t *optOutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, host := range []string{req.URL.Hostname(), req.Host} {
+		if t.list.contains(host) {
+			return nil, fmt.Errorf("refusing to contact %s: it's on the opt-out list", normaliseOptOutHost(host))
+		}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// honourOptOuts makes the client refuse requests to anything on the list.
+// Like tapConnections, it has to come after everything else that sets the
+// client up.
+func honourOptOuts(client *http.Client, list *optOutList) {
+	client.Transport = &optOutTransport{RoundTripper: client.Transport, list: list}
+}
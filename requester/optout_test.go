@@ -0,0 +1,68 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOptOutList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "# do not scan\nExample.com.\n*.internal.example.org  # the lot\n\n192.0.2.10\n")
+	}))
+	defer srv.Close()
+
+	l, err := loadOptOutList(srv.URL + "/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"example.com":              true,
+		"www.example.com:443":      true,
+		"EXAMPLE.COM.":             true,
+		"notexample.com":           false,
+		"a.b.internal.example.org": true,
+		"example.org":              false,
+		"192.0.2.10":               true,
+		"192.0.2.11":               false,
+		"":                         false,
+	}
+	for host, want := range cases {
+		if have := l.contains(host); have != want {
+			t.Errorf("%q: want %t, have %t", host, want, have)
+		}
+	}
+
+	if _, err := loadOptOutList(srv.URL + "/missing"); err == nil {
+		t.Error("want an error for a list that can't be fetched")
+	}
+	if _, err := loadOptOutList("/no/such/list"); err == nil {
+		t.Error("want an error for a list that can't be read")
+	}
+}
+
+func TestOptOutTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := newClient(false, "")
+	honourOptOuts(client, &optOutList{domains: map[string]bool{"example.com": true}})
+
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Errorf("want request that isn't opted out to work, have %s", err)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Host = "www.example.com"
+	_, err := client.Do(req)
+	if err == nil || !strings.Contains(err.Error(), "opt-out list") {
+		t.Errorf("want request with an opted out Host refused, have %v", err)
+	}
+}
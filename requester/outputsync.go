@@ -0,0 +1,164 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the file in the output directory that says how much of the index has
+// been synced
+const syncStateFile = ".synced"
+
+// outputSyncer handles --sync-cmd, which runs a command every so often to
+// copy what's been saved somewhere safer, so a long run on a machine that
+// might go away doesn't lose everything. Only responses that are in the
+// index get synced, because they're the ones that have been written out
+// completely. How far through the index the last successful sync got is
+// kept in the output directory, so each sync only has the new files, even
+// across runs.
+//
+// The command's run with sh -c, with $FFF_OUTPUT set to the output
+// directory and $FFF_SYNC_LIST to a file listing the new files, relative
+// to the output directory, one per line, e.g.
+//
+//	rclone copy --files-from "$FFF_SYNC_LIST" "$FFF_OUTPUT" remote:scans
+type outputSyncer struct {
+	dir     string
+	cmd     string
+	timeout time.Duration
+
+	// where the command's output and failed syncs go
+	log io.Writer
+
+	// syncs don't overlap
+	mu sync.Mutex
+}
+
+func newOutputSyncer(dir, cmd string, timeout time.Duration, log io.Writer) *outputSyncer {
+	return &outputSyncer{dir: dir, cmd: cmd, timeout: timeout, log: log}
+}
+
+// loop syncs every interval
+func (s *outputSyncer) loop(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.final()
+	}
+}
+
+// final syncs, and says so if it didn't work; it's for the end of the
+// run, once the index has been written out
+func (s *outputSyncer) final() {
+	if err := s.sync(); err != nil {
+		fmt.Fprintf(s.log, "sync failed: %s\n", err)
+	}
+}
+
+// sync runs the command for anything that's been indexed since the last
+// time it succeeded. A sync that fails, or takes longer than the timeout,
+// is tried again next time.
+func (s *outputSyncer) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statePath := filepath.Join(s.dir, syncStateFile)
+	var offset int64
+	if data, err := ioutil.ReadFile(statePath); err == nil {
+		offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	files, end, err := s.newFiles(offset)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	list, err := ioutil.TempFile(s.dir, ".sync-list-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	_, err = list.WriteString(strings.Join(files, "\n") + "\n")
+	if cerr := list.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.cmd)
+	cmd.Env = append(os.Environ(), "FFF_OUTPUT="+s.dir, "FFF_SYNC_LIST="+list.Name())
+	cmd.Stdout, cmd.Stderr = s.log, s.log
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("gave up after %s", s.timeout)
+		}
+		return err
+	}
+
+	return writeFileAtomic(statePath, []byte(strconv.FormatInt(end, 10)+"\n"))
+}
+
+// newFiles lists the files for the responses on the complete lines of the
+// index after offset, and the index itself, and returns the offset of the
+// end of the last of those lines
+func (s *outputSyncer) newFiles(offset int64) ([]string, int64, error) {
+	f, err := os.Open(filepath.Join(s.dir, "index"))
+	if os.IsNotExist(err) {
+		return nil, offset, nil
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+
+	// an index that's smaller than it was has been started again
+	if info, err := f.Stat(); err == nil && info.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, offset, err
+	}
+
+	i := bytes.LastIndexByte(data, '\n')
+	if i == -1 {
+		return nil, offset, nil
+	}
+	data = data[:i+1]
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		body, _, _ := strings.Cut(line, " ")
+
+		// a response's files all have the same name as its body, apart
+		// from the extension
+		matches, _ := filepath.Glob(strings.TrimSuffix(body, ".body") + ".*")
+		for _, m := range matches {
+			rel, err := filepath.Rel(s.dir, m)
+			if err != nil || seen[rel] || strings.HasPrefix(filepath.Base(rel), ".") {
+				continue
+			}
+			seen[rel] = true
+			files = append(files, rel)
+		}
+	}
+	files = append(files, "index")
+
+	return files, offset + int64(len(data)), nil
+}
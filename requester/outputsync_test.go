@@ -0,0 +1,59 @@
+package requester
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputSyncer(t *testing.T) {
+	dir, logDir := t.TempDir(), t.TempDir()
+	log := filepath.Join(logDir, "log")
+
+	addResponse := func(name string) {
+		for _, ext := range []string{".body", ".headers"} {
+			ioutil.WriteFile(filepath.Join(dir, name+ext), nil, 0644)
+		}
+		f, _ := os.OpenFile(filepath.Join(dir, "index"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f.WriteString(filepath.Join(dir, name+".body") + " http://example.com/" + name + " (200) 0 2026-01-02T15:04:05Z text/html\n")
+		f.Close()
+	}
+	synced := func() string {
+		data, _ := ioutil.ReadFile(log)
+		os.Remove(log)
+		return strings.TrimSpace(string(data))
+	}
+
+	s := newOutputSyncer(dir, `cat "$FFF_SYNC_LIST" >> `+log, time.Minute, ioutil.Discard)
+
+	addResponse("a")
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if have := synced(); have != "a.body\na.headers\nindex" {
+		t.Errorf("unexpected first sync %q", have)
+	}
+
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if have := synced(); have != "" {
+		t.Errorf("want nothing to sync, have %q", have)
+	}
+
+	// a failed sync is tried again next time
+	addResponse("b")
+	failing := newOutputSyncer(dir, "exit 1", time.Minute, ioutil.Discard)
+	if err := failing.sync(); err == nil {
+		t.Error("want an error from a failed sync")
+	}
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if have := synced(); have != "b.body\nb.headers\nindex" {
+		t.Errorf("unexpected sync after a failure %q", have)
+	}
+}
@@ -0,0 +1,138 @@
+package requester
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputTemplate is the layout of the output directory from
+// --output-template, which names a response's files with placeholders:
+//
+//	{{hostname}}   the host name, without the port
+//	{{host}}       the host name, and _port if the URL has a port
+//	{{port}}       the port, which is 80 or 443 if the URL doesn't have one
+//	{{scheme}}     http or https
+//	{{path}}       the path, tidied up the same way as the default layout's
+//	{{method}}     the request method
+//	{{status}}     the response's status code
+//	{{ext}}        an extension for the response's content type (html, json...)
+//	{{hash}}       the response's ID, which every template has to have
+//	{{date}}       the date it was saved, as 2006-01-02
+//	{{timestamp}}  when it was saved, as 20060102T150405Z
+//
+// The default layout is {{hostname}}/{{path}}/{{hash}}. The files for a
+// response are its path with .body, .headers and so on added; a .body on
+// the end of the template is taken off first, so either way works.
+type outputTemplate struct {
+	tmpl string
+}
+
+var outputPlaceholderRe = regexp.MustCompile(`{{([a-z]*)}}`)
+
+var outputPlaceholders = map[string]bool{
+	"hostname": true, "host": true, "port": true, "scheme": true, "path": true, "method": true,
+	"status": true, "ext": true, "hash": true, "date": true, "timestamp": true,
+}
+
+func parseOutputTemplate(tmpl string) (*outputTemplate, error) {
+	tmpl = strings.TrimSuffix(tmpl, ".body")
+
+	for _, m := range outputPlaceholderRe.FindAllStringSubmatch(tmpl, -1) {
+		if !outputPlaceholders[m[1]] {
+			return nil, fmt.Errorf("unknown placeholder %s", m[0])
+		}
+	}
+	if !strings.Contains(tmpl, "{{hash}}") {
+		return nil, fmt.Errorf("it needs {{hash}} in it, so that responses can't overwrite each other")
+	}
+	return &outputTemplate{tmpl: tmpl}, nil
+}
+
+// render returns the base path for the artifact's files, relative to the
+// output directory. Nothing that's filled in can take it outside of the
+// directory.
+func (t *outputTemplate) render(a artifact, u *url.URL, host string, now time.Time) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	hostPort := host
+	if u.Port() != "" {
+		hostPort += "_" + u.Port()
+	}
+
+	status, contentType := 0, ""
+	if a.Response != nil {
+		status, contentType = a.Response.StatusCode, a.Response.Header.Get("Content-Type")
+	}
+
+	values := map[string]string{
+		"hostname":  host,
+		"host":      hostPort,
+		"port":      port,
+		"scheme":    u.Scheme,
+		"path":      strings.TrimPrefix(normalisePath(u), "/"),
+		"method":    a.Method,
+		"status":    strconv.Itoa(status),
+		"ext":       contentTypeExtension(contentType),
+		"hash":      a.Hash,
+		"date":      now.UTC().Format("2006-01-02"),
+		"timestamp": now.UTC().Format("20060102T150405Z"),
+	}
+
+	p := outputPlaceholderRe.ReplaceAllStringFunc(t.tmpl, func(m string) string {
+		v := values[m[2:len(m)-2]]
+		if m == "{{path}}" {
+			return v
+		}
+		return unsafePathChars.ReplaceAllString(v, "-")
+	})
+
+	// cleaning the path as if it were absolute gets rid of any ../
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// extensions for the most common content types, so they're the same
+// everywhere; anything else is looked up in the system's MIME types
+var contentTypeExtensions = map[string]string{
+	"text/html":              "html",
+	"text/plain":             "txt",
+	"text/css":               "css",
+	"text/javascript":        "js",
+	"application/javascript": "js",
+	"application/json":       "json",
+	"application/xml":        "xml",
+	"text/xml":               "xml",
+	"image/png":              "png",
+	"image/jpeg":             "jpg",
+	"image/gif":              "gif",
+	"image/svg+xml":          "svg",
+	"application/pdf":        "pdf",
+}
+
+// contentTypeExtension returns an extension for the content type, or bin
+// when there's no telling
+func contentTypeExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "bin"
+	}
+	if ext, ok := contentTypeExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return strings.TrimPrefix(exts[0], ".")
+	}
+	return "bin"
+}
@@ -0,0 +1,50 @@
+package requester
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestOutputTemplate(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	a := artifact{
+		Hash:     "0a4d55a8d778e5022fab701977c5d840bbc486d0",
+		Method:   "POST",
+		Response: &http.Response{StatusCode: 404, Header: http.Header{"Content-Type": {"application/json; charset=utf-8"}}},
+	}
+
+	cases := []struct {
+		tmpl string
+		url  string
+		want string
+	}{
+		{"{{hostname}}/{{path}}/{{status}}-{{hash}}.body", "https://example.com/a b/c", "example.com/a-b/c/404-" + a.Hash},
+		{"{{host}}/{{method}}/{{hash}}.{{ext}}", "http://example.com:8080/", "example.com_8080/POST/" + a.Hash + ".json"},
+		{"{{date}}/{{scheme}}-{{port}}/{{timestamp}}-{{hash}}", "https://example.com/", "2026-01-02/https-443/20260102T150405Z-" + a.Hash},
+		{"../../{{path}}/../../{{hash}}", "https://example.com/x", a.Hash},
+	}
+
+	for _, c := range cases {
+		tmpl, err := parseOutputTemplate(c.tmpl)
+		if err != nil {
+			t.Errorf("%s: %s", c.tmpl, err)
+			continue
+		}
+		u, _ := url.Parse(c.url)
+		if have := tmpl.render(a, u, u.Hostname(), now); have != c.want {
+			t.Errorf("%s: want %s, have %s", c.tmpl, c.want, have)
+		}
+
+		if h := hashFromPath("out/" + c.want + ".body"); h != a.Hash {
+			t.Errorf("%s: want hash back out of the path, have %q", c.tmpl, h)
+		}
+	}
+
+	for _, bad := range []string{"{{hostname}}/{{path}}", "{{hash}}-{{colour}}"} {
+		if _, err := parseOutputTemplate(bad); err == nil {
+			t.Errorf("want error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package requester
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+)
+
+// hostPins are the addresses a job's connections are pinned to. The
+// first connection to a host decides its address, and every connection
+// after that for the same job goes to the same place, so a host that
+// resolves to something different halfway through (DNS rebinding) can't
+// change where the job's requests end up.
+type hostPins struct {
+	mu    sync.Mutex
+	addrs map[string]string
+}
+
+func newHostPins() *hostPins {
+	return &hostPins{addrs: make(map[string]string)}
+}
+
+func (p *hostPins) get(host string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ip, ok := p.addrs[host]
+	return ip, ok
+}
+
+// public reports whether any host's been pinned to a public address
+func (p *hostPins) public() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ip := range p.addrs {
+		if parsed := net.ParseIP(ip); parsed != nil && !isPrivateIP(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// pin sets the address for a host, unless it's already got one
+func (p *hostPins) pin(host, ip string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.addrs[host]; !ok {
+		p.addrs[host] = ip
+	}
+}
+
+type hostPinsKey struct{}
+
+// withPins returns a context that makes requests use the pins. It's for
+// requests made on a job's behalf that aren't the job's own request, which
+// shouldn't get the rest of its context (like its connection trace).
+func withPins(ctx context.Context, p *hostPins) context.Context {
+	if p == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, hostPinsKey{}, p)
+}
+
+// pinDNS makes the client connect to the pinned address for a host when
+// the request's context has pins, and pin hosts that haven't been yet.
+// Once a job's connected somewhere public, it can't go on to somewhere
+// private (a redirect to a host that resolves to 127.0.0.1 or the cloud
+// metadata address, say): connecting to a new host like that is refused.
+func pinDNS(client *http.Client) {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{ControlContext: dialControl}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		pins, ok := ctx.Value(hostPinsKey{}).(*hostPins)
+		if !ok {
+			return dial(ctx, network, addr)
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		if ip, ok := pins.get(host); ok {
+			return dial(ctx, network, net.JoinHostPort(ip, port))
+		}
+
+		// the first connection to a host (like one a redirect went to)
+		// decides where it's pinned
+		var policy dialPolicy = func(string) error { return nil }
+		if pins.public() {
+			policy = func(address string) error {
+				if denyPrivatePolicy(address) != nil {
+					ip, _, _ := net.SplitHostPort(address)
+					return fmt.Errorf("refusing to go from a public address to private address %s for %s", ip, host)
+				}
+				return nil
+			}
+		}
+
+		conn, err := dial(withDialPolicy(ctx, policy), network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// in case the dialer underneath doesn't use dialControl
+		if err := policy(conn.RemoteAddr().String()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if ip, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			pins.pin(host, ip)
+		}
+		return conn, nil
+	}
+}
+
+// pinner keeps the first address each host was pinned to during the run,
+// so that jobs can note when a host's address has changed since
+type pinner struct {
+	mu    sync.Mutex
+	first map[string]string
+}
+
+func newPinner() *pinner {
+	return &pinner{first: make(map[string]string)}
+}
+
+// hook gives the job its pins, which are filled in as connections are
+// made (or reused). It goes in the schedule stage, just before the fetch.
+func (p *pinner) hook(j *job) bool {
+	j.pins = newHostPins()
+
+	host := j.req.URL.Hostname()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if ip, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+				j.pins.pin(host, ip)
+			}
+		},
+	}
+
+	ctx := httptrace.WithClientTrace(withPins(j.req.Context(), j.pins), trace)
+	j.req = j.req.WithContext(ctx)
+	return true
+}
+
+// metaHook notes the addresses the job was pinned to, and any that are
+// different from the first address seen for the host. Hosts behind
+// round-robin DNS and CDNs change all the time, but it's worth knowing
+// about for ones that shouldn't. It goes in the enrich stage.
+func (p *pinner) metaHook(j *job) bool {
+	if j.pins == nil {
+		return true
+	}
+
+	j.pins.mu.Lock()
+	hosts := make([]string, 0, len(j.pins.addrs))
+	for h := range j.pins.addrs {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	p.mu.Lock()
+	for _, h := range hosts {
+		ip := j.pins.addrs[h]
+		j.meta = append(j.meta, fmt.Sprintf("pinned: %s %s", h, ip))
+
+		first, ok := p.first[h]
+		if !ok {
+			p.first[h] = ip
+			continue
+		}
+		if first != ip {
+			j.meta = append(j.meta, fmt.Sprintf("dns-changed: %s was %s, now %s", h, first, ip))
+		}
+	}
+	p.mu.Unlock()
+	j.pins.mu.Unlock()
+
+	return true
+}
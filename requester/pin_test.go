@@ -0,0 +1,98 @@
+package requester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPinDNS(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	client := newClient(false, "")
+	recordDNS(client)
+	pinDNS(client)
+
+	// a host that doesn't resolve at all, standing in for one that now
+	// resolves somewhere else, still goes to the pinned address
+	pins := newHostPins()
+	pins.pin("rebound.invalid", "127.0.0.1")
+
+	req, _ := http.NewRequestWithContext(withPins(context.Background(), pins), "GET", "http://rebound.invalid:"+u.Port()+"/ok", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// without the pin it doesn't
+	req, _ = http.NewRequest("GET", "http://rebound.invalid:"+u.Port()+"/ok", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("want an error without the pin")
+	}
+}
+
+func TestPinner(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	client := newClient(false, "")
+	pinDNS(client)
+	p := newPinner()
+
+	j := newJob(srv.URL+"/ok", "GET", "", nil)
+	if !buildRequestHook(j) || !p.hook(j) || !fetchHook(client, bodyLimits{})(j) || !p.metaHook(j) {
+		t.Fatal(j.err)
+	}
+	if ip, _ := j.pins.get("127.0.0.1"); ip != "127.0.0.1" {
+		t.Errorf("want the job pinned to 127.0.0.1, have %q", ip)
+	}
+
+	// a later job pinned somewhere else gets noted
+	other := &job{pins: newHostPins()}
+	other.pins.pin("127.0.0.1", "10.0.0.1")
+	p.metaHook(other)
+
+	if want := "dns-changed: 127.0.0.1 was 127.0.0.1, now 10.0.0.1"; !strings.Contains(strings.Join(other.meta, "\n"), want) {
+		t.Errorf("want %q in meta, have %v", want, other.meta)
+	}
+}
+
+func TestPinDNSPublicToPrivate(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	client := newClient(false, "")
+	pinDNS(client)
+
+	// a job that started out somewhere public (standing in for one that
+	// was redirected) can't move on to a new host that's private
+	pins := newHostPins()
+	pins.pin("public.example.com", "93.184.216.34")
+
+	req, _ := http.NewRequestWithContext(withPins(context.Background(), pins), "GET", "http://localhost:"+u.Port()+"/ok", nil)
+	_, err := client.Do(req)
+	if err == nil || !strings.Contains(err.Error(), "from a public address to private address") {
+		t.Errorf("want the connection refused, have %v", err)
+	}
+	if _, ok := pins.get("localhost"); ok {
+		t.Error("want the refused host left unpinned")
+	}
+
+	// one that started somewhere private can
+	pins = newHostPins()
+	pins.pin("internal.example.com", "10.0.0.1")
+
+	req, _ = http.NewRequestWithContext(withPins(context.Background(), pins), "GET", "http://localhost:"+u.Port()+"/ok", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
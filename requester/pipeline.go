@@ -0,0 +1,235 @@
+package requester
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Every input URL becomes a job that's passed through each of the stages
+// of the pipeline in turn:
+//
+//	prepare → schedule → fetch → filter → enrich → store → report
+//
+// Each stage is a list of hooks. A hook can look at and modify the job,
+// or return false to stop it going any further (e.g. a filter that doesn't
+// match). Setting job.err stops the job too, but runs the error hooks
+// first so that the failure can be reported.
+type stage int
+
+const (
+	stagePrepare stage = iota
+	stageSchedule
+	stageFetch
+	stageFilter
+	stageEnrich
+	stageStore
+	stageReport
+
+	numStages
+)
+
+// hook is a single step in a stage of the pipeline
+type hook func(j *job) bool
+
+// redirectHook decides what to do when a job's request is redirected.
+// It can change where the redirect goes by modifying next, and says
+// whether to follow it, not follow it, or leave it to the next hook.
+type redirectHook func(j *job, next *url.URL) redirectDecision
+
+type redirectDecision int
+
+const (
+	redirectPass redirectDecision = iota
+	redirectFollow
+	redirectStop
+)
+
+type pipeline struct {
+	hooks         [numStages][]hook
+	errorHooks    []func(j *job)
+	redirectHooks []redirectHook
+
+	// the most redirects to follow for a job; zero means the default
+	maxRedirects int
+}
+
+// Use adds a hook to the end of a stage
+func (p *pipeline) Use(s stage, h hook) {
+	p.hooks[s] = append(p.hooks[s], h)
+}
+
+// OnError adds a hook that's called for jobs that fail
+func (p *pipeline) OnError(h func(j *job)) {
+	p.errorHooks = append(p.errorHooks, h)
+}
+
+// OnRedirect adds a hook that's asked about redirects. Redirects are only
+// followed when one of them says so.
+func (p *pipeline) OnRedirect(h redirectHook) {
+	p.redirectHooks = append(p.redirectHooks, h)
+}
+
+// Run passes the job through every stage until it's finished or stopped
+func (p *pipeline) Run(j *job) {
+	defer j.finish()
+	j.pipe = p
+
+	for s := stage(0); s < numStages; s++ {
+		for _, h := range p.hooks[s] {
+			ok := h(j)
+
+			if j.err != nil {
+				for _, eh := range p.errorHooks {
+					eh(j)
+				}
+				return
+			}
+
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// job is a single request and its response on their way through the
+// pipeline
+type job struct {
+	// the input line the job came from, and the request to make
+	input   string
+	url     string
+	method  string
+	body    string
+	headers []string
+
+	req      *http.Request
+	resp     *http.Response
+	respBody []byte
+
+	// the body's size, words and lines, counted as it was read
+	counts *bodyCounts
+
+	// how long it took to send the request and read the response, and
+	// what happened when connecting
+	fetchTime time.Duration
+	conn      *connInfo
+
+	// informational responses received before the real one
+	interim *interimResponses
+
+	// the bytes that were actually sent, with --save-sent
+	sent *sentRecord
+
+	// the redirects and retries before the final response, with
+	// --save-chain
+	chain *responseChain
+
+	// the addresses the job's connections are pinned to
+	pins *hostPins
+
+	// when looking through the response started, and how many steps
+	// were skipped because it took too long, with --processing-timeout
+	analysisStart   time.Time
+	analysisSkipped int
+
+	// the proxy from --proxy-file that the request last went through
+	proxy *url.URL
+
+	// err is set when something has gone wrong with the job
+	err error
+
+	// marker is the reflection marker injected into the request, if any
+	marker string
+
+	// the name in the Host header, with --vhost-file
+	vhost string
+
+	// meta lines hold detail about the response that's stored with it,
+	// extras are extra files to store alongside the body
+	meta   []string
+	extras map[string][]byte
+
+	// res is what gets stored in the index and reported
+	res Result
+
+	// the pipeline running the job, which has a say in its redirects,
+	// and the redirects that were followed, as "status URL"
+	pipe      *pipeline
+	redirects []string
+
+	cleanups []func()
+}
+
+func newJob(rawURL, method, body string, headers []string) *job {
+	return &job{
+		input:   rawURL,
+		url:     rawURL,
+		method:  method,
+		body:    body,
+		headers: headers,
+		extras:  make(map[string][]byte),
+	}
+}
+
+// requestID is the hash of the request as it's going to be sent. The URL
+// comes from the request along with everything else, so a reflection
+// marker in the query string counts the same as one in a header.
+func (j *job) requestID() (string, error) {
+	sum, err := j.requestSum()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sum[:]), nil
+}
+
+// requestSum is requestID before it's turned into hex
+func (j *job) requestSum() ([sha1.Size]byte, error) {
+	body, err := requestBody(j.req)
+	if err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	return artifactSum(j.method, j.req.URL.String(), sentHeaderLines(j.req), body, nil, nil), nil
+}
+
+// analysisCopy copies the job for a --processing-timeout step, which might
+// be left running after the job's moved on. Anything the step could add to
+// is copied, so that it can't change what the job has.
+func (j *job) analysisCopy() *job {
+	c := *j
+	c.meta = c.meta[:len(c.meta):len(c.meta)]
+	c.cleanups = c.cleanups[:len(c.cleanups):len(c.cleanups)]
+	c.res.Tags = c.res.Tags[:len(c.res.Tags):len(c.res.Tags)]
+	c.res.Matches = c.res.Matches[:len(c.res.Matches):len(c.res.Matches)]
+
+	c.extras = make(map[string][]byte, len(j.extras))
+	for k, v := range j.extras {
+		c.extras[k] = v
+	}
+	if j.res.Fields != nil {
+		c.res.Fields = make(map[string]interface{}, len(j.res.Fields))
+		for k, v := range j.res.Fields {
+			c.res.Fields[k] = v
+		}
+	}
+	return &c
+}
+
+// tag adds a short label for something interesting about the response
+func (j *job) tag(t string) {
+	j.res.Tags = append(j.res.Tags, t)
+}
+
+// onFinish registers a function to be called when the job is finished
+// with, however far through the pipeline it got
+func (j *job) onFinish(fn func()) {
+	j.cleanups = append(j.cleanups, fn)
+}
+
+func (j *job) finish() {
+	for i := len(j.cleanups) - 1; i >= 0; i-- {
+		j.cleanups[i]()
+	}
+}
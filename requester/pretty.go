@@ -0,0 +1,161 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// prettyOutput handles --pretty: a line per result in columns that line up,
+// coloured by the status class, with long URLs cut down to fit the
+// terminal. It's only for people, so when stdout isn't a terminal the
+// usual output is used instead.
+type prettyOutput struct {
+	w     io.Writer
+	color bool
+	width int
+}
+
+// ANSI colours for each status class, and for errors
+var (
+	statusColors = map[int]string{
+		1: "\x1b[37m",
+		2: "\x1b[32m",
+		3: "\x1b[36m",
+		4: "\x1b[33m",
+		5: "\x1b[31m",
+	}
+	errorColor = "\x1b[35m"
+	dimColor   = "\x1b[2m"
+	resetColor = "\x1b[0m"
+)
+
+// the widths of the columns before the URL
+const prettyPrefixWidth = len("200  123.4kB  123456w  12345l  application/json  ")
+
+// newPrettyOutput returns nil when stdout isn't a terminal; the lines
+// are written to w. The terminal's width is taken from $COLUMNS, because
+// there's no portable way of asking the terminal without going outside the
+// standard library. Colour's left out when $NO_COLOR is set.
+func newPrettyOutput(stdout, w io.Writer) *prettyOutput {
+	if !isTerminal(stdout) {
+		return nil
+	}
+
+	p := &prettyOutput{w: w, color: os.Getenv("NO_COLOR") == "", width: 120}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		p.width = n
+	}
+	return p
+}
+
+// isTerminal reports whether w is a terminal rather than a file, a pipe
+// or something else altogether
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *prettyOutput) hook(j *job) bool {
+	fmt.Fprint(p.w, p.format(j.res))
+	return true
+}
+
+func (p *prettyOutput) errorHook(j *job) {
+	fmt.Fprint(p.w, p.formatError(j))
+}
+
+// format returns the line for a result:
+//
+//	200  1.2kB  298w  47l  text/html  https://example.com/ [tags] -> location
+func (p *prettyOutput) format(r Result) string {
+	contentType := r.ContentType
+	if i := strings.IndexByte(contentType, ';'); i != -1 {
+		contentType = contentType[:i]
+	}
+	if contentType == "" {
+		contentType = "-"
+	}
+
+	cols := fmt.Sprintf("%-3d  %7s  %7s  %6s  %-16s  ",
+		r.Status,
+		formatBytes(r.Size),
+		strconv.Itoa(r.Words)+"w",
+		strconv.Itoa(r.Lines)+"l",
+		truncateMiddle(contentType, 16),
+	)
+
+	var extra string
+	if r.VHost != "" {
+		extra += " (vhost: " + r.VHost + ")"
+	}
+	if len(r.Tags) > 0 {
+		extra += " [" + strings.Join(r.Tags, " ") + "]"
+	}
+	if r.Title != "" {
+		extra += " " + strconv.Quote(truncateMiddle(r.Title, 40))
+	}
+	if r.Location != "" {
+		extra += " -> " + r.Location
+	}
+
+	u := truncateMiddle(r.URL, p.urlWidth(len(extra)))
+	if !p.color {
+		return cols + u + extra + "\n"
+	}
+
+	c := statusColors[r.Status/100]
+	return c + cols[:3] + resetColor + cols[3:] + u + dimColor + extra + resetColor + "\n"
+}
+
+func (p *prettyOutput) formatError(j *job) string {
+	cols := fmt.Sprintf("%-*s", prettyPrefixWidth, "ERR")
+
+	// the URL's already there
+	err := j.err
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	msg := " " + err.Error()
+
+	u := truncateMiddle(j.url, p.urlWidth(0))
+	if !p.color {
+		return cols + u + msg + "\n"
+	}
+	return errorColor + cols[:3] + resetColor + cols[3:] + u + dimColor + msg + resetColor + "\n"
+}
+
+// urlWidth is how much room there is for the URL on a line with the
+// columns and then extra bytes after it. URLs always get a fair amount,
+// and it's the extra that wraps on a narrow terminal.
+func (p *prettyOutput) urlWidth(extra int) int {
+	w := p.width - prettyPrefixWidth - extra
+	if w < 40 {
+		w = 40
+	}
+	return w
+}
+
+// truncateMiddle cuts s down to n runes by taking out the middle, which
+// keeps the host and the end of the path of a URL
+func truncateMiddle(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n < 3 {
+		return string(r[:n])
+	}
+	head := (n - 1) / 2
+	tail := n - 1 - head
+	return string(r[:head]) + "…" + string(r[len(r)-tail:])
+}
@@ -0,0 +1,54 @@
+package requester
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestPrettyOutput(t *testing.T) {
+	p := &prettyOutput{width: prettyPrefixWidth + 40}
+
+	r := Result{
+		URL:         "https://example.com/a/very/long/path/that/wont/fit/on/the/line",
+		Status:      301,
+		Size:        2048,
+		Words:       12,
+		Lines:       3,
+		ContentType: "text/html; charset=utf-8",
+		Location:    "/b",
+	}
+	want := "301    2.0kB      12w      3l  text/html         https://example.com…wont/fit/on/the/line -> /b\n"
+	if have := p.format(r); have != want {
+		t.Errorf("want\n%q\nhave\n%q", want, have)
+	}
+
+	j := &job{url: "http://example.com/", err: &url.Error{Op: "Get", URL: "http://example.com/", Err: errors.New("refused")}}
+	want = "ERR                                              http://example.com/ refused\n"
+	if have := p.formatError(j); have != want {
+		t.Errorf("want\n%q\nhave\n%q", want, have)
+	}
+
+	p.color = true
+	if have := p.format(r); have[:len(statusColors[3])] != statusColors[3] {
+		t.Errorf("want a 3xx coloured as one, have %q", have)
+	}
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	cases := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"abcdefghij", 5, "ab…ij"},
+		{"abcdefghij", 6, "ab…hij"},
+		{"héllo wörld", 7, "hél…rld"},
+	}
+	for _, c := range cases {
+		if have := truncateMiddle(c.s, c.n); have != c.want {
+			t.Errorf("%q to %d: want %q, have %q", c.s, c.n, c.want, have)
+		}
+	}
+}
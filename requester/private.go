@@ -0,0 +1,125 @@
+package requester
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// extra ranges that aren't public but that net.IP's methods don't know
+// about
+var privateNets = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),        // "this" network
+	mustCIDR("100.64.0.0/10"),    // carrier-grade NAT, and Alibaba's metadata service
+	mustCIDR("192.0.0.0/24"),     // IETF protocol assignments
+	mustCIDR("198.18.0.0/15"),    // benchmarking
+	mustCIDR("168.63.129.16/32"), // Azure's host services
+	mustCIDR("255.255.255.255/32"),
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// nat64 addresses have an IPv4 address in their last four bytes
+var nat64 = mustCIDR("64:ff9b::/96")
+
+// isPrivateIP reports whether an IP is somewhere --deny-private shouldn't
+// go: private networks, loopback, link-local (which includes the cloud
+// metadata services at 169.254.169.254) and the like
+func isPrivateIP(ip net.IP) bool {
+	if nat64.Contains(ip) {
+		ip = ip[12:16]
+	}
+
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialPolicy decides whether a connection to an address (an IP and port,
+// after DNS has been dealt with) is allowed
+type dialPolicy func(address string) error
+
+type dialPolicyKey struct{}
+
+// dialControl is the Control function for fff's dialers. It's called
+// with the address that's actually about to be connected to, and applies
+// any policy in the dial's context.
+func dialControl(ctx context.Context, network, address string, c syscall.RawConn) error {
+	if policy, ok := ctx.Value(dialPolicyKey{}).(dialPolicy); ok {
+		return policy(address)
+	}
+	return nil
+}
+
+// withDialPolicy adds a policy to the context, on top of any it already
+// has
+func withDialPolicy(ctx context.Context, policy dialPolicy) context.Context {
+	if prev, ok := ctx.Value(dialPolicyKey{}).(dialPolicy); ok {
+		next := policy
+		policy = func(address string) error {
+			if err := prev(address); err != nil {
+				return err
+			}
+			return next(address)
+		}
+	}
+	return context.WithValue(ctx, dialPolicyKey{}, policy)
+}
+
+func denyPrivatePolicy(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateIP(ip) {
+		return fmt.Errorf("refusing to connect to private address %s", host)
+	}
+	return nil
+}
+
+// denyPrivate handles --deny-private, making the client refuse to connect
+// to private addresses. It's checked at the last moment, against the
+// address that's about to be connected to, so a hostname that resolves
+// to something public the first time and something private the next
+// can't get around it.
+func denyPrivate(client *http.Client) {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{ControlContext: dialControl}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(withDialPolicy(ctx, denyPrivatePolicy), network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// in case the dialer underneath doesn't use dialControl
+		if err := denyPrivatePolicy(conn.RemoteAddr().String()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
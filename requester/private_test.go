@@ -0,0 +1,54 @@
+package requester
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsPrivateIP(t *testing.T) {
+	cases := map[string]bool{
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"127.0.0.1":        true,
+		"169.254.169.254":  true,
+		"100.100.100.200":  true,
+		"0.0.0.0":          true,
+		"168.63.129.16":    true,
+		"::1":              true,
+		"fe80::1":          true,
+		"fd00:ec2::254":    true,
+		"::ffff:10.0.0.1":  true,
+		"64:ff9b::a00:1":   true,
+		"8.8.8.8":          false,
+		"172.32.0.1":       false,
+		"2606:4700::1111":  false,
+		"64:ff9b::808:808": false,
+	}
+
+	for s, want := range cases {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		if have := isPrivateIP(ip); have != want {
+			t.Errorf("%s: want %t, have %t", s, want, have)
+		}
+	}
+}
+
+func TestDenyPrivate(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	client := newClient(false, "")
+	recordDNS(client)
+	denyPrivate(client)
+
+	_, err := client.Get(srv.URL + "/ok")
+	if err == nil || !strings.Contains(err.Error(), "refusing to connect to private address 127.0.0.1") {
+		t.Errorf("want the connection refused, have %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package requester
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// profile is everything about a request that --compare-profiles lets
+// differ between the two fetches of a URL; anything left unset falls
+// back to the command line
+type profile struct {
+	name    string
+	method  string
+	body    string
+	headers []string
+}
+
+// loadProfile reads a profile from a file. Profiles are written in a
+// small subset of YAML:
+//
+//	name: admin
+//	method: POST
+//	headers:
+//	  Cookie: session=abc123
+//	  Authorization: Bearer xyz
+//	body: |
+//	  {"all": true}
+//
+// headers can be a list of 'Name: value' items instead. The name
+// defaults to the file's name without the extension.
+func loadProfile(path string) (*profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := parseProfile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if p.name == "" {
+		p.name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+func parseProfile(r io.Reader) (*profile, error) {
+	p := &profile{}
+
+	// the key whose indented block we're in, if any
+	var block string
+	var bodyLines []string
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+
+		indented := len(trimmed) < len(line)
+		if block != "" && (indented || (block == "body" && trimmed == "")) {
+			switch block {
+			case "body":
+				bodyLines = append(bodyLines, line)
+			case "headers":
+				if trimmed == "" || trimmed[0] == '#' {
+					continue
+				}
+				h := strings.TrimSpace(strings.TrimPrefix(trimmed, "- "))
+				h = unquoteYAML(h)
+				name, val, ok := strings.Cut(h, ":")
+				if !ok {
+					return nil, fmt.Errorf("line %d: invalid header %q", n, h)
+				}
+				p.headers = append(p.headers, strings.TrimSpace(name)+": "+unquoteYAML(strings.TrimSpace(val)))
+			}
+			continue
+		}
+
+		if block == "body" {
+			p.body = blockScalar(bodyLines)
+		}
+		block = ""
+
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		if indented {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+
+		key, val, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected 'key: value'", n)
+		}
+		val = unquoteYAML(strings.TrimSpace(val))
+
+		switch key {
+		case "name":
+			p.name = val
+		case "method":
+			p.method = val
+		case "body":
+			if val == "|" {
+				block = "body"
+				bodyLines = nil
+				continue
+			}
+			p.body = val
+		case "headers":
+			if val != "" {
+				return nil, fmt.Errorf("line %d: headers should be an indented block", n)
+			}
+			block = "headers"
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n, key)
+		}
+	}
+	if block == "body" {
+		p.body = blockScalar(bodyLines)
+	}
+	return p, sc.Err()
+}
+
+// blockScalar joins the lines of a literal block, taking off the first
+// line's indentation and any trailing blank lines
+func blockScalar(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	indent := len(lines[0]) - len(strings.TrimLeft(lines[0], " \t"))
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if len(l) >= indent {
+			l = l[indent:]
+		}
+		out[i] = l
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+func unquoteYAML(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// ProfileArgs is a flag for the two profile files, which can be comma
+// separated or given one at a time
+type ProfileArgs []string
+
+func (p *ProfileArgs) Set(val string) error {
+	*p = append(*p, strings.Split(val, ",")...)
+	return nil
+}
+
+func (p ProfileArgs) String() string {
+	return strings.Join(p, ",")
+}
+
+// similarity scores how alike two bodies are, from 0 to 1, by comparing
+// how often each word appears in them. Word counts are used rather than
+// a diff so that things like reordered lists, timestamps and CSRF tokens
+// only cost a little.
+func similarity(a, b []byte) float64 {
+	wa, wb := wordCounts(a), wordCounts(b)
+
+	total, shared := 0, 0
+	for w, ca := range wa {
+		total += ca
+		if cb := wb[w]; cb < ca {
+			shared += cb
+		} else {
+			shared += ca
+		}
+	}
+	for _, cb := range wb {
+		total += cb
+	}
+
+	if total == 0 {
+		return 1
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+func wordCounts(b []byte) map[string]int {
+	words := strings.FieldsFunc(string(b), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	counts := make(map[string]int, len(words))
+	for _, w := range words {
+		counts[w]++
+	}
+	return counts
+}
+
+// profileComparison handles --compare-profiles. Every URL is requested
+// as profile a and as profile b, and the responses are given a similarity
+// score, which is halved when the statuses don't match. The scores are
+// kept so that they can be ranked at the end.
+type profileComparison struct {
+	a, b *profile
+
+	// the request from the command line, which the profiles add to
+	method  string
+	body    string
+	headers []string
+
+	mu      sync.Mutex
+	entries []profileEntry
+}
+
+type profileEntry struct {
+	url     string
+	score   float64
+	statusA int
+	statusB int
+	errB    error
+	sizeA   int
+	sizeB   int
+}
+
+// request returns the method, body and headers to use for a profile
+func (c *profileComparison) request(p *profile) (string, string, []string) {
+	method, body := c.method, c.body
+	if p.body != "" {
+		body = p.body
+	}
+	if p.method != "" {
+		method = p.method
+	} else if body != "" && method == "GET" {
+		method = "POST"
+	}
+
+	headers := mergeHeaders(c.headers, p.headers)
+	return method, body, headers
+}
+
+// applyHook makes the job's request profile a's; it goes in the prepare
+// stage, before the request is built
+func (c *profileComparison) applyHook(j *job) bool {
+	j.method, j.body, j.headers = c.request(c.a)
+	return true
+}
+
+// fetchHook requests the URL again as profile b and scores the two
+// responses. It goes at the end of the fetch stage, so every URL's in
+// the ranking whether it's filtered out or not.
+func (c *profileComparison) fetchHook(client *http.Client, limits bodyLimits) hook {
+	return func(j *job) bool {
+		method, body, headers := c.request(c.b)
+		r, respBody := fetchResponse(withPins(context.Background(), j.pins), client, limits, method, j.req.URL.String(), body, headers)
+
+		e := profileEntry{
+			url:     j.req.URL.String(),
+			statusA: j.resp.StatusCode,
+			sizeA:   int(j.resp.ContentLength),
+			statusB: r.status,
+			sizeB:   r.size,
+			errB:    r.err,
+		}
+
+		if r.err == nil {
+			e.score = similarity(j.respBody, respBody)
+			if r.status != j.resp.StatusCode {
+				e.score /= 2
+			}
+		}
+
+		j.meta = append(j.meta, fmt.Sprintf("profile %s: %s", c.b.name, r))
+		j.meta = append(j.meta, fmt.Sprintf("similarity: %.2f", e.score))
+
+		c.mu.Lock()
+		c.entries = append(c.entries, e)
+		c.mu.Unlock()
+		return true
+	}
+}
+
+// print writes the URLs ranked from most to least similar. Near the top
+// are the URLs where profile b gets what profile a does, which is where
+// to look for broken authorization when b is the less privileged of the
+// two; near the bottom are the ones where authorization matters.
+func (c *profileComparison) print(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sort.SliceStable(c.entries, func(i, k int) bool {
+		return c.entries[i].score > c.entries[k].score
+	})
+
+	for _, e := range c.entries {
+		b := fmt.Sprintf("%d %d", e.statusB, e.sizeB)
+		if e.errB != nil {
+			b = "error"
+		}
+		fmt.Fprintf(w, "%.2f %s: %d %d %s: %s %s\n", e.score, c.a.name, e.statusA, e.sizeA, c.b.name, b, e.url)
+	}
+}
@@ -0,0 +1,73 @@
+package requester
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	in := `# the admin user
+name: admin
+method: 'PUT'
+headers:
+  Cookie: "session=abc; admin=1"
+  - Authorization: Bearer xyz
+
+body: |
+  {
+    "all": true
+  }
+
+`
+	p, err := parseProfile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &profile{
+		name:    "admin",
+		method:  "PUT",
+		body:    "{\n  \"all\": true\n}\n",
+		headers: []string{"Cookie: session=abc; admin=1", "Authorization: Bearer xyz"},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("want %+v, have %+v", want, p)
+	}
+
+	for _, bad := range []string{"colour: blue", "headers: x", "  name: indented", "headers:\n  no colon"} {
+		if _, err := parseProfile(strings.NewReader(bad)); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
+
+func TestProfileRequest(t *testing.T) {
+	c := &profileComparison{method: "GET", headers: []string{"X-Base: 1"}}
+
+	method, body, headers := c.request(&profile{body: "a=b", headers: []string{"Cookie: x"}})
+	if method != "POST" || body != "a=b" || !reflect.DeepEqual(headers, []string{"X-Base: 1", "Cookie: x"}) {
+		t.Errorf("have %s %q %v", method, body, headers)
+	}
+	if len(c.headers) != 1 {
+		t.Errorf("base headers were modified: %v", c.headers)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"hello world", "world, hello!", 1},
+		{"hello world", "", 0},
+		{"a b c d", "a b x y", 0.5},
+	}
+
+	for _, c := range cases {
+		if have := similarity([]byte(c.a), []byte(c.b)); have != c.want {
+			t.Errorf("similarity(%q, %q): want %v, have %v", c.a, c.b, c.want, have)
+		}
+	}
+}
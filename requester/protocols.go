@@ -0,0 +1,32 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// setProtocols handles --http2 and --http1.1-only. Without either, only
+// HTTP/1.1 is used, because Go only offers HTTP/2 by itself when the
+// transport hasn't been set up the way fff sets it up. With --http2 it's
+// HTTP/2 or nothing: negotiated with ALPN for https, and spoken straight
+// away (h2c with prior knowledge) for http.
+func setProtocols(client *http.Client, http2, http1Only bool) error {
+	if http2 && http1Only {
+		return fmt.Errorf("--http2 and --http1.1-only can't be used together")
+	}
+
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil
+	}
+
+	p := &http.Protocols{}
+	if http2 {
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+	} else {
+		p.SetHTTP1(true)
+	}
+	tr.Protocols = p
+	return nil
+}
@@ -0,0 +1,55 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetProtocols(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	tlsSrv := httptest.NewUnstartedServer(handler)
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	// a plain server that speaks both, so h2c is only used if it's asked for
+	plain := httptest.NewUnstartedServer(handler)
+	plain.Config.Protocols = &http.Protocols{}
+	plain.Config.Protocols.SetHTTP1(true)
+	plain.Config.Protocols.SetUnencryptedHTTP2(true)
+	plain.Start()
+	defer plain.Close()
+
+	for _, c := range []struct {
+		http2, http1Only bool
+		want             int
+	}{
+		{false, false, 1},
+		{false, true, 1},
+		{true, false, 2},
+	} {
+		client := newClient(false, "")
+		if err := setProtocols(client, c.http2, c.http1Only); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, u := range []string{tlsSrv.URL, plain.URL} {
+			resp, err := client.Get(u)
+			if err != nil {
+				t.Fatalf("%s: %s", u, err)
+			}
+			resp.Body.Close()
+			if resp.ProtoMajor != c.want {
+				t.Errorf("http2 %v, http1.1-only %v: want HTTP/%d from %s, have %s", c.http2, c.http1Only, c.want, u, resp.Proto)
+			}
+		}
+	}
+
+	if setProtocols(newClient(false, ""), true, true) == nil {
+		t.Error("want an error for both at once")
+	}
+}
@@ -0,0 +1,158 @@
+package requester
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// how many requests in a row can fail through a proxy from --proxy-file
+// before it's dropped. Dead targets make requests fail through good proxies
+// too, so it takes a few; any success starts the count again.
+const maxProxyFailures = 5
+
+var errNoProxies = errors.New("no proxies left; they've all failed too often")
+
+// proxyPool spreads requests over the proxies from --proxy-file, either in
+// turn or at random, and drops proxies that keep failing
+type proxyPool struct {
+	mu      sync.Mutex
+	proxies []*poolProxy
+	random  bool
+	next    int
+
+	// where to say that a proxy has been dropped
+	log io.Writer
+}
+
+type poolProxy struct {
+	url      *url.URL
+	failures int
+}
+
+// loadProxies reads proxy URLs from a file, one per line. Blank lines and
+// lines starting with # are skipped.
+func loadProxies(path string) ([]*url.URL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var proxies []*url.URL
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		u, err := url.Parse(line)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%s: line %d: want a proxy URL like http://host:port", path, n)
+		}
+		proxies = append(proxies, u)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("%s: no proxies", path)
+	}
+	return proxies, nil
+}
+
+func newProxyPool(urls []*url.URL, random bool, log io.Writer) *proxyPool {
+	p := &proxyPool{random: random, log: log}
+	for _, u := range urls {
+		p.proxies = append(p.proxies, &poolProxy{url: u})
+	}
+	return p
+}
+
+// pick chooses the proxy for a request
+func (p *proxyPool) pick() (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.proxies) == 0 {
+		return nil, errNoProxies
+	}
+
+	if p.random {
+		return p.proxies[rand.Intn(len(p.proxies))].url, nil
+	}
+
+	p.next %= len(p.proxies)
+	u := p.proxies[p.next].url
+	p.next++
+	return u, nil
+}
+
+// report records how a request through a proxy went, dropping the proxy
+// once too many have failed in a row
+func (p *proxyPool) report(u *url.URL, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, pp := range p.proxies {
+		if pp.url != u {
+			continue
+		}
+
+		if ok {
+			pp.failures = 0
+			return
+		}
+
+		pp.failures++
+		if pp.failures >= maxProxyFailures {
+			p.proxies = append(p.proxies[:i], p.proxies[i+1:]...)
+			fmt.Fprintf(p.log, "dropped proxy %s after %d failures in a row; %d left\n", u.Redacted(), pp.failures, len(p.proxies))
+		}
+		return
+	}
+}
+
+// Proxy is the transport's Proxy func. Every request gets the next proxy,
+// and the job it belongs to remembers which one, so the fetch can report
+// how it went.
+func (p *proxyPool) Proxy(req *http.Request) (*url.URL, error) {
+	u, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+
+	if j, ok := req.Context().Value(jobKey{}).(*job); ok {
+		j.proxy = u
+	}
+	return u, nil
+}
+
+// fetchHook wraps the fetch hook to report whether the request got
+// through the proxy it was given, and records which proxy that was. It
+// goes inside retryHook so that every attempt counts, and each retry goes
+// through a different proxy (at least with more than one to choose from).
+// Errors count as failures, as do 407s, which say the proxy wants
+// credentials that it wasn't given.
+func (p *proxyPool) fetchHook(fetch hook) hook {
+	return func(j *job) bool {
+		j.proxy = nil
+		ok := fetch(j)
+		if j.proxy == nil {
+			return ok
+		}
+
+		p.report(j.proxy, j.err == nil && j.resp != nil && j.resp.StatusCode != http.StatusProxyAuthRequired)
+		j.meta = append(j.meta, "proxy: "+j.proxy.Redacted())
+		return ok
+	}
+}
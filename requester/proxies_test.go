@@ -0,0 +1,75 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyPool(t *testing.T) {
+	// the proxy just answers requests itself, saying it was the proxy
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("via proxy for " + r.URL.String()))
+	}))
+	defer good.Close()
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	goodURL, _ := url.Parse(good.URL)
+	deadURL, _ := url.Parse(dead.URL)
+
+	pool := newProxyPool([]*url.URL{goodURL, deadURL}, false, ioutil.Discard)
+
+	client := newClient(false, "")
+	client.Transport.(*http.Transport).Proxy = pool.Proxy
+	fetch := pool.fetchHook(fetchHook(client, bodyLimits{}))
+
+	// they're taken in turn until the dead one has failed too often
+	var viaGood, failed int
+	for i := 0; i < 2*maxProxyFailures+2; i++ {
+		j := newJob("http://example.com/", "GET", "", nil)
+		buildRequestHook(j)
+
+		if fetch(j) {
+			viaGood++
+			if string(j.respBody) != "via proxy for http://example.com/" || j.meta[len(j.meta)-1] != "proxy: "+good.URL {
+				t.Errorf("unexpected response %q, meta %q", j.respBody, j.meta)
+			}
+		} else {
+			failed++
+		}
+	}
+	if failed != maxProxyFailures || viaGood != maxProxyFailures+2 {
+		t.Errorf("want %d failures and the rest through the good proxy, have %d and %d", maxProxyFailures, failed, viaGood)
+	}
+	if len(pool.proxies) != 1 || pool.proxies[0].url != goodURL {
+		t.Errorf("want only the good proxy left, have %d", len(pool.proxies))
+	}
+
+	// once they're all gone, requests fail
+	for i := 0; i < maxProxyFailures; i++ {
+		pool.report(goodURL, false)
+	}
+	j := newJob("http://example.com/", "GET", "", nil)
+	buildRequestHook(j)
+	if fetch(j) || j.err == nil || !strings.Contains(j.err.Error(), "no proxies left") {
+		t.Errorf("want no proxies left, have %v", j.err)
+	}
+}
+
+func TestProxyPoolResets(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1:8080")
+	pool := newProxyPool([]*url.URL{u}, true, ioutil.Discard)
+
+	// failures have to be in a row
+	for i := 0; i < 3*maxProxyFailures; i++ {
+		pool.report(u, i%maxProxyFailures == 0)
+	}
+	if p, err := pool.pick(); err != nil || p != u {
+		t.Errorf("want the proxy kept, have %v, %v", p, err)
+	}
+}
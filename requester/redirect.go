@@ -0,0 +1,225 @@
+package requester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// the most redirects that are followed for one request, unless the
+// pipeline says otherwise
+const defaultMaxRedirects = 10
+
+type jobKey struct{}
+
+// withJob returns a context that lets the client find the job a request
+// belongs to
+func withJob(ctx context.Context, j *job) context.Context {
+	return context.WithValue(ctx, jobKey{}, j)
+}
+
+// checkRedirect is the client's CheckRedirect. It asks the pipeline
+// running the job the request belongs to what to do; requests that don't
+// belong to a job don't follow redirects.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	j, ok := req.Context().Value(jobKey{}).(*job)
+	if !ok || j.pipe == nil || len(j.pipe.redirectHooks) == 0 {
+		return http.ErrUseLastResponse
+	}
+	return j.pipe.redirect(j, req, via)
+}
+
+// redirect runs the redirect hooks for a redirect the job's request got,
+// noting what was decided in the job's meta
+func (p *pipeline) redirect(j *job, req *http.Request, via []*http.Request) error {
+	status := 0
+	if req.Response != nil {
+		status = req.Response.StatusCode
+	}
+	from := req.URL.String()
+
+	max := p.maxRedirects
+	if max <= 0 {
+		max = defaultMaxRedirects
+	}
+	if len(via) > max {
+		j.meta = append(j.meta, fmt.Sprintf("redirect: %d %s not followed (too many redirects)", status, from))
+		return http.ErrUseLastResponse
+	}
+
+	next := *req.URL
+	decision := redirectPass
+	for _, h := range p.redirectHooks {
+		if decision = h(j, &next); decision != redirectPass {
+			break
+		}
+	}
+
+	line := fmt.Sprintf("redirect: %d %s", status, next.String())
+	if next.String() != from {
+		line += " (rewritten from " + from + ")"
+	}
+
+	if decision != redirectFollow {
+		j.meta = append(j.meta, line+" not followed")
+		return http.ErrUseLastResponse
+	}
+
+	j.meta = append(j.meta, line)
+	j.redirects = append(j.redirects, fmt.Sprintf("%d %s", status, next.String()))
+	if j.chain != nil && req.Response != nil {
+		j.chain.addRedirect(req.Response)
+	}
+	if next.Host != req.URL.Host {
+		req.Host = ""
+	}
+	req.URL = &next
+	return nil
+}
+
+// followRedirects is the redirect hook for -L, which follows anything the
+// --redirect rules haven't already decided about
+func followRedirects(j *job, next *url.URL) redirectDecision {
+	return redirectFollow
+}
+
+// parseRedirectRule parses a --redirect rule into a redirect hook. Rules
+// are one of:
+//
+//	allow [matcher...]             follow the redirect if every matcher matches
+//	deny [matcher...]              don't follow it if every matcher matches
+//	strip-param <name>[,<name>]    take query parameters out of the redirect
+//	rewrite <regex> <replacement>  rewrite the redirect's URL
+//
+// and matchers are host=<regex>, path=<regex>, url=<regex> or same-host
+// (the same host as the job's request). allow and deny without matchers
+// match everything. strip-param names can be globs, like utm_*.
+func parseRedirectRule(rule string) (redirectHook, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	switch fields[0] {
+	case "allow", "deny":
+		decision := redirectFollow
+		if fields[0] == "deny" {
+			decision = redirectStop
+		}
+
+		var matchers []func(j *job, next *url.URL) bool
+		for _, f := range fields[1:] {
+			m, err := parseRedirectMatcher(f)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, m)
+		}
+
+		return func(j *job, next *url.URL) redirectDecision {
+			for _, m := range matchers {
+				if !m(j, next) {
+					return redirectPass
+				}
+			}
+			return decision
+		}, nil
+
+	case "strip-param":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("strip-param needs a list of parameter names")
+		}
+		names := strings.Split(fields[1], ",")
+		for _, n := range names {
+			if _, err := path.Match(n, ""); err != nil {
+				return nil, fmt.Errorf("invalid parameter name %q", n)
+			}
+		}
+
+		return func(j *job, next *url.URL) redirectDecision {
+			q := next.Query()
+			changed := false
+			for k := range q {
+				for _, n := range names {
+					if ok, _ := path.Match(n, k); ok {
+						q.Del(k)
+						changed = true
+					}
+				}
+			}
+			if changed {
+				next.RawQuery = q.Encode()
+			}
+			return redirectPass
+		}, nil
+
+	case "rewrite":
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("rewrite needs a regex and a replacement")
+		}
+		re, err := regexp.Compile(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		repl := fields[2]
+
+		return func(j *job, next *url.URL) redirectDecision {
+			rewritten, err := url.Parse(re.ReplaceAllString(next.String(), repl))
+			if err == nil && rewritten.Host != "" {
+				*next = *rewritten
+			}
+			return redirectPass
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rule %q", fields[0])
+}
+
+func parseRedirectMatcher(m string) (func(j *job, next *url.URL) bool, error) {
+	if m == "same-host" {
+		return func(j *job, next *url.URL) bool {
+			return strings.EqualFold(next.Host, j.req.URL.Host)
+		}, nil
+	}
+
+	kind, pattern, ok := strings.Cut(m, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid matcher %q", m)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var part func(u *url.URL) string
+	switch kind {
+	case "host":
+		part = func(u *url.URL) string { return u.Host }
+	case "path":
+		part = func(u *url.URL) string { return u.Path }
+	case "url":
+		part = func(u *url.URL) string { return u.String() }
+	default:
+		return nil, fmt.Errorf("unknown matcher %q", kind)
+	}
+
+	return func(j *job, next *url.URL) bool {
+		return re.MatchString(part(next))
+	}, nil
+}
+
+// RedirectArgs are the --redirect rules, in order
+type RedirectArgs []string
+
+func (r *RedirectArgs) Set(val string) error {
+	*r = append(*r, val)
+	return nil
+}
+
+func (r RedirectArgs) String() string {
+	return strings.Join(r, "; ")
+}
@@ -0,0 +1,129 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirectRules(t *testing.T) {
+	cases := []struct {
+		rules []string
+		to    string
+		want  redirectDecision
+		url   string
+	}{
+		{[]string{"allow"}, "https://example.com/a", redirectFollow, "https://example.com/a"},
+		{[]string{"allow same-host"}, "https://other.example.com/a", redirectPass, "https://other.example.com/a"},
+		{[]string{"deny host=^internal\\.", "allow"}, "https://internal.example.com/", redirectStop, "https://internal.example.com/"},
+		{[]string{"allow path=^/api/ same-host"}, "https://example.com/api/users", redirectFollow, "https://example.com/api/users"},
+		{[]string{"strip-param utm_*,fbclid"}, "https://example.com/?utm_source=x&id=1&fbclid=y", redirectPass, "https://example.com/?id=1"},
+		{[]string{"rewrite ^http: https:"}, "http://example.com/", redirectPass, "https://example.com/"},
+	}
+
+	for _, c := range cases {
+		j := newJob("https://example.com/", "GET", "", nil)
+		buildRequestHook(j)
+		next, _ := url.Parse(c.to)
+
+		have := redirectPass
+		for _, r := range c.rules {
+			h, err := parseRedirectRule(r)
+			if err != nil {
+				t.Fatalf("%s: %s", r, err)
+			}
+			if have = h(j, next); have != redirectPass {
+				break
+			}
+		}
+
+		if have != c.want || next.String() != c.url {
+			t.Errorf("%v on %s: want %d %s, have %d %s", c.rules, c.to, c.want, c.url, have, next)
+		}
+	}
+
+	for _, bad := range []string{"", "follow", "allow colour=blue", "allow path=(", "rewrite x", "strip-param"} {
+		if _, err := parseRedirectRule(bad); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
+
+func TestFollowRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/next?utm_source=x", http.StatusFound)
+		case "/next":
+			http.Redirect(w, r, "/elsewhere?"+r.URL.RawQuery, http.StatusFound)
+		default:
+			w.Write([]byte("end of the line"))
+		}
+	}))
+	defer srv.Close()
+
+	pipe, col := testPipeline(nil)
+	allow, _ := parseRedirectRule("allow path=^/next")
+	strip, _ := parseRedirectRule("strip-param utm_*")
+	pipe.OnRedirect(strip)
+	pipe.OnRedirect(allow)
+
+	var meta []string
+	pipe.Use(stageEnrich, func(j *job) bool {
+		meta = j.meta
+		return true
+	})
+	pipe.Run(newJob(srv.URL+"/start", "GET", "", nil))
+
+	if len(col.results) != 1 || col.results[0].Status != 302 {
+		t.Fatalf("want to stop at the second redirect, have %+v", col.results)
+	}
+
+	want := []string{
+		"redirect: 302 " + srv.URL + "/next (rewritten from " + srv.URL + "/next?utm_source=x)",
+		"redirect: 302 " + srv.URL + "/elsewhere? not followed",
+	}
+	if strings.Join(meta, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want meta %q, have %q", want, meta)
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /3 redirects to /2, then /1, then /0, which is the end
+		if n := strings.TrimPrefix(r.URL.Path, "/"); n != "0" {
+			http.Redirect(w, r, "/"+string(n[0]-1), http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("made it"))
+	}))
+	defer srv.Close()
+
+	for _, c := range []struct {
+		max    int
+		status int
+		hops   int
+	}{
+		{0, 200, 3},
+		{3, 200, 3},
+		{2, 301, 2},
+	} {
+		pipe, col := testPipeline(nil)
+		pipe.OnRedirect(followRedirects)
+		pipe.maxRedirects = c.max
+		pipe.Run(newJob(srv.URL+"/3", "GET", "", nil))
+
+		if len(col.results) != 1 {
+			t.Fatalf("max %d: want one result, have %+v", c.max, col.results)
+		}
+		r := col.results[0]
+		if r.Status != c.status || len(r.Redirects) != c.hops {
+			t.Errorf("max %d: want %d after %d redirects, have %d after %q", c.max, c.status, c.hops, r.Status, r.Redirects)
+		}
+		if r.Redirects[0] != "301 "+srv.URL+"/2" {
+			t.Errorf("max %d: want the first redirect to be to /2, have %q", c.max, r.Redirects)
+		}
+	}
+}
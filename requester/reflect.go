@@ -0,0 +1,134 @@
+package requester
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// reflectProbe is appended to the random part of a reflection marker. If
+// these characters come back in the body as-is then the reflection isn't
+// being encoded, which is what makes it interesting.
+const reflectProbe = `"'<>`
+
+// the number of bytes either side of a reflection to keep as context
+const reflectContext = 40
+
+// reflectLocation is somewhere in the request a marker can be injected
+type reflectLocation struct {
+	kind string // query, header or path
+	name string
+}
+
+type ReflectLocations []reflectLocation
+
+// Set parses a comma separated list of locations, each of which can
+// optionally specify a name, e.g. 'query:q,header:Referer,path'
+func (r *ReflectLocations) Set(val string) error {
+	for _, l := range strings.Split(val, ",") {
+		parts := strings.SplitN(strings.TrimSpace(l), ":", 2)
+
+		loc := reflectLocation{kind: parts[0]}
+		if len(parts) == 2 {
+			loc.name = parts[1]
+		}
+
+		switch loc.kind {
+		case "query":
+			if loc.name == "" {
+				loc.name = "fff"
+			}
+		case "header":
+			if loc.name == "" {
+				loc.name = "X-Fff-Reflect"
+			}
+		case "path":
+		default:
+			return fmt.Errorf("unknown reflection location %q", loc.kind)
+		}
+
+		*r = append(*r, loc)
+	}
+	return nil
+}
+
+func (r ReflectLocations) String() string {
+	var out []string
+	for _, l := range r {
+		if l.name == "" {
+			out = append(out, l.kind)
+			continue
+		}
+		out = append(out, l.kind+":"+l.name)
+	}
+	return strings.Join(out, ",")
+}
+
+// newReflectMarker returns a marker that's unique to a single request
+func newReflectMarker() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return "fff" + hex.EncodeToString(b) + reflectProbe
+}
+
+// injectMarker adds the marker to the request in each of the locations
+func injectMarker(req *http.Request, marker string, locs ReflectLocations) {
+	for _, l := range locs {
+		switch l.kind {
+		case "query":
+			q := l.name + "=" + url.QueryEscape(marker)
+			if req.URL.RawQuery == "" {
+				req.URL.RawQuery = q
+			} else {
+				req.URL.RawQuery += "&" + q
+			}
+		case "header":
+			req.Header.Set(l.name, marker)
+		case "path":
+			req.URL.Path = strings.TrimSuffix(req.URL.Path, "/") + "/" + marker
+			req.URL.RawPath = ""
+		}
+	}
+}
+
+// findReflections looks for the marker in the body. It returns a tag
+// describing the kind of reflection that was found (if any) and some
+// context around each unencoded reflection.
+func findReflections(body []byte, marker string) (string, []string) {
+	token := []byte(strings.TrimSuffix(marker, reflectProbe))
+
+	var contexts []string
+	for offset := 0; ; {
+		i := bytes.Index(body[offset:], []byte(marker))
+		if i == -1 {
+			break
+		}
+		i += offset
+
+		start := i - reflectContext
+		if start < 0 {
+			start = 0
+		}
+		end := i + len(marker) + reflectContext
+		if end > len(body) {
+			end = len(body)
+		}
+		contexts = append(contexts, fmt.Sprintf("%q", body[start:end]))
+
+		offset = i + len(marker)
+	}
+
+	if len(contexts) > 0 {
+		return "reflected", contexts
+	}
+
+	if bytes.Contains(body, token) {
+		return "reflected-encoded", nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,304 @@
+package requester
+
+import (
+	"io/ioutil"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// bodyRegex is what -mr and -fr match bodies with: a *regexp.Regexp, or a
+// regexSet when there's more than one
+type bodyRegex interface {
+	Match(body []byte) bool
+	FindAllIndex(body []byte, n int) [][]int
+}
+
+// RegexArgs is -mr and -fr, which can be given more than once, or read
+// from a file with @file
+type RegexArgs []string
+
+// Set adds a regex, or with @file, a regex from each line of the file.
+// Blank lines and lines starting with # are skipped, so a regex that
+// starts with a # needs it escaping as \#.
+func (r *RegexArgs) Set(val string) error {
+	if !strings.HasPrefix(val, "@") {
+		*r = append(*r, val)
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(val[1:])
+	if err != nil {
+		return err
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		*r = append(*r, l)
+	}
+	return nil
+}
+
+func (r RegexArgs) String() string {
+	return strings.Join(r, ", ")
+}
+
+// the shortest literal worth looking for first; anything shorter turns
+// up in nearly every body anyway
+const minRequiredLiteral = 3
+
+// regexSet is several regexes that match a body when any of them does.
+// Running dozens of them over a big body one after another gets slow, so
+// the work that can be shared is done once for all of them:
+//
+//   - most regexes can only match where some literal text is (AKIA[0-9A-Z]{16}
+//     needs AKIA), so the literals for all of them are looked for in one
+//     pass, and a regex is only run if its literal's there
+//   - the rest are joined into one regex, so the body's only gone through
+//     once for all of those
+//
+// so adding rules with literals in them costs next to nothing for the
+// bodies they can't match.
+type regexSet struct {
+	rules []*regexp.Regexp
+
+	// literal[i] is the index in finder of the literal that rules[i]
+	// needs, or -1 if it's in joined instead
+	literal []int
+	finder  *literalFinder
+	joined  *regexp.Regexp
+}
+
+// compileRegexSet compiles the patterns, which are in Go's syntax
+func compileRegexSet(patterns []string) (*regexSet, error) {
+	s := &regexSet{}
+	var literals, rest []string
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, re)
+
+		if lit := requiredLiteral(p); len(lit) >= minRequiredLiteral {
+			s.literal = append(s.literal, len(literals))
+			literals = append(literals, lit)
+			continue
+		}
+		s.literal = append(s.literal, -1)
+		rest = append(rest, "(?:"+p+")")
+	}
+
+	if len(literals) > 0 {
+		s.finder = newLiteralFinder(literals)
+	}
+	if len(rest) > 0 {
+		// the flags in each pattern only last to the end of its group, so
+		// joining them can't change what they match
+		s.joined = regexp.MustCompile(strings.Join(rest, "|"))
+	}
+	return s, nil
+}
+
+// candidates returns the rules with literals that are in the body
+func (s *regexSet) candidates(body []byte) []*regexp.Regexp {
+	if s.finder == nil {
+		return nil
+	}
+	found := s.finder.find(body)
+
+	var out []*regexp.Regexp
+	for i, re := range s.rules {
+		if s.literal[i] != -1 && found[s.literal[i]] {
+			out = append(out, re)
+		}
+	}
+	return out
+}
+
+func (s *regexSet) Match(body []byte) bool {
+	for _, re := range s.candidates(body) {
+		if re.Match(body) {
+			return true
+		}
+	}
+	return s.joined != nil && s.joined.Match(body)
+}
+
+// FindAllIndex returns the matches of all of the rules in the order they
+// appear in the body, up to n of them (all of them if n is negative).
+// Matches from different rules can overlap.
+func (s *regexSet) FindAllIndex(body []byte, n int) [][]int {
+	var locs [][]int
+	for _, re := range s.candidates(body) {
+		locs = append(locs, re.FindAllIndex(body, n)...)
+	}
+	if s.joined != nil {
+		locs = append(locs, s.joined.FindAllIndex(body, n)...)
+	}
+
+	sort.SliceStable(locs, func(a, b int) bool {
+		return locs[a][0] < locs[b][0]
+	})
+	if n >= 0 && len(locs) > n {
+		locs = locs[:n]
+	}
+	return locs
+}
+
+// requiredLiteral returns the longest bit of literal text that anything
+// the pattern matches has to contain, lower cased, or "" if there isn't
+// one that can be looked for
+func requiredLiteral(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	return longestLiteral(re.Simplify())
+}
+
+func longestLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		lit := string(re.Rune)
+		if re.Flags&syntax.FoldCase != 0 {
+			// the literals are looked for ignoring case, but only for
+			// ASCII; (?i)k and (?i)s match the Kelvin sign and the long
+			// s as well, so they can't be looked for that way
+			for _, r := range re.Rune {
+				if r >= 0x80 || strings.ContainsRune("kKsS", r) {
+					return ""
+				}
+			}
+		}
+		return asciiLower(lit)
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return longestLiteral(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return longestLiteral(re.Sub[0])
+		}
+
+	case syntax.OpConcat:
+		best := ""
+		for _, sub := range re.Sub {
+			if lit := longestLiteral(sub); len(lit) > len(best) {
+				best = lit
+			}
+		}
+		return best
+	}
+	return ""
+}
+
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// literalFinder looks for lots of literals at once, ignoring ASCII case,
+// in one pass over the body (it's Aho-Corasick, turned into a table with
+// a row for each state so there's one lookup per byte)
+type literalFinder struct {
+	count int
+	delta [][256]int32
+	// the literals that have been found on reaching each state
+	out [][]int
+}
+
+func newLiteralFinder(literals []string) *literalFinder {
+	f := &literalFinder{count: len(literals), delta: make([][256]int32, 1), out: make([][]int, 1)}
+
+	// the trie, with the root as state 0; in the trie, 0 means there's no
+	// edge, since nothing goes back to the root
+	for i, lit := range literals {
+		s := int32(0)
+		for j := 0; j < len(lit); j++ {
+			c := lit[j]
+			if f.delta[s][c] == 0 {
+				f.delta = append(f.delta, [256]int32{})
+				f.out = append(f.out, nil)
+				f.delta[s][c] = int32(len(f.delta) - 1)
+			}
+			s = f.delta[s][c]
+		}
+		f.out[s] = append(f.out[s], i)
+	}
+
+	// the rest of the table, breadth first so that a state's fail state
+	// (the longest suffix of it that's in the trie) is done before it is
+	fail := make([]int32, len(f.delta))
+	var queue []int32
+	for c := 0; c < 256; c++ {
+		if s := f.delta[0][c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		f.out[s] = append(f.out[s], f.out[fail[s]]...)
+
+		for c := 0; c < 256; c++ {
+			t := f.delta[s][c]
+			if t == 0 {
+				f.delta[s][c] = f.delta[fail[s]][c]
+				continue
+			}
+			fail[t] = f.delta[fail[s]][c]
+			queue = append(queue, t)
+		}
+	}
+
+	// upper case letters go the same way as lower case ones
+	for s := range f.delta {
+		for c := 'A'; c <= 'Z'; c++ {
+			f.delta[s][c] = f.delta[s][c+'a'-'A']
+		}
+	}
+	return f
+}
+
+// find reports which of the literals are in the body
+func (f *literalFinder) find(body []byte) []bool {
+	found := make([]bool, f.count)
+	left := f.count
+
+	s := int32(0)
+	for _, c := range body {
+		s = f.delta[s][c]
+		for _, i := range f.out[s] {
+			if !found[i] {
+				found[i] = true
+				left--
+			}
+		}
+		if left == 0 {
+			break
+		}
+	}
+	return found
+}
+
+// newBodyRegex compiles the patterns from -mr or -fr, as a plain regex if
+// there's only one
+func newBodyRegex(patterns RegexArgs) (bodyRegex, error) {
+	if len(patterns) == 1 {
+		re, err := regexp.Compile(patterns[0])
+		if err != nil {
+			return nil, err
+		}
+		return re, nil
+	}
+	return compileRegexSet(patterns)
+}
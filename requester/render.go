@@ -0,0 +1,332 @@
+package requester
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chromeCandidates are the executable names tried when no --chrome-path
+// is provided
+var chromeCandidates = []string{
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"chrome",
+}
+
+const renderTimeout = time.Second * 20
+
+// linksExpression collects every URL referenced by the live DOM. Reading
+// the properties rather than the attributes means the browser has already
+// resolved them into absolute URLs for us.
+const linksExpression = `Array.from(new Set([].concat(
+	Array.from(document.querySelectorAll('[href]'), e => e.href),
+	Array.from(document.querySelectorAll('[src]'), e => e.src),
+	Array.from(document.querySelectorAll('form[action]'), e => e.action)
+))).filter(u => typeof u === 'string' && u !== '')`
+
+// screenshots are taken of the viewport at this size
+const (
+	screenshotWidth  = 1280
+	screenshotHeight = 800
+)
+
+// renderer renders pages with a single shared headless Chrome
+type renderer struct {
+	cmd     *exec.Cmd
+	dataDir string
+	cdp     *cdpClient
+	headers map[string]string
+
+	// capture a PNG of each page as well as the DOM
+	screenshot bool
+
+	// limits the number of tabs open at once
+	sem chan struct{}
+}
+
+// renderResult is what we got out of the browser for a single page
+type renderResult struct {
+	DOM        string
+	Screenshot []byte
+
+	// Links are the URLs found in the rendered DOM plus any the page
+	// requested itself while loading (scripts, XHR, fetch etc)
+	Links []string
+}
+
+func newRenderer(chromePath, proxy string, headers []string, screenshot bool) (*renderer, error) {
+	if chromePath == "" {
+		for _, c := range chromeCandidates {
+			if p, err := exec.LookPath(c); err == nil {
+				chromePath = p
+				break
+			}
+		}
+	}
+	if chromePath == "" {
+		return nil, errors.New("couldn't find chrome; use --chrome-path to specify it")
+	}
+
+	dataDir, err := ioutil.TempDir("", "fff-chrome")
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--ignore-certificate-errors",
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + dataDir,
+	}
+
+	// chrome refuses to run sandboxed as root
+	if os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox")
+	}
+
+	if proxy != "" {
+		args = append(args, "--proxy-server="+proxy)
+	}
+
+	cmd := exec.Command(chromePath, append(args, "about:blank")...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+
+	// chrome tells us where to connect on stderr
+	wsURL := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			l := sc.Text()
+			if strings.HasPrefix(l, "DevTools listening on ") {
+				wsURL <- strings.TrimPrefix(l, "DevTools listening on ")
+			}
+		}
+	}()
+
+	r := &renderer{
+		cmd:        cmd,
+		dataDir:    dataDir,
+		headers:    make(map[string]string),
+		screenshot: screenshot,
+		sem:        make(chan struct{}, 4),
+	}
+
+	// Chrome takes one value per header, so repeated ones are joined,
+	// and there's no removing the ones it sends itself
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch {
+		case val == "":
+			delete(r.headers, name)
+		case r.headers[name] != "":
+			r.headers[name] += ", " + val
+		default:
+			r.headers[name] = val
+		}
+	}
+
+	select {
+	case u := <-wsURL:
+		r.cdp, err = newCDPClient(u)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	case <-time.After(renderTimeout):
+		r.Close()
+		return nil, errors.New("timed out waiting for chrome to start")
+	}
+
+	return r, nil
+}
+
+// Render loads the URL in a new tab and returns the resulting DOM (and a
+// screenshot if they're enabled) once the page's load event has fired
+func (r *renderer) Render(rawURL string) (renderResult, error) {
+	var res renderResult
+
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	err := r.cdp.call("", "Target.createTarget", map[string]interface{}{"url": "about:blank"}, &target, renderTimeout)
+	if err != nil {
+		return res, err
+	}
+	defer r.cdp.call("", "Target.closeTarget", map[string]interface{}{"targetId": target.TargetID}, nil, renderTimeout)
+
+	var attached struct {
+		SessionID string `json:"sessionId"`
+	}
+	err = r.cdp.call("", "Target.attachToTarget", map[string]interface{}{
+		"targetId": target.TargetID,
+		"flatten":  true,
+	}, &attached, renderTimeout)
+	if err != nil {
+		return res, err
+	}
+
+	sid := attached.SessionID
+	session := r.cdp.addSession(sid)
+	defer r.cdp.removeSession(sid)
+
+	for _, m := range []string{"Page.enable", "Network.enable"} {
+		if err := r.cdp.call(sid, m, struct{}{}, nil, renderTimeout); err != nil {
+			return res, err
+		}
+	}
+
+	if len(r.headers) > 0 {
+		err = r.cdp.call(sid, "Network.setExtraHTTPHeaders", map[string]interface{}{"headers": r.headers}, nil, renderTimeout)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	if r.screenshot {
+		err = r.cdp.call(sid, "Emulation.setDeviceMetricsOverride", map[string]interface{}{
+			"width":             screenshotWidth,
+			"height":            screenshotHeight,
+			"deviceScaleFactor": 1,
+			"mobile":            false,
+		}, nil, renderTimeout)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	deadline := time.Now().Add(renderTimeout)
+
+	var nav struct {
+		ErrorText string `json:"errorText"`
+	}
+	err = r.cdp.call(sid, "Page.navigate", map[string]interface{}{"url": rawURL}, &nav, renderTimeout)
+	if err != nil {
+		return res, err
+	}
+	if nav.ErrorText != "" {
+		return res, fmt.Errorf("navigation failed: %s", nav.ErrorText)
+	}
+
+	// pages that never finish loading still get rendered with whatever
+	// they managed to do before the deadline
+	session.waitFor("Page.loadEventFired", deadline)
+
+	var eval struct {
+		Result struct {
+			Value string `json:"value"`
+		} `json:"result"`
+	}
+	err = r.cdp.call(sid, "Runtime.evaluate", map[string]interface{}{
+		"expression":    "document.documentElement.outerHTML",
+		"returnByValue": true,
+	}, &eval, renderTimeout)
+	if err != nil {
+		return res, err
+	}
+	res.DOM = eval.Result.Value
+
+	var domLinks struct {
+		Result struct {
+			Value []string `json:"value"`
+		} `json:"result"`
+	}
+	err = r.cdp.call(sid, "Runtime.evaluate", map[string]interface{}{
+		"expression":    linksExpression,
+		"returnByValue": true,
+	}, &domLinks, renderTimeout)
+	if err != nil {
+		return res, err
+	}
+	res.Links = mergeLinks(domLinks.Result.Value, networkURLs(session))
+
+	if r.screenshot {
+		var shot struct {
+			Data string `json:"data"`
+		}
+		err = r.cdp.call(sid, "Page.captureScreenshot", map[string]interface{}{"format": "png"}, &shot, renderTimeout)
+		if err != nil {
+			return res, err
+		}
+
+		res.Screenshot, err = base64.StdEncoding.DecodeString(shot.Data)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+func (r *renderer) Close() {
+	if r.cdp != nil {
+		r.cdp.ws.Close()
+	}
+	if r.cmd.Process != nil {
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+	}
+	os.RemoveAll(r.dataDir)
+}
+
+// networkURLs returns the URLs of every request the page made
+func networkURLs(s *cdpSession) []string {
+	var urls []string
+	for _, e := range s.eventsFor("Network.requestWillBeSent") {
+		var params struct {
+			Request struct {
+				URL string `json:"url"`
+			} `json:"request"`
+		}
+		if err := json.Unmarshal(e.Params, &params); err != nil {
+			continue
+		}
+		urls = append(urls, params.Request.URL)
+	}
+	return urls
+}
+
+// mergeLinks combines lists of URLs into a single sorted and de-duplicated
+// list, keeping only the http(s) ones
+func mergeLinks(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, l := range lists {
+		for _, u := range l {
+			if seen[u] || !(strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")) {
+				continue
+			}
+			seen[u] = true
+			out = append(out, u)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,1063 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requester requests URLs and does everything else fff does with the
+// responses: filtering them, saving them and reporting them. It's what the
+// fff command runs, and it's here for running fff from other programs
+// without shelling out:
+//
+//	opts := requester.DefaultOptions()
+//	opts.OutputDir = "out"
+//	opts.Input = requester.NewChanSource(urls)
+//	opts.OnResult = func(res requester.Result) {
+//		fmt.Println(res.URL, res.Status, res.Path)
+//	}
+//
+//	r, err := requester.New(opts)
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//	return r.Run(ctx)
+type Requester struct {
+	run    func(ctx context.Context) error
+	finish func(interrupted bool)
+
+	// reload is what Reload does, if there's anything to do
+	reload func()
+
+	// servers running alongside the requests (--grpc) send an error
+	// here if they stop, which stops the run
+	serverErrs chan error
+
+	// things to stop and tidy up, in the order they were started
+	cleanups  []func()
+	closeOnce sync.Once
+}
+
+// New checks the options and sets everything up, ready to Run. Anything
+// that's wrong with the options is an error here, before anything's
+// requested.
+func New(o Options) (r *Requester, err error) {
+	r = &Requester{serverErrs: make(chan error, 1)}
+
+	// whatever was started before something went wrong is stopped again
+	started := r
+	defer func() {
+		if err != nil {
+			started.Close()
+			r = nil
+		}
+	}()
+
+	// everything printed on stdout goes through out, so lines from
+	// different workers can't get mixed up, and the same goes for errOut
+	// and stderr
+	out := newLockedWriter(o.Stdout)
+	errOut := newLockedWriter(o.Stderr)
+
+	// --host is the Host header, which is also the server name in TLS
+	// handshakes unless --sni says otherwise
+	if o.Host != "" {
+		if strings.ContainsAny(o.Host, " \t/") {
+			return nil, fmt.Errorf("invalid --host %q; want a host name, optionally with a port", o.Host)
+		}
+		o.Headers = mergeHeaders(o.Headers, []string{"Host: " + o.Host})
+		if o.TLS.ServerName == "" {
+			o.TLS.ServerName = (&url.URL{Host: o.Host}).Hostname()
+		}
+	}
+
+	client := newClient(o.KeepAlives, o.Proxy)
+
+	var proxies *proxyPool
+	if o.ProxyFile != "" {
+		// Chrome only takes the one proxy
+		if o.Proxy != "" || o.Render || o.Screenshot {
+			return nil, errors.New("--proxy-file can't be used with --proxy, --render or --screenshot")
+		}
+
+		urls, err := loadProxies(o.ProxyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load proxies: %s", err)
+		}
+		proxies = newProxyPool(urls, o.ProxyRandom, errOut)
+		client.Transport.(*http.Transport).Proxy = proxies.Proxy
+	}
+	proxied := o.Proxy != "" || proxies != nil
+
+	if removesHeader(o.Headers, "Accept-Encoding") {
+		// otherwise Go asks for gzip itself
+		client.Transport.(*http.Transport).DisableCompression = true
+	}
+	err = setProtocols(client, o.HTTP2, o.HTTP1Only)
+	if err != nil {
+		return nil, err
+	}
+	err = configureTLS(client, o.TLS)
+	if err != nil {
+		return nil, err
+	}
+	dns := recordDNS(client)
+	setTimeouts(client, o.Timeout, o.ConnectTimeout, o.TLSTimeout)
+	if len(o.Resolve) > 0 || o.ResolveFile != "" {
+		// the proxy and Chrome do their own lookups
+		if proxied || o.Render || o.Screenshot {
+			return nil, errors.New("--resolve and --resolve-file can't be used with --proxy, --proxy-file, --render or --screenshot")
+		}
+		overrides, err := loadResolveOverrides(o.Resolve, o.ResolveFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --resolve overrides: %s", err)
+		}
+		resolveHosts(client, overrides)
+	}
+	if o.DenyPrivate {
+		// the proxy and Chrome connect to things themselves, so there'd
+		// be no stopping them
+		if proxied || o.Render || o.Screenshot {
+			return nil, errors.New("--deny-private can't be used with --proxy, --proxy-file, --render or --screenshot")
+		}
+		denyPrivate(client)
+	}
+	// with a proxy, it's the proxy that decides where to connect
+	var pins *pinner
+	if !proxied {
+		pinDNS(client)
+		pins = newPinner()
+	}
+	if o.StallTimeout > 0 || o.MinSpeed.ByteSize > 0 {
+		if o.MinSpeed.ByteSize > 0 && o.MinSpeedTime <= 0 {
+			return nil, errors.New("--min-speed-time has to be more than 0")
+		}
+		detectStalls(client, o.StallTimeout, int64(o.MinSpeed.ByteSize), o.MinSpeedTime)
+	}
+	if o.MaxBandwidth.ByteSize > 0 {
+		limitBandwidth(client, newBandwidthLimiter(int64(o.MaxBandwidth.ByteSize)))
+	}
+	if o.SaveChain && o.OutputDir == "" {
+		return nil, errors.New("--save-chain needs -o")
+	}
+	if o.SaveSent {
+		if o.OutputDir == "" {
+			return nil, errors.New("--save-sent needs -o")
+		}
+		if o.HTTP2 {
+			// requests are multiplexed and compressed, so there's no
+			// telling which bytes belong to which
+			return nil, errors.New("--save-sent can't be used with --http2")
+		}
+		tapConnections(client)
+	}
+	if o.OptOutList != "" {
+		// Chrome fetches whatever a page asks for itself, so there'd be
+		// no stopping it
+		if o.Render || o.Screenshot {
+			return nil, errors.New("--optout-list can't be used with --render or --screenshot")
+		}
+		list, err := loadOptOutList(o.OptOutList)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --optout-list: %s", err)
+		}
+		honourOptOuts(client, list)
+	}
+	prefix := o.OutputDir
+	if prefix == "" {
+		prefix = "out"
+	}
+
+	// responses are saved by the request that was made, so it's only
+	// possible to tell what's already been done when the response isn't
+	// part of that
+	if o.Resume && (o.OutputDir == "" || o.HashResponse) {
+		return nil, errors.New("--resume needs -o and can't be used with --hash-response")
+	}
+
+	// the syncer's deferred first so that the last sync happens after
+	// the index has been closed
+	var syncer *outputSyncer
+	if o.SyncCmd != "" {
+		if o.OutputDir == "" {
+			return nil, errors.New("--sync-cmd needs -o")
+		}
+		if o.SyncInterval <= 0 {
+			return nil, errors.New("--sync-interval must be more than zero")
+		}
+		syncer = newOutputSyncer(o.OutputDir, o.SyncCmd, o.SyncInterval, errOut)
+		r.cleanups = append(r.cleanups, syncer.final)
+		go syncer.loop(o.SyncInterval)
+	}
+
+	var store Storage
+	var fsStore *fsStorage
+	if o.OutputDir != "" || o.CaptureProxy != "" {
+		fs := newFSStorage(prefix)
+		fs.flushEvery = o.FlushEvery
+		fs.flushInterval = o.FlushInterval
+		fs.log = errOut
+		if o.OutputTemplate != "" {
+			t, err := parseOutputTemplate(o.OutputTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --output-template: %s", err)
+			}
+			fs.template = t
+		}
+		switch o.OutputFormat {
+		case "", "fff":
+		case "meg":
+			// meg's files are named for what's in them, so there's no
+			// telling which requests have been made, and no room for a
+			// layout
+			if o.Resume || o.OutputTemplate != "" {
+				return nil, errors.New("--format meg can't be used with --resume or --output-template")
+			}
+			fs.meg = true
+		default:
+			return nil, fmt.Errorf("unknown --format %q; want fff or meg", o.OutputFormat)
+		}
+		err := fs.Lock(o.Shared)
+		if err != nil {
+			return nil, err
+		}
+		r.cleanups = append(r.cleanups, func() {
+			if err := fs.Close(); err != nil {
+				fmt.Fprintf(errOut, "failed to write index: %s\n", err)
+			}
+		})
+		store, fsStore = fs, fs
+	}
+
+	if o.PIDFile != "" {
+		removePID, err := WritePIDFile(o.PIDFile)
+		if err != nil {
+			return nil, err
+		}
+		r.cleanups = append(r.cleanups, removePID)
+	}
+
+	if o.CaptureProxy != "" {
+		caDir, err := captureCADir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a config directory for the capture CA: %s", err)
+		}
+		p, caPath, err := newCaptureProxy(caDir, store, client, out, errOut)
+		if err != nil {
+			return nil, err
+		}
+		if k := oldCAKey(prefix); k != "" {
+			fmt.Fprintf(errOut, "%s is a capture CA key from an older fff; it isn't used any more, and should be deleted\n", k)
+		}
+
+		addr := captureListenAddr(o.CaptureProxy)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start capture proxy: %s", err)
+		}
+		if listensEverywhere(addr) {
+			fmt.Fprintf(errOut, "warning: the capture proxy is listening on every interface, so anyone who can reach %s can use it\n", addr)
+		}
+		fmt.Fprintf(errOut, "capture proxy listening on %s; trust %s to capture HTTPS\n", l.Addr(), caPath)
+
+		srv := &http.Server{Handler: p}
+		r.cleanups = append(r.cleanups, func() { srv.Close() })
+		r.run = func(ctx context.Context) error {
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			err := srv.Serve(l)
+			if err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("capture proxy failed: %s", err)
+			}
+			return nil
+		}
+		return r, nil
+	}
+
+	var mirrorBase *url.URL
+	if o.Mirror != "" {
+		var err error
+		mirrorBase, err = url.Parse(o.Mirror)
+		if err != nil || mirrorBase.Host == "" {
+			return nil, fmt.Errorf("invalid --mirror URL: %s", o.Mirror)
+		}
+	}
+
+	if o.GraphQLQuery != "" || o.GraphQLSchemas != "" {
+		o.GraphQL = true
+	}
+	if o.GraphQL {
+		body, err := graphqlBody(o.GraphQLQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GraphQL query: %s", err)
+		}
+		o.Body = body
+		o.Headers = mergeHeaders([]string{"Content-Type: application/json"}, o.Headers)
+	}
+
+	var sign signer
+	if o.Sign != "" {
+		var err error
+		sign, err = parseSigner(o.Sign)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sign: %s", err)
+		}
+	}
+
+	var profiles *profileComparison
+	if len(o.CompareProfiles) > 0 {
+		if len(o.CompareProfiles) != 2 {
+			return nil, errors.New("--compare-profiles needs exactly two profiles")
+		}
+
+		profiles = &profileComparison{}
+		for i, dst := range []**profile{&profiles.a, &profiles.b} {
+			p, err := loadProfile(o.CompareProfiles[i])
+			if err != nil {
+				return nil, fmt.Errorf("failed to load profile: %s", err)
+			}
+			*dst = p
+		}
+	}
+
+	if o.ReflectMarker && len(o.ReflectIn) == 0 {
+		o.ReflectIn.Set("query")
+	}
+
+	// sinks get every result as it's produced
+	var sinks []resultSink
+
+	if o.Forward != "" {
+		fwd := newForwarder(o.Forward, o.ForwardURLs, errOut)
+		r.cleanups = append(r.cleanups, fwd.Close)
+		sinks = append(sinks, fwd)
+	}
+
+	// with more than one method, every URL is requested with each of them
+	methods := parseMethods(o.Method)
+	if o.MethodsFile != "" {
+		var err error
+		methods, err = readMethodsFile(o.MethodsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read methods: %s", err)
+		}
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no methods given")
+	}
+	o.Method = methods[0]
+	if len(methods) == 1 {
+		methods = nil
+	}
+	if fsStore != nil {
+		fsStore.withMethods = len(methods) > 0
+	}
+
+	// with --vhost-file, every URL is requested with each of the names
+	var vhosts []string
+	if o.VhostFile != "" {
+		if o.Host != "" {
+			return nil, errors.New("--vhost-file can't be used with --host")
+		}
+		var err error
+		vhosts, err = loadVhosts(o.VhostFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --vhost-file: %s", err)
+		}
+	}
+
+	if _, ok := inputFormats[o.InputFormat]; o.InputFormat != "" && !ok {
+		return nil, fmt.Errorf("unknown --input-format %q; want one of %s", o.InputFormat, inputFormatNames())
+	}
+	targets := targetOptions{
+		ports:      o.Ports,
+		paths:      o.Paths,
+		expandCIDR: o.ExpandCIDR,
+		format:     o.InputFormat,
+		methods:    methods,
+		vhosts:     vhosts,
+		log:        errOut,
+	}
+	var sample *sampler
+	if o.SamplePerHost < 0 {
+		return nil, errors.New("--sample-per-host can't be negative")
+	}
+	if o.Sample > 0 || o.SamplePerHost > 0 {
+		sample = newSampler(o.Sample, o.SamplePerHost)
+	}
+
+	// input comes from files if any were given, otherwise stdin
+	var sources []InputSource
+	for _, f := range o.InputFiles {
+		src, err := newFileSource(f, errOut)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file: %s", err)
+		}
+		r.cleanups = append(r.cleanups, func() { src.Close() })
+		sources = append(sources, src)
+	}
+	if o.Input != nil {
+		sources = append(sources, o.Input)
+	}
+	if len(sources) == 0 && o.Stdin != nil && !o.RPC {
+		sources = append(sources, newReaderSource(o.Stdin, errOut))
+	}
+
+	// the gRPC server accepts URLs as well as streaming results, so when
+	// it's running we carry on after the other input runs out
+	if o.GRPC != "" {
+		queue := newQueueSource()
+		gs := newGRPCServer(queue.Submit)
+		sinks = append(sinks, gs)
+
+		l, err := net.Listen("tcp", o.GRPC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start gRPC server: %s", err)
+		}
+		srv := newGRPCHTTPServer(gs)
+		r.serve("gRPC server", func() error { return srv.Serve(l) })
+		r.cleanups = append(r.cleanups, func() { srv.Close() })
+
+		sources = append(sources, queue)
+
+		// reloading requests everything in the input files again, for
+		// when they've changed
+		r.reload = func() {
+			fmt.Fprintln(errOut, "re-reading input files")
+			go func() {
+				for _, f := range o.InputFiles {
+					src, err := newFileSource(f, errOut)
+					if err != nil {
+						fmt.Fprintf(errOut, "failed to open input file: %s\n", err)
+						continue
+					}
+					for line := range src.Lines(nil) {
+						queue.Submit(line)
+					}
+				}
+			}()
+		}
+	}
+
+	var rend *renderer
+	if o.Render || o.Screenshot {
+		if o.OutputDir == "" {
+			return nil, errors.New("--render and --screenshot require an output directory (-o)")
+		}
+
+		var err error
+		rend, err = newRenderer(o.ChromePath, o.Proxy, o.Headers, o.Screenshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start chrome: %s", err)
+		}
+		r.cleanups = append(r.cleanups, rend.Close)
+	}
+
+	var reqTemplate *requestTemplate
+	if o.RequestFile != "" {
+		var err error
+		reqTemplate, err = loadRequestTemplate(o.RequestFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load request: %s", err)
+		}
+	}
+
+	var script *scriptHost
+	if o.Script != "" {
+		var err error
+		script, err = startScript(o.Script, scriptProcs(o), errOut)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start script: %s", err)
+		}
+		r.cleanups = append(r.cleanups, func() { script.Close() })
+	}
+
+	// Can't send a body with a GET request
+	if o.Body != "" && o.Method == "GET" {
+		o.Method = "POST"
+	}
+
+	pipe := &pipeline{}
+	for _, r := range o.Redirects {
+		h, err := parseRedirectRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redirect rule %q: %s", r, err)
+		}
+		pipe.OnRedirect(h)
+	}
+	if o.FollowRedirects {
+		pipe.OnRedirect(followRedirects)
+	}
+	if o.MaxRedirects < 1 {
+		return nil, errors.New("--max-redirects must be at least 1")
+	}
+	pipe.maxRedirects = o.MaxRedirects
+
+	// how much of bodies is read, both for the responses and for anything
+	// that's requested to compare them with (--mirror, --auto-calibrate...)
+	limits := bodyLimits{
+		truncateAt:      int64(o.TruncateAt),
+		maxSize:         int64(o.MaxSize),
+		maxDecompressed: int64(o.MaxDecompressed),
+		countRunes:      o.CountRunes,
+	}
+
+	pipe.Use(stagePrepare, templateHook)
+	pipe.Use(stagePrepare, validURLHook)
+	if o.CompareSchemes {
+		pipe.Use(stagePrepare, compareSchemesHook(client, limits, out))
+	}
+	if profiles != nil {
+		profiles.method, profiles.body, profiles.headers = o.Method, o.Body, o.Headers
+		pipe.Use(stagePrepare, profiles.applyHook)
+	}
+	if script != nil {
+		pipe.Use(stagePrepare, script.requestHook)
+	}
+	pipe.Use(stagePrepare, buildRequestHook)
+	pipe.Use(stagePrepare, duplicateHook(o.SkipDuplicates, errOut))
+	if o.ReflectMarker {
+		pipe.Use(stagePrepare, injectMarkerHook(o.ReflectIn))
+	}
+	stats := newRunStats()
+	if o.Health != "" {
+		l, err := net.Listen("tcp", o.Health)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start health endpoint: %s", err)
+		}
+		srv := &http.Server{Handler: HealthHandler(func() interface{} { return stats.summary(false) })}
+		r.serve("health endpoint", func() error { return srv.Serve(l) })
+		r.cleanups = append(r.cleanups, func() { srv.Close() })
+	}
+	pipe.OnError(stats.errorHook)
+	if o.Resume {
+		pipe.Use(stagePrepare, stats.resumeHook(store))
+	}
+
+	if o.SerializeHosts {
+		o.HostConcurrency = 1
+	}
+	if o.HostConcurrency > 0 {
+		pipe.Use(stageSchedule, hostConcurrencyHook(o.HostConcurrency))
+	} else if o.AutoConcurrency {
+		pipe.Use(stageSchedule, hostLimitHook())
+	}
+	if o.HostDelay > 0 {
+		pipe.Use(stageSchedule, hostDelayHook(o.HostDelay))
+	}
+
+	pipe.Use(stageSchedule, connTraceHook)
+	pipe.Use(stageSchedule, interimTraceHook)
+	if pins != nil {
+		pipe.Use(stageSchedule, pins.hook)
+	}
+	var calibrate *calibrator
+	if o.AutoCalibrate {
+		calibrate = newCalibrator(client, limits)
+		pipe.Use(stageSchedule, calibrate.hook)
+	}
+	var vhost *vhostProber
+	if len(vhosts) > 0 {
+		vhost = newVhostProber(client, limits)
+		pipe.Use(stageSchedule, vhost.hook)
+	}
+
+	if sign != nil {
+		pipe.Use(stageSchedule, signHook(sign))
+	}
+	if o.SaveSent {
+		pipe.Use(stageSchedule, sentTraceHook)
+	}
+	if o.SaveChain {
+		pipe.Use(stageSchedule, chainTraceHook)
+	}
+
+	fetch := fetchHook(client, limits)
+	if proxies != nil {
+		fetch = proxies.fetchHook(fetch)
+	}
+	if o.Retries > 0 {
+		fetch = retryHook(fetch, o.Retries, o.RetryBackoff)
+	}
+	pipe.Use(stageFetch, fetch)
+
+	if mirrorBase != nil {
+		pipe.Use(stageFetch, mirrorHook(client, limits, mirrorBase))
+	}
+
+	if len(o.DiffHeaders) > 0 {
+		pipe.Use(stageFetch, diffHeadersHook(client, limits, o.DiffHeaders))
+	}
+
+	if profiles != nil {
+		pipe.Use(stageFetch, profiles.fetchHook(client, limits))
+	}
+
+	pipe.Use(stageFetch, stats.fetchedHook)
+
+	var backoff *hostBackoff
+	if o.HostBackoff {
+		backoff = newHostBackoff()
+		pipe.Use(stageFetch, backoff.hook)
+		pipe.OnError(backoff.errorHook)
+	}
+
+	// hooks that look through the body are skipped once a response has
+	// used up its --processing-timeout
+	var budget *analysisBudget
+	analyse := func(h hook) hook { return h }
+	if o.ProcessingTimeout > 0 {
+		budget = newAnalysisBudget(o.ProcessingTimeout)
+		analyse = budget.guard
+		pipe.Use(stageFilter, budget.startHook)
+	}
+
+	if calibrate != nil {
+		pipe.Use(stageFilter, calibrate.filterHook)
+	}
+	if vhost != nil {
+		pipe.Use(stageFilter, vhost.filterHook)
+	}
+	if o.SkipOversized {
+		if o.MaxSize == 0 {
+			return nil, errors.New("--skip-oversized needs --max-size")
+		}
+		pipe.Use(stageFilter, skipOversizedHook)
+	}
+	if o.IgnoreHTML {
+		pipe.Use(stageFilter, analyse(ignoreHTMLHook))
+	}
+	if o.IgnoreEmpty {
+		pipe.Use(stageFilter, ignoreEmptyHook)
+	}
+	// bad patterns are reported before anything's requested
+	var matchRe, filterRe bodyRegex
+	if len(o.MatchRegex) > 0 {
+		re, err := newBodyRegex(o.MatchRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -mr regex: %s", err)
+		}
+		matchRe = re
+	}
+	if len(o.FilterRegex) > 0 {
+		re, err := newBodyRegex(o.FilterRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -fr regex: %s", err)
+		}
+		filterRe = re
+	}
+	if o.MinMatches < 1 {
+		return nil, errors.New("--min-matches must be at least 1")
+	}
+	if o.MatchString != "" {
+		pipe.Use(stageFilter, analyse(matchStringHook(o.MatchString, o.MinMatches)))
+	}
+	if matchRe != nil {
+		pipe.Use(stageFilter, analyse(matchRegexHook(matchRe, o.MinMatches)))
+	}
+	if filterRe != nil {
+		pipe.Use(stageFilter, analyse(filterRegexHook(filterRe)))
+	}
+	for _, m := range []struct {
+		ranges RangeArgs
+		metric func(j *job) int64
+		keep   bool
+	}{
+		{o.MatchSize, bodySize, true},
+		{o.MatchWords, bodyWords, true},
+		{o.MatchLines, bodyLines, true},
+		{o.FilterSize, bodySize, false},
+		{o.FilterWords, bodyWords, false},
+		{o.FilterLines, bodyLines, false},
+	} {
+		if len(m.ranges) > 0 {
+			pipe.Use(stageFilter, analyse(metricHook(m.ranges, m.metric, m.keep)))
+		}
+	}
+	if len(o.MatchCodes) > 0 {
+		pipe.Use(stageFilter, statusHook(o.MatchCodes))
+	}
+	if len(o.FilterCodes) > 0 {
+		pipe.Use(stageFilter, filterStatusHook(o.FilterCodes))
+	}
+	var dedupe *bodyDeduper
+	if o.Dedupe || o.DedupePerHost {
+		dedupe = newBodyDeduper(o.DedupePerHost)
+		pipe.Use(stageFilter, dedupe.hook)
+	}
+	if script != nil {
+		pipe.Use(stageFilter, analyse(script.responseHook))
+	}
+
+	pipe.Use(stageEnrich, resultHook)
+	pipe.Use(stageEnrich, analyse(findReflectionsHook))
+	if o.GraphQL {
+		pipe.Use(stageEnrich, analyse(graphqlHook(o.GraphQLSchemas)))
+	}
+	var openapi *openapiDiscovery
+	if o.OpenAPIDiscover || o.OpenAPIExpand {
+		openapi = newOpenAPIDiscovery(o.OpenAPIExpand)
+		pipe.Use(stageEnrich, analyse(openapi.hook))
+	}
+	pipe.Use(stageEnrich, dnsHook(dns))
+	if pins != nil {
+		pipe.Use(stageEnrich, pins.metaHook)
+	}
+	pipe.Use(stageEnrich, connMetaHook)
+	pipe.Use(stageEnrich, timingHook)
+	pipe.Use(stageEnrich, interimMetaHook)
+	pipe.Use(stageEnrich, cookieHook(o.AuditCookies))
+	if o.SaveSent {
+		pipe.Use(stageEnrich, sentHook)
+	}
+	if o.MatchString != "" {
+		pipe.Use(stageEnrich, analyse(matchContextHook(stringMatcher(o.MatchString), o.MatchContext, o.ShowMatches)))
+	}
+	if matchRe != nil {
+		pipe.Use(stageEnrich, analyse(matchContextHook(regexMatcher(matchRe), o.MatchContext, o.ShowMatches)))
+	}
+	if o.Preview > 0 {
+		pipe.Use(stageEnrich, previewHook(o.Preview))
+	}
+	if o.IncludeHeaders {
+		pipe.Use(stageEnrich, includeHeadersHook)
+	}
+	if rend != nil {
+		pipe.Use(stageEnrich, renderHook(rend, o.Render, o.Screenshot, errOut))
+	}
+
+	if budget != nil {
+		pipe.Use(stageStore, budget.doneHook)
+	}
+	if o.OutputDir != "" {
+		if o.SaveChain {
+			pipe.Use(stageStore, chainStoreHook(store, errOut))
+		}
+		pipe.Use(stageStore, storeHook(store, o.HashResponse, errOut))
+	}
+
+	pipe.Use(stageReport, sinksHook(sinks))
+
+	// anything that's written at the end of the run (the summary and so
+	// on) is written when it's interrupted too, but only once
+	var emit *emitter
+	var unique *uniqueOutput
+	var grouped *groupedOutput
+	var finishOnce sync.Once
+	finish := func(interrupted bool) {
+		finishOnce.Do(func() {
+			if grouped != nil {
+				grouped.print(out)
+			}
+			if emit != nil {
+				if err := emit.finish(); err != nil {
+					fmt.Fprintf(errOut, "failed to write results: %s\n", err)
+				}
+			}
+			if profiles != nil {
+				profiles.print(out)
+			}
+
+			if !o.Quiet {
+				stats.print(errOut)
+				if sample != nil {
+					sample.print(errOut)
+				}
+				if backoff != nil {
+					backoff.print(errOut)
+				}
+				if calibrate != nil {
+					calibrate.print(errOut)
+				}
+				if vhost != nil {
+					vhost.print(errOut)
+				}
+				if dedupe != nil {
+					dedupe.print(errOut)
+				}
+				if budget != nil {
+					budget.print(errOut)
+				}
+				if unique != nil {
+					unique.print(errOut)
+				}
+			}
+			if o.StatsJSON != "" {
+				if err := stats.writeJSON(o.StatsJSON, interrupted); err != nil {
+					fmt.Fprintf(errOut, "failed to write stats: %s\n", err)
+				}
+			}
+		})
+	}
+
+	r.finish = finish
+
+	pool := newWorkerPool(pipe, o.Concurrency)
+
+	// in RPC mode stdin and stdout belong to the client
+	if o.RPC {
+		if o.Stdin == nil {
+			return nil, errors.New("--rpc needs Stdin to read requests from")
+		}
+		rpc := newRPCServer(out)
+		pipe.Use(stageReport, rpc.reportHook)
+		pipe.OnError(rpc.errorHook)
+
+		r.run = func(ctx context.Context) error {
+			pool.stop = ctx.Done()
+			rpc.serve(o.Stdin, pool, o.Delay, rpcRequest{
+				Method:  o.Method,
+				Headers: o.Headers,
+				Body:    o.Body,
+			})
+			finish(false)
+			return nil
+		}
+		return r, nil
+	}
+
+	if o.UniqueBy != "" {
+		var err error
+		unique, err = newUniqueOutput(o.UniqueBy)
+		if err != nil {
+			return nil, err
+		}
+		pipe.Use(stageReport, unique.hook)
+	}
+
+	// results go to the callbacks if there are any, instead of being
+	// printed
+	if o.OnResult != nil || o.OnError != nil {
+		pipe.Use(stageReport, callbackHook(o.OnResult))
+		pipe.OnError(callbackErrorHook(o.OnError))
+		o.Emit, o.GroupBy, o.Null, o.JSON, o.Pretty = "", "", false, false, false
+	}
+
+	var pretty *prettyOutput
+	if o.Pretty {
+		if o.JSON {
+			return nil, errors.New("--pretty and --json can't be used together")
+		}
+		pretty = newPrettyOutput(o.Stdout, out)
+	}
+
+	if o.Null && (o.Emit != "" || o.GroupBy != "" || o.Pretty) {
+		return nil, errors.New("-0 can't be used with --emit, --group-by or --pretty")
+	}
+
+	if o.Emit != "" {
+		if o.JSON || o.GroupBy != "" || o.Pretty {
+			return nil, errors.New("--emit can't be used with --json, --group-by or --pretty")
+		}
+
+		var err error
+		emit, err = newEmitter(o.Emit, out, errOut)
+		if err != nil {
+			return nil, err
+		}
+		pipe.Use(stageReport, emit.hook)
+		pipe.OnError(emit.errorHook)
+	} else if o.GroupBy != "" {
+		var err error
+		grouped, err = newGroupedOutput(o.GroupBy, o.JSON)
+		if err != nil {
+			return nil, err
+		}
+		if pretty != nil {
+			grouped.format, grouped.formatError = pretty.format, pretty.formatError
+		}
+		pipe.Use(stageReport, grouped.hook)
+		pipe.OnError(grouped.errorHook)
+	} else if o.Null {
+		format, formatErr := formatResult, formatError
+		if o.JSON {
+			format, formatErr = formatResultJSON, formatErrorJSON
+		}
+		pipe.Use(stageReport, nullHook(out, format))
+		pipe.OnError(nullErrorHook(out, formatErr))
+	} else if o.JSON {
+		pipe.Use(stageReport, stdoutJSONHook(out))
+		pipe.OnError(stdoutJSONErrorHook(out))
+	} else if pretty != nil {
+		pipe.Use(stageReport, pretty.hook)
+		pipe.OnError(pretty.errorHook)
+	} else if o.OnResult == nil && o.OnError == nil {
+		pipe.Use(stageReport, stdoutHook(out))
+		pipe.OnError(stdoutErrorHook(out))
+	}
+
+	// the global limit is applied here rather than in the pipeline so that
+	// we stop reading input while we're waiting for room
+	var global *aimdLimiter
+	if o.AutoConcurrency {
+		global = newAIMDLimiter(autoGlobalStart, autoGlobalMax)
+	}
+
+	// start runs a job for the target; pool.add has to have been called
+	// for it
+	start := func(t target) {
+		j := newJob(t.url, o.Method, o.Body, o.Headers)
+		j.input = t.input
+		if reqTemplate != nil {
+			reqTemplate.apply(j)
+		}
+		if t.hints != nil {
+			t.hints.apply(j)
+		}
+		if t.method != "" {
+			j.method = t.method
+		}
+		if t.vhost != "" {
+			j.vhost = t.vhost
+			j.headers = mergeHeaders(j.headers, []string{"Host: " + t.vhost})
+		}
+
+		if global != nil {
+			global.Acquire()
+			j.onFinish(func() { global.Release(j) })
+		}
+
+		pool.dispatch(j)
+	}
+
+	// URLs found along the way are requested at the same pace as the
+	// input. They're counted while the job that found them is still
+	// running, so the wait below can't finish before they do.
+	if openapi != nil {
+		openapi.submit = func(ts []target) {
+			pool.add(len(ts))
+			go func() {
+				for _, t := range ts {
+					time.Sleep(o.Delay)
+					start(t)
+				}
+			}()
+		}
+	}
+
+	r.run = func(ctx context.Context) error {
+		pool.stop = ctx.Done()
+
+		input := expandTargets(mergeSources(sources, ctx.Done()), targets)
+		if sample != nil {
+			input = sampleTargets(input, sample)
+		}
+		if openapi != nil {
+			input = openapiProbes(input)
+		}
+		if o.Interleave {
+			input = interleaveTargets(input, interleaveWindow)
+		}
+		if backoff != nil {
+			input = backoffTargets(input, backoff, interleaveWindow)
+		}
+
+	feed:
+		for {
+			select {
+			case <-ctx.Done():
+				break feed
+			case t, ok := <-input:
+				if !ok {
+					break feed
+				}
+				pool.add(1)
+				time.Sleep(o.Delay)
+				start(t)
+			}
+		}
+
+		// the sources stop once the context's done, and whatever's
+		// between them and here is drained so that nothing's left
+		// waiting to send
+		go func() {
+			for range input {
+			}
+		}()
+
+		pool.Wait()
+		finish(ctx.Err() != nil)
+		return nil
+	}
+	return r, nil
+}
+
+// Run requests everything from the input, and returns once it's all
+// finished. When the context's cancelled no more input is read, and Run
+// returns once the requests that are already going have finished; the
+// summary's written and the results are finished off either way.
+func (r *Requester) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// a server that's stopped stops the run
+	failed := make(chan error, 1)
+	go func() {
+		select {
+		case err := <-r.serverErrs:
+			failed <- err
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := r.run(ctx)
+	cancel()
+	select {
+	case serr := <-failed:
+		return serr
+	default:
+		return err
+	}
+}
+
+// Reload re-reads the input files and queues everything in them to be
+// requested again, for when they've changed, when the run's going to carry
+// on after the input's run out (with --grpc). It does nothing otherwise.
+// The fff command reloads on a SIGHUP.
+func (r *Requester) Reload() {
+	if r.reload != nil {
+		r.reload()
+	}
+}
+
+// serve runs a server that's already listening in the background. If it
+// stops for any reason other than being closed, the error's sent to Run.
+func (r *Requester) serve(name string, serve func() error) {
+	go func() {
+		err := serve()
+		if err == nil || err == http.ErrServerClosed || errors.Is(err, net.ErrClosed) {
+			return
+		}
+		select {
+		case r.serverErrs <- fmt.Errorf("%s failed: %s", name, err):
+		default:
+		}
+	}()
+}
+
+// Close finishes off the results and the summary if Run hasn't (when it's
+// been stopped part way through, say), then stops everything and writes
+// out the index. It has to be called once the Requester's finished with,
+// and it's safe to call more than once, or while Run's still going, to
+// stop straight away.
+func (r *Requester) Close() {
+	r.closeOnce.Do(func() {
+		if r.finish != nil {
+			r.finish(true)
+		}
+		for i := len(r.cleanups) - 1; i >= 0; i-- {
+			r.cleanups[i]()
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRequester(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	urls := make(chan string, 3)
+	urls <- ts.URL + "/a"
+	urls <- ts.URL + "/missing"
+	urls <- ts.URL + "/b"
+	close(urls)
+
+	var mu sync.Mutex
+	var have []string
+	opts := DefaultOptions()
+	opts.Delay = 0
+	opts.Quiet = true
+	opts.Input = NewChanSource(urls)
+	opts.FilterCodes.Set("404")
+	opts.OnResult = func(res Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		have = append(have, fmt.Sprintf("%s %d", res.URL, res.Status))
+	}
+
+	r, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(have)
+	want := []string{ts.URL + "/a 200", ts.URL + "/b 200"}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("want results %v, have %v", want, have)
+	}
+}
+
+func TestRequesterBadOptions(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SaveChain = true
+	if _, err := New(opts); err == nil {
+		t.Error("want an error for --save-chain without -o")
+	}
+}
+
+func TestRequesterHostAndSNI(t *testing.T) {
+	var mu sync.Mutex
+	var have []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		have = append(have, r.Host+" "+r.TLS.ServerName)
+	}))
+	defer ts.Close()
+
+	for _, c := range []struct {
+		host, sni string
+		want      string
+	}{
+		{"origin.example.com", "", "origin.example.com origin.example.com"},
+		{"origin.example.com:8443", "", "origin.example.com:8443 origin.example.com"},
+		{"origin.example.com", "cdn.example.net", "origin.example.com cdn.example.net"},
+		{"", "cdn.example.net", strings.TrimPrefix(ts.URL, "https://") + " cdn.example.net"},
+	} {
+		have = nil
+		urls := make(chan string, 1)
+		urls <- ts.URL + "/"
+		close(urls)
+
+		opts := DefaultOptions()
+		opts.Delay = 0
+		opts.Quiet = true
+		opts.Input = NewChanSource(urls)
+		opts.OnResult = func(Result) {}
+		opts.Host = c.host
+		opts.TLS.ServerName = c.sni
+
+		r, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Run(context.Background())
+		r.Close()
+
+		if len(have) != 1 || have[0] != c.want {
+			t.Errorf("--host %q --sni %q: want %q, have %q", c.host, c.sni, c.want, have)
+		}
+	}
+}
+
+func TestRequesterStdout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	opts := DefaultOptions()
+	opts.Delay = 0
+	opts.Quiet = true
+	opts.Stdin = strings.NewReader(ts.URL + "/a\n")
+	var out bytes.Buffer
+	opts.Stdout = &out
+
+	r, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(out.String(), ts.URL+"/a,,status: 200,") {
+		t.Errorf("want the result on stdout, have %q", out.String())
+	}
+}
+
+func TestRequesterStderr(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer ts.Close()
+
+	run := func(quiet bool) string {
+		opts := DefaultOptions()
+		opts.Delay = 0
+		opts.Concurrency = 1
+		opts.Dedupe = true
+		opts.Quiet = quiet
+		opts.Stdin = strings.NewReader(ts.URL + "/a\n" + ts.URL + "/a\n" + ts.URL + "/b\n")
+		var stderr bytes.Buffer
+		opts.Stderr = &stderr
+
+		r, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if err := r.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return stderr.String()
+	}
+
+	// warnings go to Stderr whether it's quiet or not, but the summaries
+	// at the end don't when it is
+	for _, quiet := range []bool{false, true} {
+		out := run(quiet)
+		if !strings.Contains(out, "duplicate request for "+ts.URL+"/a") {
+			t.Errorf("quiet %t: want a duplicate warning, have %q", quiet, out)
+		}
+		summaries := strings.Contains(out, "3 requests in") && strings.Contains(out, "(--dedupe)")
+		if summaries == quiet {
+			t.Errorf("quiet %t: want summaries %t, have %q", quiet, !quiet, out)
+		}
+	}
+}
+
+func TestRequesterListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	opts := DefaultOptions()
+	opts.GRPC = l.Addr().String()
+	if _, err := New(opts); err == nil {
+		t.Error("want an error when the gRPC server can't listen")
+	}
+
+	opts = DefaultOptions()
+	opts.Health = l.Addr().String()
+	if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "health endpoint") {
+		t.Errorf("want an error when the health endpoint can't listen, have %v", err)
+	}
+}
+
+func TestRequesterInputFileError(t *testing.T) {
+	opts := DefaultOptions()
+	opts.InputFiles = InputArgs{filepath.Join(t.TempDir(), "nonexistent")}
+	if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "failed to open input file") {
+		t.Errorf("want an error for an input file that isn't there, have %v", err)
+	}
+}
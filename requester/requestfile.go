@@ -0,0 +1,55 @@
+package requester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// requestTemplate is a raw HTTP request from --request-file, like one
+// saved from Burp, that's made to every URL in the input. The scheme and
+// host always come from the URL. So does the path, unless the URL doesn't
+// have one, in which case it's the request's; that way a list of hosts
+// gets the request exactly as it was, and a list of URLs gets it sent
+// to each of them.
+type requestTemplate struct {
+	hints *requestHints
+
+	// the request's path and query
+	uri string
+}
+
+func loadRequestTemplate(path string) (*requestTemplate, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := strings.TrimLeft(string(raw), "\r\n")
+	hints, uri, err := rawRequestHints(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't an HTTP request: %s", path, err)
+	}
+
+	// an editor leaving a newline at the end of a request without a body
+	// shouldn't give it one
+	if strings.TrimSpace(hints.body) == "" {
+		hints.body = ""
+	}
+	return &requestTemplate{hints: hints, uri: uri}, nil
+}
+
+// apply makes the job's request the template's, for the job's URL. Any -H
+// headers go on top of the template's.
+func (t *requestTemplate) apply(j *job) {
+	u, err := url.Parse(j.url)
+	if err == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		if ref, err := url.Parse(t.uri); err == nil {
+			u.Path, u.RawPath, u.RawQuery = ref.Path, ref.RawPath, ref.RawQuery
+			j.url = u.String()
+		}
+	}
+
+	t.hints.apply(j)
+}
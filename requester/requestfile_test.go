@@ -0,0 +1,59 @@
+package requester
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRequestTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "req.txt")
+	raw := "\nPOST /api/login?next=%2Fhome HTTP/1.1\r\nHost: burp.example.com\r\nContent-Type: application/json\r\nX-Token: abc\r\nContent-Length: 13\r\n\r\n{\"user\":\"a\"}\n"
+	if err := ioutil.WriteFile(path, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := loadRequestTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://a.example.com", "https://a.example.com/api/login?next=%2Fhome"},
+		{"https://a.example.com/", "https://a.example.com/api/login?next=%2Fhome"},
+		{"http://b.example.com:8080/v2/login", "http://b.example.com:8080/v2/login"},
+	}
+	for _, c := range cases {
+		j := newJob(c.url, "GET", "", []string{"X-Token: override"})
+		tmpl.apply(j)
+
+		if j.url != c.want {
+			t.Errorf("%s: want URL %s, have %s", c.url, c.want, j.url)
+		}
+		if j.method != "POST" || j.body != "{\"user\":\"a\"}\n" {
+			t.Errorf("%s: want the request's method and body, have %s %q", c.url, j.method, j.body)
+		}
+		want := []string{"Content-Type: application/json", "X-Token: override"}
+		if !reflect.DeepEqual(j.headers, want) {
+			t.Errorf("%s: want headers %q, have %q", c.url, want, j.headers)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte("GET / HTTP/1.1\nHost: example.com\n\n\n"), 0644)
+	tmpl, err = loadRequestTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.hints.body != "" {
+		t.Errorf("want no body, have %q", tmpl.hints.body)
+	}
+
+	ioutil.WriteFile(path, []byte("not a request"), 0644)
+	if _, err := loadRequestTemplate(path); err == nil {
+		t.Error("want an error for a file that isn't a request")
+	}
+}
@@ -0,0 +1,168 @@
+package requester
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ResolveArgs are --resolve overrides, like curl's: host:port:addr, where
+// the port can be * for any port and there can be more than one address,
+// comma separated. They're checked as they're given.
+type ResolveArgs []string
+
+func (r *ResolveArgs) Set(val string) error {
+	if _, _, _, err := parseResolve(val); err != nil {
+		return err
+	}
+	*r = append(*r, val)
+	return nil
+}
+
+func (r ResolveArgs) String() string {
+	return strings.Join(r, ",")
+}
+
+// parseResolve splits a --resolve override into its host, port (which is
+// "*" for any) and addresses
+func parseResolve(spec string) (host, port string, addrs []string, err error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return "", "", nil, fmt.Errorf("invalid --resolve %q; want host:port:address", spec)
+	}
+	host, port = strings.ToLower(parts[0]), parts[1]
+
+	if port != "*" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", "", nil, fmt.Errorf("invalid port in --resolve %q; want a number or *", spec)
+		}
+	}
+
+	for _, a := range strings.Split(parts[2], ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]")
+		if net.ParseIP(a) == nil {
+			return "", "", nil, fmt.Errorf("invalid address %q in --resolve %q", a, spec)
+		}
+		addrs = append(addrs, a)
+	}
+	return host, port, addrs, nil
+}
+
+// resolveOverrides are the addresses to connect to instead of looking
+// hosts up, by "host:port", or "host:*" for any port
+type resolveOverrides map[string][]string
+
+// loadResolveOverrides reads the --resolve overrides and any in the
+// --resolve-file. The file has one override per line, in the same format,
+// or lines like a hosts file (an address and then names, for any port).
+// Blank lines and lines starting with # are skipped. Overrides given on
+// the command line win over the file's.
+func loadResolveOverrides(args ResolveArgs, path string) (resolveOverrides, error) {
+	o := make(resolveOverrides)
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) > 1 {
+				if net.ParseIP(fields[0]) == nil {
+					return nil, fmt.Errorf("%s: line %d: invalid address %q", path, n, fields[0])
+				}
+				for _, name := range fields[1:] {
+					if strings.HasPrefix(name, "#") {
+						break
+					}
+					o.add(name, "*", []string{fields[0]})
+				}
+				continue
+			}
+
+			host, port, addrs, err := parseResolve(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: %s", path, n, err)
+			}
+			o.add(host, port, addrs)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range args {
+		host, port, addrs, err := parseResolve(spec)
+		if err != nil {
+			return nil, err
+		}
+		o.add(host, port, addrs)
+	}
+	return o, nil
+}
+
+// add sets the addresses for a host and port. A host that's already got
+// some for the port has them replaced, rather than added to.
+func (o resolveOverrides) add(host, port string, addrs []string) {
+	o[strings.ToLower(host)+":"+port] = addrs
+}
+
+// lookup returns the addresses for a host and port, if there are any;
+// ones for the port come before ones for any port
+func (o resolveOverrides) lookup(host, port string) []string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if addrs, ok := o[host+":"+port]; ok {
+		return addrs
+	}
+	return o[host+":*"]
+}
+
+// resolveHosts handles --resolve and --resolve-file, making the client
+// connect to the given addresses for those hosts instead of looking them
+// up. Nothing else about the request changes, so the Host header and the
+// TLS server name are still the URL's host. Each address is tried in turn
+// until one of them connects.
+func resolveHosts(client *http.Client, o resolveOverrides) {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{ControlContext: dialControl}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs := o.lookup(host, port)
+		if len(addrs) == 0 {
+			return dial(ctx, network, addr)
+		}
+
+		for _, a := range addrs {
+			var conn net.Conn
+			conn, err = dial(ctx, network, net.JoinHostPort(a, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,82 @@
+package requester
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseResolve(t *testing.T) {
+	host, port, addrs, err := parseResolve("Example.com:443:10.0.0.1,[::1]")
+	if err != nil || host != "example.com" || port != "443" || strings.Join(addrs, " ") != "10.0.0.1 ::1" {
+		t.Errorf("want example.com 443 [10.0.0.1 ::1], have %s %s %v (%v)", host, port, addrs, err)
+	}
+	if _, port, _, _ := parseResolve("example.com:*:10.0.0.1"); port != "*" {
+		t.Errorf("want any port, have %s", port)
+	}
+	for _, spec := range []string{"example.com", "example.com:443", ":443:10.0.0.1", "example.com:http:10.0.0.1", "example.com:0:10.0.0.1", "example.com:443:nope"} {
+		if _, _, _, err := parseResolve(spec); err == nil {
+			t.Errorf("%q: want an error", spec)
+		}
+	}
+}
+
+func TestLoadResolveOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolve")
+	ioutil.WriteFile(path, []byte("# staging\n10.0.0.1 www.example.com api.example.com # old\n\nwww.example.com:8443:10.0.0.2\n"), 0644)
+
+	o, err := loadResolveOverrides(ResolveArgs{"api.example.com:*:10.0.0.3"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		host, port, want string
+	}{
+		{"www.example.com", "443", "10.0.0.1"},
+		{"WWW.example.com.", "8443", "10.0.0.2"},
+		{"api.example.com", "443", "10.0.0.3"},
+		{"old", "443", ""},
+		{"example.com", "443", ""},
+	} {
+		if have := strings.Join(o.lookup(c.host, c.port), " "); have != c.want {
+			t.Errorf("%s:%s: want %q, have %q", c.host, c.port, c.want, have)
+		}
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	var have string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		have = r.Host + " " + r.TLS.ServerName
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	urls := make(chan string, 1)
+	urls <- "https://origin.example.com:" + u.Port() + "/"
+	close(urls)
+
+	opts := DefaultOptions()
+	opts.Delay = 0
+	opts.Quiet = true
+	opts.Input = NewChanSource(urls)
+	opts.OnResult = func(Result) {}
+	// the first address doesn't answer, so the second's tried
+	opts.Resolve.Set("origin.example.com:" + u.Port() + ":127.0.0.2," + u.Hostname())
+
+	r, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run(context.Background())
+	r.Close()
+
+	if want := "origin.example.com:" + u.Port() + " origin.example.com"; have != want {
+		t.Errorf("want the Host and server name kept as %q, have %q", want, have)
+	}
+}
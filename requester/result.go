@@ -0,0 +1,47 @@
+package requester
+
+import "net/http"
+
+// Result describes a single response that made it through the filters.
+// It's what gets sent to anything consuming results as they're produced.
+//
+// URL is what was actually requested, which isn't always the same as the
+// Input line it came from: ports and paths get added, and URLs are
+// normalised before they're requested.
+type Result struct {
+	Input       string   `json:"input"`
+	URL         string   `json:"url"`
+	Method      string   `json:"method"`
+	Status      int      `json:"status"`
+	Size        int64    `json:"size"`
+	Words       int      `json:"words"`
+	Lines       int      `json:"lines"`
+	ContentType string   `json:"content_type"`
+	Title       string   `json:"title,omitempty"`
+	Server      string   `json:"server,omitempty"`
+	Location    string   `json:"location,omitempty"`
+	Redirects   []string `json:"redirects,omitempty"`
+	Path        string   `json:"path,omitempty"`
+	Screenshot  string   `json:"screenshot,omitempty"`
+	VHost       string   `json:"vhost,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+
+	// the start of the body, with --preview, what's around each match,
+	// with --show-matches, and the response headers, with
+	// --include-headers
+	Preview string      `json:"preview,omitempty"`
+	Matches []string    `json:"matches,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+
+	// how long the request took, and the TLS connection and certificate
+	Timing *requestTiming `json:"timing,omitempty"`
+	TLS    *tlsDetails    `json:"tls,omitempty"`
+
+	// anything --script added
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// resultSink is anything that wants results as they're produced
+type resultSink interface {
+	Send(Result)
+}
@@ -0,0 +1,127 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// the longest a retry waits, however many attempts there have been or
+// whatever a Retry-After header says
+const maxRetryWait = 30 * time.Second
+
+// retryHook handles --retries, wrapping the fetch hook so that requests
+// that fail in ways that might not happen next time (timeouts, resets,
+// and 429, 502, 503 and 504 responses) are tried again. Each retry waits
+// twice as long as the one before, give or take half, starting at
+// backoff; a Retry-After header can make it wait longer. It's the last
+// attempt that counts, even if that failed too.
+func retryHook(fetch hook, retries int, backoff time.Duration) hook {
+	return func(j *job) bool {
+		meta, tags := len(j.meta), j.res.Tags
+
+		for attempt := 1; ; attempt++ {
+			ok := fetch(j)
+
+			reason := retryReason(j)
+			if reason == "" || attempt > retries {
+				return ok
+			}
+
+			wait := retryWait(backoff, attempt)
+			if j.resp != nil {
+				if after, err := strconv.Atoi(j.resp.Header.Get("Retry-After")); err == nil && time.Duration(after)*time.Second > wait {
+					wait = time.Duration(after) * time.Second
+				}
+			}
+			if wait > maxRetryWait {
+				wait = maxRetryWait
+			}
+
+			if j.chain != nil && j.resp != nil {
+				j.chain.add("retry", j.resp, j.respBody)
+			}
+
+			// forget everything about the failed attempt but the fact
+			// that it happened
+			j.meta, j.res.Tags = j.meta[:meta], tags
+			j.meta = append(j.meta, fmt.Sprintf("retry: %d of %d after %s (%s)", attempt, retries, wait.Round(time.Millisecond), reason))
+			meta = len(j.meta)
+			j.err, j.resp, j.respBody, j.redirects = nil, nil, nil, nil
+
+			if j.req.GetBody != nil {
+				body, err := j.req.GetBody()
+				if err != nil {
+					j.err = err
+					return false
+				}
+				j.req.Body = body
+			}
+
+			time.Sleep(wait)
+		}
+	}
+}
+
+// retryWait is how long to wait before the attempt'th retry: backoff,
+// doubled for each attempt before it, give or take half. It stops doubling
+// at maxRetryWait, so lots of retries can't overflow it.
+func retryWait(backoff time.Duration, attempt int) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+
+	wait := backoff
+	for i := 1; i < attempt && wait < maxRetryWait; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryWait {
+		wait = maxRetryWait
+	}
+
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait)+1))
+	if wait > maxRetryWait {
+		wait = maxRetryWait
+	}
+	return wait
+}
+
+// retryReason says why the job's worth another go, or returns an empty
+// string if it isn't
+func retryReason(j *job) string {
+	if j.err != nil {
+		if retryableError(j.err) {
+			return j.err.Error()
+		}
+		return ""
+	}
+
+	if j.resp == nil {
+		return ""
+	}
+	switch j.resp.StatusCode {
+	case 429, 502, 503, 504:
+		return fmt.Sprintf("status %d", j.resp.StatusCode)
+	}
+	return ""
+}
+
+// retryableError reports whether an error might not happen if the request
+// was made again. Things like DNS failures and refused connections are
+// left alone, because they almost always would.
+func retryableError(err error) bool {
+	if isTimeout(err) {
+		return true
+	}
+
+	for _, e := range []error{syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
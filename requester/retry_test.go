@@ -0,0 +1,91 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// drop the connection without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	client := newClient(false, "")
+	fetch := retryHook(fetchHook(client, bodyLimits{}), 3, time.Millisecond)
+
+	j := newJob(srv.URL, "POST", "the body", nil)
+	if !buildRequestHook(j) || !fetch(j) {
+		t.Fatal(j.err)
+	}
+
+	if j.resp.StatusCode != 200 || string(j.respBody) != "the body" {
+		t.Errorf("want the body echoed on the third attempt, have %d %q", j.resp.StatusCode, j.respBody)
+	}
+	if len(j.meta) != 2 || !strings.Contains(j.meta[0], "status 503") || !strings.HasPrefix(j.meta[1], "retry: 2 of 3") {
+		t.Errorf("unexpected meta: %q", j.meta)
+	}
+
+	// it gives up in the end, with the last response
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer failing.Close()
+
+	attempts = 0
+	j = newJob(failing.URL, "GET", "", nil)
+	if !buildRequestHook(j) || !retryHook(fetchHook(client, bodyLimits{}), 1, time.Millisecond)(j) {
+		t.Fatal(j.err)
+	}
+	if j.resp.StatusCode != 429 || len(j.meta) != 1 || attempts != 2 {
+		t.Errorf("want a 429 after two attempts, have %d after %d, with meta %q", j.resp.StatusCode, attempts, j.meta)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	// the first retry waits the backoff, give or take half
+	for i := 0; i < 100; i++ {
+		if w := retryWait(time.Second, 1); w < 500*time.Millisecond || w > 1500*time.Millisecond {
+			t.Fatalf("want the first wait within half of a second, have %s", w)
+		}
+	}
+
+	// doubling 500ms would overflow long before the 100th attempt
+	for _, attempt := range []int{36, 40, 64, 100, 1000} {
+		if w := retryWait(500*time.Millisecond, attempt); w < maxRetryWait/2 || w > maxRetryWait {
+			t.Errorf("want attempt %d's wait between %s and %s, have %s", attempt, maxRetryWait/2, maxRetryWait, w)
+		}
+	}
+
+	if w := retryWait(0, 10); w != 0 {
+		t.Errorf("want no wait without a backoff, have %s", w)
+	}
+}
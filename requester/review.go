@@ -0,0 +1,290 @@
+package requester
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reviewMarker is the second field of the index lines fff tag adds, where
+// result lines have the URL
+const reviewMarker = "#review"
+
+// review is what's been said about a saved response with fff tag
+type review struct {
+	tags []string
+	note string
+}
+
+func (r *review) String() string {
+	var parts []string
+	if len(r.tags) > 0 {
+		parts = append(parts, "["+strings.Join(r.tags, " ")+"]")
+	}
+	if r.note != "" {
+		parts = append(parts, strconv.Quote(r.note))
+	}
+	return strings.Join(parts, " ")
+}
+
+// hasTags reports whether the response has been given all of the tags
+func (r *review) hasTags(tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, have := range r.tags {
+			found = found || have == t
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+var reviewTagRe = regexp.MustCompile(`^[A-Za-z0-9_.:/][A-Za-z0-9_.:/-]*$`)
+
+// ReviewTags are fff tag's --tag and --untag, which can be given more
+// than once
+type ReviewTags []string
+
+func (t *ReviewTags) Set(val string) error {
+	for _, tag := range strings.Split(val, ",") {
+		if !reviewTagRe.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q; tags can have letters, numbers and _.:/- in them", tag)
+		}
+		*t = append(*t, tag)
+	}
+	return nil
+}
+
+func (t ReviewTags) String() string {
+	return strings.Join(t, ",")
+}
+
+// reviewLine is the index line for an annotation, e.g.
+//
+//	out/example.com/a1b2c3.body #review 2026-01-02T15:04:05Z +interesting -todo note: "creds in config"
+//
+// That's the body's path, then when it was made, the tags that were added
+// and taken away, and the note, when it was given (an empty one takes the
+// note away). Each line is on top of the ones before it.
+func reviewLine(p string, at time.Time, add, remove []string, note *string) string {
+	line := fmt.Sprintf("%s %s %s", p, reviewMarker, at.UTC().Format(time.RFC3339))
+	for _, t := range add {
+		line += " +" + t
+	}
+	for _, t := range remove {
+		line += " -" + t
+	}
+	if note != nil {
+		line += " note: " + strconv.Quote(*note)
+	}
+	return line + "\n"
+}
+
+// applyReviewLine applies an annotation line to what's been said about
+// the response so far
+func applyReviewLine(r *review, fields string) {
+	fields, note, hasNote := strings.Cut(" "+fields, " note: ")
+	for _, f := range strings.Fields(fields) {
+		switch {
+		case strings.HasPrefix(f, "+"):
+			if !r.hasTags([]string{f[1:]}) {
+				r.tags = append(r.tags, f[1:])
+			}
+		case strings.HasPrefix(f, "-"):
+			kept := r.tags[:0]
+			for _, t := range r.tags {
+				if t != f[1:] {
+					kept = append(kept, t)
+				}
+			}
+			r.tags = kept
+		}
+	}
+	if hasNote {
+		if n, err := strconv.Unquote(note); err == nil {
+			r.note = n
+		}
+	}
+}
+
+// indexEntry is a saved response, as the index has it
+type indexEntry struct {
+	path string
+	url  string
+}
+
+// readIndex reads the results and the annotations in an output directory's
+// index. Both are keyed by hash.
+func readIndex(dir string) (map[string]indexEntry, map[string]*review, error) {
+	f, err := os.Open(filepath.Join(dir, "index"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]indexEntry)
+	reviews := make(map[string]*review)
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), " ", 4)
+		if len(fields) < 3 {
+			continue
+		}
+		hash := hashFromPath(fields[0])
+
+		if fields[1] == reviewMarker {
+			r := reviews[hash]
+			if r == nil {
+				r = &review{}
+				reviews[hash] = r
+			}
+			if len(fields) == 4 {
+				applyReviewLine(r, fields[3])
+			}
+			continue
+		}
+
+		// the URL can have the method in front of it
+		u := fields[1]
+		if !strings.Contains(u, "://") {
+			u = fields[2]
+		}
+		entries[hash] = indexEntry{path: fields[0], url: u}
+	}
+	return entries, reviews, sc.Err()
+}
+
+// loadReviews returns the annotations in an output directory's index, or
+// none when there isn't an index
+func loadReviews(dir string) map[string]*review {
+	_, reviews, err := readIndex(dir)
+	if err != nil {
+		return nil
+	}
+	return reviews
+}
+
+// findSaved finds the saved response that a hash, the start of one, or a
+// path to one of its files is for
+func findSaved(entries map[string]indexEntry, ref string) (string, error) {
+	if strings.ContainsAny(ref, "/.") {
+		ref = hashFromPath(ref)
+	}
+	if ref == "" {
+		return "", errors.New("no hash given")
+	}
+	if _, ok := entries[ref]; ok {
+		return ref, nil
+	}
+
+	var found []string
+	for h := range entries {
+		if strings.HasPrefix(h, ref) {
+			found = append(found, h)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no saved response for %s", ref)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("%s could be any of %d saved responses", ref, len(found))
+}
+
+// appendToIndex adds lines to the index. They're written in one go, so
+// they can't get mixed up with lines from a run that's still going, and on
+// a line of their own, even if a run died halfway through writing one.
+func appendToIndex(dir string, lines []string) error {
+	f, err := os.OpenFile(filepath.Join(dir, "index"), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data := strings.Join(lines, "")
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = "\n" + data
+		}
+	}
+
+	if _, err := io.WriteString(f, data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Tag annotates responses saved in dir, adding and taking away tags and
+// replacing the note, if it's given (an empty one takes it away). The
+// responses can be given by hash, the start of one, or the path to one of
+// their files.
+func Tag(dir string, refs []string, add, remove []string, note *string) error {
+	for _, t := range append(append([]string{}, add...), remove...) {
+		if !reviewTagRe.MatchString(t) {
+			return fmt.Errorf("invalid tag %q; tags can have letters, numbers and _.:/- in them", t)
+		}
+	}
+
+	entries, _, err := readIndex(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %s", err)
+	}
+
+	var lines []string
+	for _, ref := range refs {
+		hash, err := findSaved(entries, ref)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, reviewLine(entries[hash].path, time.Now(), add, remove, note))
+	}
+
+	if err := appendToIndex(dir, lines); err != nil {
+		return fmt.Errorf("failed to write to index: %s", err)
+	}
+	return nil
+}
+
+// ListTagged writes the annotated responses saved in dir that have all of
+// the tags to w
+func ListTagged(w io.Writer, dir string, tags []string) error {
+	entries, reviews, err := readIndex(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %s", err)
+	}
+	printReviews(w, entries, reviews, tags)
+	return nil
+}
+
+// printReviews lists the annotated responses that have all of the tags,
+// in path order
+func printReviews(w io.Writer, entries map[string]indexEntry, reviews map[string]*review, tags []string) {
+	var hashes []string
+	for h, r := range reviews {
+		if _, ok := entries[h]; !ok || (len(r.tags) == 0 && r.note == "") || !r.hasTags(tags) {
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return entries[hashes[i]].path < entries[hashes[j]].path
+	})
+
+	for _, h := range hashes {
+		fmt.Fprintf(w, "%s %s %s\n", entries[h].path, entries[h].url, reviews[h])
+	}
+}
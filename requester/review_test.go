@@ -0,0 +1,70 @@
+package requester
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReviews(t *testing.T) {
+	dir := t.TempDir()
+	index := "out/example.com/1916aaa77761709e698e668384195a838f632a2a.body https://example.com/ (200) 38 2026-10-16T11:07:27Z text/html\n" +
+		"out/example.com/api/1928bbb0c0ffee00000000000000000000000000.body POST https://example.com/api (500) 8 2026-10-16T11:07:27Z application/json\n" +
+		// a line that a run was halfway through writing when it died
+		"out/example.com/2a"
+	ioutil.WriteFile(filepath.Join(dir, "index"), []byte(index), 0644)
+
+	entries, _, err := readIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["1928bbb0c0ffee00000000000000000000000000"].url != "https://example.com/api" {
+		t.Errorf("want the URL without the method, have %+v", entries)
+	}
+
+	for ref, want := range map[string]string{
+		"1916": "1916aaa77761709e698e668384195a838f632a2a",
+		"out/example.com/api/1928bbb0c0ffee00000000000000000000000000.headers": "1928bbb0c0ffee00000000000000000000000000",
+		"19":   "",
+		"ffff": "",
+		"":     "",
+	} {
+		have, err := findSaved(entries, ref)
+		if have != want || (want == "" && err == nil) {
+			t.Errorf("%q: want %q, have %q (%v)", ref, want, have, err)
+		}
+	}
+
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	note, clear := `creds in "config"`, ""
+	p := entries["1916aaa77761709e698e668384195a838f632a2a"].path
+	err = appendToIndex(dir, []string{
+		reviewLine(p, at, []string{"interesting", "creds"}, nil, &note),
+		reviewLine(p, at, []string{"todo"}, []string{"creds"}, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, reviews, err := readIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("want the review lines kept apart from the results, have %d results", len(entries))
+	}
+	r := reviews["1916aaa77761709e698e668384195a838f632a2a"]
+	if r == nil || r.String() != `[interesting todo] "creds in \"config\""` {
+		t.Fatalf("want the tags and note, have %v", r)
+	}
+	if !r.hasTags([]string{"todo"}) || r.hasTags([]string{"todo", "creds"}) {
+		t.Errorf("want only the tags that are left, have %v", r.tags)
+	}
+
+	appendToIndex(dir, []string{reviewLine(p, at, nil, nil, &clear)})
+	_, reviews, _ = readIndex(dir)
+	if r := reviews["1916aaa77761709e698e668384195a838f632a2a"]; r.note != "" {
+		t.Errorf("want the note taken away, have %q", r.note)
+	}
+}
@@ -0,0 +1,142 @@
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// With --rpc, fff reads requests as JSON objects from stdin, one per line,
+// and writes a JSON response for each of them to stdout. It keeps going
+// until stdin is closed, so a wrapper in another language can start it
+// once and keep feeding it work.
+//
+//	→ {"id": 1, "url": "https://example.com/", "headers": ["X-Foo: bar"]}
+//	← {"id": 1, "result": {"url": "https://example.com/", "status": 200, ...}}
+//
+// Every request gets exactly one response, but they come back in the order
+// they finish rather than the order they were sent, so use the ids to match
+// them up. Responses that didn't make it through the filters come back with
+// "filtered": true, and failed requests with an "error".
+
+type rpcRequest struct {
+	ID      json.RawMessage `json:"id"`
+	URL     string          `json:"url"`
+	Method  string          `json:"method"`
+	Headers []string        `json:"headers"`
+	Body    string          `json:"body"`
+}
+
+type rpcResponse struct {
+	ID       json.RawMessage `json:"id"`
+	Result   *Result         `json:"result,omitempty"`
+	Filtered bool            `json:"filtered,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type rpcServer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+
+	// the id of the request each job in flight is for
+	ids map[*job]json.RawMessage
+}
+
+func newRPCServer(w io.Writer) *rpcServer {
+	return &rpcServer{
+		enc: json.NewEncoder(w),
+		ids: make(map[*job]json.RawMessage),
+	}
+}
+
+// reportHook sends the result for a job back to the client
+func (s *rpcServer) reportHook(j *job) bool {
+	res := j.res
+	s.respond(j, rpcResponse{Result: &res})
+	return true
+}
+
+// errorHook tells the client a job failed
+func (s *rpcServer) errorHook(j *job) {
+	s.respond(j, rpcResponse{Error: j.err.Error()})
+}
+
+// respond sends the response for a job, unless one has already been sent
+func (s *rpcServer) respond(j *job, resp rpcResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.ids[j]
+	if !ok {
+		return
+	}
+	delete(s.ids, j)
+
+	resp.ID = id
+	s.enc.Encode(resp)
+}
+
+func (s *rpcServer) write(resp rpcResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc.Encode(resp)
+}
+
+// serve reads requests from r until it's closed and runs each of them
+// through the pipeline. Anything a request leaves out comes from defaults,
+// and its headers replace any default ones with the same name.
+func (s *rpcServer) serve(r io.Reader, pool *workerPool, delay time.Duration, defaults rpcRequest) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.write(rpcResponse{Error: "invalid request: " + err.Error()})
+			continue
+		}
+
+		if _, _, err := normaliseURL(req.URL); err != nil {
+			s.write(rpcResponse{ID: req.ID, Error: "invalid URL: " + err.Error()})
+			continue
+		}
+
+		method := req.Method
+		if method == "" {
+			method = defaults.Method
+		}
+		body := req.Body
+		if body == "" {
+			body = defaults.Body
+		}
+		if body != "" && method == "GET" {
+			method = "POST"
+		}
+		headers := mergeHeaders(defaults.Headers, req.Headers)
+
+		j := newJob(req.URL, method, body, headers)
+
+		s.mu.Lock()
+		s.ids[j] = req.ID
+		s.mu.Unlock()
+
+		// if nothing else has responded by the time the job's finished
+		// with, it must have been filtered out
+		j.onFinish(func() {
+			s.respond(j, rpcResponse{Filtered: true})
+		})
+
+		time.Sleep(delay)
+		pool.Run(j)
+	}
+
+	pool.Wait()
+}
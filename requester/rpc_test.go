@@ -0,0 +1,74 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRPC(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	pipe, _ := testPipeline(nil)
+	pipe.Use(stageFilter, statusHook(StatusArgs{200}))
+
+	var echoed string
+	pipe.Use(stageReport, func(j *job) bool {
+		if strings.HasSuffix(j.url, "/echo") {
+			echoed = string(j.respBody)
+		}
+		return true
+	})
+
+	var out bytes.Buffer
+	rpc := newRPCServer(&out)
+	pipe.Use(stageReport, rpc.reportHook)
+	pipe.OnError(rpc.errorHook)
+
+	in := strings.Join([]string{
+		`{"id": 1, "url": "` + srv.URL + `/ok"}`,
+		`{"id": 2, "url": "` + srv.URL + `/missing"}`,
+		`{"id": 3, "url": "http://127.0.0.1:1/"}`,
+		`{"id": 4, "url": "not a url"}`,
+		`{"id": 5, "url": "` + srv.URL + `/echo", "headers": ["X-Two: 2"]}`,
+		`not json`,
+	}, "\n")
+	rpc.serve(strings.NewReader(in), newWorkerPool(pipe, 0), 0, rpcRequest{Method: "GET", Headers: []string{"X-One: 1"}})
+
+	responses := make(map[string]rpcResponse)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var resp rpcResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("bad response %q: %s", line, err)
+		}
+		responses[string(resp.ID)] = resp
+	}
+
+	if len(responses) != 6 {
+		t.Fatalf("want a response for every request, have %d", len(responses))
+	}
+	if r := responses["1"]; r.Result == nil || r.Result.Status != 200 {
+		t.Errorf("unexpected response for /ok: %+v", r)
+	}
+	if r := responses["2"]; !r.Filtered {
+		t.Errorf("want /missing filtered, have %+v", r)
+	}
+	if r := responses["3"]; r.Error == "" {
+		t.Errorf("want an error for a refused connection, have %+v", r)
+	}
+	if r := responses["4"]; !strings.Contains(r.Error, "invalid URL") {
+		t.Errorf("want an invalid URL error, have %+v", r)
+	}
+	if r := responses["5"]; r.Result == nil {
+		t.Errorf("no result for /echo: %+v", r)
+	}
+	if !strings.Contains(echoed, "X-One: 1") || !strings.Contains(echoed, "X-Two: 2") {
+		t.Errorf("want default and request headers sent, have %q", echoed)
+	}
+	if r := responses["null"]; !strings.Contains(r.Error, "invalid request") {
+		t.Errorf("want an invalid request error, have %+v", r)
+	}
+}
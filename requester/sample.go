@@ -0,0 +1,120 @@
+package requester
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SampleRate is a flag for the share of URLs to request, as a percentage
+// (10%) or a fraction (0.1)
+type SampleRate float64
+
+func (s *SampleRate) Set(val string) error {
+	pct := strings.HasSuffix(val, "%")
+	f, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+	if pct {
+		f /= 100
+	}
+	if err != nil || f <= 0 || f > 1 {
+		return fmt.Errorf("invalid sample %q; want a percentage (10%%) or a fraction (0.1) more than zero", val)
+	}
+	*s = SampleRate(f)
+	return nil
+}
+
+func (s SampleRate) String() string {
+	if s == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(s)*100, 'f', -1, 64) + "%"
+}
+
+// sampleBuckets is how finely --sample splits URLs up
+const sampleBuckets = 1000000
+
+// sampler handles --sample and --sample-per-host, cutting the input down
+// for a quick first look. Which URLs --sample keeps only depends on a
+// hash of the URL, so the same ones are picked every time, whatever else
+// is in the list, and a bigger sample includes everything a smaller one
+// did. --sample-per-host keeps the first N URLs for each host.
+type sampler struct {
+	rate    SampleRate
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]int
+	seen  int
+	kept  int
+}
+
+func newSampler(rate SampleRate, perHost int) *sampler {
+	return &sampler{
+		rate:    rate,
+		perHost: perHost,
+		hosts:   make(map[string]int),
+	}
+}
+
+// keep says whether a URL's part of the sample
+func (s *sampler) keep(rawURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen++
+	if s.rate > 0 && !inSample(rawURL, s.rate) {
+		return false
+	}
+	if s.perHost > 0 {
+		h := targetHost(rawURL)
+		if s.hosts[h] >= s.perHost {
+			return false
+		}
+		s.hosts[h]++
+	}
+	s.kept++
+	return true
+}
+
+func inSample(rawURL string, rate SampleRate) bool {
+	h := fnv.New64a()
+	io.WriteString(h, rawURL)
+	return h.Sum64()%sampleBuckets < uint64(float64(rate)*sampleBuckets)
+}
+
+// sampleTargets passes on only the targets that are part of the sample
+func sampleTargets(in <-chan target, s *sampler) <-chan target {
+	out := make(chan target)
+
+	go func() {
+		defer close(out)
+		for t := range in {
+			if s.keep(t.url) {
+				out <- t
+			}
+		}
+	}()
+
+	return out
+}
+
+func (s *sampler) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "sampled %d of %d URLs (%s)\n", s.kept, s.seen, s.describe())
+}
+
+func (s *sampler) describe() string {
+	var parts []string
+	if s.rate > 0 {
+		parts = append(parts, "--sample "+s.rate.String())
+	}
+	if s.perHost > 0 {
+		parts = append(parts, fmt.Sprintf("--sample-per-host %d", s.perHost))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,57 @@
+package requester
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampleRate(t *testing.T) {
+	for val, want := range map[string]SampleRate{"10%": 0.1, "0.25": 0.25, "100%": 1} {
+		var s SampleRate
+		if err := s.Set(val); err != nil || s != want {
+			t.Errorf("%q: want %v, have %v (%v)", val, want, s, err)
+		}
+	}
+	for _, val := range []string{"0", "0%", "150%", "-5%", "lots"} {
+		var s SampleRate
+		if err := s.Set(val); err == nil {
+			t.Errorf("%q: want an error", val)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	var urls []string
+	for i := 0; i < 10000; i++ {
+		urls = append(urls, fmt.Sprintf("http://h%d.example.com/%d", i%10, i))
+	}
+
+	// roughly the right share, and everything in a smaller sample is in a
+	// bigger one
+	small, big := newSampler(0.05, 0), newSampler(0.2, 0)
+	for _, u := range urls {
+		if small.keep(u) && !big.keep(u) {
+			t.Fatalf("%s is in the 5%% sample but not the 20%% one", u)
+		}
+	}
+	if small.kept < 400 || small.kept > 600 {
+		t.Errorf("want about 500 of 10000 in a 5%% sample, have %d", small.kept)
+	}
+
+	// the same URLs every time
+	again := newSampler(0.05, 0)
+	for _, u := range urls {
+		again.keep(u)
+	}
+	if again.kept != small.kept {
+		t.Errorf("want the same sample again, have %d then %d", small.kept, again.kept)
+	}
+
+	perHost := newSampler(0, 3)
+	for _, u := range urls {
+		perHost.keep(u)
+	}
+	if perHost.kept != 30 {
+		t.Errorf("want 3 for each of 10 hosts, have %d", perHost.kept)
+	}
+}
@@ -0,0 +1,124 @@
+package requester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// schemeResult is a summary of fetching one scheme variant of a URL
+type schemeResult struct {
+	status   int
+	size     int
+	location string
+	err      error
+}
+
+func (r schemeResult) String() string {
+	if r.err != nil {
+		return "error"
+	}
+	return fmt.Sprintf("%d %d %s", r.status, r.size, r.location)
+}
+
+// compareSchemes fetches both the http and https variants of a URL and
+// returns the two results along with a list of the ways they differ.
+func compareSchemes(client *http.Client, limits bodyLimits, method, rawURL, body string, headers []string) (schemeResult, schemeResult, []string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		r := schemeResult{err: err}
+		return r, r, nil
+	}
+
+	plain := *u
+	plain.Scheme = "http"
+	secure := *u
+	secure.Scheme = "https"
+
+	h := fetchSummary(context.Background(), client, limits, method, plain.String(), body, headers)
+	s := fetchSummary(context.Background(), client, limits, method, secure.String(), body, headers)
+
+	var diffs []string
+	switch {
+	case h.err != nil && s.err != nil:
+		return h, s, nil
+	case s.err != nil:
+		return h, s, []string{"http-only"}
+	case h.err != nil:
+		return h, s, []string{"https-only"}
+	}
+
+	// http redirecting to the same URL over https is what we'd hope to see,
+	// so that's noted but not considered a divergence
+	if h.location == secure.String() {
+		return h, s, nil
+	}
+
+	if h.status != s.status {
+		diffs = append(diffs, "status")
+	}
+	if sizesDiffer(h.size, s.size) {
+		diffs = append(diffs, "size")
+	}
+	if h.location != s.location {
+		diffs = append(diffs, "location")
+	}
+
+	return h, s, diffs
+}
+
+// sizesDiffer reports whether two sizes differ by more than 10%; exact
+// comparison would flag every page with a timestamp or CSRF token in it
+func sizesDiffer(a, b int) bool {
+	larger, diff := a, a-b
+	if b > a {
+		larger, diff = b, b-a
+	}
+	return diff*10 > larger
+}
+
+func fetchSummary(ctx context.Context, client *http.Client, limits bodyLimits, method, rawURL, body string, headers []string) schemeResult {
+	r, _ := fetchResponse(ctx, client, limits, method, rawURL, body, headers)
+	return r
+}
+
+// fetchResponse is fetchSummary for when the body's needed as well. No
+// more of the body is read than the max size allows, the same as for the
+// responses themselves.
+func fetchResponse(ctx context.Context, client *http.Client, limits bodyLimits, method, rawURL, body string, headers []string) (schemeResult, []byte) {
+	var b io.Reader
+	if body != "" {
+		b = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, b)
+	if err != nil {
+		return schemeResult{err: err}, nil
+	}
+	applyHeaders(req, headers)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return schemeResult{err: err}, nil
+	}
+	defer resp.Body.Close()
+
+	var rd io.Reader = resp.Body
+	if max, _ := limits.max(resp); max > 0 {
+		rd = io.LimitReader(resp.Body, max)
+	}
+	responseBody, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return schemeResult{err: err}, nil
+	}
+
+	return schemeResult{
+		status:   resp.StatusCode,
+		size:     len(responseBody),
+		location: resp.Header.Get("Location"),
+	}, responseBody
+}
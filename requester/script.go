@@ -0,0 +1,243 @@
+package requester
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// scriptHost runs the --script command for as long as fff's running, and
+// asks it about each request before it's made and each response that's
+// got through the filters. Each question is a line of JSON on the
+// script's stdin, and the script answers with a line of JSON on its
+// stdout, so it can be written in anything that can read and write
+// lines. Bodies are base64 encoded, so binary ones get there intact.
+//
+// A request looks like
+//
+//	{"event":"request","url":"...","method":"GET","headers":["Name: value"],"body":""}
+//
+// and the answer can change any of url, method, headers and body, or say
+// "skip":true to not make it at all. A response looks like
+//
+//	{"event":"response","url":"...","method":"GET","status":200,"headers":[...],"body":"..."}
+//
+// and the answer can say "match":false to drop it, add "tags", and add
+// "fields", which are kept with the result. Answering {} leaves things as
+// they were.
+//
+// Each copy of the script's only asked one thing at a time, so several
+// copies are run, and each question goes to whichever one's free.
+type scriptHost struct {
+	procs []*scriptProc
+	free  chan *scriptProc
+}
+
+// scriptProc is one running copy of the script
+type scriptProc struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+type scriptEvent struct {
+	Event   string   `json:"event"`
+	URL     string   `json:"url"`
+	Method  string   `json:"method"`
+	Status  int      `json:"status,omitempty"`
+	Headers []string `json:"headers"`
+	Body    []byte   `json:"body"`
+}
+
+type scriptReply struct {
+	// for requests
+	URL     *string  `json:"url"`
+	Method  *string  `json:"method"`
+	Headers []string `json:"headers"`
+	Body    *[]byte  `json:"body"`
+	Skip    bool     `json:"skip"`
+
+	// for responses
+	Match  *bool                  `json:"match"`
+	Tags   []string               `json:"tags"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// startScript starts n copies of the command, which is split on
+// whitespace like --sign's exec:cmd. Whatever they write to stderr goes
+// to log.
+func startScript(command string, n int, log io.Writer) (*scriptHost, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	s := &scriptHost{free: make(chan *scriptProc, n)}
+	for i := 0; i < n; i++ {
+		p, err := startScriptProc(args, log)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.procs = append(s.procs, p)
+		s.free <- p
+	}
+	return s, nil
+}
+
+func startScriptProc(args []string, log io.Writer) (*scriptProc, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = log
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &scriptProc{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// scriptProcs is how many copies of the script to run: --script-procs,
+// or one for each CPU, but no more than can be asked at once
+func scriptProcs(o Options) int {
+	if o.ScriptProcs > 0 {
+		return o.ScriptProcs
+	}
+	n := runtime.NumCPU()
+	if o.Concurrency > 0 && o.Concurrency < n {
+		n = o.Concurrency
+	}
+	return n
+}
+
+// ask sends the event to a copy of the script that isn't busy, and reads
+// its answer
+func (s *scriptHost) ask(ev scriptEvent) (scriptReply, error) {
+	var reply scriptReply
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return reply, err
+	}
+
+	p := <-s.free
+	defer func() { s.free <- p }()
+
+	if _, err := p.in.Write(append(line, '\n')); err != nil {
+		return reply, fmt.Errorf("script isn't running: %s", err)
+	}
+
+	answer, err := p.out.ReadBytes('\n')
+	if err != nil {
+		return reply, fmt.Errorf("script didn't answer: %s", err)
+	}
+	if err := json.Unmarshal(answer, &reply); err != nil {
+		return reply, fmt.Errorf("script's answer isn't JSON: %s", err)
+	}
+	return reply, nil
+}
+
+// requestHook lets the script change the request before it's built, so it
+// goes in the prepare stage before buildRequestHook
+func (s *scriptHost) requestHook(j *job) bool {
+	reply, err := s.ask(scriptEvent{
+		Event:   "request",
+		URL:     j.url,
+		Method:  j.method,
+		Headers: append([]string{}, j.headers...),
+		Body:    []byte(j.body),
+	})
+	if err != nil {
+		j.err = err
+		return false
+	}
+
+	if reply.Skip {
+		return false
+	}
+	if reply.URL != nil {
+		j.url = *reply.URL
+	}
+	if reply.Method != nil {
+		j.method = *reply.Method
+	}
+	if reply.Headers != nil {
+		j.headers = reply.Headers
+	}
+	if reply.Body != nil {
+		j.body = string(*reply.Body)
+	}
+	return true
+}
+
+// responseHook lets the script drop the response, tag it, and add fields
+// to it. It goes at the end of the filter stage, so the script only sees
+// responses that are going to be kept otherwise.
+func (s *scriptHost) responseHook(j *job) bool {
+	reply, err := s.ask(scriptEvent{
+		Event:   "response",
+		URL:     j.url,
+		Method:  j.method,
+		Status:  j.resp.StatusCode,
+		Headers: append([]string{}, headerLines(j.resp.Header)...),
+		Body:    j.respBody,
+	})
+	if err != nil {
+		j.err = err
+		return false
+	}
+
+	if reply.Match != nil && !*reply.Match {
+		return false
+	}
+	for _, t := range reply.Tags {
+		j.tag(t)
+	}
+
+	if len(reply.Fields) == 0 {
+		return true
+	}
+	if j.res.Fields == nil {
+		j.res.Fields = make(map[string]interface{})
+	}
+
+	names := make([]string, 0, len(reply.Fields))
+	for k, v := range reply.Fields {
+		j.res.Fields[k] = v
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v, _ := json.Marshal(reply.Fields[k])
+		j.meta = append(j.meta, fmt.Sprintf("script: %s: %s", k, v))
+	}
+	return true
+}
+
+// Close tells the script there's nothing more to come, and waits for
+// each copy of it to exit
+func (s *scriptHost) Close() error {
+	var firstErr error
+	for _, p := range s.procs {
+		p.in.Close()
+		if err := p.cmd.Wait(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,123 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScriptHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hooks.sh")
+	err := os.WriteFile(path, []byte(`
+while read -r line; do
+	case "$line" in
+	*'"event":"request"'*'/skip'*) echo '{"skip":true}' ;;
+	*'"event":"request"'*) echo '{"method":"POST","body":"Yj0x"}' ;;
+	*'"body":"//4A"'*) echo '{"tags":["binary"]}' ;;
+	*admin*) echo '{"tags":["admin"],"fields":{"panel":"admin","n":1}}' ;;
+	*) echo '{"match":false}' ;;
+	esac
+done
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := startScript("sh "+path, 1, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	j := newJob("http://example.com/", "GET", "", nil)
+	if !s.requestHook(j) {
+		t.Fatal("request was skipped", j.err)
+	}
+	if j.method != "POST" || j.body != "b=1" || j.url != "http://example.com/" {
+		t.Errorf("request wasn't changed as expected: %s %s %q", j.method, j.url, j.body)
+	}
+
+	if s.requestHook(newJob("http://example.com/skip", "GET", "", nil)) {
+		t.Error("want request to be skipped")
+	}
+
+	j.url = "http://example.com/admin"
+	j.resp = &http.Response{StatusCode: 200, Header: http.Header{}}
+	j.respBody = []byte("<h1>admin</h1>")
+	if !s.responseHook(j) {
+		t.Fatal("response was dropped", j.err)
+	}
+	if !reflect.DeepEqual(j.res.Tags, []string{"admin"}) {
+		t.Errorf("want admin tag, have %v", j.res.Tags)
+	}
+	want := []string{`script: n: 1`, `script: panel: "admin"`}
+	if !reflect.DeepEqual(j.meta, want) {
+		t.Errorf("want meta %q, have %q", want, j.meta)
+	}
+	if j.res.Fields["panel"] != "admin" {
+		t.Errorf("want panel field, have %v", j.res.Fields)
+	}
+
+	// bodies that aren't text get to the script as they are
+	bin := newJob("http://example.com/logo.png", "GET", "", nil)
+	bin.resp = &http.Response{StatusCode: 200, Header: http.Header{}}
+	bin.respBody = []byte{0xff, 0xfe, 0}
+	if !s.responseHook(bin) || !reflect.DeepEqual(bin.res.Tags, []string{"binary"}) {
+		t.Errorf("want the binary body seen intact, have tags %v", bin.res.Tags)
+	}
+
+	j.url = "http://example.com/"
+	j.respBody = []byte("nothing to see")
+	if s.responseHook(j) {
+		t.Error("want response to be dropped")
+	}
+}
+
+func TestScriptHostProcs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.sh")
+	err := os.WriteFile(path, []byte("while read -r line; do sleep 0.3; echo '{}'; done\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := startScript("sh "+path, 4, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !s.requestHook(newJob("http://example.com/", "GET", "", nil)) {
+				t.Error("request was skipped")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("want the questions answered at once, took %s", d)
+	}
+}
+
+func TestScriptHostExited(t *testing.T) {
+	s, err := startScript("true", 1, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	j := newJob("http://example.com/", "GET", "", nil)
+	if s.requestHook(j) || j.err == nil {
+		t.Error("want an error when the script isn't answering")
+	}
+}
@@ -0,0 +1,232 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+)
+
+// testHandler serves a handful of responses that exercise the different
+// parts of the pipeline. It's used by --self-test and by the tests.
+func testHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "ok then\nsecond line")
+	})
+
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><title>A Page</title><body>hello</body></html>")
+	})
+
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+
+	// echoes the request back, so reflection and headers can be checked
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s\n", r.Method, r.URL.RequestURI())
+		for k, vs := range r.Header {
+			for _, v := range vs {
+				fmt.Fprintf(w, "%s: %s\n", k, v)
+			}
+		}
+		w.Write(body)
+	})
+
+	return mux
+}
+
+// collector is a result sink that keeps everything it's sent
+type collector struct {
+	mu      sync.Mutex
+	results []Result
+	errors  []string
+}
+
+func (c *collector) Send(r Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, r)
+}
+
+func (c *collector) errorHook(j *job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, j.url)
+}
+
+// testPipeline returns a pipeline with just the core hooks, which sends
+// its results and errors to the returned collector rather than stdout
+func testPipeline(store Storage) (*pipeline, *collector) {
+	c := &collector{}
+	client := newClient(false, "")
+
+	pipe := &pipeline{}
+	pipe.Use(stagePrepare, validURLHook)
+	pipe.Use(stagePrepare, buildRequestHook)
+	pipe.Use(stageFetch, fetchHook(client, bodyLimits{}))
+	pipe.Use(stageEnrich, resultHook)
+	pipe.Use(stageEnrich, findReflectionsHook)
+	if store != nil {
+		pipe.Use(stageStore, storeHook(store, false, ioutil.Discard))
+	}
+	pipe.Use(stageReport, sinksHook([]resultSink{c}))
+	pipe.OnError(c.errorHook)
+
+	return pipe, c
+}
+
+type selfTest struct {
+	name string
+	fn   func(base string) error
+}
+
+var selfTests = []selfTest{
+	{"status and metrics", func(base string) error {
+		pipe, c := testPipeline(nil)
+		pipe.Run(newJob(base+"/ok", "GET", "", nil))
+
+		if len(c.results) != 1 {
+			return fmt.Errorf("want 1 result, have %d", len(c.results))
+		}
+		r := c.results[0]
+		if r.Status != 200 || r.Size != 19 || r.Lines != 2 {
+			return fmt.Errorf("unexpected result: %+v", r)
+		}
+		return nil
+	}},
+
+	{"filters", func(base string) error {
+		pipe, c := testPipeline(nil)
+		pipe.Use(stageFilter, ignoreHTMLHook)
+		pipe.Use(stageFilter, ignoreEmptyHook)
+		pipe.Use(stageFilter, statusHook(StatusArgs{200}))
+
+		for _, p := range []string{"/ok", "/html", "/empty", "/missing"} {
+			pipe.Run(newJob(base+p, "GET", "", nil))
+		}
+
+		if len(c.results) != 1 || !strings.HasSuffix(c.results[0].URL, "/ok") {
+			return fmt.Errorf("want only /ok, have %+v", c.results)
+		}
+		return nil
+	}},
+
+	{"redirects aren't followed", func(base string) error {
+		pipe, c := testPipeline(nil)
+		pipe.Run(newJob(base+"/redirect", "GET", "", nil))
+
+		if len(c.results) != 1 || c.results[0].Status != 302 || c.results[0].Location != "/ok" {
+			return fmt.Errorf("unexpected results: %+v", c.results)
+		}
+		return nil
+	}},
+
+	{"request headers and body", func(base string) error {
+		pipe, c := testPipeline(nil)
+		var body []byte
+		pipe.Use(stageReport, func(j *job) bool {
+			body = j.respBody
+			return true
+		})
+		pipe.Run(newJob(base+"/echo", "POST", "the-body", []string{"X-Test: yes"}))
+
+		if len(c.results) != 1 {
+			return errors.New("no result")
+		}
+		for _, want := range []string{"POST /echo", "X-Test: yes", "the-body"} {
+			if !strings.Contains(string(body), want) {
+				return fmt.Errorf("%q not sent", want)
+			}
+		}
+		return nil
+	}},
+
+	{"storage", func(base string) error {
+		dir, err := ioutil.TempDir("", "fff-self-test")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		store := newFSStorage(dir)
+		defer store.Close()
+
+		pipe, c := testPipeline(store)
+		pipe.Run(newJob(base+"/ok", "GET", "", nil))
+
+		if len(c.results) != 1 {
+			return errors.New("no result")
+		}
+		p := c.results[0].Path
+
+		body, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if string(body) != "ok then\nsecond line" {
+			return fmt.Errorf("unexpected body %q", body)
+		}
+
+		headers, err := ioutil.ReadFile(strings.TrimSuffix(p, ".body") + ".headers")
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(string(headers), "GET "+base+"/ok\n") {
+			return fmt.Errorf("unexpected headers file %q", headers)
+		}
+
+		if !store.Exists(hashFromPath(p)) {
+			return errors.New("stored response not in index")
+		}
+		return nil
+	}},
+
+	{"request errors", func(base string) error {
+		// nothing should be listening on port 1
+		pipe, c := testPipeline(nil)
+		pipe.Run(newJob("http://127.0.0.1:1/", "GET", "", nil))
+
+		if len(c.errors) != 1 || len(c.results) != 0 {
+			return fmt.Errorf("want 1 error, have %d errors and %d results", len(c.errors), len(c.results))
+		}
+		return nil
+	}},
+}
+
+// SelfTest runs the engine against an embedded test server, writing how
+// each check went to w, and returns the number that failed
+func SelfTest(w io.Writer) int {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	failed := 0
+	for _, t := range selfTests {
+		if err := t.fn(srv.URL); err != nil {
+			fmt.Fprintf(w, "FAIL %s: %s\n", t.name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "ok   %s\n", t.name)
+	}
+	return failed
+}
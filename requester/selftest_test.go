@@ -0,0 +1,167 @@
+package requester
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+// the self-test checks double as integration tests for the pipeline
+func TestSelfTest(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	for _, st := range selfTests {
+		st := st
+		t.Run(st.name, func(t *testing.T) {
+			if err := st.fn(srv.URL); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	cases := []struct {
+		name  string
+		hook  hook
+		paths []string
+		want  []string
+	}{
+		{"ignore html", ignoreHTMLHook, []string{"/ok", "/html"}, []string{"/ok"}},
+		{"ignore empty", ignoreEmptyHook, []string{"/ok", "/empty"}, []string{"/ok"}},
+		{"match string", matchStringHook("hello", 1), []string{"/ok", "/html"}, []string{"/html"}},
+		{"match status", statusHook(StatusArgs{404, 302}), []string{"/ok", "/missing", "/redirect"}, []string{"/missing", "/redirect"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pipe, col := testPipeline(nil)
+			pipe.Use(stageFilter, c.hook)
+
+			for _, p := range c.paths {
+				pipe.Run(newJob(srv.URL+p, "GET", "", nil))
+			}
+
+			if len(col.results) != len(c.want) {
+				t.Fatalf("want %d results, have %d", len(c.want), len(col.results))
+			}
+			for i, w := range c.want {
+				if col.results[i].URL != srv.URL+w {
+					t.Errorf("want %s, have %s", srv.URL+w, col.results[i].URL)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	pipe, col := testPipeline(nil)
+
+	for _, u := range []string{"", "not a url", "example.com/no-scheme"} {
+		pipe.Run(newJob(u, "GET", "", nil))
+	}
+
+	if len(col.results) != 0 || len(col.errors) != 0 {
+		t.Errorf("want invalid input dropped, have %d results and %d errors", len(col.results), len(col.errors))
+	}
+}
+
+func TestBadMethod(t *testing.T) {
+	pipe, col := testPipeline(nil)
+	pipe.Run(newJob("http://example.com/", "BAD METHOD", "", nil))
+
+	if len(col.errors) != 1 {
+		t.Errorf("want 1 error, have %d", len(col.errors))
+	}
+}
+
+func TestTruncateAt(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	j := newJob(srv.URL+"/ok", "GET", "", nil)
+	if !buildRequestHook(j) || !fetchHook(newClient(false, ""), bodyLimits{truncateAt: 5})(j) {
+		t.Fatal(j.err)
+	}
+
+	if string(j.respBody) != "ok th" || j.resp.ContentLength != 19 {
+		t.Errorf("want 5 of 19 bytes kept, have %q of %d", j.respBody, j.resp.ContentLength)
+	}
+	if len(j.res.Tags) != 1 || j.res.Tags[0] != "truncated" {
+		t.Errorf("want truncated tag, have %v", j.res.Tags)
+	}
+}
+
+func TestFetchResponseMaxSize(t *testing.T) {
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	r, body := fetchResponse(context.Background(), newClient(false, ""), bodyLimits{maxSize: 5}, "GET", srv.URL+"/ok", "", nil)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if string(body) != "ok th" || r.size != 5 {
+		t.Errorf("want 5 bytes read, have %q (%d)", body, r.size)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		dupes := duplicateHook(skip, ioutil.Discard)
+
+		var passed, tagged int
+		for _, in := range []string{"http://example.com/a", "HTTP://EXAMPLE.COM/a", "http://example.com/a#b", "http://example.com/b"} {
+			j := newJob(in, "GET", "", nil)
+			if !validURLHook(j) || !buildRequestHook(j) {
+				t.Fatalf("%s isn't valid", in)
+			}
+			if dupes(j) {
+				passed++
+			}
+			if len(j.res.Tags) > 0 {
+				tagged++
+			}
+		}
+
+		if skip && (passed != 2 || tagged != 0) {
+			t.Errorf("want 2 passed when skipping, have %d (%d tagged)", passed, tagged)
+		}
+		if !skip && (passed != 4 || tagged != 2) {
+			t.Errorf("want 4 passed with 2 tagged, have %d passed with %d tagged", passed, tagged)
+		}
+	}
+}
+
+func TestDuplicatesWithMarker(t *testing.T) {
+	var locs ReflectLocations
+	locs.Set("query")
+	dupes, inject := duplicateHook(false, ioutil.Discard), injectMarkerHook(locs)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		j := newJob("http://example.com/a", "GET", "", nil)
+		if !validURLHook(j) || !buildRequestHook(j) || !dupes(j) || !inject(j) {
+			t.Fatalf("request was stopped")
+		}
+		if i == 1 && (len(j.res.Tags) != 1 || j.res.Tags[0] != "duplicate") {
+			t.Errorf("want the second request tagged duplicate, have %v", j.res.Tags)
+		}
+
+		id, err := j.requestID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// the marker's in the query string, so the requests that were sent
+	// are different
+	if ids[0] == ids[1] {
+		t.Errorf("want different IDs for requests with different markers")
+	}
+}
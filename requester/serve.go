@@ -0,0 +1,300 @@
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// storedResponse is a response that was previously saved to the output
+// directory, as read back from its .headers file.
+type storedResponse struct {
+	method   string
+	url      string
+	status   int
+	header   http.Header
+	bodyPath string
+
+	// what's been said about it with fff tag
+	review *review
+}
+
+// hop-by-hop and length headers shouldn't be replayed verbatim; net/http
+// works them out for itself from the body we write
+var replaySkipHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+}
+
+// Replay answers requests with the responses saved in an output
+// directory, for fff serve --replay. It's an http.Handler, and it can
+// serve the gRPC results API too, with the saved responses as the results.
+type Replay struct {
+	dir string
+
+	// where saved responses that can't be read are reported
+	log io.Writer
+
+	mu      sync.RWMutex
+	corpus  map[string]storedResponse
+	handler http.Handler
+
+	grpc *grpcServer
+}
+
+// NewReplay loads the responses saved in dir. Any that can't be read
+// are skipped with a line on log.
+func NewReplay(dir string, log io.Writer) (*Replay, error) {
+	corpus, err := loadCorpus(dir, log)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &Replay{dir: dir, log: log, corpus: corpus, handler: replayHandler(corpus)}
+	rp.grpc = newGRPCServer(nil)
+	rp.grpc.backlog = func() []Result {
+		rp.mu.RLock()
+		defer rp.mu.RUnlock()
+		return corpusResults(rp.corpus, nil)
+	}
+	return rp, nil
+}
+
+// Len returns how many responses there are to replay
+func (rp *Replay) Len() int {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return len(rp.corpus)
+}
+
+// Reload loads the saved responses again, so ones that have been saved
+// since are replayed too. gRPC clients are sent the new ones.
+func (rp *Replay) Reload() error {
+	c, err := loadCorpus(rp.dir, rp.log)
+	if err != nil {
+		return err
+	}
+
+	rp.mu.Lock()
+	old := rp.corpus
+	rp.corpus, rp.handler = c, replayHandler(c)
+	rp.mu.Unlock()
+
+	for _, res := range corpusResults(c, old) {
+		rp.grpc.Send(res)
+	}
+	return nil
+}
+
+func (rp *Replay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rp.mu.RLock()
+	h := rp.handler
+	rp.mu.RUnlock()
+	h.ServeHTTP(w, r)
+}
+
+// GRPC returns the gRPC results API (see ServeGRPC), which streams the
+// saved responses as results. Nothing's being requested, so URLs can't be
+// submitted.
+func (rp *Replay) GRPC() http.Handler {
+	return rp.grpc
+}
+
+// corpusResults returns the saved responses that aren't in an older copy
+// of the corpus (all of them, when there isn't one) as results, in path
+// order
+func corpusResults(corpus, old map[string]storedResponse) []Result {
+	var out []Result
+	for k, sr := range corpus {
+		if _, ok := old[k]; ok {
+			continue
+		}
+		out = append(out, sr.result())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// result is a saved response as a result, as near as the saved files can
+// give it; the tags are the ones it's been given with fff tag
+func (sr storedResponse) result() Result {
+	res := Result{
+		URL:         sr.url,
+		Method:      sr.method,
+		Status:      sr.status,
+		ContentType: sr.header.Get("Content-Type"),
+		Location:    sr.header.Get("Location"),
+		Path:        sr.bodyPath,
+	}
+	if body, err := ioutil.ReadFile(sr.bodyPath); err == nil {
+		res.Size = int64(len(body))
+		res.Words = len(bytes.Fields(body))
+		res.Lines = bytes.Count(body, []byte("\n"))
+	}
+	if sr.review != nil {
+		res.Tags = sr.review.tags
+	}
+	return res
+}
+
+// loadCorpus walks the output directory and reads every .headers file
+// into a map keyed by method and URL, along with any annotations
+func loadCorpus(dir string, log io.Writer) (map[string]storedResponse, error) {
+	corpus := make(map[string]storedResponse)
+	reviews := loadReviews(dir)
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != ".headers" {
+			return nil
+		}
+
+		sr, err := readStoredResponse(p)
+		if err != nil {
+			fmt.Fprintf(log, "skipping %s: %s\n", p, err)
+			return nil
+		}
+		sr.review = reviews[hashFromPath(sr.bodyPath)]
+		corpus[replayKey(sr.method, sr.url)] = sr
+		return nil
+	})
+
+	return corpus, err
+}
+
+// readStoredResponse parses a .headers file. The first line holds the
+// method and URL, and the response status and headers are the trailing
+// block of lines prefixed with '< '. Parsing from the end means a request
+// body that happens to contain '< ' lines can't confuse us.
+func readStoredResponse(p string) (storedResponse, error) {
+	sr := storedResponse{
+		header:   make(http.Header),
+		bodyPath: strings.TrimSuffix(p, ".headers") + ".body",
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return sr, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return sr, err
+	}
+
+	if len(lines) == 0 {
+		return sr, fmt.Errorf("empty headers file")
+	}
+
+	parts := strings.SplitN(lines[0], " ", 2)
+	if len(parts) != 2 {
+		return sr, fmt.Errorf("malformed request line")
+	}
+	sr.method, sr.url = parts[0], parts[1]
+
+	start := len(lines)
+	for start > 1 && strings.HasPrefix(lines[start-1], "< ") {
+		start--
+	}
+	if start == len(lines) {
+		return sr, fmt.Errorf("no response found")
+	}
+
+	// the first response line is the proto and status, e.g. '< HTTP/1.1 200 OK'
+	statusParts := strings.SplitN(strings.TrimPrefix(lines[start], "< "), " ", 3)
+	if len(statusParts) < 2 {
+		return sr, fmt.Errorf("malformed status line")
+	}
+	sr.status, err = strconv.Atoi(statusParts[1])
+	if err != nil {
+		return sr, fmt.Errorf("malformed status line")
+	}
+
+	for _, l := range lines[start+1:] {
+		kv := strings.SplitN(strings.TrimPrefix(l, "< "), ": ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		sr.header.Add(kv[0], kv[1])
+	}
+
+	return sr, nil
+}
+
+func replayKey(method, rawURL string) string {
+	return method + " " + rawURL
+}
+
+// replayHandler answers requests from the corpus. Requests can be sent
+// either directly (in which case the Host header is used to build the URL)
+// or through it as an HTTP proxy (in which case the request URL is absolute).
+func replayHandler(corpus map[string]storedResponse) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var candidates []string
+		if r.URL.IsAbs() {
+			candidates = append(candidates, r.URL.String())
+		} else {
+			candidates = append(candidates,
+				"https://"+r.Host+r.URL.RequestURI(),
+				"http://"+r.Host+r.URL.RequestURI(),
+			)
+		}
+
+		for _, c := range candidates {
+			sr, ok := corpus[replayKey(r.Method, c)]
+			if !ok {
+				continue
+			}
+
+			body, err := ioutil.ReadFile(sr.bodyPath)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to read stored body: %s", err), http.StatusInternalServerError)
+				return
+			}
+
+			for k, vs := range sr.header {
+				if replaySkipHeaders[k] {
+					continue
+				}
+				for _, v := range vs {
+					w.Header().Add(k, v)
+				}
+			}
+			if sr.review != nil {
+				if len(sr.review.tags) > 0 {
+					w.Header().Set("X-Fff-Tags", strings.Join(sr.review.tags, " "))
+				}
+				if sr.review.note != "" {
+					w.Header().Set("X-Fff-Note", sr.review.note)
+				}
+			}
+			w.WriteHeader(sr.status)
+			w.Write(body)
+			return
+		}
+
+		http.Error(w, "no stored response for "+r.Method+" "+candidates[0], http.StatusNotFound)
+	})
+}
@@ -0,0 +1,122 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// saveReplayResponse saves a 200 for a GET of rawURL, like a run with -o
+func saveReplayResponse(t *testing.T, s *fsStorage, hash, rawURL, body string) {
+	_, err := s.Put(artifact{
+		Hash:   hash,
+		Method: "GET",
+		URL:    rawURL,
+		Response: &http.Response{
+			Proto:  "HTTP/1.1",
+			Status: "200 OK",
+			Header: http.Header{"Content-Type": {"text/plain"}, "X-Saved": {"yes"}},
+		},
+		Body: []byte(body),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := newFSStorage(dir)
+	saveReplayResponse(t, s, "a1", "http://example.com/robots.txt", "User-agent: *\n")
+
+	rp, err := NewReplay(dir, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Len() != 1 {
+		t.Fatalf("want 1 response loaded, have %d", rp.Len())
+	}
+	srv := httptest.NewServer(rp)
+	defer srv.Close()
+
+	// sent straight to it, the Host header says which site it's for
+	get := func(method, path string) (*http.Response, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		req.Host = "example.com"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	resp, body := get("GET", "/robots.txt")
+	if resp.StatusCode != 200 || body != "User-agent: *\n" || resp.Header.Get("X-Saved") != "yes" {
+		t.Errorf("want the saved response, have %d %q (%v)", resp.StatusCode, body, resp.Header)
+	}
+	for _, miss := range [][2]string{{"GET", "/nope"}, {"POST", "/robots.txt"}} {
+		if resp, body := get(miss[0], miss[1]); resp.StatusCode != 404 || !strings.Contains(body, "no stored response") {
+			t.Errorf("%s %s: want a 404, have %d %q", miss[0], miss[1], resp.StatusCode, body)
+		}
+	}
+
+	// or it can be used as a proxy
+	proxyURL, _ := url.Parse(srv.URL)
+	proxied := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err = proxied.Get("http://example.com/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("want the saved response through the proxy, have %d", resp.StatusCode)
+	}
+
+	// gRPC clients get what's saved, then what's been saved since on a
+	// reload
+	base, c := grpcTestServer(t, rp.GRPC())
+	req, _ := http.NewRequest("POST", base+"/fff.Results/Stream", bytes.NewReader(grpcFrame(nil)))
+	req.Header.Set("Content-Type", "application/grpc")
+	stream, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+
+	readFrame := func() []byte {
+		head := make([]byte, 5)
+		if _, err := io.ReadFull(stream.Body, head); err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(head[1:]))
+		if _, err := io.ReadFull(stream.Body, msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	if msg := readFrame(); !bytes.Contains(msg, []byte("http://example.com/robots.txt")) {
+		t.Errorf("want the saved response streamed, have %q", msg)
+	}
+
+	saveReplayResponse(t, s, "b2", "http://example.com/new", "new")
+	if resp, _ := get("GET", "/new"); resp.StatusCode != 404 {
+		t.Errorf("want a 404 before reloading, have %d", resp.StatusCode)
+	}
+	if err := rp.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := get("GET", "/new"); resp.StatusCode != 200 || body != "new" || rp.Len() != 2 {
+		t.Errorf("want the new response after reloading, have %d %q (%d loaded)", resp.StatusCode, body, rp.Len())
+	}
+	if msg := readFrame(); !bytes.Contains(msg, []byte("http://example.com/new")) {
+		t.Errorf("want just the new response streamed, have %q", msg)
+	}
+}
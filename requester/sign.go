@@ -0,0 +1,179 @@
+package requester
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os/exec"
+	"strings"
+)
+
+// signer works out the headers to add to a request from its canonical
+// form, which is the same as the one artifact hashes are made from:
+//
+//	METHOD URL\n
+//	Name: value\n       (one line per header, sorted)
+//	\n
+//	body length\n
+//	body
+type signer func(canonical []byte) ([]string, error)
+
+// parseSigner parses a --sign spec, which is the kind of signer followed
+// by its options, all colon separated:
+//
+//	hmac-sha256:key=secret:header=X-Signature:encoding=base64
+//	exec:cmd=./sign.py:header=X-Signature
+//
+// The hmac signers (hmac-sha1, hmac-sha256 and hmac-sha512) need a key;
+// the header defaults to X-Signature and the encoding to hex. The exec
+// signer runs a command with the canonical request on its stdin. If a
+// header's given, the command's output is that header's value, otherwise
+// each line it outputs is a 'Name: value' header to add.
+//
+// A part without an = carries on the value before it, so keys and
+// commands can have colons in them.
+func parseSigner(spec string) (signer, error) {
+	parts := strings.Split(spec, ":")
+	kind := parts[0]
+
+	opts := make(map[string]string)
+	last := ""
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			if last == "" {
+				return nil, fmt.Errorf("invalid option %q", p)
+			}
+			opts[last] += ":" + p
+			continue
+		}
+		opts[k] = v
+		last = k
+	}
+
+	known := map[string]bool{"header": true}
+	header := opts["header"]
+
+	var s signer
+	switch kind {
+	case "hmac-sha1", "hmac-sha256", "hmac-sha512":
+		known["key"], known["encoding"] = true, true
+
+		newHash := map[string]func() hash.Hash{
+			"hmac-sha1":   sha1.New,
+			"hmac-sha256": sha256.New,
+			"hmac-sha512": sha512.New,
+		}[kind]
+
+		key := opts["key"]
+		if key == "" {
+			return nil, fmt.Errorf("%s needs a key", kind)
+		}
+		if header == "" {
+			header = "X-Signature"
+		}
+
+		encode := hex.EncodeToString
+		switch opts["encoding"] {
+		case "", "hex":
+		case "base64":
+			encode = base64.StdEncoding.EncodeToString
+		default:
+			return nil, fmt.Errorf("unknown encoding %q", opts["encoding"])
+		}
+
+		s = func(canonical []byte) ([]string, error) {
+			mac := hmac.New(newHash, []byte(key))
+			mac.Write(canonical)
+			return []string{header + ": " + encode(mac.Sum(nil))}, nil
+		}
+
+	case "exec":
+		known["cmd"] = true
+
+		args := strings.Fields(opts["cmd"])
+		if len(args) == 0 {
+			return nil, fmt.Errorf("exec needs a cmd")
+		}
+
+		s = func(canonical []byte) ([]string, error) {
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdin = bytes.NewReader(canonical)
+
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("signing command failed: %s", err)
+			}
+
+			if header != "" {
+				return []string{header + ": " + strings.TrimSpace(string(out))}, nil
+			}
+
+			var headers []string
+			for _, l := range strings.Split(string(out), "\n") {
+				if l = strings.TrimSpace(l); l != "" {
+					headers = append(headers, l)
+				}
+			}
+			return headers, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown signer %q", kind)
+	}
+
+	for k := range opts {
+		if !known[k] {
+			return nil, fmt.Errorf("unknown option %q for %s", k, kind)
+		}
+	}
+	return s, nil
+}
+
+// signHook adds the signer's headers to the request. It goes at the end
+// of the schedule stage, so the request's final by then and signatures
+// that include a timestamp are made just before it's sent.
+func signHook(s signer) hook {
+	return func(j *job) bool {
+		body, err := requestBody(j.req)
+		if err != nil {
+			j.err = err
+			return false
+		}
+
+		// header values are trimmed the way they are when they're sent,
+		// so that the other end can work out the same signature
+		var headers []string
+		for k, vs := range j.req.Header {
+			for _, v := range vs {
+				headers = append(headers, k+": "+strings.TrimSpace(v))
+			}
+		}
+
+		var canonical bytes.Buffer
+		fmt.Fprintf(&canonical, "%s %s\n", j.req.Method, j.req.URL)
+		writeCanonical(&canonical, headers, []byte(body))
+
+		sigHeaders, err := s(canonical.Bytes())
+		if err != nil {
+			j.err = err
+			return false
+		}
+
+		for _, h := range sigHeaders {
+			name, val, ok := strings.Cut(h, ":")
+			if !ok {
+				j.err = fmt.Errorf("invalid header from signer: %q", h)
+				return false
+			}
+			j.req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(val))
+		}
+		return true
+	}
+}
@@ -0,0 +1,66 @@
+package requester
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestParseSigner(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("s3cr:et"))
+	mac.Write([]byte("request"))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	cases := map[string][]string{
+		"hmac-sha256:key=s3cr:et":                  {"X-Signature: " + sig},
+		"hmac-sha256:header=X-Sig:key=s3cr:et":     {"X-Sig: " + sig},
+		"hmac-sha1:key=k:encoding=base64:header=A": {"A: 7nyx7OB4FFS9VbeuB1Ii+Ir3ygQ="},
+		"exec:cmd=head -c 3:header=X-Sig":          {"X-Sig: req"},
+		"exec:cmd=sed s/^/X-Canonical:/":           {"X-Canonical:request"},
+	}
+
+	for spec, want := range cases {
+		s, err := parseSigner(spec)
+		if err != nil {
+			t.Errorf("%s: %s", spec, err)
+			continue
+		}
+
+		have, err := s([]byte("request"))
+		if err != nil {
+			t.Errorf("%s: %s", spec, err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: want %v, have %v", spec, want, have)
+		}
+	}
+
+	for _, bad := range []string{"rsa:key=x", "hmac-sha256", "hmac-sha256:key=x:colour=blue", "hmac-sha256:key=x:encoding=rot13", "exec", "hmac-sha256:oops"} {
+		if _, err := parseSigner(bad); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
+
+func TestSignHook(t *testing.T) {
+	var canonical string
+	sign := signHook(func(c []byte) ([]string, error) {
+		canonical = string(c)
+		return []string{"X-Signature: sig"}, nil
+	})
+
+	j := newJob("http://example.com/a?b=c", "POST", "body", []string{"X-B: 2", "X-A: 1"})
+	if !buildRequestHook(j) || !sign(j) {
+		t.Fatal(j.err)
+	}
+
+	want := "POST http://example.com/a?b=c\nX-A: 1\nX-B: 2\n\n4\nbody"
+	if canonical != want {
+		t.Errorf("want canonical request %q, have %q", want, canonical)
+	}
+	if j.req.Header.Get("X-Signature") != "sig" {
+		t.Errorf("signature header not set: %v", j.req.Header)
+	}
+}
@@ -0,0 +1,211 @@
+package requester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// InputSource is anything that provides input lines (URLs, hosts, ranges
+// etc) to be requested. Lines from every source are merged together before
+// being expanded and scheduled.
+type InputSource interface {
+	// Lines returns a channel of input lines that's closed when the
+	// source has nothing more to give. Once done is closed, nobody's
+	// reading any more, so the source should stop sending.
+	Lines(done <-chan struct{}) <-chan string
+}
+
+// readerSource reads lines from an io.Reader, like stdin. A read that
+// fails is reported on log.
+type readerSource struct {
+	r   io.Reader
+	log io.Writer
+}
+
+func newReaderSource(r io.Reader, log io.Writer) *readerSource {
+	return &readerSource{r: r, log: log}
+}
+
+func (s *readerSource) Lines(done <-chan struct{}) <-chan string {
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+
+		sc := bufio.NewScanner(s.r)
+		for sc.Scan() {
+			select {
+			case out <- sc.Text():
+			case <-done:
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			fmt.Fprintf(s.log, "failed to read input: %s\n", err)
+		}
+	}()
+
+	return out
+}
+
+// chanSource is lines sent on a channel, for using fff as a library
+type chanSource struct {
+	lines <-chan string
+}
+
+// NewChanSource returns a source for the lines sent on the channel; the
+// input's finished once it's closed
+func NewChanSource(lines <-chan string) InputSource {
+	return &chanSource{lines: lines}
+}
+
+func (s *chanSource) Lines(done <-chan struct{}) <-chan string {
+	return s.lines
+}
+
+// fileSource reads lines from a file
+type fileSource struct {
+	f   *os.File
+	log io.Writer
+}
+
+// newFileSource opens the file straight away, so that one that can't be
+// read is an error before anything's requested
+func newFileSource(path string, log io.Writer) (*fileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSource{f: f, log: log}, nil
+}
+
+// Close closes the file, if it hasn't already been read to the end
+func (s *fileSource) Close() error {
+	return s.f.Close()
+}
+
+func (s *fileSource) Lines(done <-chan struct{}) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer s.f.Close()
+
+		for l := range newReaderSource(s.f, s.log).Lines(done) {
+			select {
+			case out <- l:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// queueSource is fed lines by other parts of fff (like the gRPC server)
+// while it runs. It's never exhausted, so runs using it carry on until
+// they're stopped.
+type queueSource struct {
+	mu    sync.Mutex
+	lines []string
+
+	// ready has a value in it when lines have been added
+	ready chan struct{}
+}
+
+func newQueueSource() *queueSource {
+	return &queueSource{ready: make(chan struct{}, 1)}
+}
+
+// Submit adds a line to the queue. It doesn't wait for the line to be
+// taken, so a client submitting lots of URLs isn't held up by --delay.
+func (s *queueSource) Submit(line string) {
+	s.mu.Lock()
+	s.lines = append(s.lines, line)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the first line off the queue
+func (s *queueSource) next() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.lines) == 0 {
+		return "", false
+	}
+	l := s.lines[0]
+	s.lines = s.lines[1:]
+	return l, true
+}
+
+func (s *queueSource) Lines(done <-chan struct{}) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for {
+			l, ok := s.next()
+			if !ok {
+				select {
+				case <-s.ready:
+					continue
+				case <-done:
+					return
+				}
+			}
+
+			select {
+			case out <- l:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// mergeSources combines the lines from all of the sources. The returned
+// channel is closed once all of them are exhausted, or done is closed.
+func mergeSources(sources []InputSource, done <-chan struct{}) <-chan string {
+	out := make(chan string)
+
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func(lines <-chan string) {
+			defer wg.Done()
+			for l := range lines {
+				select {
+				case out <- l:
+				case <-done:
+					return
+				}
+			}
+		}(src.Lines(done))
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+type InputArgs []string
+
+func (i *InputArgs) Set(val string) error {
+	*i = append(*i, val)
+	return nil
+}
+
+func (i InputArgs) String() string {
+	return strings.Join(i, ",")
+}
@@ -0,0 +1,89 @@
+package requester
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueSource(t *testing.T) {
+	q := newQueueSource()
+
+	// nothing's taking lines yet, and Submit doesn't wait for that
+	done := make(chan bool)
+	go func() {
+		for _, l := range []string{"a", "b", "c"} {
+			q.Submit(l)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Submit waited for the lines to be taken")
+	}
+
+	lines := q.Lines(nil)
+	for _, want := range []string{"a", "b", "c"} {
+		if have := <-lines; have != want {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	}
+
+	// lines submitted later still come through
+	q.Submit("d")
+	select {
+	case l := <-lines:
+		if l != "d" {
+			t.Errorf("want d, have %s", l)
+		}
+	case <-time.After(time.Second):
+		t.Error("a line submitted after the queue emptied never came through")
+	}
+}
+
+func TestSourcesStopWhenDone(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "urls")
+	if err := ioutil.WriteFile(p, []byte(strings.Repeat("http://example.com/\n", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := newFileSource(p, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := newQueueSource()
+	reader := newReaderSource(strings.NewReader(strings.Repeat("http://example.com/\n", 1000)), ioutil.Discard)
+
+	done := make(chan struct{})
+	lines := mergeSources([]InputSource{file, queue, reader}, done)
+	<-lines
+	close(done)
+
+	// everything stops and the merged channel's closed, even though
+	// there's lots more input and the queue's never finished
+	timeout := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-lines:
+		case <-timeout:
+			t.Fatal("the sources carried on after done was closed")
+		}
+	}
+
+	// and the file's been closed
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := file.f.Read(make([]byte, 1))
+		if errors.Is(err, os.ErrClosed) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want the input file closed, have %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,212 @@
+package requester
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Split splits the lines in the files up into shards, writing them to
+// the files named, and returns how many lines went in each. By host, each
+// host's URLs all go in the same shard, so that --host-concurrency,
+// --host-delay and the like still mean what they say; otherwise lines are
+// just dealt out. The lines can be in an --input-format. Files that can't
+// be read to the end are reported on log.
+func Split(files, names []string, byHost bool, format string, log io.Writer) ([]int, error) {
+	if _, ok := inputFormats[format]; format != "" && !ok {
+		return nil, fmt.Errorf("unknown --input-format %q; want one of %s", format, inputFormatNames())
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no shards to split into")
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			return nil, fmt.Errorf("failed to open input file: %s", err)
+		}
+	}
+
+	// by host, the input's read twice: once to count each host's URLs and
+	// once to write them out
+	s := &splitter{shards: len(names), byHost: byHost, format: format}
+	if s.byHost {
+		lines, err := splitLines(files, log)
+		if err != nil {
+			return nil, err
+		}
+		s.plan(lines)
+	}
+
+	lines, err := splitLines(files, log)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.write(lines, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write shards: %s", err)
+	}
+	return counts, nil
+}
+
+// splitter decides which shard each input line goes in. By host, the
+// hosts are planned out up front, biggest first, each going in whichever
+// shard has the fewest lines so far, so one huge host doesn't end up
+// sharing with lots of others. Otherwise lines are just dealt out in turn.
+type splitter struct {
+	shards int
+	byHost bool
+	format string
+
+	hosts map[string]int
+	next  int
+}
+
+// plan counts each host's lines and picks a shard for each host
+func (s *splitter) plan(lines <-chan string) {
+	counts := make(map[string]int)
+	for line := range lines {
+		if key, ok := s.key(line); ok {
+			counts[key]++
+		}
+	}
+
+	hosts := make([]string, 0, len(counts))
+	for h := range counts {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if counts[hosts[i]] != counts[hosts[j]] {
+			return counts[hosts[i]] > counts[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	s.hosts = make(map[string]int, len(hosts))
+	load := make([]int, s.shards)
+	for _, h := range hosts {
+		least := 0
+		for i := range load {
+			if load[i] < load[least] {
+				least = i
+			}
+		}
+		s.hosts[h] = least
+		load[least] += counts[h]
+	}
+}
+
+// shard returns which shard a line goes in, and false for lines that are
+// left out (blank ones)
+func (s *splitter) shard(line string) (int, bool) {
+	key, ok := s.key(line)
+	if !ok {
+		return 0, false
+	}
+	if s.byHost {
+		return s.hosts[key], true
+	}
+	n := s.next
+	s.next = (s.next + 1) % s.shards
+	return n, true
+}
+
+// key is the host a line's for. Lines that aren't URLs are taken to be
+// hosts, the same as when they're requested, and ones in an --input-format
+// that can't be read are kept together under the line itself.
+func (s *splitter) key(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", false
+	}
+
+	target := line
+	if parse, ok := inputFormats[s.format]; ok {
+		t, _, err := parse(line)
+		if err != nil {
+			return line, true
+		}
+		target = t
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	if h := targetHost(target); h != "" {
+		return strings.ToLower(h), true
+	}
+	return line, true
+}
+
+// write writes each line to its shard's file, and returns how many lines
+// went in each
+func (s *splitter) write(lines <-chan string, names []string) ([]int, error) {
+	var files []*os.File
+	var writers []*bufio.Writer
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range names {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		writers = append(writers, bufio.NewWriter(f))
+	}
+
+	counts := make([]int, len(names))
+	for line := range lines {
+		n, ok := s.shard(line)
+		if !ok {
+			continue
+		}
+		fmt.Fprintln(writers[n], strings.TrimSpace(line))
+		counts[n]++
+	}
+
+	for i, w := range writers {
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+		if err := files[i].Close(); err != nil {
+			return nil, err
+		}
+	}
+	files = nil
+	return counts, nil
+}
+
+// splitLines reads the lines from the files, one after the other
+func splitLines(files []string, log io.Writer) (<-chan string, error) {
+	var sources []InputSource
+	for _, f := range files {
+		src, err := newFileSource(f, log)
+		if err != nil {
+			for _, s := range sources {
+				s.(*fileSource).Close()
+			}
+			return nil, fmt.Errorf("failed to open input file: %s", err)
+		}
+		sources = append(sources, src)
+	}
+	return concatSources(sources), nil
+}
+
+// concatSources is like mergeSources, but the lines come in order: all of
+// the first source's, then all of the next one's
+func concatSources(sources []InputSource) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for _, src := range sources {
+			for line := range src.Lines(nil) {
+				out <- line
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,67 @@
+package requester
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplitterKey(t *testing.T) {
+	s := &splitter{}
+	for line, want := range map[string]string{
+		"https://Example.com/a?b=c": "example.com",
+		"example.com:8443":          "example.com",
+		"  10.0.0.1  ":              "10.0.0.1",
+		"http://[::1]:8080/":        "::1",
+	} {
+		if have, _ := s.key(line); have != want {
+			t.Errorf("%q: want %q, have %q", line, want, have)
+		}
+	}
+	if _, ok := s.key("   "); ok {
+		t.Error("want blank lines left out")
+	}
+}
+
+func TestSplitterPlan(t *testing.T) {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		// one big host and lots of small ones
+		for i := 0; i < 30; i++ {
+			lines <- fmt.Sprintf("https://big.example.com/%d", i)
+		}
+		for h := 0; h < 10; h++ {
+			for i := 0; i < 3; i++ {
+				lines <- fmt.Sprintf("https://h%d.example.com/%d", h, i)
+			}
+		}
+	}()
+
+	s := &splitter{shards: 3, byHost: true}
+	s.plan(lines)
+
+	load := make([]int, 3)
+	for h, n := range s.hosts {
+		size := 3
+		if h == "big.example.com" {
+			size = 30
+		}
+		load[n] += size
+	}
+	if load[s.hosts["big.example.com"]] != 30 {
+		t.Errorf("want the big host on its own, have %v", load)
+	}
+	for _, l := range load {
+		if l < 12 || l > 30 {
+			t.Errorf("want the shards balanced, have %v", load)
+			break
+		}
+	}
+
+	// every line for a host goes in its shard
+	for i := 0; i < 3; i++ {
+		if n, _ := s.shard(fmt.Sprintf("https://h4.example.com/%d", i)); n != s.hosts["h4.example.com"] {
+			t.Errorf("want h4's lines together, have shard %d", n)
+		}
+	}
+}
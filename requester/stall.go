@@ -0,0 +1,126 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// detectStalls makes every connection the client opens give up if nothing
+// is received for the timeout, or if less than minSpeed bytes a second are
+// received over a window. That's separate from the overall timeout: a
+// tarpit that sends a byte every few seconds never trips a stall, but it
+// can hold a request open for the whole of the overall timeout, every time.
+// It doesn't get past the minimum speed, though. Either check is off when
+// it's 0.
+func detectStalls(client *http.Client, timeout time.Duration, minSpeed int64, window time.Duration) {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &stallConn{Conn: conn, timeout: timeout, minSpeed: minSpeed, window: window}, nil
+	}
+}
+
+// stallConn pushes the read deadline back every time it's read from, and
+// counts what's been read in each window for the minimum speed. The first
+// window starts with the first read, so time spent sending the request
+// doesn't count.
+type stallConn struct {
+	net.Conn
+	timeout time.Duration
+
+	minSpeed    int64
+	window      time.Duration
+	windowStart time.Time
+	windowBytes int64
+}
+
+func (c *stallConn) Read(p []byte) (int, error) {
+	for {
+		now := time.Now()
+		if c.minSpeed > 0 && c.windowStart.IsZero() {
+			c.windowStart = now
+		}
+
+		var deadline, windowEnd time.Time
+		if c.timeout > 0 {
+			deadline = now.Add(c.timeout)
+		}
+		if c.minSpeed > 0 {
+			windowEnd = c.windowStart.Add(c.window)
+			if deadline.IsZero() || windowEnd.Before(deadline) {
+				deadline = windowEnd
+			}
+		}
+		c.Conn.SetReadDeadline(deadline)
+
+		n, err := c.Conn.Read(p)
+		c.windowBytes += int64(n)
+
+		if c.minSpeed > 0 && !time.Now().Before(windowEnd) {
+			if c.windowBytes < int64(float64(c.minSpeed)*c.window.Seconds()) {
+				return n, slowError{c.windowBytes, c.window}
+			}
+			c.windowStart = time.Time{}
+			c.windowBytes = 0
+
+			// the deadline was the end of the window, not a stall, so
+			// there's nothing wrong with the connection yet
+			if n == 0 && isTimeout(err) {
+				continue
+			}
+		}
+
+		if isTimeout(err) {
+			err = stallError{c.timeout}
+		}
+		return n, err
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// stallError is returned for connections that have stalled. It's a
+// timeout as far as anything checking net.Error is concerned.
+type stallError struct {
+	timeout time.Duration
+}
+
+func (e stallError) Error() string {
+	return fmt.Sprintf("stalled: nothing received for %s", e.timeout)
+}
+
+func (e stallError) Timeout() bool   { return true }
+func (e stallError) Temporary() bool { return true }
+
+// slowError is returned for connections that received less than the
+// minimum speed over a window. It's a timeout too.
+type slowError struct {
+	received int64
+	window   time.Duration
+}
+
+func (e slowError) Error() string {
+	return fmt.Sprintf("too slow: %d bytes received in %s", e.received, e.window)
+}
+
+func (e slowError) Timeout() bool   { return true }
+func (e slowError) Temporary() bool { return true }
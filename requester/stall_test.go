@@ -0,0 +1,81 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStallTimeout(t *testing.T) {
+	// a tarpit that sends a byte every interval
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		interval, _ := time.ParseDuration(r.URL.Query().Get("interval"))
+		for i := 0; i < 4; i++ {
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(interval)
+		}
+	}))
+	defer srv.Close()
+
+	client := newClient(false, "")
+	detectStalls(client, 100*time.Millisecond, 0, 0)
+
+	resp, err := client.Get(srv.URL + "?interval=20ms")
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err != nil {
+		t.Fatalf("slow but steady server tripped the stall timeout: %s", err)
+	}
+
+	resp, err = client.Get(srv.URL + "?interval=300ms")
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "stalled") {
+		t.Errorf("want stalled error, have %v", err)
+	}
+}
+
+func TestMinSpeed(t *testing.T) {
+	// a tarpit that drips a byte out every 20ms, so it never stalls
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 50; i++ {
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	client := newClient(false, "")
+	detectStalls(client, 100*time.Millisecond, 1000, 200*time.Millisecond)
+
+	resp, err := client.Get(srv.URL)
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "too slow") {
+		t.Errorf("want too slow error, have %v", err)
+	}
+
+	// fast enough
+	client = newClient(false, "")
+	detectStalls(client, 0, 10, 200*time.Millisecond)
+
+	resp, err = client.Get(srv.URL)
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err != nil {
+		t.Errorf("server over the minimum speed tripped it: %s", err)
+	}
+}
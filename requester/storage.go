@@ -0,0 +1,526 @@
+package requester
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is where responses end up when they're saved. The filesystem is
+// the default (see fsStorage), but anything that can store artifacts and
+// an index of them will do.
+type Storage interface {
+	// Put stores an artifact and returns where it was put
+	Put(a artifact) (string, error)
+
+	// Index records a result for an artifact that has been Put
+	Index(r Result) error
+
+	// Exists reports whether an artifact with the hash has been stored,
+	// either during this run or a previous one
+	Exists(hash string) bool
+}
+
+// artifact is everything that gets stored for a single response
+type artifact struct {
+	Hash string
+
+	Method         string
+	URL            string
+	RequestHeaders []string
+	RequestBody    string
+
+	// Meta lines ('key: value') hold anything else we know about the response
+	Meta []string
+
+	Response *http.Response
+	Body     []byte
+
+	// Extras are any other files to store alongside the body, keyed by
+	// their extension (e.g. 'dom' or 'png')
+	Extras map[string][]byte
+}
+
+// hashScheme names the way artifact hashes are worked out (see
+// artifactHash), and is recorded with each artifact so that other tools
+// know how to compute the same IDs
+const hashScheme = "fff-sha1-v2"
+
+// artifactHash identifies an artifact. It's the SHA-1 of the request as
+// it was sent, in this form:
+//
+//	METHOD URL\n
+//	Name: value\n       (one line per header, sorted)
+//	\n
+//	body length\n
+//	body
+//
+// With a response, its status code and body are added to the end in the
+// same way (a line with the status code, a blank line for the headers,
+// which are left out because things like Date change every time, then the
+// body length and body) so that identical requests with different
+// responses get different IDs.
+func artifactHash(method, rawURL string, headers []string, body string, resp *http.Response, respBody []byte) string {
+	sum := artifactSum(method, rawURL, headers, body, resp, respBody)
+	return fmt.Sprintf("%x", sum[:])
+}
+
+// artifactSum is artifactHash before it's turned into hex, for when lots
+// of them are kept in memory
+func artifactSum(method, rawURL string, headers []string, body string, resp *http.Response, respBody []byte) (sum [sha1.Size]byte) {
+	h := sha1.New()
+
+	fmt.Fprintf(h, "%s %s\n", method, rawURL)
+	writeCanonical(h, headers, []byte(body))
+
+	if resp != nil {
+		fmt.Fprintf(h, "%d\n", resp.StatusCode)
+		writeCanonical(h, nil, respBody)
+	}
+
+	h.Sum(sum[:0])
+	return sum
+}
+
+func writeCanonical(w io.Writer, headers []string, body []byte) {
+	sorted := append([]string(nil), headers...)
+	sort.Strings(sorted)
+
+	for _, h := range sorted {
+		fmt.Fprintf(w, "%s\n", h)
+	}
+	fmt.Fprintf(w, "\n%d\n", len(body))
+	w.Write(body)
+}
+
+// fsStorage stores artifacts in a directory. Output files are stored in
+// prefix/domain/normalisedpath/hash.(body|headers), and there's a line
+// for each of them in prefix/index. When there was a request body it's
+// saved byte for byte in hash.request too.
+type fsStorage struct {
+	prefix string
+
+	// writes to the index are buffered, and flushed to disk every
+	// flushEvery lines and every flushInterval (give or take a bit of
+	// jitter, so that lots of fffs sharing a disk don't all sync at once)
+	flushEvery    int
+	flushInterval time.Duration
+
+	// index lines are only ever written out whole, and all of the
+	// pending ones in a single write, so that with --shared the lines
+	// from different runs can't get mixed up
+	mu       sync.Mutex
+	index    *os.File
+	indexBuf bytes.Buffer
+	pending  int
+	done     chan struct{}
+
+	// where index writes that fail in the background are reported
+	log io.Writer
+
+	// the lock on the directory, and whether it's shared with other runs
+	lock   *dirLock
+	shared bool
+
+	// the hashes in the index; nil until it's first needed
+	known map[string]bool
+
+	// when each URL's being requested with several methods, the method's
+	// in the name of the files (hash.POST.body) and in front of the URL
+	// in the index, so they can be told apart at a glance
+	withMethods bool
+
+	// the layout from --output-template, if there is one
+	template *outputTemplate
+
+	// with --format meg, files and index lines are the way meg has them
+	// (see putMeg)
+	meg bool
+}
+
+func newFSStorage(prefix string) *fsStorage {
+	return &fsStorage{
+		prefix:        prefix,
+		flushEvery:    100,
+		flushInterval: time.Second,
+		log:           ioutil.Discard,
+	}
+}
+
+// Lock takes a lock on the output directory for the run (see lockDir),
+// creating the directory if it needs to
+func (s *fsStorage) Lock(shared bool) error {
+	err := os.MkdirAll(s.prefix, 0750)
+	if err != nil {
+		return fmt.Errorf("failed to create dir: %s", err)
+	}
+
+	s.lock, err = lockDir(s.prefix, shared)
+	if err != nil {
+		return err
+	}
+	s.shared = shared
+	return nil
+}
+
+// Put writes the response body and a headers file describing the request
+// and response, plus any extras, returning the path to the body. Any meta
+// lines are written to the headers file between the request and the
+// response.
+func (s *fsStorage) Put(a artifact) (string, error) {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL: %s", err)
+	}
+
+	host, err := hostToASCII(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("failed to convert host: %s", err)
+	}
+	if s.meg {
+		return s.putMeg(a, u, host)
+	}
+
+	base := path.Join(s.prefix, host, normalisePath(u), a.Hash)
+	if s.template != nil {
+		base = path.Join(s.prefix, s.template.render(a, u, host, time.Now()))
+	}
+	if s.withMethods && s.template == nil {
+		base += "." + a.Method
+	}
+	p := base + ".body"
+	err = os.MkdirAll(path.Dir(p), 0750)
+	if err != nil {
+		return "", fmt.Errorf("failed to create dir: %s", err)
+	}
+
+	// write the response body to a file
+	err = writeFileAtomic(p, a.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to write file contents: %s", err)
+	}
+
+	// create the headers file
+	err = writeFileAtomic(base+".headers", []byte(headersFileContents(a)))
+	if err != nil {
+		return "", fmt.Errorf("failed to write file contents: %s", err)
+	}
+
+	if a.RequestBody != "" {
+		err = writeFileAtomic(base+".request", []byte(a.RequestBody))
+		if err != nil {
+			return "", fmt.Errorf("failed to write file contents: %s", err)
+		}
+	}
+
+	for ext, data := range a.Extras {
+		err = writeFileAtomic(base+"."+ext, data)
+		if err != nil {
+			return "", fmt.Errorf("failed to write file contents: %s", err)
+		}
+	}
+
+	return p, nil
+}
+
+// extraPath returns where an extra (like a screenshot, with the extension
+// png) was put, given the body's path that Put returned
+func extraPath(p, ext string) string {
+	if strings.HasSuffix(p, ".body") {
+		return strings.TrimSuffix(p, ".body") + "." + ext
+	}
+	// meg's files don't have an extension
+	return p + "." + ext
+}
+
+// writeFileAtomic writes a file under another name and then renames it,
+// so that nothing ever sees half of it, even when another run is writing
+// the same file at the same time
+func writeFileAtomic(p string, data []byte) error {
+	f, err := ioutil.TempFile(path.Dir(p), "."+path.Base(p)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func headersFileContents(a artifact) string {
+	var buf strings.Builder
+
+	// put the request URL and method at the top
+	buf.WriteString(fmt.Sprintf("%s %s\n\n", a.Method, a.URL))
+
+	// add the request headers
+	for _, h := range a.RequestHeaders {
+		buf.WriteString(fmt.Sprintf("> %s\n", h))
+	}
+	buf.WriteRune('\n')
+
+	// add the request body
+	if a.RequestBody != "" {
+		buf.WriteString(a.RequestBody)
+		buf.WriteString("\n\n")
+	}
+
+	// add anything else we know about the response
+	if len(a.Meta) > 0 {
+		for _, m := range a.Meta {
+			buf.WriteString(fmt.Sprintf("* %s\n", m))
+		}
+		buf.WriteRune('\n')
+	}
+
+	// add the proto and status
+	buf.WriteString(fmt.Sprintf("< %s %s\n", a.Response.Proto, a.Response.Status))
+
+	// add the response headers
+	for k, vs := range a.Response.Header {
+		for _, v := range vs {
+			buf.WriteString(fmt.Sprintf("< %s: %s\n", k, v))
+		}
+	}
+
+	return buf.String()
+}
+
+// Index appends a line for the result to the index file, e.g.
+//
+//	out/example.com/a1b2c3.body https://example.com/ (200) 1256 2026-01-02T15:04:05Z text/html; charset=UTF-8
+//
+// That's the path, URL, status, size, when it was saved and the content
+// type, which comes last because it can have spaces in it (it's - when
+// there isn't one). With withMethods, the URL has the method in front of
+// it (POST https://example.com/). Writes are done under a lock so lines
+// from concurrent requests don't get interleaved.
+func (s *fsStorage) Index(r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.index == nil {
+		f, err := os.OpenFile(path.Join(s.prefix, "index"), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open index: %s", err)
+		}
+		s.index = f
+
+		// a run that has the directory to itself can tidy up after one
+		// that died halfway through writing a line; with others writing
+		// to it too, the line could be theirs
+		if !s.shared {
+			err = trimPartialLine(f)
+			if err != nil {
+				return fmt.Errorf("failed to repair index: %s", err)
+			}
+		}
+
+		if s.flushInterval > 0 {
+			s.done = make(chan struct{})
+			go s.flushLoop()
+		}
+	}
+
+	var line string
+	if s.meg {
+		line = megIndexLine(r)
+	} else {
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "-"
+		}
+		target := r.URL
+		if s.withMethods {
+			target = r.Method + " " + r.URL
+		}
+		line = fmt.Sprintf("%s %s (%d) %d %s %s\n", r.Path, target, r.Status, r.Size, time.Now().UTC().Format(time.RFC3339), contentType)
+	}
+
+	_, err := s.indexBuf.WriteString(line)
+	if err != nil {
+		return fmt.Errorf("failed to write to index: %s", err)
+	}
+	s.pending++
+
+	if s.known != nil {
+		s.known[hashFromPath(r.Path)] = true
+	}
+
+	if s.pending >= s.flushEvery {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes any buffered index lines out to disk; s.mu must be held
+func (s *fsStorage) flush() error {
+	if s.pending == 0 {
+		return nil
+	}
+
+	_, err := s.index.Write(s.indexBuf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write to index: %s", err)
+	}
+	s.indexBuf.Reset()
+	s.pending = 0
+
+	err = s.index.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync index: %s", err)
+	}
+	return nil
+}
+
+// flushLoop flushes the index every flushInterval, plus or minus up to
+// a tenth of it, until the storage is closed
+func (s *fsStorage) flushLoop() {
+	for {
+		d := s.flushInterval
+		if jitter := int64(d / 10); jitter > 0 {
+			d += time.Duration(rand.Int63n(2*jitter) - jitter)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(d):
+		}
+
+		s.mu.Lock()
+		if err := s.flush(); err != nil {
+			fmt.Fprintf(s.log, "%s\n", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Exists checks the index for the hash, reading it in on first use
+func (s *fsStorage) Exists(hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.known == nil {
+		s.known = make(map[string]bool)
+
+		// make sure the file has everything that's been indexed so far
+		if s.index != nil {
+			s.flush()
+		}
+
+		f, err := os.Open(path.Join(s.prefix, "index"))
+		if err == nil {
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				fields := strings.SplitN(sc.Text(), " ", 2)
+				s.known[hashFromPath(fields[0])] = true
+			}
+			f.Close()
+		}
+	}
+
+	return s.known[hash]
+}
+
+func (s *fsStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock != nil {
+		defer s.lock.Unlock()
+		s.lock = nil
+	}
+
+	if s.index == nil {
+		return nil
+	}
+
+	if s.done != nil {
+		close(s.done)
+	}
+
+	err := s.flush()
+	if cerr := s.index.Close(); err == nil {
+		err = cerr
+	}
+	s.index = nil
+	return err
+}
+
+// trimPartialLine cuts off the end of a file after its last newline
+func trimPartialLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for end := info.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i != -1 {
+			if start+int64(i)+1 == info.Size() {
+				return nil
+			}
+			return f.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	return f.Truncate(0)
+}
+
+var hashInPathRe = regexp.MustCompile(`[0-9a-f]{40}`)
+
+// hashFromPath gets the hash back out of a body path, which might have a
+// method in it as well as the extension, or be laid out by
+// --output-template with anything else around the hash
+func hashFromPath(p string) string {
+	base := path.Base(p)
+	if h := hashInPathRe.FindString(base); h != "" {
+		return h
+	}
+	hash, _, _ := strings.Cut(base, ".")
+	return hash
+}
+
+func normalisePath(u *url.URL) string {
+	re := regexp.MustCompile(`[^a-zA-Z0-9/._-]+`)
+	p := re.ReplaceAllString(u.Path, "-")
+
+	// cleaning the path as if it were absolute gets rid of any ../ that
+	// would otherwise take us outside of the output directory
+	return path.Clean("/" + p)
+}
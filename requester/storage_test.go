@@ -0,0 +1,291 @@
+package requester
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func testArtifact() artifact {
+	return artifact{
+		Hash:           "0123456789abcdef",
+		Method:         "POST",
+		URL:            "http://example.com/some path/x.php?a=b",
+		RequestHeaders: []string{"X-One: 1", "X-Two: 2"},
+		RequestBody:    "a=b&c=d",
+		Meta:           []string{"tags: reflected", "reflect-marker: fff123"},
+		Response: &http.Response{
+			Proto:  "HTTP/1.1",
+			Status: "200 OK",
+			Header: http.Header{"Content-Type": {"text/plain"}},
+		},
+		Body: []byte("the body"),
+	}
+}
+
+func TestHeadersFileGolden(t *testing.T) {
+	have := headersFileContents(testArtifact())
+
+	golden := filepath.Join("testdata", "headers.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(have), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have != string(want) {
+		t.Errorf("headers file doesn't match golden file\nhave:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+// other tools compute IDs in the way artifactHash documents, so these
+// must never change without changing hashScheme
+func TestArtifactHash(t *testing.T) {
+	headers := []string{"B: 2", "A: 1"}
+
+	have := artifactHash("POST", "http://example.com/x", headers, "abc", nil, nil)
+	if want := "2a1b8459217d9ac7c10180c1b854ea752c031ea1"; have != want {
+		t.Errorf("want request hash %s, have %s", want, have)
+	}
+
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Date": {"now"}}}
+	have = artifactHash("POST", "http://example.com/x", headers, "abc", resp, []byte("ok"))
+	if want := "39807fff68a8239597cda69111bee02dae8639be"; have != want {
+		t.Errorf("want request and response hash %s, have %s", want, have)
+	}
+
+	// the body length stops it from running into what follows it
+	if artifactHash("GET", "http://example.com/", nil, "a\n", nil, nil) == artifactHash("GET", "http://example.com/", []string{"a"}, "", nil, nil) {
+		t.Error("body and headers collide")
+	}
+}
+
+func TestFSStorageLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fff-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newFSStorage(dir)
+	defer s.Close()
+
+	a := testArtifact()
+	a.Extras = map[string][]byte{"dom": []byte("<html>")}
+
+	p, err := s.Put(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := filepath.Join(dir, "example.com", "some-path", "x.php", a.Hash)
+	if p != base+".body" {
+		t.Errorf("want body at %s, have %s", base+".body", p)
+	}
+
+	for ext, want := range map[string]string{
+		"body":    "the body",
+		"headers": headersFileContents(a),
+		"dom":     "<html>",
+		"request": "a=b&c=d",
+	} {
+		have, err := ioutil.ReadFile(base + "." + ext)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(have) != want {
+			t.Errorf("unexpected %s file contents: %q", ext, have)
+		}
+	}
+
+	if s.Exists(a.Hash) {
+		t.Error("hash exists before being indexed")
+	}
+	if err := s.Index(Result{URL: a.URL, Status: 200, Size: 8, ContentType: "text/plain; charset=utf-8", Path: p}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Exists(a.Hash) {
+		t.Error("hash doesn't exist after being indexed")
+	}
+
+	// the index is buffered until it's flushed
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSuffix(string(index), "\n")
+	prefix, suffix := p+" "+a.URL+" (200) 8 ", " text/plain; charset=utf-8"
+	if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, suffix) {
+		t.Errorf("unexpected index %q", index)
+	} else if _, err := time.Parse(time.RFC3339, line[len(prefix):len(line)-len(suffix)]); err != nil {
+		t.Errorf("unexpected time in index: %s", err)
+	}
+
+	// a fresh store should read the existing index back in
+	if !newFSStorage(dir).Exists(a.Hash) {
+		t.Error("hash not found in existing index")
+	}
+}
+
+func TestFSStorageMethod(t *testing.T) {
+	dir := t.TempDir()
+	s := newFSStorage(dir)
+	s.withMethods = true
+
+	p, err := s.Put(artifact{Hash: "a1b2c3", Method: "POST", URL: "https://example.com/", Response: &http.Response{Header: http.Header{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "example.com", "a1b2c3.POST.body"); p != want {
+		t.Errorf("want %s, have %s", want, p)
+	}
+	if err := s.Index(Result{URL: "https://example.com/", Method: "POST", Status: 200, Path: p}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, _ := ioutil.ReadFile(filepath.Join(dir, "index"))
+	if !strings.HasPrefix(string(index), p+" POST https://example.com/ (200) ") {
+		t.Errorf("unexpected index %q", index)
+	}
+	if !newFSStorage(dir).Exists("a1b2c3") {
+		t.Error("hash not found in existing index")
+	}
+}
+
+func TestExtraPath(t *testing.T) {
+	for _, meg := range []bool{false, true} {
+		dir := t.TempDir()
+		s := newFSStorage(dir)
+		s.meg = meg
+
+		a := testArtifact()
+		a.Extras = map[string][]byte{"png": []byte("a picture")}
+		p, err := s.Put(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shot := extraPath(p, "png")
+		if data, err := ioutil.ReadFile(shot); err != nil || string(data) != "a picture" {
+			t.Errorf("meg %v: want the screenshot at %s, have %q (%v)", meg, shot, data, err)
+		}
+	}
+}
+
+func TestFSStorageFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fff-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newFSStorage(dir)
+	s.flushEvery = 2
+	s.flushInterval = 0
+	defer s.Close()
+
+	lines := func() int {
+		index, _ := ioutil.ReadFile(filepath.Join(dir, "index"))
+		return strings.Count(string(index), "\n")
+	}
+
+	for i, want := range []int{0, 2, 2, 4} {
+		s.Index(Result{URL: "http://example.com/", Status: 200, Path: "x.body"})
+		if have := lines(); have != want {
+			t.Errorf("after %d results want %d lines in index, have %d", i+1, want, have)
+		}
+	}
+
+	// and with an interval it shouldn't need any more results
+	s.flushEvery = 100
+	s.flushInterval = 10 * time.Millisecond
+	s.Close()
+	s.Index(Result{URL: "http://example.com/", Status: 200, Path: "x.body"})
+	time.Sleep(50 * time.Millisecond)
+	if have := lines(); have != 5 {
+		t.Errorf("want 5 lines after flush interval, have %d", have)
+	}
+}
+
+func TestFSStorageBadDir(t *testing.T) {
+	f, err := ioutil.TempFile("", "fff-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// the prefix is a file, so nothing can be created under it
+	_, err = newFSStorage(f.Name()).Put(testArtifact())
+	if err == nil || !strings.Contains(err.Error(), "failed to create dir") {
+		t.Errorf("want dir creation error, have %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	s := newFSStorage(dir)
+	defer s.Close()
+
+	done := newJob("http://example.com/done", "GET", "", nil)
+	buildRequestHook(done)
+	h, err := done.requestID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Index(Result{URL: done.url, Status: 200, Path: filepath.Join(dir, "example.com", "done", h+".body")}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := newRunStats()
+	resume := stats.resumeHook(s)
+	if resume(done) {
+		t.Error("want a saved request to be skipped")
+	}
+
+	todo := newJob("http://example.com/todo", "GET", "", nil)
+	buildRequestHook(todo)
+	if !resume(todo) {
+		t.Error("want an unsaved request to be made")
+	}
+	if stats.skipped != 1 {
+		t.Errorf("want one request skipped, have %d", stats.skipped)
+	}
+}
+
+func TestIndexRepair(t *testing.T) {
+	dir := t.TempDir()
+
+	// a run that died halfway through a line
+	whole := "out/a.body http://example.com/ (200) 1 2026-01-02T15:04:05Z -\n"
+	ioutil.WriteFile(filepath.Join(dir, "index"), []byte(whole+"out/b.body http://exa"), 0644)
+
+	s := newFSStorage(dir)
+	s.Index(Result{URL: "http://example.com/c", Status: 200, Path: "out/c.body"})
+	s.Close()
+
+	index, _ := ioutil.ReadFile(filepath.Join(dir, "index"))
+	lines := strings.Split(strings.TrimSuffix(string(index), "\n"), "\n")
+	if len(lines) != 2 || lines[0]+"\n" != whole || !strings.HasPrefix(lines[1], "out/c.body ") {
+		t.Errorf("want the partial line gone, have %q", index)
+	}
+}
@@ -0,0 +1,283 @@
+package requester
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runStats are counted over the whole run and printed at the end (or when
+// the run's interrupted)
+type runStats struct {
+	mu    sync.Mutex
+	start time.Time
+
+	responses int
+	reused    int
+
+	// response statuses, how much was downloaded, and failed requests by
+	// what went wrong (see errorCategory)
+	statuses map[int]int
+	bytes    int64
+	errors   map[string]int
+
+	// TLS handshakes, and how long they took altogether, split by
+	// whether they resumed an earlier session
+	fullHandshakes    int
+	fullHandshakeTime time.Duration
+	resumed           int
+	resumedTime       time.Duration
+
+	// requests that weren't made because of --resume
+	skipped int
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		start:    time.Now(),
+		statuses: make(map[int]int),
+		errors:   make(map[string]int),
+	}
+}
+
+// fetchedHook counts each response as it's fetched; it goes at the end
+// of the fetch stage so filtered responses count too
+func (s *runStats) fetchedHook(j *job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses++
+	s.statuses[j.resp.StatusCode]++
+	if j.resp.ContentLength > 0 {
+		s.bytes += j.resp.ContentLength
+	} else {
+		s.bytes += int64(len(j.respBody))
+	}
+
+	if j.conn == nil {
+		return true
+	}
+	if j.conn.Reused() {
+		s.reused++
+	}
+	if took, resumed, ok := j.conn.Handshake(); ok && resumed {
+		s.resumed++
+		s.resumedTime += took
+	} else if ok {
+		s.fullHandshakes++
+		s.fullHandshakeTime += took
+	}
+	return true
+}
+
+// errorHook counts a failed request
+func (s *runStats) errorHook(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[errorCategory(j.err)]++
+}
+
+// errorCategory sorts errors into the broad kinds that are worth counting
+// separately: a lot of timeouts says something different to a lot of DNS
+// failures
+func errorCategory(err error) string {
+	var opErr *net.OpError
+	if errors.Is(err, errNoProxies) || (errors.As(err, &opErr) && opErr.Op == "proxyconnect") {
+		return "proxy"
+	}
+
+	if isTimeout(err) {
+		return "timeout"
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "refused"
+	}
+	for _, e := range []error{syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, e) {
+			return "reset"
+		}
+	}
+
+	var (
+		recordErr *tls.RecordHeaderError
+		alertErr  tls.AlertError
+		certErr   *tls.CertificateVerificationError
+		unknownCA x509.UnknownAuthorityError
+		hostErr   x509.HostnameError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &certErr) || errors.As(err, &unknownCA) || errors.As(err, &hostErr) {
+		return "tls"
+	}
+
+	if strings.Contains(err.Error(), "refusing to connect to private address") {
+		return "denied"
+	}
+	return "other"
+}
+
+// resumeHook handles --resume, stopping jobs for requests that have
+// already been saved, in this run or an earlier one. It needs the
+// request, so it goes at the end of the prepare stage. Requests that
+// change every time (like signed ones) are never skipped.
+func (s *runStats) resumeHook(store Storage) hook {
+	return func(j *job) bool {
+		h, err := j.requestID()
+		if err != nil {
+			j.err = err
+			return false
+		}
+		if !store.Exists(h) {
+			return true
+		}
+
+		s.mu.Lock()
+		s.skipped++
+		s.mu.Unlock()
+		return false
+	}
+}
+
+// statsSummary is what's in the summary, and the --stats-json file
+type statsSummary struct {
+	Started     time.Time      `json:"started"`
+	Elapsed     float64        `json:"elapsed_seconds"`
+	Interrupted bool           `json:"interrupted"`
+	Requests    int            `json:"requests"`
+	PerSecond   float64        `json:"requests_per_second"`
+	Responses   int            `json:"responses"`
+	Statuses    map[string]int `json:"statuses"`
+	Bytes       int64          `json:"bytes"`
+	Errors      map[string]int `json:"errors"`
+	Skipped     int            `json:"skipped"`
+	Reused      int            `json:"reused_connections"`
+
+	TLSHandshakes        int `json:"tls_handshakes"`
+	ResumedTLSHandshakes int `json:"resumed_tls_handshakes"`
+}
+
+func (s *runStats) summary(interrupted bool) statsSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.start)
+	sum := statsSummary{
+		Started:     s.start.UTC(),
+		Elapsed:     elapsed.Seconds(),
+		Interrupted: interrupted,
+		Responses:   s.responses,
+		Statuses:    make(map[string]int),
+		Bytes:       s.bytes,
+		Errors:      make(map[string]int),
+		Skipped:     s.skipped,
+		Reused:      s.reused,
+
+		TLSHandshakes:        s.fullHandshakes + s.resumed,
+		ResumedTLSHandshakes: s.resumed,
+	}
+
+	sum.Requests = s.responses
+	for category, n := range s.errors {
+		sum.Errors[category] = n
+		sum.Requests += n
+	}
+	for status, n := range s.statuses {
+		sum.Statuses[fmt.Sprint(status)] = n
+	}
+	if elapsed > 0 {
+		sum.PerSecond = float64(sum.Requests) / elapsed.Seconds()
+	}
+	return sum
+}
+
+// writeJSON writes the summary to a file, for --stats-json
+func (s *runStats) writeJSON(path string, interrupted bool) error {
+	out, err := json.MarshalIndent(s.summary(interrupted), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
+
+// print writes the summary. Connection reuse is there so that it's easy
+// to tell whether things like -k are actually paying off.
+func (s *runStats) print(w io.Writer) {
+	sum := s.summary(false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.skipped > 0 {
+		fmt.Fprintf(w, "%d requests skipped, already saved\n", s.skipped)
+	}
+	if sum.Requests == 0 {
+		return
+	}
+
+	elapsed := time.Duration(sum.Elapsed * float64(time.Second))
+	fmt.Fprintf(w, "%d requests in %s (%.1f/s), %d failed%s\n", sum.Requests, elapsed.Round(time.Millisecond), sum.PerSecond, sum.Requests-sum.Responses, countList(s.errors))
+	if s.responses == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d responses, %d on reused connections (%.0f%%)\n", s.responses, s.reused, 100*float64(s.reused)/float64(s.responses))
+	fmt.Fprintf(w, "%s downloaded, statuses%s\n", formatBytes(s.bytes), countList(sum.Statuses))
+
+	// resuming a session saves a round trip and the certificate checks,
+	// which is worth knowing about for hosts that won't keep connections
+	// alive
+	if s.resumed > 0 && s.fullHandshakes > 0 {
+		full := s.fullHandshakeTime / time.Duration(s.fullHandshakes)
+		resumed := s.resumedTime / time.Duration(s.resumed)
+		fmt.Fprintf(w, "%d TLS handshakes, %d resumed (%s each, against %s for full ones; about %s saved)\n",
+			s.fullHandshakes+s.resumed, s.resumed, resumed.Round(time.Millisecond), full.Round(time.Millisecond),
+			(time.Duration(s.resumed) * (full - resumed)).Round(time.Millisecond))
+	}
+}
+
+// countList formats counts as ' (a: 1, b: 2)', sorted by key, or returns
+// an empty string if there aren't any
+func countList(counts map[string]int) string {
+	if len(counts) == 0 {
+		return ""
+	}
+
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", k, counts[k])
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// formatBytes formats a number of bytes for people
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fkB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,62 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestErrorCategory(t *testing.T) {
+	cases := map[string]error{
+		"timeout": &net.OpError{Op: "dial", Err: stallError{}},
+		"dns":     &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "x.invalid"}},
+		"refused": &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		"reset":   io.ErrUnexpectedEOF,
+		"proxy":   &net.OpError{Op: "proxyconnect", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		"other":   errors.New("something else"),
+	}
+	for want, err := range cases {
+		if have := errorCategory(err); have != want {
+			t.Errorf("%v: want %s, have %s", err, want, have)
+		}
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	s := newRunStats()
+	for _, status := range []int{200, 200, 404} {
+		s.fetchedHook(&job{resp: &http.Response{StatusCode: status, ContentLength: 1024}})
+	}
+	s.errorHook(&job{err: io.EOF})
+
+	var buf bytes.Buffer
+	s.print(&buf)
+	for _, want := range []string{"4 requests in ", ", 1 failed (reset: 1)", "3 responses", "3.0kB downloaded, statuses (200: 2, 404: 1)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q in the summary, have %q", want, buf.String())
+		}
+	}
+
+	p := filepath.Join(t.TempDir(), "stats.json")
+	if err := s.writeJSON(p, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(p)
+
+	var sum statsSummary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if !sum.Interrupted || sum.Requests != 4 || sum.Statuses["200"] != 2 || sum.Errors["reset"] != 1 || sum.Bytes != 3072 {
+		t.Errorf("unexpected summary %s", data)
+	}
+}
@@ -0,0 +1,88 @@
+package requester
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// Request bodies, header values and the URL itself can have placeholders
+// in them that are filled in for each request:
+//
+//	{{url}}     the URL
+//	{{scheme}}  http or https
+//	{{host}}    the host name, without the port
+//	{{port}}    the port, which is 80 or 443 if the URL doesn't have one
+//	{{path}}    the path, which is / if the URL doesn't have one
+//	{{rand}}    a random string, the same everywhere in a request
+//
+// Anything else in double braces is left alone.
+
+// templateHook fills in the placeholders in the job's request. It goes in
+// the prepare stage, before the URL's normalised, so that the URL can
+// have them too.
+func templateHook(j *job) bool {
+	if !strings.Contains(j.url, "{{") && !strings.Contains(j.body, "{{") && !hasPlaceholder(j.headers) {
+		return true
+	}
+
+	b := make([]byte, 6)
+	rand.Read(b)
+	rnd := hex.EncodeToString(b)
+
+	// the URL's filled in first, so that everything else gets the URL
+	// that's actually requested
+	r, ok := templateReplacer(j.url, rnd)
+	if !ok {
+		// it's not going to get any further anyway
+		return true
+	}
+	j.url = r.Replace(j.url)
+
+	r, ok = templateReplacer(j.url, rnd)
+	if !ok {
+		return true
+	}
+	j.body = r.Replace(j.body)
+
+	// the headers might be shared with other jobs
+	headers := make([]string, len(j.headers))
+	for i, h := range j.headers {
+		headers[i] = r.Replace(h)
+	}
+	j.headers = headers
+	return true
+}
+
+func hasPlaceholder(headers []string) bool {
+	for _, h := range headers {
+		if strings.Contains(h, "{{") {
+			return true
+		}
+	}
+	return false
+}
+
+// templateReplacer returns a replacer that fills in the placeholders for
+// the URL, or false if it isn't a URL
+func templateReplacer(rawURL, rnd string) (*strings.Replacer, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.NewReplacer(
+		"{{url}}", rawURL,
+		"{{scheme}}", u.Scheme,
+		"{{host}}", u.Hostname(),
+		"{{port}}", urlPort(u),
+		"{{path}}", path,
+		"{{rand}}", rnd,
+	), true
+}
@@ -0,0 +1,43 @@
+package requester
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateHook(t *testing.T) {
+	headers := []string{"Host: {{host}}.evil.com", "X-Id: {{rand}}"}
+	j := newJob("https://example.com/a/b?c={{rand}}", "POST", `{"url":"{{url}}","port":{{port}},"path":"{{path}}","x":"{{nope}}"}`, headers)
+
+	if !templateHook(j) {
+		t.Fatal("want the job kept")
+	}
+
+	rnd := strings.TrimPrefix(j.headers[1], "X-Id: ")
+	if len(rnd) != 12 || strings.Contains(rnd, "{{") {
+		t.Fatalf("want a random string, have %q", rnd)
+	}
+
+	if j.url != "https://example.com/a/b?c="+rnd {
+		t.Errorf("want the same random string in the URL, have %s", j.url)
+	}
+	if j.headers[0] != "Host: example.com.evil.com" {
+		t.Errorf("unexpected Host header %q", j.headers[0])
+	}
+	want := `{"url":"https://example.com/a/b?c=` + rnd + `","port":443,"path":"/a/b","x":"{{nope}}"}`
+	if j.body != want {
+		t.Errorf("want body %s, have %s", want, j.body)
+	}
+
+	// the headers shared between jobs are left alone
+	if headers[0] != "Host: {{host}}.evil.com" {
+		t.Errorf("shared headers changed: %q", headers)
+	}
+
+	// and each job gets its own random string
+	other := newJob("http://example.com", "GET", "", headers)
+	templateHook(other)
+	if other.headers[1] == j.headers[1] {
+		t.Error("want a different random string for each job")
+	}
+}
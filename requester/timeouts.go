@@ -0,0 +1,42 @@
+package requester
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// how long requests, connecting and TLS handshakes get unless --timeout,
+// --connect-timeout or --tls-timeout say otherwise
+const defaultTimeout = 10 * time.Second
+
+// setTimeouts sets how long the client gives requests overall, connecting
+// (including looking up the host) and TLS handshakes. A zero duration
+// means no limit. The connect timeout is applied to whatever dialer the
+// client already has, so it has to be called after recordDNS, which
+// replaces it.
+func setTimeouts(client *http.Client, total, connect, handshake time.Duration) {
+	client.Timeout = total
+
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	tr.TLSHandshakeTimeout = handshake
+
+	if connect <= 0 {
+		return
+	}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, connect)
+		defer cancel()
+		return dial(ctx, network, addr)
+	}
+}
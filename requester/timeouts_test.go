@@ -0,0 +1,31 @@
+package requester
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConnectTimeout(t *testing.T) {
+	client := newClient(false, "")
+
+	// a dialer that never connects, like a host that drops SYNs
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	setTimeouts(client, time.Minute, 50*time.Millisecond, time.Minute)
+
+	start := time.Now()
+	if _, err := client.Get("http://example.com/"); err == nil {
+		t.Fatal("want an error")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("want the connect timeout to apply, took %s", took)
+	}
+	if client.Timeout != time.Minute {
+		t.Errorf("want the overall timeout to be set, have %s", client.Timeout)
+	}
+}
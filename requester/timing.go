@@ -0,0 +1,112 @@
+package requester
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// requestTiming is how long each part of a request took, in milliseconds.
+// DNS, Connect and TLS are left out when they didn't happen, like on a
+// reused connection; TTFB is from the start of the request to the first
+// byte of the response, and Total includes reading the body.
+type requestTiming struct {
+	DNS     float64 `json:"dns_ms,omitempty"`
+	Connect float64 `json:"connect_ms,omitempty"`
+	TLS     float64 `json:"tls_ms,omitempty"`
+	TTFB    float64 `json:"ttfb_ms"`
+	Total   float64 `json:"total_ms"`
+}
+
+// tlsDetails describes the TLS connection a response came over, and the
+// server's certificate
+type tlsDetails struct {
+	Version  string    `json:"version"`
+	Cipher   string    `json:"cipher"`
+	Subject  string    `json:"subject,omitempty"`
+	Issuer   string    `json:"issuer,omitempty"`
+	SANs     []string  `json:"sans,omitempty"`
+	NotAfter time.Time `json:"not_after,omitempty"`
+}
+
+// timingHook adds the timing and TLS details to the result and the meta,
+// and tags responses from hosts with certificates that have expired. It
+// goes in the enrich stage, after resultHook.
+func timingHook(j *job) bool {
+	if j.conn != nil {
+		dns, connect, handshake, firstByte := j.conn.timing()
+		t := &requestTiming{
+			DNS:     ms(dns),
+			Connect: ms(connect),
+			TLS:     ms(handshake),
+			TTFB:    ms(firstByte),
+			Total:   ms(j.fetchTime),
+		}
+		j.res.Timing = t
+		j.meta = append(j.meta, "timing: "+t.String())
+	}
+
+	if j.resp.TLS != nil {
+		d := newTLSDetails(j.resp.TLS)
+		j.res.TLS = d
+		j.meta = append(j.meta, fmt.Sprintf("tls: %s %s", d.Version, d.Cipher))
+		if d.Subject != "" {
+			j.meta = append(j.meta, "certificate: "+d.certificate())
+		}
+		if !d.NotAfter.IsZero() && d.NotAfter.Before(time.Now()) {
+			j.tag("expired-cert")
+		}
+	}
+	return true
+}
+
+// ms returns the duration in milliseconds, to a tenth of one
+func ms(d time.Duration) float64 {
+	return float64(d.Round(100*time.Microsecond)) / float64(time.Millisecond)
+}
+
+func (t *requestTiming) String() string {
+	var parts []string
+	for _, p := range []struct {
+		name string
+		ms   float64
+	}{
+		{"dns", t.DNS},
+		{"connect", t.Connect},
+		{"tls", t.TLS},
+	} {
+		if p.ms > 0 {
+			parts = append(parts, fmt.Sprintf("%s %gms", p.name, p.ms))
+		}
+	}
+	parts = append(parts, fmt.Sprintf("ttfb %gms", t.TTFB), fmt.Sprintf("total %gms", t.Total))
+	return strings.Join(parts, ", ")
+}
+
+func newTLSDetails(state *tls.ConnectionState) *tlsDetails {
+	d := &tlsDetails{
+		Version: tls.VersionName(state.Version),
+		Cipher:  tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		d.Subject = cert.Subject.String()
+		d.Issuer = cert.Issuer.String()
+		d.SANs = cert.DNSNames
+		for _, ip := range cert.IPAddresses {
+			d.SANs = append(d.SANs, ip.String())
+		}
+		d.NotAfter = cert.NotAfter.UTC()
+	}
+	return d
+}
+
+// certificate describes the certificate on a line
+func (d *tlsDetails) certificate() string {
+	s := d.Subject
+	if len(d.SANs) > 0 {
+		s += "; sans " + strings.Join(d.SANs, " ")
+	}
+	return s + "; issuer " + d.Issuer + "; expires " + d.NotAfter.Format(time.RFC3339)
+}
@@ -0,0 +1,79 @@
+package requester
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingHook(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	j := newJob(ts.URL, "GET", "", nil)
+	buildRequestHook(j)
+	connTraceHook(j)
+	fetchHook(newClient(false, ""), bodyLimits{})(j)
+	if j.err != nil {
+		t.Fatal(j.err)
+	}
+	resultHook(j)
+	timingHook(j)
+
+	tm := j.res.Timing
+	if tm == nil || tm.Connect <= 0 || tm.TLS <= 0 || tm.TTFB < 20 || tm.Total < tm.TTFB {
+		t.Errorf("want connect, tls, ttfb and total timings, have %+v", tm)
+	}
+	if j.res.TLS == nil || !strings.HasPrefix(j.res.TLS.Version, "TLS 1.") || j.res.TLS.NotAfter.IsZero() {
+		t.Errorf("want TLS details, have %+v", j.res.TLS)
+	}
+
+	var timing, cert bool
+	for _, m := range j.meta {
+		timing = timing || strings.HasPrefix(m, "timing: connect ")
+		cert = cert || strings.HasPrefix(m, "certificate: ")
+	}
+	if !timing || !cert {
+		t.Errorf("want timing and certificate meta, have %q", j.meta)
+	}
+
+	// the timings are in the JSON, but the plain line's left alone
+	if !strings.Contains(formatResultJSON(j.res), `"ttfb_ms":`) {
+		t.Errorf("want the timings in the JSON, have %s", formatResultJSON(j.res))
+	}
+	if line := formatResult(j.res); strings.Contains(line, "ttfb") {
+		t.Errorf("want no timings in the plain output, have %q", line)
+	}
+	if len(j.res.Tags) > 0 {
+		t.Errorf("want no tags, have %q", j.res.Tags)
+	}
+}
+
+func TestExpiredCert(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "old.example.com"},
+		DNSNames: []string{"old.example.com"},
+		NotAfter: time.Now().Add(-time.Hour),
+	}
+	j := &job{resp: &http.Response{TLS: &tls.ConnectionState{
+		Version:          tls.VersionTLS12,
+		CipherSuite:      tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{cert},
+	}}}
+	timingHook(j)
+
+	if len(j.res.Tags) != 1 || j.res.Tags[0] != "expired-cert" {
+		t.Errorf("want expired-cert tag, have %q", j.res.Tags)
+	}
+	if want := "tls: TLS 1.2 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"; j.meta[0] != want {
+		t.Errorf("want %q, have %q", want, j.meta[0])
+	}
+}
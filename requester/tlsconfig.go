@@ -0,0 +1,79 @@
+package requester
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSOptions are --verify-tls, --cacert, --client-cert, --client-key and
+// --sni. Certificates aren't checked by default, because recon turns up
+// plenty of hosts with self-signed and expired ones that are still worth a
+// look.
+type TLSOptions struct {
+	Verify     bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// configureTLS sets up certificate checking and the client certificate on
+// the client's transport. A CA bundle means certificates are checked, and
+// the CAs in it are trusted as well as the system's. The key can be in
+// the same file as the client certificate. A server name is sent in every
+// handshake instead of the URL's host, and certificates are checked
+// against it.
+func configureTLS(client *http.Client, o TLSOptions) error {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	cfg := tr.TLSClientConfig
+
+	if o.Verify || o.CAFile != "" {
+		cfg.InsecureSkipVerify = false
+	}
+	if o.ServerName != "" {
+		cfg.ServerName = o.ServerName
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --cacert: %s", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.KeyFile != "" && o.CertFile == "" {
+		return fmt.Errorf("--client-key needs --client-cert")
+	}
+	if o.CertFile != "" {
+		keyFile := o.KeyFile
+		if keyFile == "" {
+			keyFile = o.CertFile
+		}
+
+		cert, err := tls.LoadX509KeyPair(o.CertFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return nil
+}
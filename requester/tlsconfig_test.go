@@ -0,0 +1,93 @@
+package requester
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	// a client certificate, with its key in the same file
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fff"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert := filepath.Join(dir, "client.pem")
+	ioutil.WriteFile(clientCert, append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...,
+	), 0600)
+
+	clientCA, _ := x509.ParseCertificate(der)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+
+	cases := []struct {
+		name string
+		opts TLSOptions
+		ok   bool
+	}{
+		{"no client certificate", TLSOptions{}, false},
+		{"unchecked", TLSOptions{CertFile: clientCert}, true},
+		{"untrusted", TLSOptions{Verify: true, CertFile: clientCert}, false},
+		{"trusted", TLSOptions{CAFile: caFile, CertFile: clientCert}, true},
+	}
+
+	for _, c := range cases {
+		client := newClient(false, "")
+		if err := configureTLS(client, c.opts); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%s: want ok %t, have error %v", c.name, c.ok, err)
+		}
+	}
+
+	if err := configureTLS(newClient(false, ""), TLSOptions{CAFile: clientCert + "x"}); err == nil {
+		t.Error("want an error for a missing CA file")
+	}
+	if err := configureTLS(newClient(false, ""), TLSOptions{KeyFile: clientCert}); err == nil {
+		t.Error("want an error for a key without a certificate")
+	}
+}
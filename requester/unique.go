@@ -0,0 +1,95 @@
+package requester
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// uniqueSignatures are the ways --unique-by can tell responses apart. A
+// response with an empty signature is never treated as a repeat.
+var uniqueSignatures = map[string]func(j *job) string{
+	// the body, byte for byte
+	"hash": func(j *job) string {
+		return fmt.Sprintf("%x", sha1.Sum(j.respBody))
+	},
+
+	"status+size": func(j *job) string {
+		return fmt.Sprintf("%d %d", j.res.Status, j.res.Size)
+	},
+
+	// pages without a title can't be told apart this way, so they're
+	// all shown
+	"title": func(j *job) string {
+		return pageTitle(j.respBody)
+	},
+}
+
+// uniqueOutput handles --unique-by. Only the first response with each
+// signature is printed; the rest are still saved, so they're easy to find
+// when one turns out to be interesting.
+type uniqueOutput struct {
+	signature func(j *job) string
+
+	mu         sync.Mutex
+	seen       map[string]bool
+	suppressed int
+}
+
+func newUniqueOutput(by string) (*uniqueOutput, error) {
+	sig, ok := uniqueSignatures[by]
+	if !ok {
+		var names []string
+		for name := range uniqueSignatures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown --unique-by signature %q; want one of %s", by, strings.Join(names, ", "))
+	}
+	return &uniqueOutput{signature: sig, seen: make(map[string]bool)}, nil
+}
+
+// hook stops repeats before they're printed. It goes in the report stage,
+// after anything that isn't stdout.
+func (u *uniqueOutput) hook(j *job) bool {
+	sig := u.signature(j)
+	if sig == "" {
+		return true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.seen[sig] {
+		u.suppressed++
+		return false
+	}
+	u.seen[sig] = true
+	return true
+}
+
+func (u *uniqueOutput) print(w io.Writer) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.suppressed > 0 {
+		fmt.Fprintf(w, "%d repeated responses not shown (--unique-by)\n", u.suppressed)
+	}
+}
+
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// pageTitle returns the text of an HTML page's title, with the entities
+// decoded and the whitespace tidied up
+func pageTitle(body []byte) string {
+	m := titleRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+}
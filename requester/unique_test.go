@@ -0,0 +1,57 @@
+package requester
+
+import "testing"
+
+func TestUniqueOutput(t *testing.T) {
+	page := func(status int, body string) *job {
+		j := &job{respBody: []byte(body)}
+		j.res.Status = status
+		j.res.Size = int64(len(body))
+		return j
+	}
+
+	cases := []struct {
+		by   string
+		jobs []*job
+		want []bool
+	}{
+		{"hash", []*job{page(200, "a"), page(404, "a"), page(200, "b")}, []bool{true, false, true}},
+		{"status+size", []*job{page(200, "a"), page(200, "b"), page(404, "b")}, []bool{true, false, true}},
+		{"title", []*job{
+			page(200, "<title>Not Found</title>"),
+			page(404, "<TITLE>\n  Not   Found\n</TITLE>x"),
+			page(200, "no title"),
+			page(200, "no title either"),
+		}, []bool{true, false, true, true}},
+	}
+
+	for _, c := range cases {
+		u, err := newUniqueOutput(c.by)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, j := range c.jobs {
+			if have := u.hook(j); have != c.want[i] {
+				t.Errorf("%s: job %d: want %t, have %t", c.by, i, c.want[i], have)
+			}
+		}
+	}
+
+	if _, err := newUniqueOutput("words"); err == nil {
+		t.Error("want an error for an unknown signature")
+	}
+}
+
+func TestPageTitle(t *testing.T) {
+	cases := map[string]string{
+		"<html><title>A Page</title></html>":         "A Page",
+		"<title lang=en>Tom &amp; Jerry</title>":     "Tom & Jerry",
+		"<title>\n\tLogin\n</title><title>x</title>": "Login",
+		"<h1>no title</h1>":                          "",
+	}
+	for body, want := range cases {
+		if have := pageTitle([]byte(body)); have != want {
+			t.Errorf("%q: want %q, have %q", body, want, have)
+		}
+	}
+}
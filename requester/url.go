@@ -0,0 +1,216 @@
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// normaliseURL parses an input line leniently and puts it in the form
+// that's requested and stored. Input comes from all sorts of dirty places,
+// so rather than dropping anything that doesn't parse cleanly we fix what
+// we can and return a note for each change made:
+//
+//	escaped   control characters, spaces or stray %s were percent-encoded
+//	idn       an internationalised host was converted to punycode
+//	host-case the host was lowercased
+//	fragment  the #fragment was removed (it's never sent to the server)
+func normaliseURL(raw string) (*url.URL, []string, error) {
+	var notes []string
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		u, err = url.Parse(escapeInvalid(raw))
+		if err != nil {
+			return nil, nil, err
+		}
+		notes = append(notes, "escaped")
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, nil, errors.New("URL must have a scheme and host")
+	}
+
+	host := u.Hostname()
+	ascii, err := hostToASCII(host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad host %q: %s", host, err)
+	}
+	if ascii != host {
+		if ascii == strings.ToLower(host) {
+			notes = append(notes, "host-case")
+		} else {
+			notes = append(notes, "idn")
+		}
+
+		port := u.Port()
+		u.Host = bracketIPv6(ascii)
+		if port != "" {
+			u.Host += ":" + port
+		}
+	}
+
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		u.Fragment = ""
+		u.RawFragment = ""
+		notes = append(notes, "fragment")
+	}
+
+	return u, notes, nil
+}
+
+// escapeInvalid percent-encodes the characters that make url.Parse give
+// up: control characters, spaces, and %s that don't start an escape
+func escapeInvalid(raw string) string {
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c <= ' ' || c == 0x7f:
+			fmt.Fprintf(&b, "%%%02X", c)
+		case c == '%' && (i+2 >= len(raw) || !isHex(raw[i+1]) || !isHex(raw[i+2])):
+			b.WriteString("%25")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// hostToASCII lowercases a host and converts any internationalised labels
+// to punycode (e.g. bücher.de -> xn--bcher-kva.de). It doesn't do the full
+// IDNA mapping, but it covers the hosts that turn up in practice.
+func hostToASCII(host string) (string, error) {
+	// the ideographic full stops are label separators too
+	host = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(host)
+
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i, l := range labels {
+		if isASCII(l) {
+			continue
+		}
+
+		p, err := punycode(l)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + p
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// punycode parameters from RFC 3492
+const (
+	pcBase        = 36
+	pcTMin        = 1
+	pcTMax        = 26
+	pcSkew        = 38
+	pcDamp        = 700
+	pcInitialBias = 72
+	pcInitialN    = 128
+)
+
+// punycode encodes a single label as described in RFC 3492
+func punycode(s string) (string, error) {
+	runes := []rune(s)
+
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(pcInitialN), 0, pcInitialBias
+	for h := basic; h < len(runes); {
+		// the smallest code point that's still to be handled
+		m := rune(0x7fffffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if int(m-n) > (1<<31-1-delta)/(h+1) {
+			return "", errors.New("punycode overflow")
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+
+			q := delta
+			for k := pcBase; ; k += pcBase {
+				t := k - bias
+				if t < pcTMin {
+					t = pcTMin
+				} else if t > pcTMax {
+					t = pcTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(pcBase-t)))
+				q = (q - t) / (pcBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+
+			bias = punycodeAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(out), nil
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= pcDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((pcBase-pcTMin)*pcTMax)/2 {
+		delta /= pcBase - pcTMin
+		k += pcBase
+	}
+	return k + (pcBase-pcTMin+1)*delta/(delta+pcSkew)
+}
@@ -0,0 +1,172 @@
+package requester
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// loadVhosts reads the names in a --vhost-file, one per line. Blank lines
+// and lines starting with # are skipped.
+func loadVhosts(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no names in %s", path)
+	}
+	return names, nil
+}
+
+// vhostProber handles --vhost-file, where each URL's requested once for
+// each name in the Host header. Before the first of them, the URL's
+// requested with a few names that can't exist, and what comes back for
+// those is what the server sends for hosts it doesn't know about (its
+// default vhost, say). Responses that look the same are dropped, because
+// the name made no difference.
+type vhostProber struct {
+	client *http.Client
+	limits bodyLimits
+
+	mu        sync.Mutex
+	baselines map[string]*vhostBaseline
+
+	// how many responses were dropped, for the summary
+	filtered int
+}
+
+type vhostBaseline struct {
+	done        chan struct{}
+	fingerprint *softNotFound
+}
+
+func newVhostProber(client *http.Client, limits bodyLimits) *vhostProber {
+	return &vhostProber{client: client, limits: limits, baselines: make(map[string]*vhostBaseline)}
+}
+
+// hook gets the baseline for the job's URL if there isn't one already;
+// other jobs for the URL wait until it's done. It goes in the schedule
+// stage, after the host limits and pinning.
+func (v *vhostProber) hook(j *job) bool {
+	if j.vhost == "" {
+		return true
+	}
+	key := j.method + " " + j.url
+
+	v.mu.Lock()
+	b, ok := v.baselines[key]
+	if !ok {
+		b = &vhostBaseline{done: make(chan struct{})}
+		v.baselines[key] = b
+	}
+	v.mu.Unlock()
+
+	if ok {
+		<-b.done
+		return true
+	}
+
+	b.fingerprint = v.baseline(j)
+	close(b.done)
+	return true
+}
+
+// baseline requests the URL with made-up names, the same way the job's
+// going to be requested otherwise, and returns what came back. The names
+// are different lengths so that pages that echo the host back don't all
+// look the same. It's nil when the server didn't send the same thing
+// back each time.
+func (v *vhostProber) baseline(j *job) *softNotFound {
+	body, err := requestBody(j.req)
+	if err != nil {
+		return nil
+	}
+	headers := headerLines(j.req.Header)
+
+	var fp *softNotFound
+	for i, n := range calibrationPaths {
+		b := make([]byte, n/2)
+		rand.Read(b)
+		host := "Host: " + hex.EncodeToString(b) + ".invalid"
+
+		r, respBody := fetchResponse(withPins(context.Background(), j.pins), v.client, v.limits, j.method, j.url, body, append(headers, host))
+		if r.err != nil {
+			return nil
+		}
+
+		got := newSoftNotFound(r.status, respBody)
+		if i == 0 {
+			fp = got
+			continue
+		}
+		if !fp.add(got) {
+			return nil
+		}
+	}
+
+	if !fp.consistent() {
+		return nil
+	}
+	return fp
+}
+
+// filterHook drops responses that look like the URL's baseline, and notes
+// what the baseline looks like on the rest. It goes in the filter stage.
+func (v *vhostProber) filterHook(j *job) bool {
+	if j.vhost == "" {
+		return true
+	}
+
+	v.mu.Lock()
+	b := v.baselines[j.method+" "+j.url]
+	v.mu.Unlock()
+	if b == nil {
+		return true
+	}
+
+	<-b.done
+	if b.fingerprint == nil {
+		j.meta = append(j.meta, "vhost baseline: none consistent")
+		return true
+	}
+
+	if b.fingerprint.matches(j.resp.StatusCode, j.respBody) {
+		v.mu.Lock()
+		v.filtered++
+		v.mu.Unlock()
+		return false
+	}
+	j.meta = append(j.meta, "vhost baseline: "+b.fingerprint.String())
+	return true
+}
+
+func (v *vhostProber) print(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.filtered > 0 {
+		fmt.Fprintf(w, "%d responses dropped as the same as an unknown host's (--vhost-file)\n", v.filtered)
+	}
+}
@@ -0,0 +1,76 @@
+package requester
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestVhostProber(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the default site echoes the name back, so it's different every
+		// time, but always the same number of words
+		switch r.Host {
+		case "admin.example.com":
+			fmt.Fprint(w, "admin panel")
+		case "api.example.com":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "welcome to "+r.Host)
+		default:
+			fmt.Fprint(w, "welcome to "+r.Host)
+		}
+	}))
+	defer ts.Close()
+
+	vhostFile := filepath.Join(t.TempDir(), "vhosts")
+	ioutil.WriteFile(vhostFile, []byte("# names\nadmin.example.com\n\nwww.example.com\napi.example.com\n"), 0644)
+
+	urls := make(chan string, 1)
+	urls <- ts.URL + "/"
+	close(urls)
+
+	var mu sync.Mutex
+	var have []string
+	opts := DefaultOptions()
+	opts.Delay = 0
+	opts.Quiet = true
+	opts.Input = NewChanSource(urls)
+	opts.VhostFile = vhostFile
+	opts.OnResult = func(res Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		have = append(have, res.VHost)
+	}
+
+	r, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run(context.Background())
+	r.Close()
+
+	sort.Strings(have)
+	want := []string{"admin.example.com", "api.example.com"}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestSentHeaderLines(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://10.0.0.1/", nil)
+	req.Header.Set("X-Foo", "bar")
+	if have := fmt.Sprint(sentHeaderLines(req)); have != "[X-Foo: bar]" {
+		t.Errorf("want no Host for the URL's host, have %s", have)
+	}
+
+	req.Host = "admin.example.com"
+	if have := fmt.Sprint(sentHeaderLines(req)); have != "[Host: admin.example.com X-Foo: bar]" {
+		t.Errorf("want the Host that was set, have %s", have)
+	}
+}
@@ -0,0 +1,214 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// the most of a request's bytes that are kept by --save-sent
+const maxSentRecord = 1 << 20
+
+// The headers file says what net/http was asked to send, but the transport
+// adds and reorders things on the way out. With --save-sent the bytes that
+// actually went over the connection (inside TLS) are saved alongside the
+// response, so they can be trusted when working on parser differentials.
+//
+// Connections are tapped when they're made. Every time a request gets a
+// connection, the tap is pointed at the record for the job the request
+// belongs to (or at nothing, for requests that aren't recorded). HTTP/1.1
+// only sends one request at a time on a connection, so everything written
+// until the next request gets it belongs to that request.
+
+type sentKey struct{}
+
+// sentRecord is the bytes written for a job's requests, including any
+// redirects and retries
+type sentRecord struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (r *sentRecord) write(b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if room := maxSentRecord - r.buf.Len(); len(b) > room {
+		b = b[:room]
+		r.truncated = true
+	}
+	r.buf.Write(b)
+}
+
+// wireTap copies writes to whichever record it's pointed at
+type wireTap struct {
+	mu  sync.Mutex
+	rec *sentRecord
+}
+
+func (t *wireTap) record(r *sentRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rec = r
+}
+
+func (t *wireTap) tap(b []byte) {
+	t.mu.Lock()
+	rec := t.rec
+	t.mu.Unlock()
+
+	if rec != nil {
+		rec.write(b)
+	}
+}
+
+type tappedConn struct {
+	net.Conn
+	wireTap
+}
+
+func (c *tappedConn) Write(b []byte) (int, error) {
+	c.tap(b)
+	return c.Conn.Write(b)
+}
+
+// tappedTLSConn is tapped above TLS, so it's the plaintext that's
+// recorded. Embedding the *tls.Conn means the transport can still get at
+// the connection state.
+type tappedTLSConn struct {
+	*tls.Conn
+	wireTap
+}
+
+func (c *tappedTLSConn) Write(b []byte) (int, error) {
+	c.tap(b)
+	return c.Conn.Write(b)
+}
+
+type recordable interface {
+	record(r *sentRecord)
+}
+
+// tapConnections makes every connection the client opens tappable, and
+// points the tap at the right record for each request. It has to come
+// after everything else that sets the client up, so that the transport is
+// given the tapped connections directly. For https it does the TLS itself,
+// with the transport's config and handshake timeout. Requests for https
+// URLs through a proxy aren't tapped, because the transport makes the TLS
+// connection through the tunnel itself.
+func tapConnections(client *http.Client) {
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	client.Transport = &tapTransport{tr}
+
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &tappedConn{Conn: conn}, nil
+	}
+
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := &tls.Config{}
+		if tr.TLSClientConfig != nil {
+			cfg = tr.TLSClientConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+
+		if tr.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tr.TLSHandshakeTimeout)
+			defer cancel()
+		}
+
+		// the transport only reports handshakes it does itself, so this
+		// one is reported here
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+
+		tc := tls.Client(conn, cfg)
+		err = tc.HandshakeContext(ctx)
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tc.ConnectionState(), err)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &tappedTLSConn{Conn: tc}, nil
+	}
+}
+
+// tapTransport points the tap on the connection each request gets at the
+// request's record
+type tapTransport struct {
+	*http.Transport
+}
+
+func (t *tapTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec, _ := req.Context().Value(sentKey{}).(*sentRecord)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if c, ok := info.Conn.(recordable); ok {
+				c.record(rec)
+			}
+		},
+	}
+	return t.Transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// sentTraceHook starts recording what's sent for the job. It goes in the
+// schedule stage, just before the fetch.
+func sentTraceHook(j *job) bool {
+	j.sent = &sentRecord{}
+	j.req = j.req.WithContext(context.WithValue(j.req.Context(), sentKey{}, j.sent))
+	return true
+}
+
+// sentHook saves what was sent as an extra .sent file, and notes how much
+// there was in the job's meta
+func sentHook(j *job) bool {
+	if j.sent == nil {
+		return true
+	}
+
+	j.sent.mu.Lock()
+	defer j.sent.mu.Unlock()
+
+	if j.sent.buf.Len() == 0 {
+		j.meta = append(j.meta, "sent: not recorded (https through a proxy)")
+		return true
+	}
+
+	j.extras["sent"] = append([]byte(nil), j.sent.buf.Bytes()...)
+	line := fmt.Sprintf("sent: %d bytes", j.sent.buf.Len())
+	if j.sent.truncated {
+		line += " (truncated)"
+	}
+	j.meta = append(j.meta, line)
+	return true
+}
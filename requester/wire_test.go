@@ -0,0 +1,41 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSaveSent(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := newClient(true, "")
+	tapConnections(client)
+	fetch := fetchHook(client, bodyLimits{})
+
+	// the second request reuses the connection, and mustn't end up in
+	// the first one's record
+	var sent []string
+	for _, path := range []string{"/first", "/second"} {
+		j := newJob(srv.URL+path, "GET", "", []string{"X-Test: yes"})
+		if !buildRequestHook(j) || !sentTraceHook(j) || !fetch(j) {
+			t.Fatal(j.err)
+		}
+		if j.resp.TLS == nil {
+			t.Error("want the TLS connection state to survive tapping")
+		}
+		sentHook(j)
+		sent = append(sent, string(j.extras["sent"]))
+	}
+
+	if !strings.HasPrefix(sent[0], "GET /first HTTP/1.1\r\n") || !strings.Contains(sent[0], "\r\nX-Test: yes\r\n") || strings.Contains(sent[0], "/second") {
+		t.Errorf("unexpected first request %q", sent[0])
+	}
+	if !strings.HasPrefix(sent[1], "GET /second HTTP/1.1\r\n") {
+		t.Errorf("unexpected second request %q", sent[1])
+	}
+}
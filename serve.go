@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dirtybull/fff/requester"
+)
+
+// serveMain is fff serve, which serves saved responses back
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+
+	var replay bool
+	fs.BoolVar(&replay, "replay", false, "")
+
+	var outputDir string
+	fs.StringVar(&outputDir, "output", "out", "")
+	fs.StringVar(&outputDir, "o", "out", "")
+
+	var listen string
+	fs.StringVar(&listen, "listen", "127.0.0.1:8080", "")
+	fs.StringVar(&listen, "l", "127.0.0.1:8080", "")
+
+	var pidFile string
+	fs.StringVar(&pidFile, "pid-file", "", "")
+
+	var healthAddr string
+	fs.StringVar(&healthAddr, "health", "", "")
+
+	var grpcAddr string
+	fs.StringVar(&grpcAddr, "grpc", "", "")
+
+	fs.Usage = func() {
+		h := []string{
+			"Serve responses from an output directory",
+			"",
+			"Usage:",
+			"  fff serve --replay [options]",
+			"",
+			"Options:",
+			"      --grpc <addr>         Serve the gRPC results API (see proto/fff.proto) on <addr>, with the saved responses as results",
+			"      --health <addr>       Answer GET /health on <addr>, for service managers",
+			"  -l, --listen <addr>       Address to listen on (default: 127.0.0.1:8080)",
+			"  -o, --output <dir>        Directory containing saved responses (default: out)",
+			"      --pid-file <file>     Write the process ID to <file> while running",
+			"      --replay              Answer requests from the saved responses",
+			"",
+			"A SIGHUP reloads the saved responses.",
+			"",
+		}
+		fmt.Fprint(os.Stderr, strings.Join(h, "\n"))
+	}
+
+	fs.Parse(args)
+
+	if !replay {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	rp, err := requester.NewReplay(outputDir, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load responses: %s\n", err)
+		os.Exit(1)
+	}
+
+	// everything's listening before we say we're ready, so a port that's
+	// taken is an error straight away
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to serve: %s\n", err)
+		os.Exit(1)
+	}
+	if grpcAddr != "" {
+		gl, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start gRPC server: %s\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			err := requester.ServeGRPC(gl, rp.GRPC())
+			fmt.Fprintf(os.Stderr, "gRPC server failed: %s\n", err)
+			os.Exit(1)
+		}()
+	}
+	if healthAddr != "" {
+		hl, err := net.Listen("tcp", healthAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start health endpoint: %s\n", err)
+			os.Exit(1)
+		}
+		go http.Serve(hl, requester.HealthHandler(func() interface{} {
+			return map[string]int{"responses": rp.Len()}
+		}))
+	}
+	fmt.Fprintf(os.Stderr, "loaded %d responses from %s, listening on %s\n", rp.Len(), outputDir, listen)
+
+	removePID := func() {}
+	if pidFile != "" {
+		removePID, err = requester.WritePIDFile(pidFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// a SIGHUP reloads the saved responses while we're serving them
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				removePID()
+				os.Exit(requester.SignalExitCode(sig))
+			}
+			if err := rp.Reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to reload responses: %s\n", err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "reloaded %d responses from %s\n", rp.Len(), outputDir)
+		}
+	}()
+
+	err = http.Serve(l, rp)
+	removePID()
+	fmt.Fprintf(os.Stderr, "failed to serve: %s\n", err)
+	os.Exit(1)
+}
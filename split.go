@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dirtybull/fff/requester"
+)
+
+// splitMain is fff split, which splits input up into shards for running
+// fff on several machines
+func splitMain(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+
+	var shards int
+	fs.IntVar(&shards, "n", 0, "")
+
+	var by string
+	fs.StringVar(&by, "by", "host", "")
+
+	var prefix string
+	fs.StringVar(&prefix, "output", "", "")
+	fs.StringVar(&prefix, "o", "", "")
+
+	var format string
+	fs.StringVar(&format, "input-format", "", "")
+
+	fs.Usage = func() {
+		h := []string{
+			"Split input up into shards, for running fff on several machines",
+			"",
+			"Usage:",
+			"  fff split -n <shards> [options] [file...]",
+			"",
+			"Options:",
+			"      --by <what>           Keep each host's URLs together (host, the default), or just deal out lines (line)",
+			"      --input-format <fmt>  The lines are in this --input-format (see README)",
+			"  -n <shards>               How many shards to split the input into",
+			"  -o, --output <prefix>     Write the shards to <prefix>-1.txt and so on (default: the input file's name, or shard)",
+			"",
+			"Input is read from stdin if there are no files.",
+			"",
+		}
+		fmt.Fprint(os.Stderr, strings.Join(h, "\n"))
+	}
+
+	// files can come before the options as well as after them
+	fs.Parse(args)
+	var files []string
+	for fs.NArg() > 0 {
+		files = append(files, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+
+	if shards < 1 || (by != "host" && by != "line") {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	ext := ".txt"
+	if len(files) > 0 {
+		ext = filepath.Ext(files[0])
+		if prefix == "" {
+			prefix = strings.TrimSuffix(files[0], ext)
+		}
+	}
+	if prefix == "" {
+		prefix = "shard"
+	}
+
+	// by host, the input's read twice, so stdin's kept in a temporary file
+	if len(files) == 0 {
+		tmp, err := spoolStdin()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read stdin: %s\n", err)
+			os.Exit(1)
+		}
+		defer os.Remove(tmp)
+		files = []string{tmp}
+	}
+
+	var names []string
+	for i := 1; i <= shards; i++ {
+		names = append(names, fmt.Sprintf("%s-%d%s", prefix, i, ext))
+	}
+	counts, err := requester.Split(files, names, by == "host", format, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for i, name := range names {
+		fmt.Fprintf(os.Stderr, "%s: %d lines\n", name, counts[i])
+	}
+}
+
+func spoolStdin() (string, error) {
+	f, err := ioutil.TempFile("", "fff-split-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dirtybull/fff/requester"
+)
+
+// tagMain is fff tag, which annotates saved responses in the index, for
+// keeping track of what's been looked at and what was found
+func tagMain(args []string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+
+	var outputDir string
+	fs.StringVar(&outputDir, "output", "out", "")
+	fs.StringVar(&outputDir, "o", "out", "")
+
+	var add, remove requester.ReviewTags
+	fs.Var(&add, "tag", "")
+	fs.Var(&add, "t", "")
+	fs.Var(&remove, "untag", "")
+
+	var note string
+	fs.StringVar(&note, "note", "", "")
+
+	var list bool
+	fs.BoolVar(&list, "list", false, "")
+
+	fs.Usage = func() {
+		h := []string{
+			"Annotate saved responses, or list the ones that have been",
+			"",
+			"Usage:",
+			"  fff tag [options] <hash>...",
+			"  fff tag --list [--tag <tag>]",
+			"",
+			"Options:",
+			"      --list                List annotated responses (with all of the --tags, if any are given)",
+			"      --note <text>         Add a note, replacing any there was before ('' takes it away)",
+			"  -o, --output <dir>        Directory containing saved responses (default: out)",
+			"  -t, --tag <tag>           Add a tag (repeatable, or comma separated)",
+			"      --untag <tag>         Take a tag away (repeatable, or comma separated)",
+			"",
+			"Responses can be given by hash, the start of one, or the path to one of their files.",
+			"",
+		}
+		fmt.Fprint(os.Stderr, strings.Join(h, "\n"))
+	}
+
+	// hashes can come before the options as well as after them
+	fs.Parse(args)
+	var refs []string
+	for fs.NArg() > 0 {
+		refs = append(refs, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+
+	var n *string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "note" {
+			n = &note
+		}
+	})
+
+	if list {
+		if err := requester.ListTagged(os.Stdout, outputDir, add); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(refs) == 0 || (len(add) == 0 && len(remove) == 0 && n == nil) {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := requester.Tag(outputDir, refs, add, remove, n); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}